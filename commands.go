@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ffardo/user-crud/consistency"
+	"github.com/ffardo/user-crud/migrations"
+	"github.com/ffardo/user-crud/repositories"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const USAGE = `usage: user-crud [command] [flags]
+
+Without a command the HTTP server is started.
+
+Commands:
+  migrate    apply pending migrations to the users collection
+  indexes    print the differences between declared and existing indexes
+  backfill   copy every user to the secondary Mongo, resuming from a checkpoint
+  check      report inconsistent user documents, and repair those it can
+`
+
+func runCommand(name string, args []string) {
+	switch name {
+	case "migrate":
+		migrateCommand(args)
+	case "indexes":
+		indexesCommand(args)
+	case "backfill":
+		backfillCommand(args)
+	case "check":
+		checkCommand(args)
+	default:
+		fmt.Fprint(os.Stderr, USAGE)
+		os.Exit(2)
+	}
+}
+
+func migrateCommand(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report pending migrations without applying them")
+	fs.Parse(args)
+
+	results, err := runMigrations(connect(PRIMARY_MONGO), *dryRun)
+
+	for _, r := range results {
+		if r.Applied {
+			fmt.Printf("applied %d %s: %d documents\n", r.Version, r.Description, r.Documents)
+		} else {
+			fmt.Printf("pending %d %s: %d documents\n", r.Version, r.Description, r.Documents)
+		}
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(results) == 0 {
+		fmt.Println("no pending migrations")
+	}
+}
+
+func runMigrations(client *mongo.Client, dryRun bool) ([]migrations.Result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), MIGRATION_TIMEOUT)
+	defer cancel()
+
+	names := storage(PRIMARY_MONGO)
+
+	runner := migrations.Runner{
+		Database:   client.Database(names.DatabaseName()),
+		Collection: names.UsersName(),
+		Migrations: migrations.All(emailNormalizer()),
+		DryRun:     dryRun,
+	}
+
+	return runner.Run(ctx)
+}
+
+func indexesCommand(args []string) {
+	fs := flag.NewFlagSet("indexes", flag.ExitOnError)
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), repositories.INDEX_TIMEOUT)
+	defer cancel()
+
+	manager := repositories.IndexManager{Client: connect(PRIMARY_MONGO), Storage: storage(PRIMARY_MONGO)}
+
+	diffs, err := manager.Plan(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+
+	if len(diffs) == 0 {
+		fmt.Println("indexes match their declarations")
+	}
+}
+
+func backfillCommand(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	checkpoint := fs.String("checkpoint", "backfill.checkpoint", "file recording the last user copied")
+	batchSize := fs.Int("batch-size", repositories.DEFAULT_BACKFILL_BATCH_SIZE, "users read per query")
+	fs.Parse(args)
+
+	if os.Getenv(SECONDARY_MONGO+"_URI") == "" {
+		log.Fatal(SECONDARY_MONGO + "_URI is not set")
+	}
+
+	secondaryClient := connect(SECONDARY_MONGO)
+	if !ensureIndexes(secondaryClient, storage(SECONDARY_MONGO)) {
+		log.Fatal("unique indexes could not be built in the secondary, fix its users before copying more")
+	}
+
+	backfill := repositories.Backfill{
+		Source: repositories.UserRepository{
+			Client:  connect(PRIMARY_MONGO),
+			Storage: storage(PRIMARY_MONGO),
+		},
+		Target: repositories.UserRepository{
+			Client:  secondaryClient,
+			Storage: storage(SECONDARY_MONGO),
+		},
+		Checkpoint: repositories.FileCheckpoint{Path: *checkpoint},
+		BatchSize:  *batchSize,
+	}
+
+	copied, err := backfill.Run(func(copied int, last uuid.UUID) {
+		fmt.Printf("copied %d users, up to %s\n", copied, last)
+	})
+
+	if err != nil {
+		log.Fatalf("backfill stopped after %d users, run again to resume: %v", copied, err)
+	}
+
+	fmt.Printf("backfill complete: %d users copied\n", copied)
+}
+
+func checkCommand(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "store string UUIDs in binary form and fill in missing timestamps")
+	newUUIDs := fs.Bool("new-uuids", false, "with -repair, assign new UUIDs to users whose UUID does not parse, changing their public ID")
+	reportPath := fs.String("report", "", "also write every finding, with its fix, to this file as NDJSON")
+	fs.Parse(args)
+
+	names := storage(PRIMARY_MONGO)
+
+	checker := consistency.Checker{
+		Users:    connect(PRIMARY_MONGO).Database(names.DatabaseName()).Collection(names.UsersName()),
+		Emails:   emailNormalizer(),
+		Repair:   *repair,
+		NewUUIDs: *newUUIDs,
+	}
+
+	var report *json.Encoder
+	if *reportPath != "" {
+		f, err := os.Create(*reportPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		report = json.NewEncoder(f)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CHECK_TIMEOUT)
+	defer cancel()
+
+	unresolved := 0
+	found, err := checker.Run(ctx, func(f consistency.Finding) error {
+		line := fmt.Sprintf("%s %s", f.Check, f.ID.Hex())
+		if f.UUID != "" {
+			line += " " + f.UUID
+		}
+		line += ": " + f.Detail
+		if f.Fix != "" {
+			line += " (fixed: " + f.Fix + ")"
+		} else {
+			unresolved++
+		}
+		fmt.Println(line)
+
+		if report != nil {
+			return report.Encode(f)
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%d findings, %d left to resolve\n", found, unresolved)
+
+	if unresolved > 0 {
+		os.Exit(1)
+	}
+}
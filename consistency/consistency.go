@@ -0,0 +1,375 @@
+package consistency
+
+import (
+	"context"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ffardo/user-crud/emails"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Names of the checks, as reported in findings.
+const (
+	DUPLICATE_EMAIL   = "duplicate_email"
+	INVALID_UUID      = "invalid_uuid"
+	DUPLICATE_UUID    = "duplicate_uuid"
+	MISSING_CREATED   = "missing_created"
+	MISSING_UPDATED   = "missing_updated"
+	INVALID_EMAIL     = "invalid_email"
+	FUTURE_BIRTH_DATE = "future_birth_date"
+)
+
+// Finding is a problem with one user document, and its fix when repaired.
+type Finding struct {
+	Check  string             `json:"check"`
+	ID     primitive.ObjectID `json:"id"`
+	UUID   string             `json:"uuid,omitempty"`
+	Detail string             `json:"detail"`
+	// Fix describes the change made by a repair. Findings without one
+	// need to be resolved by hand.
+	Fix string `json:"fix,omitempty"`
+}
+
+// document is a user as stored, read loosely so malformed documents can
+// still be inspected.
+type document struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	UUID      bson.RawValue      `bson:"uuid"`
+	TenantID  bson.RawValue      `bson:"tenant_id"`
+	Email     bson.RawValue      `bson:"email"`
+	BirthDate bson.RawValue      `bson:"birthdate"`
+	Created   bson.RawValue      `bson:"created"`
+	Updated   bson.RawValue      `bson:"updated"`
+	Deleted   bson.RawValue      `bson:"deleted"`
+}
+
+func (d document) active() bool {
+	return d.Deleted.Type == 0 || d.Deleted.Type == bsontype.Null
+}
+
+// tenant returns the tenant ID, empty for the default tenant.
+func (d document) tenant() string {
+	tenant, _ := d.TenantID.StringValueOK()
+	return tenant
+}
+
+func (d document) email() string {
+	email, _ := d.Email.StringValueOK()
+	return email
+}
+
+// uuid returns the stored UUID, and false when it is missing, malformed or
+// the nil UUID.
+func (d document) uuid() (uuid.UUID, bool) {
+	_, data, ok := d.UUID.BinaryOK()
+	if !ok || len(data) != 16 {
+		return uuid.Nil, false
+	}
+
+	u, _ := uuid.FromBytes(data)
+	return u, u != uuid.Nil
+}
+
+// stringUUID returns the UUID stored as a string, and false when it is
+// not a string or does not parse to a UUID other than the nil one.
+func (d document) stringUUID() (uuid.UUID, bool) {
+	s, ok := d.UUID.StringValueOK()
+	if !ok {
+		return uuid.Nil, false
+	}
+
+	u, err := uuid.Parse(s)
+	return u, err == nil && u != uuid.Nil
+}
+
+func (d document) uuidString() string {
+	if u, ok := d.uuid(); ok {
+		return u.String()
+	}
+	if u, ok := d.stringUUID(); ok {
+		return u.String()
+	}
+	return ""
+}
+
+func hasTime(v bson.RawValue) bool {
+	dt, ok := v.DateTimeOK()
+	return ok && !time.UnixMilli(dt).IsZero()
+}
+
+// Checker scans the users collection for documents the service cannot
+// handle correctly.
+type Checker struct {
+	Users  *mongo.Collection
+	Emails emails.Normalizer
+	// Repair stores UUIDs kept as strings in binary form and fills in
+	// missing timestamps from the document's ObjectId. Other findings are
+	// reported only.
+	Repair bool
+	// NewUUIDs also has Repair assign new UUIDs to users whose UUID does
+	// not parse. Their public ID changes, breaking any reference to it.
+	NewUUIDs bool
+	Now      func() time.Time
+}
+
+// Run reports every finding to report, in collection order followed by the
+// duplicates, and returns how many were found.
+func (c Checker) Run(ctx context.Context, report func(Finding) error) (int, error) {
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+
+	cursor, err := c.Users.Find(ctx, bson.D{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	found := 0
+	var docs []document
+
+	for cursor.Next(ctx) {
+		var doc document
+		if err := cursor.Decode(&doc); err != nil {
+			return found, err
+		}
+
+		findings := inspect(doc, now())
+		if c.Repair {
+			if err := c.repair(ctx, doc, findings); err != nil {
+				return found, err
+			}
+		}
+
+		for _, f := range findings {
+			found++
+			if err := report(f); err != nil {
+				return found, err
+			}
+		}
+
+		// Duplicates are looked for among active users only, as soft
+		// deleted ones are left out of the unique indexes.
+		if doc.active() {
+			docs = append(docs, doc)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return found, err
+	}
+
+	for _, f := range duplicates(docs, c.Emails) {
+		found++
+		if err := report(f); err != nil {
+			return found, err
+		}
+	}
+
+	return found, nil
+}
+
+// inspect returns the problems found in a single document.
+func inspect(doc document, now time.Time) []Finding {
+	var findings []Finding
+
+	add := func(check, detail string) {
+		findings = append(findings, Finding{Check: check, ID: doc.ID, UUID: doc.uuidString(), Detail: detail})
+	}
+
+	if _, ok := doc.uuid(); !ok {
+		add(INVALID_UUID, "uuid is "+describe(doc.UUID))
+	}
+
+	if !hasTime(doc.Created) {
+		add(MISSING_CREATED, "created is "+describe(doc.Created))
+	}
+
+	if !hasTime(doc.Updated) {
+		add(MISSING_UPDATED, "updated is "+describe(doc.Updated))
+	}
+
+	if _, err := mail.ParseAddress(doc.email()); err != nil {
+		add(INVALID_EMAIL, "email "+quote(doc.email())+": "+err.Error())
+	}
+
+	if dt, ok := doc.BirthDate.DateTimeOK(); ok && time.UnixMilli(dt).After(now) {
+		add(FUTURE_BIRTH_DATE, "birthdate is "+time.UnixMilli(dt).UTC().Format("2006-01-02"))
+	}
+
+	return findings
+}
+
+// duplicates returns a finding for every active user sharing its canonical
+// email with another of its tenant, or its UUID with any other. Emails of
+// tenants other than the default one are reported prefixed with the tenant
+// ID and a slash.
+func duplicates(docs []document, normalizer emails.Normalizer) []Finding {
+	byEmail := map[string][]document{}
+	byUUID := map[uuid.UUID][]document{}
+
+	for _, doc := range docs {
+		if doc.email() != "" {
+			canonical, err := normalizer.Canonical(doc.email())
+			if err != nil {
+				canonical = strings.ToLower(strings.TrimSpace(doc.email()))
+			}
+			if tenant := doc.tenant(); tenant != "" {
+				canonical = tenant + "/" + canonical
+			}
+			byEmail[canonical] = append(byEmail[canonical], doc)
+		}
+
+		// UUIDs stored as strings are repaired into binary ones, which
+		// must not collide either.
+		if u, ok := doc.uuid(); ok {
+			byUUID[u] = append(byUUID[u], doc)
+		} else if u, ok := doc.stringUUID(); ok {
+			byUUID[u] = append(byUUID[u], doc)
+		}
+	}
+
+	var findings []Finding
+
+	for _, email := range sortedKeys(byEmail) {
+		group := byEmail[email]
+		if len(group) < 2 {
+			continue
+		}
+		for _, doc := range group {
+			findings = append(findings, Finding{
+				Check:  DUPLICATE_EMAIL,
+				ID:     doc.ID,
+				UUID:   doc.uuidString(),
+				Detail: quote(email) + " is shared by " + ids(group, doc),
+			})
+		}
+	}
+
+	for _, group := range byUUID {
+		if len(group) < 2 {
+			continue
+		}
+		for _, doc := range group {
+			findings = append(findings, Finding{
+				Check:  DUPLICATE_UUID,
+				ID:     doc.ID,
+				UUID:   doc.uuidString(),
+				Detail: "uuid is shared by " + ids(group, doc),
+			})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Check != findings[j].Check {
+			return findings[i].Check < findings[j].Check
+		}
+		return findings[i].ID.Hex() < findings[j].ID.Hex()
+	})
+
+	return findings
+}
+
+// repair fixes the findings it can and records the fix on them.
+func (c Checker) repair(ctx context.Context, doc document, findings []Finding) error {
+	set := c.fixes(doc, findings)
+
+	if len(set) == 0 {
+		return nil
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+
+	_, err := c.Users.UpdateOne(ctx, bson.D{{Key: "_id", Value: doc.ID}}, update)
+
+	return err
+}
+
+// fixes returns the fields repairing the findings it can, and records the
+// fix on them.
+func (c Checker) fixes(doc document, findings []Finding) bson.D {
+	set := bson.D{}
+	created := doc.ID.Timestamp()
+
+	for i := range findings {
+		f := &findings[i]
+
+		switch f.Check {
+		case INVALID_UUID:
+			if u, ok := doc.stringUUID(); ok {
+				set = append(set, bson.E{Key: "uuid", Value: u})
+				f.Fix = "stored uuid in binary form"
+			} else if c.NewUUIDs {
+				u := uuid.New()
+				set = append(set, bson.E{Key: "uuid", Value: u})
+				f.Fix = "assigned uuid " + u.String()
+				f.UUID = u.String()
+			}
+		case MISSING_CREATED:
+			set = append(set, bson.E{Key: "created", Value: created})
+			f.Fix = "set created to " + created.UTC().Format(time.RFC3339)
+		case MISSING_UPDATED:
+			updated := created
+			if dt, ok := doc.Created.DateTimeOK(); ok && hasTime(doc.Created) {
+				updated = time.UnixMilli(dt)
+			}
+			set = append(set, bson.E{Key: "updated", Value: updated})
+			f.Fix = "set updated to " + updated.UTC().Format(time.RFC3339)
+		}
+	}
+
+	return set
+}
+
+func describe(v bson.RawValue) string {
+	if v.Type == 0 {
+		return "missing"
+	}
+	if v.Type == bsontype.Null {
+		return "null"
+	}
+	if v.Type == bsontype.Binary {
+		_, data, _ := v.BinaryOK()
+		if len(data) == 16 {
+			return "the nil uuid"
+		}
+	}
+	if dt, ok := v.DateTimeOK(); ok {
+		return "zero (" + time.UnixMilli(dt).UTC().Format(time.RFC3339) + ")"
+	}
+	return "a " + v.Type.String()
+}
+
+func quote(s string) string {
+	return `"` + s + `"`
+}
+
+// ids lists the ObjectIds of the group other than doc.
+func ids(group []document, doc document) string {
+	var others []string
+	for _, d := range group {
+		if d.ID != doc.ID {
+			others = append(others, d.ID.Hex())
+		}
+	}
+	return strings.Join(others, ", ")
+}
+
+func sortedKeys(m map[string][]document) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
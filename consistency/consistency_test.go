@@ -0,0 +1,139 @@
+package consistency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ffardo/user-crud/emails"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var now = time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+
+func raw(v interface{}) bson.RawValue {
+	b, _ := bson.Marshal(bson.D{{Key: "v", Value: v}})
+	return bson.Raw(b).Lookup("v")
+}
+
+func validDocument(email string) document {
+	return document{
+		ID:        primitive.NewObjectID(),
+		UUID:      raw(uuid.New()),
+		Email:     raw(email),
+		BirthDate: raw(time.Date(1970, 1, 31, 0, 0, 0, 0, time.UTC)),
+		Created:   raw(now),
+		Updated:   raw(now),
+		Deleted:   raw(nil),
+	}
+}
+
+func checks(findings []Finding) []string {
+	c := []string{}
+	for _, f := range findings {
+		c = append(c, f.Check)
+	}
+	return c
+}
+
+func TestInspectValidDocument(t *testing.T) {
+	assert.Empty(t, inspect(validDocument("joe@mail.com"), now))
+}
+
+func TestInspectReportsEveryProblem(t *testing.T) {
+	doc := document{
+		ID:        primitive.NewObjectID(),
+		UUID:      raw(uuid.Nil),
+		Email:     raw("not an email"),
+		BirthDate: raw(time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)),
+		Created:   raw(time.Time{}),
+	}
+
+	findings := inspect(doc, now)
+
+	assert.Equal(t, []string{INVALID_UUID, MISSING_CREATED, MISSING_UPDATED, INVALID_EMAIL, FUTURE_BIRTH_DATE}, checks(findings))
+	assert.Equal(t, "uuid is the nil uuid", findings[0].Detail)
+	assert.Equal(t, "updated is missing", findings[2].Detail)
+}
+
+func TestInspectUUIDStoredAsString(t *testing.T) {
+	doc := validDocument("joe@mail.com")
+	doc.UUID = raw("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	findings := inspect(doc, now)
+
+	assert.Equal(t, []string{INVALID_UUID}, checks(findings))
+	assert.Equal(t, "uuid is a string", findings[0].Detail)
+}
+
+func TestDuplicatesByCanonicalEmail(t *testing.T) {
+	a := validDocument("Joe@Mail.com")
+	b := validDocument("joe@mail.com ")
+	c := validDocument("ann@mail.com")
+
+	findings := duplicates([]document{a, b, c}, emails.Normalizer{})
+
+	assert.Equal(t, []string{DUPLICATE_EMAIL, DUPLICATE_EMAIL}, checks(findings))
+	assert.Contains(t, findings[0].Detail, `"joe@mail.com" is shared by`)
+}
+
+func TestDuplicatesWithinTenant(t *testing.T) {
+	a := validDocument("joe@mail.com")
+	b := validDocument("joe@mail.com")
+	b.TenantID = raw("acme")
+	c := validDocument("joe@mail.com")
+	c.TenantID = raw("acme")
+
+	findings := duplicates([]document{a, b, c}, emails.Normalizer{})
+
+	assert.Equal(t, []string{DUPLICATE_EMAIL, DUPLICATE_EMAIL}, checks(findings))
+	assert.Contains(t, findings[0].Detail, `"acme/joe@mail.com" is shared by `+c.ID.Hex())
+}
+
+func TestDuplicatesByUUID(t *testing.T) {
+	a := validDocument("joe@mail.com")
+	b := validDocument("ann@mail.com")
+	b.UUID = a.UUID
+
+	findings := duplicates([]document{a, b}, emails.Normalizer{})
+
+	assert.Equal(t, []string{DUPLICATE_UUID, DUPLICATE_UUID}, checks(findings))
+}
+
+func TestRepairStringUUIDKeepsValue(t *testing.T) {
+	doc := validDocument("joe@mail.com")
+	doc.UUID = raw("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	findings := inspect(doc, now)
+
+	set := Checker{}.fixes(doc, findings)
+
+	assert.Equal(t, bson.D{{Key: "uuid", Value: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")}}, set)
+	assert.Equal(t, "stored uuid in binary form", findings[0].Fix)
+	assert.Equal(t, "d035e79d-ffe9-4ebf-b665-747353b3ea40", findings[0].UUID)
+}
+
+func TestRepairUnparseableUUID(t *testing.T) {
+	doc := validDocument("joe@mail.com")
+	doc.UUID = raw("not a uuid")
+
+	findings := inspect(doc, now)
+	assert.Empty(t, Checker{}.fixes(doc, findings))
+	assert.Empty(t, findings[0].Fix)
+
+	findings = inspect(doc, now)
+	set := Checker{NewUUIDs: true}.fixes(doc, findings)
+	assert.Len(t, set, 1)
+	assert.Equal(t, "assigned uuid "+findings[0].UUID, findings[0].Fix)
+}
+
+func TestDuplicatesByStringUUID(t *testing.T) {
+	u := uuid.New()
+	a := validDocument("joe@mail.com")
+	a.UUID = raw(u)
+	b := validDocument("mary@mail.com")
+	b.UUID = raw(u.String())
+
+	assert.Equal(t, []string{DUPLICATE_UUID, DUPLICATE_UUID}, checks(duplicates([]document{a, b}, emails.Normalizer{})))
+}
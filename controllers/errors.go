@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ffardo/user-crud/patch"
+	"github.com/ffardo/user-crud/problems"
+	"github.com/ffardo/user-crud/services"
+	"github.com/gin-gonic/gin"
+)
+
+// problemKind describes how an error is reported.
+type problemKind struct {
+	err    error
+	status int
+	// code names the problem in its type URI and in field errors.
+	code string
+	// field is the field the error concerns, when it does not say itself.
+	field string
+}
+
+// PROBLEMS maps the errors clients are told about to their problems. Errors
+// are matched with errors.Is, in order; the ones not listed are reported as
+// an Internal Server Error, without detail.
+var PROBLEMS = []problemKind{
+	{services.ErrValidationFailed, http.StatusBadRequest, "validation-failed", ""},
+	{services.ErrEmailRegistered, http.StatusConflict, "email-registered", "email"},
+	{services.ErrInvalidEmailFormat, http.StatusBadRequest, "invalid-email", "email"},
+	{services.ErrInvalidDateFormat, http.StatusBadRequest, "invalid-date", "birth_date"},
+	{services.ErrInvalidUuidFormat, http.StatusBadRequest, "invalid-uuid", "uuid"},
+	{services.ErrEmailDomainNotAllowed, http.StatusBadRequest, "email-domain-not-allowed", "email"},
+	{services.ErrMissingField, http.StatusBadRequest, "missing-field", ""},
+	{services.ErrFieldRequired, http.StatusBadRequest, "required", ""},
+	{services.ErrFieldTooShort, http.StatusBadRequest, "too-short", ""},
+	{services.ErrFieldTooLong, http.StatusBadRequest, "too-long", ""},
+	{services.ErrInvalidCharacters, http.StatusBadRequest, "invalid-characters", ""},
+	{services.ErrDateOutOfRange, http.StatusBadRequest, "date-out-of-range", ""},
+	{services.ErrInvalidCursor, http.StatusBadRequest, "invalid-cursor", "cursor"},
+	{services.ErrInvalidPageSize, http.StatusBadRequest, "invalid-page-size", "limit"},
+	{services.ErrInvalidSearch, http.StatusBadRequest, "invalid-search", "q"},
+	{services.ErrInvalidSort, http.StatusBadRequest, "invalid-sort", "sort"},
+	{services.ErrInvalidBatch, http.StatusBadRequest, "invalid-batch", ""},
+	{services.ErrInvalidOperation, http.StatusBadRequest, "invalid-operation", ""},
+	{services.ErrDuplicateOperation, http.StatusBadRequest, "duplicate-operation", ""},
+	{services.ErrInvalidRecord, http.StatusBadRequest, "invalid-record", ""},
+	{ErrInvalidImport, http.StatusBadRequest, "invalid-import", ""},
+	{ErrInvalidColumns, http.StatusBadRequest, "invalid-columns", "columns"},
+	{ErrInvalidBody, http.StatusBadRequest, "invalid-body", ""},
+	{patch.ErrInvalidPatch, http.StatusBadRequest, "invalid-patch", ""},
+	{patch.ErrPathNotFound, http.StatusUnprocessableEntity, "patch-path-not-found", ""},
+	{ErrUnknownField, http.StatusUnprocessableEntity, "unknown-field", ""},
+	{ErrReadOnlyField, http.StatusUnprocessableEntity, "read-only-field", ""},
+	{ErrInvalidField, http.StatusUnprocessableEntity, "invalid-field", ""},
+	{ErrClearedField, http.StatusUnprocessableEntity, "cleared-field", ""},
+	{patch.ErrTestFailed, http.StatusConflict, "patch-test-failed", ""},
+	{ErrUnsupportedFormat, http.StatusUnsupportedMediaType, "unsupported-format", ""},
+	{services.ErrBatchTooLarge, http.StatusRequestEntityTooLarge, "batch-too-large", ""},
+	{services.ErrUserNotFound, http.StatusNotFound, "user-not-found", ""},
+	{services.ErrVersionMismatch, http.StatusPreconditionFailed, "version-mismatch", ""},
+	{services.ErrUserExists, http.StatusPreconditionFailed, "user-exists", ""},
+	{services.ErrConcurrentUpdate, http.StatusConflict, "concurrent-update", ""},
+	{services.ErrUuidTaken, http.StatusConflict, "uuid-taken", "uuid"},
+	{services.ErrBatchAborted, http.StatusFailedDependency, "batch-aborted", ""},
+	{services.ErrTenantNotFound, http.StatusNotFound, "tenant-not-found", ""},
+	{services.ErrTenantExists, http.StatusConflict, "tenant-exists", "id"},
+	{services.ErrInvalidTenantID, http.StatusBadRequest, "invalid-tenant-id", "id"},
+	{services.ErrInvalidEmailDomain, http.StatusBadRequest, "invalid-email-domain", "settings.email_domains"},
+	{services.ErrStorageUnavailable, http.StatusServiceUnavailable, "storage-unavailable", ""},
+	{services.ErrStorageTimeout, http.StatusGatewayTimeout, "storage-timeout", ""},
+}
+
+// INTERNAL_ERROR is the message of errors withheld from clients.
+const INTERNAL_ERROR = "Internal server error"
+
+// problemKindOf returns the problem err is reported as, if it is one
+// clients are told about.
+func problemKindOf(err error) (problemKind, bool) {
+	for _, kind := range PROBLEMS {
+		if errors.Is(err, kind.err) {
+			return kind, true
+		}
+	}
+	return problemKind{}, false
+}
+
+// handleError responds with the problem err is reported as.
+func handleError(ctx *gin.Context, err error) {
+	kind, ok := problemKindOf(err)
+	if !ok {
+		ctx.Error(err)
+		problems.AbortWithStatus(ctx, http.StatusInternalServerError)
+		return
+	}
+
+	problem := problems.Problem{
+		Type:   problems.Type(kind.code),
+		Title:  kind.err.Error(),
+		Status: kind.status,
+		Errors: fieldErrors(err, kind),
+	}
+	if detail := err.Error(); detail != problem.Title {
+		problem.Detail = detail
+	}
+
+	problems.Abort(ctx, problem)
+}
+
+// fieldErrors lists the fields err concerns, which are named by the
+// services.FieldError values it holds, or else by kind.
+func fieldErrors(err error, kind problemKind) []problems.FieldError {
+	var errs []problems.FieldError
+
+	for _, fe := range collectFieldErrors(err, nil) {
+		k, _ := problemKindOf(fe.Err)
+		errs = append(errs, problems.FieldError{Field: fe.Field, Code: k.code, Message: fe.Err.Error()})
+	}
+
+	if len(errs) == 0 && kind.field != "" {
+		errs = append(errs, problems.FieldError{Field: kind.field, Code: kind.code, Message: kind.err.Error()})
+	}
+
+	return errs
+}
+
+// collectFieldErrors appends the services.FieldError values in the tree of
+// err to found.
+func collectFieldErrors(err error, found []services.FieldError) []services.FieldError {
+	switch e := err.(type) {
+	case services.FieldError:
+		return append(found, e)
+	case interface{ Unwrap() []error }:
+		for _, wrapped := range e.Unwrap() {
+			found = collectFieldErrors(wrapped, found)
+		}
+	case interface{ Unwrap() error }:
+		found = collectFieldErrors(e.Unwrap(), found)
+	}
+	return found
+}
+
+// bodyError returns the error a request body that could not be bound is
+// reported with, naming the field of the wrong type if there is one.
+func bodyError(err error) error {
+	var te *json.UnmarshalTypeError
+	if errors.As(err, &te) && te.Field != "" {
+		return services.FieldError{Field: te.Field, Err: ErrInvalidField}
+	}
+	return ErrInvalidBody
+}
+
+// errorStatus returns the status err is reported with and the message shown
+// to clients, which is withheld for unexpected errors.
+func errorStatus(err error) (int, string) {
+	if kind, ok := problemKindOf(err); ok {
+		return kind.status, err.Error()
+	}
+	return http.StatusInternalServerError, INTERNAL_ERROR
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/problems"
+	"github.com/ffardo/user-crud/routes"
+	"github.com/ffardo/user-crud/services"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func problemOf(t *testing.T, err error) (*httptest.ResponseRecorder, problems.Problem) {
+	gin.SetMode("test")
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+
+	handleError(ctx, err)
+
+	var problem problems.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	return w, problem
+}
+
+func TestHandleError(t *testing.T) {
+	w, problem := problemOf(t, services.ErrEmailRegistered)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, problems.CONTENT_TYPE, w.Header().Get("Content-Type"))
+	assert.Equal(t, problems.Type("email-registered"), problem.Type)
+	assert.Equal(t, "Email already registered", problem.Title)
+	assert.Equal(t, http.StatusConflict, problem.Status)
+	assert.Empty(t, problem.Detail)
+	assert.Equal(t, w.Header().Get(problems.TRACE_HEADER), problem.TraceID)
+	assert.Equal(t, []problems.FieldError{{Field: "email", Code: "email-registered", Message: "Email already registered"}}, problem.Errors)
+}
+
+func TestHandleFieldError(t *testing.T) {
+	w, problem := problemOf(t, services.FieldError{Field: "nickname", Err: ErrUnknownField})
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, "Unknown field", problem.Title)
+	assert.Equal(t, "Unknown field: nickname", problem.Detail)
+	assert.Equal(t, []problems.FieldError{{Field: "nickname", Code: "unknown-field", Message: "Unknown field"}}, problem.Errors)
+}
+
+func TestHandleUnexpectedError(t *testing.T) {
+	w, problem := problemOf(t, errors.New("connection string has password=secret"))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "about:blank", problem.Type)
+	assert.Equal(t, "Internal Server Error", problem.Title)
+	assert.Empty(t, problem.Detail)
+	assert.Equal(t, []problems.FieldError{}, problem.Errors)
+}
+
+func TestProblemsForEveryFailure(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	router := routes.InitRouter(&UserController{us}, routes.TenantAuth("test_key", nil))
+
+	cases := []struct {
+		method string
+		url    string
+		key    string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/api/users/", "test_key", `{"name": "John`, http.StatusBadRequest},
+		{http.MethodPost, "/api/users/", "test_key", `{"name": 42}`, http.StatusUnprocessableEntity},
+		{http.MethodGet, "/api/users/", "other_key", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/nothing", "test_key", "", http.StatusNotFound},
+		{http.MethodPost, "/api/users:unknown", "test_key", "", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(c.method, c.url, bytes.NewBufferString(c.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-KEY", c.key)
+		router.ServeHTTP(w, req)
+
+		var problem problems.Problem
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem), c.url)
+		assert.Equal(t, c.status, w.Code, c.body)
+		assert.Equal(t, c.status, problem.Status, c.body)
+		assert.Equal(t, problems.CONTENT_TYPE, w.Header().Get("Content-Type"), c.url)
+		assert.NotEmpty(t, problem.TraceID, c.url)
+	}
+}
+
+func TestPostBodyFieldError(t *testing.T) {
+	err := bodyError(json.Unmarshal([]byte(`{"name": 42}`), &UserRequest{}))
+
+	assert.Equal(t, services.FieldError{Field: "name", Err: ErrInvalidField}, err)
+	assert.Equal(t, ErrInvalidBody, bodyError(json.Unmarshal([]byte(`{`), &UserRequest{})))
+}
+
+func TestHandleValidationError(t *testing.T) {
+	err := services.ValidationError{
+		{Field: "birth_date", Err: services.ErrInvalidDateFormat},
+		{Field: "name", Err: fmt.Errorf("%w (at most 200 characters)", services.ErrFieldTooLong)},
+	}
+
+	w, problem := problemOf(t, err)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, problems.Type("validation-failed"), problem.Type)
+	assert.Equal(t, "Invalid user", problem.Title)
+	assert.Equal(t, []problems.FieldError{
+		{Field: "birth_date", Code: "invalid-date", Message: "Invalid date format"},
+		{Field: "name", Code: "too-long", Message: "Field is too long (at most 200 characters)"},
+	}, problem.Errors)
+}
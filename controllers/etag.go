@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ffardo/user-crud/models"
+)
+
+// etag returns the strong entity tag for the current version of user in r.
+// Each representation has its own tags, so a cache never takes one version's
+// document for the other's.
+func (r representation) etag(user models.User) string {
+	return fmt.Sprintf(`"%s%d"`, r.tagPrefix, user.Version)
+}
+
+// parseETag extracts the version from a single strong entity tag as sent in
+// If-Match, whichever representation it was given with. Weak tags never
+// match for updates.
+func parseETag(tag string) (int64, bool) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+
+	value := tag[1 : len(tag)-1]
+	for _, r := range representations {
+		if r.tagPrefix != "" && strings.HasPrefix(value, r.tagPrefix) {
+			value = strings.TrimPrefix(value, r.tagPrefix)
+			break
+		}
+	}
+
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return version, true
+}
+
+// noneMatch reports whether an If-None-Match header value matches tag, using
+// the weak comparison required for GET.
+func noneMatch(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
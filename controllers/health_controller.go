@@ -0,0 +1,27 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthController answers liveness and readiness probes.
+type HealthController struct {
+	// IsReady reports whether requests can be served.
+	IsReady func() bool
+}
+
+// Live reports that the process is up, whether or not it is ready.
+func (hc HealthController) Live(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (hc HealthController) Ready(ctx *gin.Context) {
+	if !hc.IsReady() {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/routes"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthReadyReflectsState(t *testing.T) {
+	gin.SetMode("test")
+	ready := false
+
+	router := gin.New()
+	routes.InitHealthRoutes(router, HealthController{IsReady: func() bool { return ready }})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	ready = true
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHealthLiveWhileNotReady(t *testing.T) {
+	gin.SetMode("test")
+
+	router := gin.New()
+	routes.InitHealthRoutes(router, HealthController{IsReady: func() bool { return false }})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/health/live", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestUserRoutesUnavailableUntilReady(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	uc := UserController{us}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil), routes.RequireReady(func() bool { return false }))
+
+	req := httptest.NewRequest("GET", "/api/users/d035e79d-ffe9-4ebf-b665-747353b3ea40", nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	us.AssertNotCalled(t, "GetUser")
+}
@@ -0,0 +1,407 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/openapi"
+	"github.com/ffardo/user-crud/problems"
+	"github.com/gin-gonic/gin"
+)
+
+// Security schemes of the OpenAPI document.
+const (
+	API_KEY_SECURITY   = "ApiKey"
+	ADMIN_KEY_SECURITY = "AdminKey"
+)
+
+// DocsController serves the OpenAPI document of the service and a page to
+// browse it.
+type DocsController struct {
+	Document *openapi.Document
+}
+
+func (dc DocsController) Spec(ctx *gin.Context) {
+	ctx.IndentedJSON(http.StatusOK, dc.Document)
+}
+
+func (dc DocsController) UI(ctx *gin.Context) {
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", openapi.UI)
+}
+
+// jsonBody describes a JSON body of the type of v.
+func jsonBody(v interface{}) map[string]interface{} {
+	return map[string]interface{}{openapi.JSON_CONTENT_TYPE: v}
+}
+
+// problem describes a failure answered with problem details.
+func problem(description string) openapi.Reply {
+	return openapi.Reply{
+		Description: description,
+		Content:     map[string]interface{}{problems.CONTENT_TYPE: problems.Problem{}},
+	}
+}
+
+func query(name, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: openapi.String("")}
+}
+
+func header(name, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "header", Description: description, Schema: openapi.String("")}
+}
+
+var listParameters = []openapi.Parameter{
+	query("email", "Users with this email, compared as when registering it"),
+	query("name_prefix", "Users whose name starts with it, case sensitively"),
+	query("created_from", "Users created from this date or RFC 3339 time"),
+	query("created_to", "Users created until this date or RFC 3339 time"),
+	query("birth_date_from", "Users born from this date"),
+	query("birth_date_to", "Users born until this date"),
+	query("sort", "Field to sort by, prefixed with - for descending order"),
+}
+
+// userRoute documents a route of the tenant's users in version, adding what
+// they all share: authentication, the tenant header and the failures of
+// both, and how the version is picked and announced.
+func userRoute(version string, route openapi.Route) openapi.Route {
+	route.Tag = "users"
+	route.Security = API_KEY_SECURITY
+	route.Parameters = append([]openapi.Parameter{
+		header("X-TENANT-ID", "Tenant the service API key acts for"),
+	}, route.Parameters...)
+
+	if version == "" {
+		route.Parameters = append(route.Parameters, header("Accept", "application/vnd.user-crud.v2+json to be served v2 rather than v1"))
+		route.Responses[http.StatusNotAcceptable] = problem("Unsupported API version")
+	}
+
+	if version != interfaces.API_V2 {
+		for status, reply := range route.Responses {
+			if status < http.StatusMultipleChoices {
+				reply.Headers = deprecationHeaders(reply.Headers)
+				route.Responses[status] = reply
+			}
+		}
+	}
+
+	route.Responses[http.StatusUnauthorized] = problem("Unknown API key or tenant")
+	route.Responses[http.StatusForbidden] = problem("Tenant disabled")
+	route.Responses[http.StatusServiceUnavailable] = problem("Storage unavailable")
+	return route
+}
+
+// deprecationHeaders adds the headers announcing the sunset of v1 to
+// headers.
+func deprecationHeaders(headers map[string]string) map[string]string {
+	h := map[string]string{
+		"Deprecation": "When v1 was deprecated, as @ and seconds since the epoch",
+		"Sunset":      "When v1 stops being served",
+	}
+	for name, description := range headers {
+		h[name] = description
+	}
+	return h
+}
+
+// adminRoute documents a route guarded by the admin API key.
+func adminRoute(tag string, route openapi.Route) openapi.Route {
+	route.Tag = tag
+	route.Security = ADMIN_KEY_SECURITY
+	route.Responses[http.StatusUnauthorized] = problem("Wrong admin API key")
+	route.Responses[http.StatusServiceUnavailable] = problem("Storage unavailable")
+	return route
+}
+
+// OpenAPI returns the OpenAPI document of every route the service
+// registers.
+func OpenAPI() *openapi.Document {
+	d := openapi.New(openapi.Info{
+		Title:       "user-crud",
+		Version:     "1.0.0",
+		Description: "Manages the users of each tenant. Failures are answered with RFC 7807 problem details.",
+	})
+
+	d.Components.SecuritySchemes[API_KEY_SECURITY] = openapi.SecurityScheme{
+		Type: "apiKey", In: "header", Name: "X-API-KEY",
+		Description: "The service API key, or a tenant's own key",
+	}
+	d.Components.SecuritySchemes[ADMIN_KEY_SECURITY] = openapi.SecurityScheme{
+		Type: "apiKey", In: "header", Name: "X-ADMIN-KEY",
+		Description: "The admin API key, ADMIN_API_KEY",
+	}
+
+	d.Tags = []openapi.Tag{
+		{Name: "users", Description: "Users of the tenant the request acts for"},
+		{Name: "admin", Description: "Administration of every tenant's users"},
+		{Name: "tenants", Description: "Tenant administration"},
+		{Name: "health", Description: "Probes"},
+		{Name: "docs", Description: "This document"},
+	}
+
+	var routes []openapi.Route
+	routes = append(routes, userRoutes("/api/users", "")...)
+	routes = append(routes, userRoutes("/api/v1/users", interfaces.API_V1)...)
+	routes = append(routes, userRoutes("/api/v2/users", interfaces.API_V2)...)
+
+	for _, route := range append(routes, []openapi.Route{
+		adminRoute("admin", openapi.Route{
+			Method: http.MethodGet, Path: "/api/admin/users/deleted", ID: "listDeletedUsers",
+			Summary: "List deleted users",
+			Responses: map[int]openapi.Reply{
+				http.StatusOK: {Description: "Restorable users, most recently deleted first", Content: jsonBody([]DeletedUserResponse{})},
+			},
+		}),
+		adminRoute("tenants", openapi.Route{
+			Method: http.MethodPost, Path: "/api/admin/tenants/", ID: "createTenant",
+			Summary: "Create tenant",
+			Request: jsonBody(TenantRequest{}),
+			Responses: map[int]openapi.Reply{
+				http.StatusCreated:    {Description: "Tenant created, with its API key", Content: jsonBody(TenantResponse{})},
+				http.StatusBadRequest: problem("Invalid tenant"),
+				http.StatusConflict:   problem("Tenant exists"),
+			},
+		}),
+		adminRoute("tenants", openapi.Route{
+			Method: http.MethodGet, Path: "/api/admin/tenants/", ID: "listTenants",
+			Summary: "List tenants",
+			Responses: map[int]openapi.Reply{
+				http.StatusOK: {Description: "Every tenant", Content: jsonBody([]TenantResponse{})},
+			},
+		}),
+		adminRoute("tenants", openapi.Route{
+			Method: http.MethodGet, Path: "/api/admin/tenants/:id", ID: "getTenant",
+			Summary: "Get tenant",
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:       {Description: "The tenant", Content: jsonBody(TenantResponse{})},
+				http.StatusNotFound: problem("Tenant not found"),
+			},
+		}),
+		adminRoute("tenants", openapi.Route{
+			Method: http.MethodPatch, Path: "/api/admin/tenants/:id", ID: "updateTenant",
+			Summary: "Update tenant",
+			Request: jsonBody(TenantPatchRequest{}),
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:         {Description: "Tenant updated", Content: jsonBody(TenantResponse{})},
+				http.StatusBadRequest: problem("Invalid settings"),
+				http.StatusNotFound:   problem("Tenant not found"),
+			},
+		}),
+		adminRoute("tenants", openapi.Route{
+			Method: http.MethodPost, Path: "/api/admin/tenants/:id/rotate-key", ID: "rotateTenantKey",
+			Summary: "Rotate tenant API key",
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:       {Description: "The new API key; the previous one stops working", Content: jsonBody(APIKeyResponse{})},
+				http.StatusNotFound: problem("Tenant not found"),
+			},
+		}),
+		{
+			Method: http.MethodGet, Path: "/health/live", ID: "live", Tag: "health",
+			Summary: "Liveness probe",
+			Responses: map[int]openapi.Reply{
+				http.StatusOK: {Description: "The process is up", Content: jsonBody(map[string]string{})},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/health/ready", ID: "ready", Tag: "health",
+			Summary: "Readiness probe",
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:                 {Description: "Requests can be served", Content: jsonBody(map[string]string{})},
+				http.StatusServiceUnavailable: {Description: "Storage not ready", Content: jsonBody(map[string]string{})},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/openapi.json", ID: "openapi", Tag: "docs",
+			Summary: "OpenAPI document",
+			Responses: map[int]openapi.Reply{
+				http.StatusOK: {Description: "This document", Content: jsonBody(map[string]interface{}{})},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/docs", ID: "docs", Tag: "docs",
+			Summary: "API reference",
+			Responses: map[int]openapi.Reply{
+				http.StatusOK: {Description: "A page rendering this document", Content: map[string]interface{}{"text/html": ""}},
+			},
+		},
+	}...) {
+		d.Add(route)
+	}
+
+	return d
+}
+
+// userRoutes documents the user endpoints served under prefix in version,
+// or in the version negotiated with Accept when version is empty.
+func userRoutes(prefix, version string) []openapi.Route {
+	etag := map[string]string{"ETag": "Version of the user"}
+	ifMatch := header("If-Match", "ETag the user must still have")
+
+	suffix := strings.ToUpper(version)
+	user := jsonBody(UserResponse{})
+	userRequest := jsonBody(UserRequest{})
+	userList := jsonBody(UserListResponse{})
+	searchResults := jsonBody(SearchResponse{})
+
+	if version == interfaces.API_V2 {
+		user = jsonBody(UserResponseV2{})
+		userRequest = jsonBody(UserRequestV2{})
+		userList = jsonBody(UserListResponseV2{})
+		searchResults = jsonBody(SearchResponseV2{})
+	}
+
+	routes := []openapi.Route{
+		userRoute(version, openapi.Route{
+			Method: http.MethodPost, Path: prefix + "/", ID: "createUser" + suffix,
+			Summary: "Create user",
+			Request: userRequest,
+			Responses: map[int]openapi.Reply{
+				http.StatusCreated:    {Description: "User created", Content: user},
+				http.StatusBadRequest: problem("Invalid user"),
+				http.StatusConflict:   problem("Email already registered"),
+			},
+		}),
+		userRoute(version, openapi.Route{
+			Method: http.MethodGet, Path: prefix + "/", ID: "listUsers" + suffix,
+			Summary:     "List users",
+			Description: "Pages through the users, in the order given by sort.",
+			Parameters: append(listParameters,
+				query("cursor", "next_cursor of the previous page"),
+				query("limit", "Users per page"),
+			),
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:         {Description: "A page of users", Content: userList},
+				http.StatusBadRequest: problem("Invalid filter, sort, cursor or limit"),
+			},
+		}),
+		userRoute(version, openapi.Route{
+			Method: http.MethodGet, Path: prefix + "/search", ID: "searchUsers" + suffix,
+			Summary:    "Search users",
+			Parameters: []openapi.Parameter{query("q", "Words to find in names and addresses"), query("limit", "Results returned")},
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:         {Description: "Best matches first", Content: searchResults},
+				http.StatusBadRequest: problem("Invalid query or limit"),
+			},
+		}),
+		userRoute(version, openapi.Route{
+			Method: http.MethodGet, Path: prefix + "/:uuid", ID: "getUser" + suffix,
+			Summary:    "Get user",
+			Parameters: []openapi.Parameter{header("If-None-Match", "ETag the client has")},
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:          {Description: "The user", Headers: etag, Content: user},
+				http.StatusNotModified: {Description: "The user still has the ETag sent", Headers: etag},
+				http.StatusBadRequest:  problem("Invalid UUID"),
+				http.StatusNotFound:    problem("User not found"),
+			},
+		}),
+		userRoute(version, openapi.Route{
+			Method: http.MethodPut, Path: prefix + "/:uuid", ID: "replaceUser" + suffix,
+			Summary:     "Replace user",
+			Description: "Replaces every field of the user, creating it under the UUID of the path when there is none.",
+			Parameters:  []openapi.Parameter{ifMatch, header("If-None-Match", "* to only create the user")},
+			Request:     userRequest,
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:                  {Description: "User replaced", Headers: etag, Content: user},
+				http.StatusCreated:             {Description: "User created", Headers: etag, Content: user},
+				http.StatusBadRequest:          problem("Invalid user"),
+				http.StatusConflict:            problem("Email or UUID already in use"),
+				http.StatusPreconditionFailed:  problem("User modified since, missing or already existing"),
+				http.StatusUnprocessableEntity: problem("Unknown or read-only field"),
+			},
+		}),
+		userRoute(version, openapi.Route{
+			Method: http.MethodPatch, Path: prefix + "/:uuid", ID: "updateUser" + suffix,
+			Summary:     "Update user",
+			Description: "Applies a JSON Merge Patch, or a JSON Patch, to the user as Get User returns it.",
+			Parameters:  []openapi.Parameter{ifMatch},
+			Request: map[string]interface{}{
+				openapi.JSON_CONTENT_TYPE: userRequest[openapi.JSON_CONTENT_TYPE],
+				MERGE_PATCH_CONTENT_TYPE:  userRequest[openapi.JSON_CONTENT_TYPE],
+				JSON_PATCH_CONTENT_TYPE:   []map[string]interface{}{},
+			},
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:                   {Description: "User updated", Headers: etag, Content: user},
+				http.StatusBadRequest:           problem("Invalid user or patch"),
+				http.StatusNotFound:             problem("User not found"),
+				http.StatusConflict:             problem("Email registered, patch test failed or concurrent update"),
+				http.StatusPreconditionFailed:   problem("User modified since"),
+				http.StatusUnsupportedMediaType: {Description: "Unsupported patch format", Headers: map[string]string{"Accept-Patch": ACCEPT_PATCH}},
+				http.StatusUnprocessableEntity:  problem("Unknown or read-only field, or missing patch path"),
+			},
+		}),
+		userRoute(version, openapi.Route{
+			Method: http.MethodDelete, Path: prefix + "/:uuid", ID: "deleteUser" + suffix,
+			Summary: "Delete user",
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:         {Description: "User deleted, and restorable until purged"},
+				http.StatusBadRequest: problem("Invalid UUID"),
+				http.StatusNotFound:   problem("User not found"),
+			},
+		}),
+		userRoute(version, openapi.Route{
+			Method: http.MethodPost, Path: prefix + "/:uuid/restore", ID: "restoreUser" + suffix,
+			Summary: "Restore user",
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:         {Description: "User restored", Headers: etag, Content: user},
+				http.StatusBadRequest: problem("Invalid UUID"),
+				http.StatusNotFound:   problem("No deleted user"),
+				http.StatusConflict:   problem("Email registered by another user since"),
+			},
+		}),
+	}
+
+	// Batches, imports and exports are not served in v2 yet.
+	if version == interfaces.API_V2 {
+		return routes
+	}
+
+	return append(routes,
+		userRoute(version, openapi.Route{
+			Method: http.MethodPost, Path: prefix + "/import", ID: "importUsers" + suffix,
+			Summary: "Import users",
+			Parameters: []openapi.Parameter{
+				query("columns", "Input columns or keys holding the user fields, as column:field pairs separated by commas, such as full_name:name,dob:birth_date"),
+				query("dry_run", "Validate the users without creating them"),
+			},
+			Request: map[string]interface{}{
+				formatContentTypes[FORMAT_NDJSON]: "",
+				formatContentTypes[FORMAT_CSV]:    "",
+			},
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:                   {Description: "Outcome of the import", Content: jsonBody(ImportResponse{})},
+				http.StatusBadRequest:           problem("Invalid import"),
+				http.StatusUnsupportedMediaType: problem("Unsupported format"),
+			},
+		}),
+		userRoute(version, openapi.Route{
+			Method: http.MethodGet, Path: prefix + "/export", ID: "exportUsers" + suffix,
+			Summary:    "Export users",
+			Parameters: append(listParameters, query("format", "ndjson or csv, chosen by the Accept header when missing")),
+			Responses: map[int]openapi.Reply{
+				http.StatusOK: {
+					Description: "Every user matching the filters",
+					Headers:     map[string]string{"Trailer": EXPORT_ERROR_TRAILER},
+					Content: map[string]interface{}{
+						formatContentTypes[FORMAT_NDJSON]: "",
+						formatContentTypes[FORMAT_CSV]:    "",
+					},
+				},
+				http.StatusBadRequest:           problem("Invalid filter or sort"),
+				http.StatusUnsupportedMediaType: problem("Unsupported format"),
+			},
+		}),
+		userRoute(version, openapi.Route{
+			Method: http.MethodPost, Path: prefix + ":batch", ID: "batchUsers" + suffix,
+			Summary:     "Batch users",
+			Description: "Creates, updates and deletes users in one request, all or none of them when atomic.",
+			Request:     jsonBody(BatchRequest{}),
+			Responses: map[int]openapi.Reply{
+				http.StatusOK:                    {Description: "Every operation succeeded", Content: jsonBody(BatchResponse{})},
+				http.StatusMultiStatus:           {Description: "Some operations failed", Content: jsonBody(BatchResponse{})},
+				http.StatusBadRequest:            problem("Invalid batch"),
+				http.StatusRequestEntityTooLarge: problem("Too many operations"),
+				http.StatusFailedDependency:      problem("Atomic batch aborted"),
+			},
+		}),
+	)
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/openapi"
+	"github.com/ffardo/user-crud/routes"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// customMethods are the custom methods served by the /api/users:method
+// route.
+var customMethods = []string{":batch"}
+
+// registeredOperations lists the method and OpenAPI path of every route
+// the service registers.
+func registeredOperations() []string {
+	gin.SetMode("test")
+
+	uc := UserController{new(mocks.UserService)}
+	r := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+	routes.InitAdminRoutes(r, &uc, "admin_key")
+	routes.InitTenantAdminRoutes(r, &TenantController{}, "admin_key")
+	routes.InitHealthRoutes(r, HealthController{})
+	routes.InitDocsRoutes(r, DocsController{})
+
+	seen := map[string]bool{}
+	for _, route := range r.Routes() {
+		paths := []string{route.Path}
+		if strings.HasSuffix(route.Path, ":method") {
+			paths = nil
+			for _, m := range customMethods {
+				paths = append(paths, strings.TrimSuffix(route.Path, ":method")+m)
+			}
+		}
+
+		// Routes with and without a trailing slash are the same
+		// operation, gin redirecting between them.
+		for _, p := range paths {
+			seen[route.Method+" "+openapi.Path(p)] = true
+		}
+	}
+
+	operations := make([]string, 0, len(seen))
+	for o := range seen {
+		operations = append(operations, o)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	assert.Equal(t, registeredOperations(), OpenAPI().Operations())
+}
+
+func TestOpenAPIOperationIDsAreUnique(t *testing.T) {
+	ids := map[string]string{}
+
+	for path, item := range OpenAPI().Paths {
+		for method, op := range item {
+			assert.NotEmpty(t, op.OperationID, "%s %s", method, path)
+			assert.NotContains(t, ids, op.OperationID, "%s %s", method, path)
+			ids[op.OperationID] = method + " " + path
+		}
+	}
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	gin.SetMode("test")
+
+	router := gin.New()
+	routes.InitDocsRoutes(router, DocsController{Document: OpenAPI()})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, openapi.VERSION, doc["openapi"])
+	assert.Contains(t, doc["paths"], "/api/users/{uuid}")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), "/openapi.json")
+}
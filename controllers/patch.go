@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/patch"
+	"github.com/ffardo/user-crud/services"
+)
+
+var ErrUnknownField = errors.New("Unknown field")
+var ErrReadOnlyField = errors.New("Read-only field")
+var ErrInvalidField = errors.New("Invalid field")
+var ErrClearedField = errors.New("Field cannot be cleared")
+
+// Content types of the patches users are updated with. Plain JSON is taken
+// as a merge patch, as updates were sent before patches were supported.
+const (
+	MERGE_PATCH_CONTENT_TYPE = "application/merge-patch+json"
+	JSON_PATCH_CONTENT_TYPE  = "application/json-patch+json"
+)
+
+// ACCEPT_PATCH lists the patch content types, for the Accept-Patch header.
+const ACCEPT_PATCH = MERGE_PATCH_CONTENT_TYPE + ", " + JSON_PATCH_CONTENT_TYPE
+
+// READ_ONLY_FIELDS are the fields of the user document patches cannot
+// change.
+var READ_ONLY_FIELDS = []string{"uuid"}
+
+// UNCLEARABLE_FIELDS are the fields patches can change but not clear,
+// whatever the validation rules allow: an empty password would be stored
+// as the hash of the empty string.
+var UNCLEARABLE_FIELDS = []string{"password"}
+
+// patchFunc returns the function applying patches of contentType to a
+// document.
+func patchFunc(contentType string) (func(doc, patch []byte) ([]byte, error), error) {
+	switch contentType {
+	case MERGE_PATCH_CONTENT_TYPE, "application/json":
+		return patch.Merge, nil
+	case JSON_PATCH_CONTENT_TYPE:
+		return patch.Apply, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// patchParams applies a patch to the document of user in rep, and returns
+// the update params for the fields it changed. Fields removed, or set to
+// null, are cleared.
+func patchParams(rep representation, user models.User, apply func(doc, patch []byte) ([]byte, error), p []byte) (map[string]string, error) {
+	doc, err := json.Marshal(rep.user(user))
+	if err != nil {
+		return nil, err
+	}
+
+	patched, err := apply(doc, p)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := rep.fields(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := rep.fields(patched)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fields the document does not hold, such as the v2 password, can be
+	// added.
+	keys := map[string]bool{}
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	params := map[string]string{}
+
+	for key := range keys {
+		value, previous := after[key], before[key]
+		if value == previous {
+			continue
+		}
+
+		if rep.isReadOnly(key) {
+			return nil, services.FieldError{Field: key, Err: ErrReadOnlyField}
+		}
+
+		if value == "" && contains(UNCLEARABLE_FIELDS, key) {
+			return nil, services.FieldError{Field: key, Err: ErrClearedField}
+		}
+
+		params[key] = value
+	}
+
+	return params, nil
+}
+
+// documentFields returns the fields of a user document, null ones as empty
+// strings. Only the fields of UserResponse are accepted.
+func documentFields(doc []byte) (map[string]string, error) {
+	var values map[string]interface{}
+	if err := json.Unmarshal(doc, &values); err != nil {
+		return nil, fmt.Errorf("%w: user is not an object", ErrInvalidField)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := map[string]string{}
+
+	for _, key := range keys {
+		if !isImportField(key) && !isReadOnlyField(key) {
+			return nil, services.FieldError{Field: key, Err: ErrUnknownField}
+		}
+
+		switch v := values[key].(type) {
+		case string:
+			fields[key] = v
+		case nil:
+			fields[key] = ""
+		default:
+			return nil, services.FieldError{Field: key, Err: ErrInvalidField}
+		}
+	}
+
+	return fields, nil
+}
+
+func isReadOnlyField(name string) bool {
+	for _, f := range READ_ONLY_FIELDS {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
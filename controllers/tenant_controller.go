@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/gin-gonic/gin"
+)
+
+type TenantController struct {
+	interfaces.TenantService
+}
+
+type TenantRequest struct {
+	ID       string                 `json:"id"`
+	Name     string                 `json:"name"`
+	Settings *models.TenantSettings `json:"settings"`
+}
+
+// TenantPatchRequest changes the fields present in the request.
+type TenantPatchRequest struct {
+	Name     *string                `json:"name"`
+	Settings *models.TenantSettings `json:"settings"`
+}
+
+type TenantResponse struct {
+	ID       string                `json:"id"`
+	Name     string                `json:"name"`
+	Settings models.TenantSettings `json:"settings"`
+	Created  time.Time             `json:"created"`
+	Updated  time.Time             `json:"updated"`
+	// APIKey is only returned when the key is created or rotated.
+	APIKey string `json:"api_key,omitempty"`
+}
+
+type APIKeyResponse struct {
+	APIKey string `json:"api_key"`
+}
+
+func newTenantResponse(tenant models.Tenant) TenantResponse {
+	settings := tenant.Settings
+	if settings.EmailDomains == nil {
+		settings.EmailDomains = []string{}
+	}
+
+	return TenantResponse{
+		ID:       tenant.ID,
+		Name:     tenant.Name,
+		Settings: settings,
+		Created:  tenant.Created,
+		Updated:  tenant.Updated,
+	}
+}
+
+func (c *TenantController) Post(ctx *gin.Context) {
+	var params TenantRequest
+
+	if err := ctx.ShouldBindJSON(&params); err != nil {
+		handleError(ctx, bodyError(err))
+		return
+	}
+
+	var settings models.TenantSettings
+	if params.Settings != nil {
+		settings = *params.Settings
+	}
+
+	tenant, apiKey, err := c.CreateTenant(params.ID, params.Name, settings)
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	r := newTenantResponse(tenant)
+	r.APIKey = apiKey
+
+	ctx.IndentedJSON(http.StatusCreated, r)
+}
+
+func (c *TenantController) List(ctx *gin.Context) {
+	tenants, err := c.ListTenants()
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	r := make([]TenantResponse, 0, len(tenants))
+	for _, tenant := range tenants {
+		r = append(r, newTenantResponse(tenant))
+	}
+
+	ctx.IndentedJSON(http.StatusOK, r)
+}
+
+func (c *TenantController) Get(ctx *gin.Context) {
+	tenant, err := c.GetTenant(ctx.Param("id"))
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, newTenantResponse(tenant))
+}
+
+func (c *TenantController) Patch(ctx *gin.Context) {
+	var params TenantPatchRequest
+
+	if err := ctx.ShouldBindJSON(&params); err != nil {
+		handleError(ctx, bodyError(err))
+		return
+	}
+
+	tenant, err := c.UpdateTenant(ctx.Param("id"), params.Name, params.Settings)
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, newTenantResponse(tenant))
+}
+
+func (c *TenantController) RotateKey(ctx *gin.Context) {
+	apiKey, err := c.RotateAPIKey(ctx.Param("id"))
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, APIKeyResponse{APIKey: apiKey})
+}
@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/routes"
+	"github.com/ffardo/user-crud/services"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTenantRouter(ts *mocks.TenantService) *gin.Engine {
+	gin.SetMode("test")
+	tc := TenantController{ts}
+	router := gin.New()
+	routes.InitTenantAdminRoutes(router, &tc, "admin_key")
+	return router
+}
+
+func TestCreateTenant(t *testing.T) {
+	ts := new(mocks.TenantService)
+	router := newTenantRouter(ts)
+
+	settings := models.TenantSettings{EmailDomains: []string{"acme.com"}}
+	ts.On("CreateTenant", "acme", "Acme", settings).Return(models.Tenant{ID: "acme", Name: "Acme", Settings: settings}, "secret", nil)
+
+	body, _ := json.Marshal(TenantRequest{ID: "acme", Name: "Acme", Settings: &settings})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/admin/tenants/", bytes.NewReader(body))
+	req.Header.Set("X-ADMIN-KEY", "admin_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var res TenantResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "acme", res.ID)
+	assert.Equal(t, "secret", res.APIKey)
+	assert.Equal(t, []string{"acme.com"}, res.Settings.EmailDomains)
+}
+
+func TestCreateTenantExists(t *testing.T) {
+	ts := new(mocks.TenantService)
+	router := newTenantRouter(ts)
+
+	ts.On("CreateTenant", "acme", "Acme", models.TenantSettings{}).Return(models.Tenant{}, "", services.ErrTenantExists)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/admin/tenants/", bytes.NewReader([]byte(`{"id":"acme","name":"Acme"}`)))
+	req.Header.Set("X-ADMIN-KEY", "admin_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestGetTenantRequiresAdminKey(t *testing.T) {
+	ts := new(mocks.TenantService)
+	router := newTenantRouter(ts)
+
+	ts.On("GetTenant", "acme").Return(models.Tenant{}, services.ErrTenantNotFound)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/admin/tenants/acme", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/api/admin/tenants/acme", nil)
+	req.Header.Set("X-ADMIN-KEY", "admin_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPatchTenantSettings(t *testing.T) {
+	ts := new(mocks.TenantService)
+	router := newTenantRouter(ts)
+
+	settings := models.TenantSettings{Disabled: true}
+	ts.On("UpdateTenant", "acme", (*string)(nil), &settings).Return(models.Tenant{ID: "acme", Settings: settings}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPatch, "/api/admin/tenants/acme", bytes.NewReader([]byte(`{"settings":{"disabled":true}}`)))
+	req.Header.Set("X-ADMIN-KEY", "admin_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res TenantResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.True(t, res.Settings.Disabled)
+	assert.Empty(t, res.APIKey)
+}
+
+func TestRotateTenantKey(t *testing.T) {
+	ts := new(mocks.TenantService)
+	router := newTenantRouter(ts)
+
+	ts.On("RotateAPIKey", "acme").Return("new_secret", nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/admin/tenants/acme/rotate-key", nil)
+	req.Header.Set("X-ADMIN-KEY", "admin_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"api_key":"new_secret"}`, w.Body.String())
+}
+
+// runTenantRequest gets a user with the given headers, answering with the
+// user of whatever tenant the request was authenticated for.
+func runTenantRequest(t *testing.T, ts *mocks.TenantService, headers map[string]string) (*httptest.ResponseRecorder, *mocks.UserService) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", mock.Anything).Return(us)
+	us.On("GetUser", mock.Anything).Return(models.User{}, nil)
+
+	uc := UserController{us}
+
+	var tenants interfaces.TenantService
+	if ts != nil {
+		tenants = ts
+	}
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", tenants))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/users/d035e79d-ffe9-4ebf-b665-747353b3ea40", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	router.ServeHTTP(w, req)
+
+	return w, us
+}
+
+func TestTenantAuthWithTenantKey(t *testing.T) {
+	ts := new(mocks.TenantService)
+	tenant := models.Tenant{ID: "acme"}
+	ts.On("Authenticate", "acme_key").Return(tenant, nil)
+
+	w, us := runTenantRequest(t, ts, map[string]string{"X-API-KEY": "acme_key"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	us.AssertCalled(t, "ForTenant", tenant)
+}
+
+func TestTenantAuthWithServiceKeyAndHeader(t *testing.T) {
+	ts := new(mocks.TenantService)
+	tenant := models.Tenant{ID: "acme"}
+	ts.On("GetTenant", "acme").Return(tenant, nil)
+
+	w, us := runTenantRequest(t, ts, map[string]string{"X-API-KEY": "test_key", "X-TENANT-ID": "acme"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	us.AssertCalled(t, "ForTenant", tenant)
+}
+
+func TestTenantAuthDefaultTenant(t *testing.T) {
+	w, us := runTenantRequest(t, new(mocks.TenantService), map[string]string{"X-API-KEY": "test_key"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	us.AssertCalled(t, "ForTenant", models.Tenant{})
+}
+
+func TestTenantAuthRejects(t *testing.T) {
+	ts := new(mocks.TenantService)
+	ts.On("Authenticate", "wrong").Return(models.Tenant{}, services.ErrInvalidAPIKey)
+	ts.On("Authenticate", "acme_key").Return(models.Tenant{ID: "acme"}, nil)
+	ts.On("Authenticate", "disabled_key").Return(models.Tenant{ID: "old", Settings: models.TenantSettings{Disabled: true}}, nil)
+	ts.On("GetTenant", "missing").Return(models.Tenant{}, services.ErrTenantNotFound)
+
+	cases := []struct {
+		headers map[string]string
+		status  int
+	}{
+		{map[string]string{"X-API-KEY": "wrong"}, http.StatusUnauthorized},
+		{map[string]string{"X-API-KEY": "acme_key", "X-TENANT-ID": "other"}, http.StatusUnauthorized},
+		{map[string]string{"X-API-KEY": "disabled_key"}, http.StatusForbidden},
+		{map[string]string{"X-API-KEY": "test_key", "X-TENANT-ID": "missing"}, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		w, us := runTenantRequest(t, ts, c.headers)
+		assert.Equal(t, c.status, w.Code, c.headers)
+		us.AssertNotCalled(t, "GetUser", mock.Anything)
+	}
+
+	w, _ := runTenantRequest(t, nil, map[string]string{"X-API-KEY": "test_key", "X-TENANT-ID": "acme"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/services"
+)
+
+var ErrInvalidColumns = errors.New("Invalid column mapping")
+var ErrUnsupportedFormat = errors.New("Unsupported format")
+var ErrInvalidImport = errors.New("Invalid import parameters")
+
+// Formats users are imported and exported in.
+const (
+	FORMAT_NDJSON = "ndjson"
+	FORMAT_CSV    = "csv"
+)
+
+var formatContentTypes = map[string]string{
+	FORMAT_NDJSON: "application/x-ndjson",
+	FORMAT_CSV:    "text/csv",
+}
+
+// IMPORT_FIELDS are the fields imported records may set.
+var IMPORT_FIELDS = []string{"name", "birth_date", "email", "address", "password"}
+
+// EXPORT_COLUMNS are the columns of CSV exports, named as the fields of
+// ExportedUser.
+var EXPORT_COLUMNS = []string{"uuid", "name", "birth_date", "email", "address"}
+
+// ExportedUser is a user as exported: as UserResponse, but without the
+// password hash, which importing would take for a password and hash again.
+type ExportedUser struct {
+	UUID      string `json:"uuid"`
+	Name      string `json:"name"`
+	BirthDate string `json:"birth_date"`
+	Email     string `json:"email"`
+	Address   string `json:"address"`
+}
+
+func newExportedUser(user models.User) ExportedUser {
+	res := newUserResponse(user)
+
+	return ExportedUser{
+		UUID:      res.UUID,
+		Name:      res.Name,
+		BirthDate: res.BirthDate,
+		Email:     res.Email,
+		Address:   res.Address,
+	}
+}
+
+// contentFormat returns the format of a content type, accepting the
+// alternative names NDJSON goes by.
+func contentFormat(contentType string) (string, error) {
+	switch contentType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return FORMAT_NDJSON, nil
+	case "text/csv":
+		return FORMAT_CSV, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// parseColumns parses a column mapping such as "full_name:name,dob:birth_date",
+// which names the field each input column or key holds. Inputs not mapped
+// keep their own name.
+func parseColumns(value string) (map[string]string, error) {
+	columns := map[string]string{}
+	if value == "" {
+		return columns, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		from, to, ok := strings.Cut(pair, ":")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || !isImportField(to) {
+			return nil, ErrInvalidColumns
+		}
+		columns[from] = to
+	}
+
+	return columns, nil
+}
+
+func isImportField(name string) bool {
+	for _, f := range IMPORT_FIELDS {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// field returns the field an input column holds, if any.
+func field(columns map[string]string, name string) (string, bool) {
+	if to, ok := columns[name]; ok {
+		return to, true
+	}
+	return name, isImportField(name)
+}
+
+func newUserReader(format string, r io.Reader, columns map[string]string) (interfaces.UserReader, error) {
+	switch format {
+	case FORMAT_NDJSON:
+		return &ndjsonReader{scanner: newLineScanner(r), columns: columns}, nil
+	case FORMAT_CSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		return &csvReader{reader: reader, columns: columns}, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// MAX_IMPORT_LINE bounds the length of an NDJSON line.
+const MAX_IMPORT_LINE = 1 << 20
+
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), MAX_IMPORT_LINE)
+	return scanner
+}
+
+// ndjsonReader reads a JSON object of string values per line. Blank lines
+// are skipped.
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	columns map[string]string
+	line    int
+}
+
+func (n *ndjsonReader) Next() (interfaces.ImportRecord, error) {
+	for n.scanner.Scan() {
+		n.line++
+
+		line := bytes.TrimSpace(n.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		record := interfaces.ImportRecord{Line: n.line, Params: map[string]string{}}
+
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(line, &values); err != nil {
+			record.Err = fmt.Errorf("%w: not a JSON object", services.ErrInvalidRecord)
+			return record, nil
+		}
+
+		for key, raw := range values {
+			name, ok := field(n.columns, key)
+			if !ok {
+				continue
+			}
+
+			var value string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				record.Err = fmt.Errorf("%w: %s is not a string", services.ErrInvalidRecord, key)
+				break
+			}
+			record.Params[name] = value
+		}
+
+		return record, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return interfaces.ImportRecord{}, err
+	}
+
+	return interfaces.ImportRecord{}, io.EOF
+}
+
+// csvReader reads CSV records whose first row names the columns.
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]string
+	// fields holds the field of each column, empty for ignored columns.
+	fields []string
+}
+
+func (c *csvReader) Next() (interfaces.ImportRecord, error) {
+	if c.fields == nil {
+		header, err := c.reader.Read()
+		if err != nil {
+			return interfaces.ImportRecord{}, err
+		}
+
+		c.fields = make([]string, len(header))
+		for i, column := range header {
+			if name, ok := field(c.columns, strings.TrimSpace(column)); ok {
+				c.fields[i] = name
+			}
+		}
+	}
+
+	row, err := c.reader.Read()
+
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return interfaces.ImportRecord{Line: pe.StartLine, Err: fmt.Errorf("%w: %v", services.ErrInvalidRecord, pe.Err)}, nil
+	}
+	if err != nil {
+		return interfaces.ImportRecord{}, err
+	}
+
+	line, _ := c.reader.FieldPos(0)
+	record := interfaces.ImportRecord{Line: line, Params: map[string]string{}}
+
+	if len(row) != len(c.fields) {
+		record.Err = fmt.Errorf("%w: %d columns, expected %d", services.ErrInvalidRecord, len(row), len(c.fields))
+		return record, nil
+	}
+
+	for i, value := range row {
+		if c.fields[i] != "" {
+			record.Params[c.fields[i]] = value
+		}
+	}
+
+	return record, nil
+}
+
+// userWriter writes users in an export format.
+type userWriter interface {
+	Write(ExportedUser) error
+	// Flush writes out anything buffered.
+	Flush() error
+}
+
+func newUserWriter(format string, w io.Writer) userWriter {
+	if format == FORMAT_CSV {
+		return &csvWriter{writer: csv.NewWriter(w)}
+	}
+	return &ndjsonWriter{encoder: json.NewEncoder(w)}
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(user ExportedUser) error {
+	return n.encoder.Encode(user)
+}
+
+func (n *ndjsonWriter) Flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	writer      *csv.Writer
+	wroteHeader bool
+}
+
+func (c *csvWriter) Write(user ExportedUser) error {
+	if err := c.header(); err != nil {
+		return err
+	}
+
+	return c.writer.Write([]string{user.UUID, user.Name, user.BirthDate, user.Email, user.Address})
+}
+
+// Flush writes the header too, so an export of no users still has one.
+func (c *csvWriter) Flush() error {
+	if err := c.header(); err != nil {
+		return err
+	}
+
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func (c *csvWriter) header() error {
+	if c.wroteHeader {
+		return nil
+	}
+	c.wroteHeader = true
+	return c.writer.Write(EXPORT_COLUMNS)
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAll(t *testing.T, reader interfaces.UserReader) []interfaces.ImportRecord {
+	records := []interfaces.ImportRecord{}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records
+		}
+		assert.NoError(t, err)
+		records = append(records, record)
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	columns, err := parseColumns("full_name:name, dob : birth_date")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"full_name": "name", "dob": "birth_date"}, columns)
+
+	for _, invalid := range []string{"full_name", ":name", "full_name:uuid"} {
+		_, err := parseColumns(invalid)
+		assert.ErrorIs(t, err, ErrInvalidColumns, invalid)
+	}
+}
+
+func TestNDJSONReader(t *testing.T) {
+	input := `{"full_name": "John Doe", "email": "joe@mail.com", "id": 7}
+
+not json
+{"full_name": "Mary Doe", "email": 42}
+`
+	reader, _ := newUserReader(FORMAT_NDJSON, strings.NewReader(input), map[string]string{"full_name": "name"})
+
+	records := readAll(t, reader)
+
+	assert.Len(t, records, 3)
+	assert.Equal(t, interfaces.ImportRecord{Line: 1, Params: map[string]string{"name": "John Doe", "email": "joe@mail.com"}}, records[0])
+	assert.Equal(t, 3, records[1].Line)
+	assert.ErrorIs(t, records[1].Err, services.ErrInvalidRecord)
+	assert.Equal(t, 4, records[2].Line)
+	assert.EqualError(t, records[2].Err, "Invalid record: email is not a string")
+}
+
+func TestCSVReader(t *testing.T) {
+	input := "Full Name,email,ignored\n" +
+		"John Doe,joe@mail.com,x\n" +
+		"\"Mary\nDoe\",mary@mail.com,y\n" +
+		"Ann Doe,ann@mail.com\n"
+
+	reader, _ := newUserReader(FORMAT_CSV, strings.NewReader(input), map[string]string{"Full Name": "name"})
+
+	records := readAll(t, reader)
+
+	assert.Len(t, records, 3)
+	assert.Equal(t, interfaces.ImportRecord{Line: 2, Params: map[string]string{"name": "John Doe", "email": "joe@mail.com"}}, records[0])
+	assert.Equal(t, interfaces.ImportRecord{Line: 3, Params: map[string]string{"name": "Mary\nDoe", "email": "mary@mail.com"}}, records[1])
+	assert.Equal(t, 5, records[2].Line)
+	assert.EqualError(t, records[2].Err, "Invalid record: 2 columns, expected 3")
+}
+
+func TestContentFormat(t *testing.T) {
+	for _, contentType := range []string{"application/x-ndjson", "application/ndjson", "application/jsonl"} {
+		format, err := contentFormat(contentType)
+		assert.NoError(t, err)
+		assert.Equal(t, FORMAT_NDJSON, format)
+	}
+
+	// JSON text sequences start records with a record separator, which
+	// NDJSON lines do not have.
+	_, err := contentFormat("application/json-seq")
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestCSVWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := newUserWriter(FORMAT_CSV, &b)
+
+	assert.NoError(t, w.Write(ExportedUser{UUID: "d035e79d-ffe9-4ebf-b665-747353b3ea40", Name: "Doe, John"}))
+	assert.NoError(t, w.Flush())
+
+	assert.Equal(t, "uuid,name,birth_date,email,address\nd035e79d-ffe9-4ebf-b665-747353b3ea40,\"Doe, John\",,,\n", b.String())
+
+	b.Reset()
+	assert.NoError(t, newUserWriter(FORMAT_CSV, &b).Flush())
+	assert.Equal(t, "uuid,name,birth_date,email,address\n", b.String())
+}
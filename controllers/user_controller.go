@@ -1,14 +1,21 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
 	"github.com/ffardo/user-crud/services"
 	"github.com/gin-gonic/gin"
 )
 
-const DATE_FORMAT = "2006-01-02"
+var ErrInvalidBody = errors.New("Invalid request body")
 
 type UserController struct {
 	interfaces.UserService
@@ -31,40 +38,111 @@ type UserResponse struct {
 	Password  string `json:"password"`
 }
 
-type ErrorResponse struct {
+type DeletedUserResponse struct {
+	UserResponse
+	TenantID  string    `json:"tenant_id,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type UserListResponse struct {
+	Users []UserResponse `json:"users"`
+	// NextCursor is sent as cursor to get the next page. It is omitted on
+	// the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type SearchResultResponse struct {
+	User  UserResponse `json:"user"`
+	Score float64      `json:"score"`
+	// Match is "text" for users found by their words, "fuzzy" for the ones
+	// found despite typos.
+	Match string `json:"match"`
+	// Highlights holds the matching fields, HTML escaped, with the matching
+	// words wrapped in <em> tags.
+	Highlights map[string]string `json:"highlights"`
+}
+
+type SearchResponse struct {
+	Results []SearchResultResponse `json:"results"`
+}
+
+type BatchOperationRequest struct {
+	// Op is create, update or delete.
+	Op   string `json:"op"`
+	UUID string `json:"uuid"`
+	// User holds the fields of the user to create, or the ones to update.
+	User map[string]string `json:"user"`
+}
+
+type BatchRequest struct {
+	// Atomic applies every operation or none.
+	Atomic     bool                    `json:"atomic"`
+	Operations []BatchOperationRequest `json:"operations"`
+}
+
+// BatchResultResponse is the outcome of the operation at the same position,
+// with the status it would have had as a request of its own.
+type BatchResultResponse struct {
+	Status int           `json:"status"`
+	User   *UserResponse `json:"user,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResultResponse `json:"results"`
+}
+
+type ImportErrorResponse struct {
+	Line  int    `json:"line"`
 	Error string `json:"error"`
 }
 
-func handleError(ctx *gin.Context, err error) {
-	r := ErrorResponse{
-		Error: err.Error(),
-	}
-	switch err {
-	case services.ErrEmailRegistered:
-		ctx.IndentedJSON(http.StatusBadRequest, r)
-	case services.ErrInvalidEmailFormat:
-		ctx.IndentedJSON(http.StatusBadRequest, r)
-	case services.ErrInvalidDateFormat:
-		ctx.IndentedJSON(http.StatusBadRequest, r)
-	case services.ErrInvalidUuidFormat:
-		ctx.IndentedJSON(http.StatusBadRequest, r)
-	case services.ErrUserNotFound:
-		ctx.IndentedJSON(http.StatusNotFound, r)
+type ImportResponse struct {
+	DryRun  bool `json:"dry_run"`
+	Records int  `json:"records"`
+	// Imported counts the users created, or that would be on a dry run.
+	Imported int                   `json:"imported"`
+	Failed   int                   `json:"failed"`
+	Errors   []ImportErrorResponse `json:"errors"`
+}
+
+func newUserResponse(user models.User) UserResponse {
+	return UserResponse{
+		UUID:      user.UUID.String(),
+		Name:      user.Name,
+		BirthDate: user.BirthDate.Format(models.DATE_FORMAT),
+		Email:     user.Email,
+		Password:  user.Password,
+		Address:   user.Address,
 	}
+}
 
+// service returns the service restricted to the tenant the request was
+// authenticated for. Administration requests have no tenant and see every
+// tenant's users.
+func (c *UserController) service(ctx *gin.Context) interfaces.UserService {
+	if tenant, ok := ctx.Get(interfaces.TENANT_CONTEXT_KEY); ok {
+		return c.UserService.ForTenant(tenant.(models.Tenant))
+	}
+	return c.UserService
 }
 
 func (c *UserController) Post(ctx *gin.Context) {
+	if apiVersion(ctx) == interfaces.API_V2 {
+		c.postV2(ctx)
+		return
+	}
+
 	var UserParam UserRequest
 
-	err := ctx.BindJSON(&UserParam)
+	err := ctx.ShouldBindJSON(&UserParam)
 
 	if err != nil {
-		handleError(ctx, err)
+		handleError(ctx, bodyError(err))
 		return
 	}
 
-	user, err := c.CreateUser(
+	user, err := c.service(ctx).CreateUser(
 		UserParam.Name,
 		UserParam.BirthDate,
 		UserParam.Email,
@@ -77,76 +155,537 @@ func (c *UserController) Post(ctx *gin.Context) {
 		return
 	}
 
-	r := UserResponse{
-		UUID:      user.UUID.String(),
-		Name:      user.Name,
-		BirthDate: user.BirthDate.Format(DATE_FORMAT),
-		Email:     user.Email,
-		Password:  user.Password,
-		Address:   user.Address,
-	}
+	r := newUserResponse(user)
 
 	ctx.IndentedJSON(http.StatusCreated, r)
 
 }
 
+// postV2 creates a user from a v2 request, whose address is structured.
+func (c *UserController) postV2(ctx *gin.Context) {
+	var request UserRequestV2
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		handleError(ctx, bodyError(err))
+		return
+	}
+
+	user, err := c.service(ctx).CreateUserWithParams(request.params())
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusCreated, newUserResponseV2(user))
+
+}
+
 func (c *UserController) Get(ctx *gin.Context) {
 	uuid := ctx.Param("uuid")
+	inm := ctx.GetHeader("If-None-Match")
 
-	user, err := c.GetUser(uuid)
+	// A client revalidating its copy must not be told it is current by a
+	// stale cache.
+	get := c.service(ctx).GetUser
+	if inm != "" {
+		get = c.service(ctx).GetCurrentUser
+	}
+
+	user, err := get(uuid)
 
 	if err != nil {
 		handleError(ctx, err)
 		return
 	}
 
-	r := UserResponse{
-		UUID:      user.UUID.String(),
-		Name:      user.Name,
-		BirthDate: user.BirthDate.Format(DATE_FORMAT),
-		Email:     user.Email,
-		Password:  user.Password,
-		Address:   user.Address,
+	rep := representationOf(ctx)
+	tag := rep.etag(user)
+	ctx.Header("ETag", tag)
+
+	if inm != "" && noneMatch(inm, tag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	ctx.IndentedJSON(200, rep.user(user))
+
+}
+
+// listRequest reads the filters and sort order of a listing.
+func listRequest(ctx *gin.Context) interfaces.ListUsersRequest {
+	return interfaces.ListUsersRequest{
+		Email:         ctx.Query("email"),
+		NamePrefix:    ctx.Query("name_prefix"),
+		CreatedFrom:   ctx.Query("created_from"),
+		CreatedTo:     ctx.Query("created_to"),
+		BirthDateFrom: ctx.Query("birth_date_from"),
+		BirthDateTo:   ctx.Query("birth_date_to"),
+		Sort:          ctx.Query("sort"),
+	}
+}
+
+func (c *UserController) List(ctx *gin.Context) {
+	request := listRequest(ctx)
+	request.Cursor = ctx.Query("cursor")
+
+	if limit := ctx.Query("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 {
+			handleError(ctx, services.ErrInvalidPageSize)
+			return
+		}
+		request.Limit = l
+	}
+
+	page, err := c.service(ctx).ListUsers(request)
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	if apiVersion(ctx) == interfaces.API_V2 {
+		r := UserListResponseV2{
+			Users:      make([]UserResponseV2, 0, len(page.Users)),
+			NextCursor: page.NextCursor,
+		}
+		for _, user := range page.Users {
+			r.Users = append(r.Users, newUserResponseV2(user))
+		}
+
+		ctx.IndentedJSON(http.StatusOK, r)
+		return
+	}
+
+	r := UserListResponse{
+		Users:      make([]UserResponse, 0, len(page.Users)),
+		NextCursor: page.NextCursor,
+	}
+	for _, user := range page.Users {
+		r.Users = append(r.Users, newUserResponse(user))
 	}
 
-	ctx.IndentedJSON(200, r)
+	ctx.IndentedJSON(http.StatusOK, r)
 
 }
 
+func (c *UserController) Search(ctx *gin.Context) {
+	limit := 0
+
+	if l := ctx.Query("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			handleError(ctx, services.ErrInvalidPageSize)
+			return
+		}
+	}
+
+	results, err := c.service(ctx).SearchUsers(ctx.Query("q"), limit)
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	if apiVersion(ctx) == interfaces.API_V2 {
+		r := SearchResponseV2{Results: make([]SearchResultResponseV2, 0, len(results))}
+		for _, result := range results {
+			r.Results = append(r.Results, SearchResultResponseV2{
+				User:       newUserResponseV2(result.User),
+				Score:      result.Score,
+				Match:      result.Match,
+				Highlights: result.Highlights,
+			})
+		}
+
+		ctx.IndentedJSON(http.StatusOK, r)
+		return
+	}
+
+	r := SearchResponse{Results: make([]SearchResultResponse, 0, len(results))}
+	for _, result := range results {
+		r.Results = append(r.Results, SearchResultResponse{
+			User:       newUserResponse(result.User),
+			Score:      result.Score,
+			Match:      result.Match,
+			Highlights: result.Highlights,
+		})
+	}
+
+	ctx.IndentedJSON(http.StatusOK, r)
+
+}
+
+// Batch responds 200 when every operation succeeded and 207 otherwise, with
+// the outcome of each operation in the body.
+func (c *UserController) Batch(ctx *gin.Context) {
+	var request BatchRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		handleError(ctx, services.ErrInvalidBatch)
+		return
+	}
+
+	operations := make([]interfaces.BatchOperation, 0, len(request.Operations))
+	for _, o := range request.Operations {
+		operations = append(operations, interfaces.BatchOperation{Op: o.Op, UUID: o.UUID, Params: o.User})
+	}
+
+	results, err := c.service(ctx).BatchUsers(operations, request.Atomic)
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	status := http.StatusOK
+	r := BatchResponse{Results: make([]BatchResultResponse, 0, len(results))}
+
+	for i, result := range results {
+		if result.Err != nil {
+			code, message := errorStatus(result.Err)
+			r.Results = append(r.Results, BatchResultResponse{Status: code, Error: message})
+			status = http.StatusMultiStatus
+			continue
+		}
+
+		switch operations[i].Op {
+		case interfaces.BATCH_CREATE:
+			user := newUserResponse(result.User)
+			r.Results = append(r.Results, BatchResultResponse{Status: http.StatusCreated, User: &user})
+		case interfaces.BATCH_DELETE:
+			r.Results = append(r.Results, BatchResultResponse{Status: http.StatusOK})
+		default:
+			user := newUserResponse(result.User)
+			r.Results = append(r.Results, BatchResultResponse{Status: http.StatusOK, User: &user})
+		}
+	}
+
+	ctx.IndentedJSON(status, r)
+
+}
+
+// Import reads NDJSON or CSV users from the body, as given by its content
+// type, and creates them unless dry_run is set.
+func (c *UserController) Import(ctx *gin.Context) {
+	format, err := contentFormat(ctx.ContentType())
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	columns, err := parseColumns(ctx.Query("columns"))
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	dryRun := false
+	if d := ctx.Query("dry_run"); d != "" {
+		if dryRun, err = strconv.ParseBool(d); err != nil {
+			handleError(ctx, ErrInvalidImport)
+			return
+		}
+	}
+
+	reader, err := newUserReader(format, ctx.Request.Body, columns)
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	summary, err := c.service(ctx).ImportUsers(reader, dryRun)
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	r := ImportResponse{
+		DryRun:   dryRun,
+		Records:  summary.Records,
+		Imported: summary.Imported,
+		Failed:   summary.Failed,
+		Errors:   make([]ImportErrorResponse, 0, len(summary.Errors)),
+	}
+	for _, e := range summary.Errors {
+		_, message := errorStatus(e.Err)
+		r.Errors = append(r.Errors, ImportErrorResponse{Line: e.Line, Error: message})
+	}
+
+	ctx.IndentedJSON(http.StatusOK, r)
+
+}
+
+// EXPORT_ERROR_TRAILER is the trailer an export sets when it fails after
+// users were sent, as the status can no longer change.
+const EXPORT_ERROR_TRAILER = "X-Export-Error"
+
+// Export streams the users matching the listing filters, a page at a time,
+// as NDJSON, or CSV when format is csv or the client accepts only CSV.
+func (c *UserController) Export(ctx *gin.Context) {
+	format := ctx.Query("format")
+	if format == "" {
+		format = FORMAT_NDJSON
+		if ctx.NegotiateFormat(formatContentTypes[FORMAT_NDJSON], formatContentTypes[FORMAT_CSV]) == formatContentTypes[FORMAT_CSV] {
+			format = FORMAT_CSV
+		}
+	}
+
+	contentType, ok := formatContentTypes[format]
+	if !ok {
+		handleError(ctx, ErrUnsupportedFormat)
+		return
+	}
+
+	service := c.service(ctx)
+	request := listRequest(ctx)
+	request.Limit = services.MAX_PAGE_SIZE
+
+	// The first page is read before responding, so a failing export still
+	// gets an error status.
+	page, err := service.ListUsers(request)
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Trailer", EXPORT_ERROR_TRAILER)
+	ctx.Status(http.StatusOK)
+
+	w := newUserWriter(format, ctx.Writer)
+
+	for {
+		for _, user := range page.Users {
+			if err := w.Write(newExportedUser(user)); err != nil {
+				ctx.Error(err)
+				return
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			ctx.Error(err)
+			return
+		}
+		ctx.Writer.Flush()
+
+		if page.NextCursor == "" {
+			return
+		}
+
+		request.Cursor = page.NextCursor
+		page, err = service.ListUsers(request)
+
+		if err != nil {
+			_, message := errorStatus(err)
+			ctx.Writer.Header().Set(EXPORT_ERROR_TRAILER, message)
+			ctx.Error(err)
+			return
+		}
+	}
+}
+
+// Patch updates a user with a JSON Merge Patch or a JSON Patch, as given by
+// the content type, applied to the user as Get returns it.
 func (c *UserController) Patch(ctx *gin.Context) {
 	uuid := ctx.Param("uuid")
 
-	userParams := make(map[string]string)
-	ctx.BindJSON(&userParams)
+	apply, err := patchFunc(ctx.ContentType())
+	if err != nil {
+		ctx.Header("Accept-Patch", ACCEPT_PATCH)
+		handleError(ctx, err)
+		return
+	}
 
-	user, err := c.UpdateUser(uuid, userParams)
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	var version *int64
+
+	if im := ctx.GetHeader("If-Match"); im != "" && im != "*" {
+		v, ok := parseETag(im)
+		if !ok {
+			handleError(ctx, services.ErrVersionMismatch)
+			return
+		}
+		version = &v
+	}
+
+	rep := representationOf(ctx)
+
+	user, err := patchUser(c.service(ctx), rep, uuid, version, apply, body)
 
 	if err != nil {
 		handleError(ctx, err)
 		return
 	}
 
-	r := UserResponse{
-		UUID:      user.UUID.String(),
-		Name:      user.Name,
-		BirthDate: user.BirthDate.Format(DATE_FORMAT),
-		Email:     user.Email,
-		Password:  user.Password,
-		Address:   user.Address,
+	ctx.Header("ETag", rep.etag(user))
+
+	ctx.IndentedJSON(200, rep.user(user))
+
+}
+
+// patchUser applies the patch to the stored user, in rep, and updates it,
+// if still at the version patched. Unless the client required a version, the patch
+// is applied again to users changed in between. The user is read past the
+// cache, which could keep returning the version the update failed on.
+func patchUser(service interfaces.UserService, rep representation, uuid string, version *int64, apply func(doc, patch []byte) ([]byte, error), body []byte) (models.User, error) {
+	for attempt := 0; attempt < services.UPDATE_ATTEMPTS; attempt++ {
+		current, err := service.GetCurrentUser(uuid)
+		if err != nil {
+			return models.User{}, err
+		}
+
+		if version != nil && current.Version != *version {
+			return models.User{}, services.ErrVersionMismatch
+		}
+
+		params, err := patchParams(rep, current, apply, body)
+		if err != nil {
+			return models.User{}, err
+		}
+
+		user, err := service.UpdateUserIfMatch(uuid, current.Version, params)
+
+		if errors.Is(err, services.ErrVersionMismatch) && version == nil {
+			continue
+		}
+
+		return user, err
+	}
+
+	return models.User{}, services.ErrConcurrentUpdate
+}
+
+// Put replaces the user with the one in the body, which must hold every
+// field but uuid, creating it under the UUID of the path if there is none.
+// If-Match: * only replaces an existing user, If-None-Match: * only creates
+// one.
+func (c *UserController) Put(ctx *gin.Context) {
+	uuid := ctx.Param("uuid")
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	if !json.Valid(body) {
+		handleError(ctx, ErrInvalidBody)
+		return
+	}
+
+	rep := representationOf(ctx)
+
+	params, err := rep.fields(body)
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	// The uuid may be sent back as Get returned it, but not changed. The
+	// other read-only fields, such as timestamps, are ignored.
+	if u, ok := params["uuid"]; ok && !strings.EqualFold(u, uuid) {
+		handleError(ctx, services.FieldError{Field: "uuid", Err: ErrReadOnlyField})
+		return
+	}
+	for _, field := range rep.readOnly {
+		delete(params, field)
+	}
+
+	var user models.User
+	created := false
+
+	im := ctx.GetHeader("If-Match")
+
+	switch {
+	case im == "*":
+		user, err = c.service(ctx).ReplaceUserIfExists(uuid, params)
+	case im != "":
+		version, ok := parseETag(im)
+		if !ok {
+			handleError(ctx, services.ErrVersionMismatch)
+			return
+		}
+		user, err = c.service(ctx).ReplaceUserIfMatch(uuid, version, params)
+	case ctx.GetHeader("If-None-Match") == "*":
+		user, err = c.service(ctx).CreateUserIfAbsent(uuid, params)
+		created = err == nil
+	default:
+		user, created, err = c.service(ctx).ReplaceUser(uuid, params)
+	}
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	ctx.Header("ETag", rep.etag(user))
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
 	}
 
-	ctx.IndentedJSON(200, r)
+	ctx.IndentedJSON(status, rep.user(user))
 
 }
 
 func (c *UserController) Delete(ctx *gin.Context) {
 	uuid := ctx.Param("uuid")
 
-	err := c.DeleteUser(uuid)
+	err := c.service(ctx).DeleteUser(uuid)
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+}
+
+func (c *UserController) Restore(ctx *gin.Context) {
+	uuid := ctx.Param("uuid")
+
+	user, err := c.service(ctx).RestoreUser(uuid)
 
 	if err != nil {
 		handleError(ctx, err)
 		return
 	}
 
+	rep := representationOf(ctx)
+
+	ctx.Header("ETag", rep.etag(user))
+
+	ctx.IndentedJSON(http.StatusOK, rep.user(user))
+
+}
+
+func (c *UserController) ListDeleted(ctx *gin.Context) {
+	users, err := c.service(ctx).ListDeletedUsers()
+
+	if err != nil {
+		handleError(ctx, err)
+		return
+	}
+
+	r := make([]DeletedUserResponse, 0, len(users))
+	for _, user := range users {
+		d := DeletedUserResponse{UserResponse: newUserResponse(user), TenantID: user.TenantID}
+		if user.Deleted != nil {
+			d.DeletedAt = *user.Deleted
+		}
+		r = append(r, d)
+	}
+
+	ctx.IndentedJSON(http.StatusOK, r)
+
 }
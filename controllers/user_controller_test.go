@@ -3,30 +3,36 @@ package controllers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/ffardo/user-crud/interfaces"
 	"github.com/ffardo/user-crud/interfaces/mocks"
 	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/patch"
+	"github.com/ffardo/user-crud/problems"
 	"github.com/ffardo/user-crud/routes"
 	"github.com/ffardo/user-crud/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestCreateUser(t *testing.T) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 
 	address := "3197 Woodrow Way"
 	hashed_password := "736563726574e3b0c44298fc1c"
@@ -90,12 +96,13 @@ func TestCreateUser(t *testing.T) {
 func TestCreateUserUnauthorized(t *testing.T) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 
 	address := "3197 Woodrow Way"
 	name := "John Doe"
@@ -125,12 +132,13 @@ func TestCreateUserUnauthorized(t *testing.T) {
 func runCreateTestWithError(t *testing.T, serviceError error, statusCode int) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 
 	address := "3197 Woodrow Way"
 	name := "John Doe"
@@ -164,12 +172,12 @@ func runCreateTestWithError(t *testing.T, serviceError error, statusCode int) {
 	router.ServeHTTP(w, req)
 	assert.Equal(t, statusCode, w.Code)
 
-	var res ErrorResponse
+	var res problems.Problem
 
 	err := json.Unmarshal(w.Body.Bytes(), &res)
 
 	assert.Equal(t, err, nil)
-	assert.Equal(t, res.Error, serviceError.Error())
+	assert.Equal(t, res.Title, serviceError.Error())
 
 }
 
@@ -182,18 +190,60 @@ func TestCreateUserInvalidEmailFormat(t *testing.T) {
 }
 
 func TestCreateUserExistingEmail(t *testing.T) {
-	runCreateTestWithError(t, services.ErrEmailRegistered, http.StatusBadRequest)
+	runCreateTestWithError(t, services.ErrEmailRegistered, http.StatusConflict)
+}
+
+func TestCreateUserValidationFailed(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	us.On("CreateUser", "", "1970-13-01", "joe25@mailprovider.com", "", "secret").Return(models.User{}, services.ValidationError{
+		{Field: "birth_date", Err: services.ErrInvalidDateFormat},
+		{Field: "name", Err: services.ErrFieldRequired},
+	})
+
+	marshalled, _ := json.Marshal(UserRequest{
+		BirthDate: "1970-13-01",
+		Email:     "joe25@mailprovider.com",
+		Password:  "secret",
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/users/", bytes.NewReader(marshalled))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var res problems.Problem
+
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, problems.Type("validation-failed"), res.Type)
+	assert.Equal(t, []problems.FieldError{
+		{Field: "birth_date", Code: "invalid-date", Message: "Invalid date format"},
+		{Field: "name", Code: "required", Message: "Field is required"},
+	}, res.Errors)
 }
 
 func TestGetUser(t *testing.T) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 
 	address := "3197 Woodrow Way"
 	name := "John Doe"
@@ -245,12 +295,13 @@ func TestGetUser(t *testing.T) {
 func TestGetUserUnauthorized(t *testing.T) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 
 	url := fmt.Sprintf("/api/users/%s", user_uuid)
@@ -267,12 +318,13 @@ func TestGetUserUnauthorized(t *testing.T) {
 func runGetUserTestWithError(t *testing.T, serviceError error, statusCode int) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 
@@ -290,12 +342,12 @@ func runGetUserTestWithError(t *testing.T, serviceError error, statusCode int) {
 	router.ServeHTTP(w, req)
 	assert.Equal(t, statusCode, w.Code)
 
-	var res ErrorResponse
+	var res problems.Problem
 
 	err := json.Unmarshal(w.Body.Bytes(), &res)
 
 	assert.Equal(t, err, nil)
-	assert.Equal(t, res.Error, serviceError.Error())
+	assert.Equal(t, res.Title, serviceError.Error())
 
 }
 
@@ -307,15 +359,24 @@ func TestGetUserNotFound(t *testing.T) {
 	runGetUserTestWithError(t, services.ErrUserNotFound, http.StatusNotFound)
 }
 
+func TestGetUserStorageUnavailable(t *testing.T) {
+	runGetUserTestWithError(t, services.ErrStorageUnavailable, http.StatusServiceUnavailable)
+}
+
+func TestGetUserStorageTimeout(t *testing.T) {
+	runGetUserTestWithError(t, services.ErrStorageTimeout, http.StatusGatewayTimeout)
+}
+
 func TestUpdateUser(t *testing.T) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 
 	address := "3197 Woodrow Way"
 	hashed_password := "736563726574e3b0c44298fc1c"
@@ -352,9 +413,11 @@ func TestUpdateUser(t *testing.T) {
 		"password":   user_request.Password,
 	}
 
+	us.On("GetCurrentUser", user_uuid).Return(models.User{UUID: uuid.MustParse(user_uuid)}, nil)
 	us.On(
-		"UpdateUser",
+		"UpdateUserIfMatch",
 		user_uuid,
+		int64(0),
 		params,
 	).Return(user, nil)
 
@@ -385,12 +448,13 @@ func TestUpdateUser(t *testing.T) {
 func TestUpdateUserUnauthorized(t *testing.T) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 
 	address := "3197 Woodrow Way"
 	name := "John Doe"
@@ -424,12 +488,13 @@ func TestUpdateUserUnauthorized(t *testing.T) {
 func runUpdateTestWithError(t *testing.T, serviceError error, statusCode int) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 
 	address := "3197 Woodrow Way"
 	name := "John Doe"
@@ -455,9 +520,11 @@ func runUpdateTestWithError(t *testing.T, serviceError error, statusCode int) {
 		"password":   user_request.Password,
 	}
 
+	us.On("GetCurrentUser", user_uuid).Return(models.User{UUID: uuid.MustParse(user_uuid)}, nil)
 	us.On(
-		"UpdateUser",
+		"UpdateUserIfMatch",
 		user_uuid,
+		int64(0),
 		params,
 	).Return(models.User{}, serviceError)
 
@@ -471,12 +538,12 @@ func runUpdateTestWithError(t *testing.T, serviceError error, statusCode int) {
 	router.ServeHTTP(w, req)
 	assert.Equal(t, statusCode, w.Code)
 
-	var res ErrorResponse
+	var res problems.Problem
 
 	err := json.Unmarshal(w.Body.Bytes(), &res)
 
 	assert.Equal(t, err, nil)
-	assert.Equal(t, res.Error, serviceError.Error())
+	assert.Equal(t, res.Title, serviceError.Error())
 
 }
 
@@ -493,7 +560,7 @@ func TestUpdateUserInvalidEmail(t *testing.T) {
 }
 
 func TestUpdateUserExistingEmail(t *testing.T) {
-	runUpdateTestWithError(t, services.ErrEmailRegistered, http.StatusBadRequest)
+	runUpdateTestWithError(t, services.ErrEmailRegistered, http.StatusConflict)
 }
 
 func TestUpdateUserNotFound(t *testing.T) {
@@ -503,12 +570,13 @@ func TestUpdateUserNotFound(t *testing.T) {
 func TestDeleteUser(t *testing.T) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 
@@ -531,12 +599,13 @@ func TestDeleteUser(t *testing.T) {
 func TestDeleteUserUnauthorized(t *testing.T) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 
@@ -559,12 +628,13 @@ func TestDeleteUserUnauthorized(t *testing.T) {
 func runDeleteUserTestWithError(t *testing.T, serviceError error, statusCode int) {
 	gin.SetMode("test")
 	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
 
 	uc := UserController{
 		us,
 	}
 
-	router := routes.InitRouter(&uc, "test_key")
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
 
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 
@@ -582,12 +652,12 @@ func runDeleteUserTestWithError(t *testing.T, serviceError error, statusCode int
 	router.ServeHTTP(w, req)
 	assert.Equal(t, statusCode, w.Code)
 
-	var res ErrorResponse
+	var res problems.Problem
 
 	err := json.Unmarshal(w.Body.Bytes(), &res)
 
 	assert.Equal(t, err, nil)
-	assert.Equal(t, res.Error, serviceError.Error())
+	assert.Equal(t, res.Title, serviceError.Error())
 
 }
 
@@ -598,3 +668,916 @@ func TestDeleteUserInvalidUuidFormat(t *testing.T) {
 func TestDeleteUserNotFound(t *testing.T) {
 	runDeleteUserTestWithError(t, services.ErrUserNotFound, http.StatusNotFound)
 }
+
+func TestDeleteUserStorageUnavailable(t *testing.T) {
+	runDeleteUserTestWithError(t, services.ErrStorageUnavailable, http.StatusServiceUnavailable)
+}
+
+func TestCreateUserUnexpectedError(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	us.On(
+		"CreateUser",
+		"John Doe",
+		"1970-01-31",
+		"joe25@mailprovider.com",
+		"3197 Woodrow Way",
+		"secret",
+	).Return(models.User{}, errors.New("connection refused"))
+
+	new_user_request := UserRequest{
+		Name:      "John Doe",
+		BirthDate: "1970-01-31",
+		Email:     "joe25@mailprovider.com",
+		Address:   "3197 Woodrow Way",
+		Password:  "secret",
+	}
+
+	marshalled, _ := json.Marshal(new_user_request)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/users/", bytes.NewReader(marshalled))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	var res problems.Problem
+
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "Internal Server Error", res.Title)
+}
+
+func TestGetUserETag(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	user := models.User{
+		UUID:    uuid.MustParse(user_uuid),
+		Name:    "John Doe",
+		Version: 7,
+	}
+
+	us.On("GetUser", user_uuid).Return(user, nil)
+	us.On("GetCurrentUser", user_uuid).Return(user, nil)
+
+	url := fmt.Sprintf("/api/users/%s", user_uuid)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"7"`, w.Header().Get("ETag"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	req.Header.Set("If-None-Match", `"6", W/"7"`)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, 0, w.Body.Len())
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	req.Header.Set("If-None-Match", `"6"`)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	us.AssertNumberOfCalls(t, "GetUser", 1)
+	us.AssertNumberOfCalls(t, "GetCurrentUser", 2)
+}
+
+func runConditionalUpdate(t *testing.T, ifMatch string, setup func(us *mocks.UserService, params map[string]string)) *httptest.ResponseRecorder {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	params := map[string]string{
+		"name": "John Nobody",
+	}
+
+	setup(us, params)
+
+	marshalled, _ := json.Marshal(params)
+	url := fmt.Sprintf("/api/users/%s", user_uuid)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPatch, url, bytes.NewReader(marshalled))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-KEY", "test_key")
+	req.Header.Set("If-Match", ifMatch)
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestUpdateUserIfMatch(t *testing.T) {
+	user := models.User{
+		UUID:    uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"),
+		Name:    "John Nobody",
+		Version: 8,
+	}
+
+	w := runConditionalUpdate(t, `"7"`, func(us *mocks.UserService, params map[string]string) {
+		us.On("GetCurrentUser", user.UUID.String()).Return(models.User{UUID: user.UUID, Name: "John Doe", Version: 7}, nil)
+		us.On("UpdateUserIfMatch", user.UUID.String(), int64(7), params).Return(user, nil)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"8"`, w.Header().Get("ETag"))
+}
+
+func TestUpdateUserIfMatchStale(t *testing.T) {
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	w := runConditionalUpdate(t, `"7"`, func(us *mocks.UserService, params map[string]string) {
+		us.On("GetCurrentUser", user_uuid).Return(models.User{UUID: uuid.MustParse(user_uuid), Version: 8}, nil)
+	})
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = runConditionalUpdate(t, `"7"`, func(us *mocks.UserService, params map[string]string) {
+		us.On("GetCurrentUser", user_uuid).Return(models.User{UUID: uuid.MustParse(user_uuid), Version: 7}, nil)
+		us.On("UpdateUserIfMatch", user_uuid, int64(7), params).Return(models.User{}, services.ErrVersionMismatch)
+	})
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestUpdateUserIfMatchWeakTag(t *testing.T) {
+	w := runConditionalUpdate(t, `W/"7"`, func(us *mocks.UserService, params map[string]string) {})
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestUpdateUserConcurrentUpdate(t *testing.T) {
+	runUpdateTestWithError(t, services.ErrConcurrentUpdate, http.StatusConflict)
+}
+
+func runPatch(t *testing.T, contentType string, body string, setup func(us *mocks.UserService, current models.User)) *httptest.ResponseRecorder {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	user_bd, _ := time.Parse(models.DATE_FORMAT, "1970-01-31")
+
+	current := models.User{
+		UUID:      uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"),
+		Name:      "John Doe",
+		BirthDate: user_bd,
+		Email:     "joe25@mailprovider.com",
+		Address:   "3197 Woodrow Way",
+		Password:  "736563726574e3b0c44298fc1c",
+		Version:   3,
+	}
+
+	setup(us, current)
+
+	url := fmt.Sprintf("/api/users/%s", current.UUID)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPatch, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestUpdateUserMergePatch(t *testing.T) {
+	w := runPatch(t, MERGE_PATCH_CONTENT_TYPE, `{"name": "John Nobody", "address": null, "email": "joe25@mailprovider.com"}`, func(us *mocks.UserService, current models.User) {
+		updated := current
+		updated.Name, updated.Address, updated.Version = "John Nobody", "", 4
+
+		us.On("GetCurrentUser", current.UUID.String()).Return(current, nil)
+		us.On("UpdateUserIfMatch", current.UUID.String(), int64(3), map[string]string{"name": "John Nobody", "address": ""}).Return(updated, nil)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+
+	var res UserResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "John Nobody", res.Name)
+	assert.Equal(t, "", res.Address)
+}
+
+func TestUpdateUserJSONPatch(t *testing.T) {
+	body := `[
+		{"op": "test", "path": "/email", "value": "joe25@mailprovider.com"},
+		{"op": "replace", "path": "/email", "value": "joe26@mailprovider.com"},
+		{"op": "remove", "path": "/address"}
+	]`
+
+	w := runPatch(t, JSON_PATCH_CONTENT_TYPE, body, func(us *mocks.UserService, current models.User) {
+		us.On("GetCurrentUser", current.UUID.String()).Return(current, nil)
+		us.On("UpdateUserIfMatch", current.UUID.String(), int64(3), map[string]string{"email": "joe26@mailprovider.com", "address": ""}).Return(current, nil)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestUpdateUserPatchRetriesConcurrentUpdate(t *testing.T) {
+	var us *mocks.UserService
+
+	w := runPatch(t, MERGE_PATCH_CONTENT_TYPE, `{"name": "John Nobody"}`, func(m *mocks.UserService, current models.User) {
+		us = m
+		changed := current
+		changed.Version = 4
+
+		params := map[string]string{"name": "John Nobody"}
+
+		us.On("GetCurrentUser", current.UUID.String()).Return(current, nil).Once()
+		us.On("GetCurrentUser", current.UUID.String()).Return(changed, nil).Once()
+		us.On("UpdateUserIfMatch", current.UUID.String(), int64(3), params).Return(models.User{}, services.ErrVersionMismatch)
+		us.On("UpdateUserIfMatch", current.UUID.String(), int64(4), params).Return(changed, nil)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	us.AssertNumberOfCalls(t, "GetCurrentUser", 2)
+}
+
+func TestUpdateUserPatchIgnoresCachedVersion(t *testing.T) {
+	var us *mocks.UserService
+
+	w := runPatch(t, MERGE_PATCH_CONTENT_TYPE, `{"name": "John Nobody"}`, func(m *mocks.UserService, current models.User) {
+		us = m
+		stale := current
+		stale.Version = 2
+
+		us.On("GetUser", current.UUID.String()).Return(stale, nil)
+		us.On("GetCurrentUser", current.UUID.String()).Return(current, nil)
+		us.On("UpdateUserIfMatch", current.UUID.String(), int64(3), map[string]string{"name": "John Nobody"}).Return(current, nil)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	us.AssertNotCalled(t, "GetUser", mock.Anything)
+}
+
+func TestUpdateUserPatchErrors(t *testing.T) {
+	cases := []struct {
+		contentType string
+		body        string
+		status      int
+		err         error
+	}{
+		{MERGE_PATCH_CONTENT_TYPE, `{"nickname": "Joe"}`, http.StatusUnprocessableEntity, ErrUnknownField},
+		{MERGE_PATCH_CONTENT_TYPE, `{"uuid": "e035e79d-ffe9-4ebf-b665-747353b3ea40"}`, http.StatusUnprocessableEntity, ErrReadOnlyField},
+		{MERGE_PATCH_CONTENT_TYPE, `{"name": 42}`, http.StatusUnprocessableEntity, ErrInvalidField},
+		{MERGE_PATCH_CONTENT_TYPE, `{"password": null}`, http.StatusUnprocessableEntity, ErrClearedField},
+		{MERGE_PATCH_CONTENT_TYPE, `{"name"`, http.StatusBadRequest, patch.ErrInvalidPatch},
+		{JSON_PATCH_CONTENT_TYPE, `[{"op": "remove", "path": "/uuid"}]`, http.StatusUnprocessableEntity, ErrReadOnlyField},
+		{JSON_PATCH_CONTENT_TYPE, `[{"op": "remove", "path": "/nickname"}]`, http.StatusUnprocessableEntity, patch.ErrPathNotFound},
+		{JSON_PATCH_CONTENT_TYPE, `[{"op": "remove", "path": "/password"}]`, http.StatusUnprocessableEntity, ErrClearedField},
+		{JSON_PATCH_CONTENT_TYPE, `[{"op": "test", "path": "/name", "value": "Mary Doe"}]`, http.StatusConflict, patch.ErrTestFailed},
+		{JSON_PATCH_CONTENT_TYPE, `{"op": "test"}`, http.StatusBadRequest, patch.ErrInvalidPatch},
+	}
+
+	for _, c := range cases {
+		w := runPatch(t, c.contentType, c.body, func(us *mocks.UserService, current models.User) {
+			us.On("GetCurrentUser", current.UUID.String()).Return(current, nil)
+		})
+
+		assert.Equal(t, c.status, w.Code, c.body)
+
+		var res problems.Problem
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Contains(t, res.Title, c.err.Error(), c.body)
+	}
+}
+
+func TestUpdateUserUnsupportedPatch(t *testing.T) {
+	w := runPatch(t, "text/plain", `name=John`, func(us *mocks.UserService, current models.User) {})
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Equal(t, ACCEPT_PATCH, w.Header().Get("Accept-Patch"))
+}
+
+func TestRestoreUser(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	user := models.User{
+		UUID:    uuid.MustParse(user_uuid),
+		Name:    "John Doe",
+		Version: 3,
+	}
+
+	us.On("RestoreUser", user_uuid).Return(user, nil)
+
+	url := fmt.Sprintf("/api/users/%s/restore", user_uuid)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, url, nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+
+	var res UserResponse
+
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res.UUID, user_uuid)
+}
+
+func TestListDeletedUsers(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+	routes.InitAdminRoutes(router, &uc, "admin_key")
+
+	deleted := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	users := []models.User{
+		{
+			UUID:    uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"),
+			Name:    "John Doe",
+			Deleted: &deleted,
+		},
+	}
+
+	us.On("ListDeletedUsers").Return(users, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/admin/users/deleted", nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/api/admin/users/deleted", nil)
+	req.Header.Set("X-ADMIN-KEY", "admin_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res []DeletedUserResponse
+
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+
+	assert.Equal(t, err, nil)
+	assert.Len(t, res, 1)
+	assert.Equal(t, "d035e79d-ffe9-4ebf-b665-747353b3ea40", res[0].UUID)
+	assert.True(t, deleted.Equal(res[0].DeletedAt))
+}
+
+func TestListUsers(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	user := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), Name: "John Doe"}
+
+	us.On("ListUsers", interfaces.ListUsersRequest{
+		NamePrefix: "John",
+		Sort:       "-name",
+		Cursor:     "abc",
+		Limit:      10,
+	}).Return(interfaces.UserPage{Users: []models.User{user}, NextCursor: "def"}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/users?name_prefix=John&sort=-name&cursor=abc&limit=10", nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res UserListResponse
+
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+
+	assert.Equal(t, err, nil)
+	assert.Len(t, res.Users, 1)
+	assert.Equal(t, "John Doe", res.Users[0].Name)
+	assert.Equal(t, "def", res.NextCursor)
+}
+
+func TestListUsersInvalidLimit(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/users/?limit=ten", nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	us.AssertNotCalled(t, "ListUsers", mock.Anything)
+}
+
+func TestSearchUsers(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	user := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), Name: "José Doe"}
+
+	us.On("SearchUsers", "jose doe", 5).Return([]interfaces.SearchResult{
+		{
+			User:       user,
+			Score:      1.5,
+			Match:      interfaces.MATCH_TEXT,
+			Highlights: map[string]string{"name": "<em>José</em> <em>Doe</em>"},
+		},
+	}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/users/search?q=jose+doe&limit=5", nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res SearchResponse
+
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+
+	assert.Equal(t, err, nil)
+	assert.Len(t, res.Results, 1)
+	assert.Equal(t, "José Doe", res.Results[0].User.Name)
+	assert.Equal(t, 1.5, res.Results[0].Score)
+	assert.Equal(t, "text", res.Results[0].Match)
+	assert.Equal(t, "<em>José</em> <em>Doe</em>", res.Results[0].Highlights["name"])
+}
+
+func TestSearchUsersWithoutQuery(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	us.On("SearchUsers", "", 0).Return(nil, services.ErrInvalidSearch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/users/search", nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestBatchUsers(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	created := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), Name: "John Doe"}
+
+	us.On("BatchUsers", []interfaces.BatchOperation{
+		{Op: "create", Params: map[string]string{"name": "John Doe"}},
+		{Op: "update", UUID: "4c5b4d8e-3fd1-4d3b-9a0e-6f1b2c3d4e5f", Params: map[string]string{"email": "joe@mail.com"}},
+		{Op: "delete", UUID: "4c5b4d8e-3fd1-4d3b-9a0e-6f1b2c3d4e5f"},
+	}, true).Return([]interfaces.BatchResult{
+		{User: created},
+		{Err: services.ErrEmailRegistered},
+		{Err: services.ErrBatchAborted},
+	}, nil)
+
+	body := `{"atomic": true, "operations": [
+		{"op": "create", "user": {"name": "John Doe"}},
+		{"op": "update", "uuid": "4c5b4d8e-3fd1-4d3b-9a0e-6f1b2c3d4e5f", "user": {"email": "joe@mail.com"}},
+		{"op": "delete", "uuid": "4c5b4d8e-3fd1-4d3b-9a0e-6f1b2c3d4e5f"}
+	]}`
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/users:batch", bytes.NewBufferString(body))
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+	var res BatchResponse
+
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+
+	assert.Equal(t, err, nil)
+	assert.Len(t, res.Results, 3)
+	assert.Equal(t, http.StatusCreated, res.Results[0].Status)
+	assert.Equal(t, "John Doe", res.Results[0].User.Name)
+	assert.Equal(t, http.StatusConflict, res.Results[1].Status)
+	assert.Equal(t, "Email already registered", res.Results[1].Error)
+	assert.Nil(t, res.Results[1].User)
+	assert.Equal(t, http.StatusFailedDependency, res.Results[2].Status)
+}
+
+func TestBatchUsersInvalidBody(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/users:batch", bytes.NewBufferString(`{"operations": {}}`))
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/api/users:merge", bytes.NewBufferString(`{}`))
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	us.AssertNotCalled(t, "BatchUsers", mock.Anything, mock.Anything)
+}
+
+func TestImportUsers(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	var read []interfaces.ImportRecord
+
+	us.On("ImportUsers", mock.Anything, true).Return(func(reader interfaces.UserReader, dryRun bool) interfaces.ImportSummary {
+		for {
+			record, err := reader.Next()
+			if err != nil {
+				break
+			}
+			read = append(read, record)
+		}
+		return interfaces.ImportSummary{
+			Records:  2,
+			Imported: 1,
+			Failed:   1,
+			Errors:   []interfaces.ImportError{{Line: 3, Err: services.ErrInvalidDateFormat}},
+		}
+	}, nil)
+
+	body := "nome,email\nJohn Doe,joe@mail.com\nMary Doe,mary@mail.com\n"
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/users/import?dry_run=true&columns=nome:name", bytes.NewBufferString(body))
+	req.Header.Set("X-API-KEY", "test_key")
+	req.Header.Set("Content-Type", "text/csv")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var res ImportResponse
+
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ImportResponse{
+		DryRun:   true,
+		Records:  2,
+		Imported: 1,
+		Failed:   1,
+		Errors:   []ImportErrorResponse{{Line: 3, Error: "Invalid date format"}},
+	}, res)
+	assert.Len(t, read, 2)
+	assert.Equal(t, map[string]string{"name": "Mary Doe", "email": "mary@mail.com"}, read[1].Params)
+}
+
+func TestImportUsersUnsupportedFormat(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/users/import", bytes.NewBufferString("[]"))
+	req.Header.Set("X-API-KEY", "test_key")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/api/users/import?columns=a:uuid", bytes.NewBufferString(""))
+	req.Header.Set("X-API-KEY", "test_key")
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	us.AssertNotCalled(t, "ImportUsers", mock.Anything, mock.Anything)
+}
+
+func TestExportUsers(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	john := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), Name: "John Doe", Password: "736563726574e3b0c44298fc1c"}
+	mary := models.User{UUID: uuid.MustParse("4c5b4d8e-3fd1-4d3b-9a0e-6f1b2c3d4e5f"), Name: "Mary Doe"}
+
+	us.On("ListUsers", interfaces.ListUsersRequest{NamePrefix: "Do", Limit: services.MAX_PAGE_SIZE}).
+		Return(interfaces.UserPage{Users: []models.User{john}, NextCursor: "next"}, nil)
+	us.On("ListUsers", interfaces.ListUsersRequest{NamePrefix: "Do", Limit: services.MAX_PAGE_SIZE, Cursor: "next"}).
+		Return(interfaces.UserPage{Users: []models.User{mary}}, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/users/export?name_prefix=Do", nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	lines := bytes.Split(bytes.TrimSpace(w.Body.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+
+	var res ExportedUser
+	assert.NoError(t, json.Unmarshal(lines[1], &res))
+	assert.Equal(t, "Mary Doe", res.Name)
+
+	// Password hashes are not exported, as importing would hash them again.
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(lines[0], &doc))
+	assert.NotContains(t, doc, "password")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/api/users/export?name_prefix=Do", nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	req.Header.Set("Accept", "text/csv")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "uuid,name,birth_date,email,address\n"+
+		"d035e79d-ffe9-4ebf-b665-747353b3ea40,John Doe,0001-01-01,,\n"+
+		"4c5b4d8e-3fd1-4d3b-9a0e-6f1b2c3d4e5f,Mary Doe,0001-01-01,,\n", w.Body.String())
+}
+
+func TestExportUsersFailingLater(t *testing.T) {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	john := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), Name: "John Doe"}
+
+	us.On("ListUsers", interfaces.ListUsersRequest{Limit: services.MAX_PAGE_SIZE}).
+		Return(interfaces.UserPage{Users: []models.User{john}, NextCursor: "next"}, nil)
+	us.On("ListUsers", interfaces.ListUsersRequest{Limit: services.MAX_PAGE_SIZE, Cursor: "next"}).
+		Return(interfaces.UserPage{}, services.ErrStorageUnavailable)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/users/export", nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Storage unavailable", w.Header().Get(EXPORT_ERROR_TRAILER))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/api/users/export?format=xml", nil)
+	req.Header.Set("X-API-KEY", "test_key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func runPut(t *testing.T, body string, headers map[string]string, setup func(us *mocks.UserService)) *httptest.ResponseRecorder {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	setup(us)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/api/users/d035e79d-ffe9-4ebf-b665-747353b3ea40", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-KEY", "test_key")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+const putBody = `{
+	"uuid": "d035e79d-ffe9-4ebf-b665-747353b3ea40",
+	"name": "John Doe",
+	"birth_date": "1970-01-31",
+	"email": "joe25@mailprovider.com",
+	"address": "3197 Woodrow Way",
+	"password": "secret"
+}`
+
+var putParams = map[string]string{
+	"name":       "John Doe",
+	"birth_date": "1970-01-31",
+	"email":      "joe25@mailprovider.com",
+	"address":    "3197 Woodrow Way",
+	"password":   "secret",
+}
+
+func TestPutUserCreates(t *testing.T) {
+	user := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), Name: "John Doe", Version: 1}
+
+	w := runPut(t, putBody, nil, func(us *mocks.UserService) {
+		us.On("ReplaceUser", user.UUID.String(), putParams).Return(user, true, nil)
+	})
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	var res UserResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, user.UUID.String(), res.UUID)
+}
+
+func TestPutUserReplaces(t *testing.T) {
+	user := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), Name: "John Doe", Version: 5}
+
+	w := runPut(t, putBody, nil, func(us *mocks.UserService) {
+		us.On("ReplaceUser", user.UUID.String(), putParams).Return(user, false, nil)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"5"`, w.Header().Get("ETag"))
+}
+
+func TestPutUserIfMatch(t *testing.T) {
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	w := runPut(t, putBody, map[string]string{"If-Match": `"4"`}, func(us *mocks.UserService) {
+		us.On("ReplaceUserIfMatch", user_uuid, int64(4), putParams).Return(models.User{}, services.ErrVersionMismatch)
+	})
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestPutUserIfMatchAny(t *testing.T) {
+	user := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), Name: "John Doe", Version: 5}
+
+	w := runPut(t, putBody, map[string]string{"If-Match": "*"}, func(us *mocks.UserService) {
+		us.On("ReplaceUserIfExists", user.UUID.String(), putParams).Return(user, nil)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"5"`, w.Header().Get("ETag"))
+
+	w = runPut(t, putBody, map[string]string{"If-Match": "*"}, func(us *mocks.UserService) {
+		us.On("ReplaceUserIfExists", user.UUID.String(), putParams).Return(models.User{}, services.ErrVersionMismatch)
+	})
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestPutUserIfNoneMatchAny(t *testing.T) {
+	user := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), Name: "John Doe", Version: 1}
+
+	w := runPut(t, putBody, map[string]string{"If-None-Match": "*"}, func(us *mocks.UserService) {
+		us.On("CreateUserIfAbsent", user.UUID.String(), putParams).Return(user, nil)
+	})
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+
+	w = runPut(t, putBody, map[string]string{"If-None-Match": "*"}, func(us *mocks.UserService) {
+		us.On("CreateUserIfAbsent", user.UUID.String(), putParams).Return(models.User{}, services.ErrUserExists)
+	})
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	var res problems.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, problems.Type("user-exists"), res.Type)
+}
+
+func TestPutUserErrors(t *testing.T) {
+	cases := []struct {
+		body   string
+		status int
+		err    error
+	}{
+		{`{"name": "John Doe"`, http.StatusBadRequest, ErrInvalidBody},
+		{`["John Doe"]`, http.StatusUnprocessableEntity, ErrInvalidField},
+		{`{"nickname": "Joe"}`, http.StatusUnprocessableEntity, ErrUnknownField},
+		{`{"name": 42}`, http.StatusUnprocessableEntity, ErrInvalidField},
+		{`{"uuid": "e035e79d-ffe9-4ebf-b665-747353b3ea40"}`, http.StatusUnprocessableEntity, ErrReadOnlyField},
+	}
+
+	for _, c := range cases {
+		w := runPut(t, c.body, nil, func(us *mocks.UserService) {})
+
+		assert.Equal(t, c.status, w.Code, c.body)
+
+		var res problems.Problem
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Contains(t, res.Title, c.err.Error(), c.body)
+	}
+}
+
+func TestPutUserServiceErrors(t *testing.T) {
+	cases := map[error]int{
+		services.ErrMissingField:    http.StatusBadRequest,
+		services.ErrUuidTaken:       http.StatusConflict,
+		services.ErrEmailRegistered: http.StatusConflict,
+	}
+
+	for serviceError, status := range cases {
+		w := runPut(t, `{"name": "John Doe"}`, nil, func(us *mocks.UserService) {
+			us.On("ReplaceUser", "d035e79d-ffe9-4ebf-b665-747353b3ea40", map[string]string{"name": "John Doe"}).Return(models.User{}, false, serviceError)
+		})
+
+		assert.Equal(t, status, w.Code, serviceError.Error())
+	}
+}
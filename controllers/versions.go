@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/services"
+	"github.com/gin-gonic/gin"
+)
+
+// AddressV2 is a structured address. Addresses sent on a single line, as v1
+// takes them, are returned as the street.
+type AddressV2 struct {
+	Street     string `json:"street"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+type UserRequestV2 struct {
+	Name      string     `json:"name"`
+	BirthDate string     `json:"birth_date"`
+	Email     string     `json:"email"`
+	Address   *AddressV2 `json:"address"`
+	Password  string     `json:"password"`
+}
+
+// UserResponseV2 is a user as v2 returns it. The password is never
+// returned, not even hashed.
+type UserResponseV2 struct {
+	UUID      string    `json:"uuid"`
+	Name      string    `json:"name"`
+	BirthDate string    `json:"birth_date"`
+	Email     string    `json:"email"`
+	Address   AddressV2 `json:"address"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type UserListResponseV2 struct {
+	Users []UserResponseV2 `json:"users"`
+	// NextCursor fetches the next page. It is omitted on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type SearchResultResponseV2 struct {
+	User       UserResponseV2    `json:"user"`
+	Score      float64           `json:"score"`
+	Match      string            `json:"match"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+type SearchResponseV2 struct {
+	Results []SearchResultResponseV2 `json:"results"`
+}
+
+func newUserResponseV2(user models.User) UserResponseV2 {
+	a := user.StructuredAddress()
+
+	return UserResponseV2{
+		UUID:      user.UUID.String(),
+		Name:      user.Name,
+		BirthDate: user.BirthDate.Format(models.DATE_FORMAT),
+		Email:     user.Email,
+		Address: AddressV2{
+			Street:     a.Street,
+			City:       a.City,
+			Region:     a.Region,
+			PostalCode: a.PostalCode,
+			Country:    a.Country,
+		},
+		CreatedAt: user.Created,
+		UpdatedAt: user.Updated,
+	}
+}
+
+// params returns the params creating the user in r.
+func (r UserRequestV2) params() map[string]string {
+	params := map[string]string{
+		"name":       r.Name,
+		"birth_date": r.BirthDate,
+		"email":      r.Email,
+		"password":   r.Password,
+	}
+
+	if r.Address != nil {
+		params[services.ADDRESS_PREFIX+"street"] = r.Address.Street
+		params[services.ADDRESS_PREFIX+"city"] = r.Address.City
+		params[services.ADDRESS_PREFIX+"region"] = r.Address.Region
+		params[services.ADDRESS_PREFIX+"postal_code"] = r.Address.PostalCode
+		params[services.ADDRESS_PREFIX+"country"] = r.Address.Country
+	}
+
+	return params
+}
+
+// representation is the shape of users in an API version.
+type representation struct {
+	// user returns the document of user.
+	user func(user models.User) interface{}
+	// fields returns the fields of a user document, as documentFields
+	// does.
+	fields func(doc []byte) (map[string]string, error)
+	// readOnly are the fields of the document clients cannot change.
+	readOnly []string
+	// tagPrefix sets the entity tags of the representation apart from the
+	// others'.
+	tagPrefix string
+}
+
+var representations = map[string]representation{
+	interfaces.API_V1: {
+		user:     func(user models.User) interface{} { return newUserResponse(user) },
+		fields:   documentFields,
+		readOnly: READ_ONLY_FIELDS,
+	},
+	interfaces.API_V2: {
+		user:      func(user models.User) interface{} { return newUserResponseV2(user) },
+		fields:    documentFieldsV2,
+		readOnly:  READ_ONLY_FIELDS_V2,
+		tagPrefix: "v2-",
+	},
+}
+
+// apiVersion returns the API version of the request, v1 when the route did
+// not set one.
+func apiVersion(ctx *gin.Context) string {
+	if v := ctx.GetString(interfaces.API_VERSION_CONTEXT_KEY); v != "" {
+		return v
+	}
+	return interfaces.API_V1
+}
+
+func representationOf(ctx *gin.Context) representation {
+	return representations[apiVersion(ctx)]
+}
+
+func (r representation) isReadOnly(field string) bool {
+	for _, f := range r.readOnly {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// READ_ONLY_FIELDS_V2 are the fields of v2 user documents clients cannot
+// change.
+var READ_ONLY_FIELDS_V2 = []string{"uuid", "created_at", "updated_at"}
+
+// FIELDS_V2 are the fields of v2 user documents clients can set, but for
+// the address.
+var FIELDS_V2 = []string{"name", "birth_date", "email", "password"}
+
+// ADDRESS_FIELDS_V2 are the fields of AddressV2.
+var ADDRESS_FIELDS_V2 = []string{"street", "city", "region", "postal_code", "country"}
+
+// documentFieldsV2 returns the fields of a v2 user document, as
+// documentFields does. The parts of the address are named as in
+// services.ADDRESS_FIELDS; all are returned when the address is set, those
+// missing as empty strings, and none when it is not.
+func documentFieldsV2(doc []byte) (map[string]string, error) {
+	var values map[string]interface{}
+	if err := json.Unmarshal(doc, &values); err != nil {
+		return nil, fmt.Errorf("%w: user is not an object", ErrInvalidField)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := map[string]string{}
+
+	for _, key := range keys {
+		if key == "address" {
+			if err := addressFields(values[key], fields); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !contains(FIELDS_V2, key) && !contains(READ_ONLY_FIELDS_V2, key) {
+			return nil, services.FieldError{Field: key, Err: ErrUnknownField}
+		}
+
+		switch v := values[key].(type) {
+		case string:
+			fields[key] = v
+		case nil:
+			fields[key] = ""
+		default:
+			return nil, services.FieldError{Field: key, Err: ErrInvalidField}
+		}
+	}
+
+	return fields, nil
+}
+
+// addressFields adds the parts of a v2 address to fields. A null address
+// clears every part.
+func addressFields(value interface{}, fields map[string]string) error {
+	parts, ok := value.(map[string]interface{})
+	if value != nil && !ok {
+		return services.FieldError{Field: "address", Err: ErrInvalidField}
+	}
+
+	for key := range parts {
+		if !contains(ADDRESS_FIELDS_V2, key) {
+			return services.FieldError{Field: services.ADDRESS_PREFIX + key, Err: ErrUnknownField}
+		}
+	}
+
+	for _, key := range ADDRESS_FIELDS_V2 {
+		field := services.ADDRESS_PREFIX + key
+
+		switch v := parts[key].(type) {
+		case string:
+			fields[field] = v
+		case nil:
+			fields[field] = ""
+		default:
+			return services.FieldError{Field: field, Err: ErrInvalidField}
+		}
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/problems"
+	"github.com/ffardo/user-crud/routes"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const V2_MEDIA_TYPE = "application/vnd.user-crud.v2+json"
+
+func runVersioned(t *testing.T, method, url string, headers map[string]string, body string, setup func(us *mocks.UserService)) *httptest.ResponseRecorder {
+	gin.SetMode("test")
+	us := new(mocks.UserService)
+	us.On("ForTenant", models.Tenant{}).Return(us)
+
+	uc := UserController{
+		us,
+	}
+
+	router := routes.InitRouter(&uc, routes.TenantAuth("test_key", nil))
+
+	setup(us)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("X-API-KEY", "test_key")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func versionedUser() models.User {
+	user_bd, _ := time.Parse(models.DATE_FORMAT, "1970-01-31")
+
+	return models.User{
+		UUID:      uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"),
+		Name:      "John Doe",
+		BirthDate: user_bd,
+		Email:     "joe25@mailprovider.com",
+		Address:   "3197 Woodrow Way, Springfield, US",
+		AddressParts: &models.Address{
+			Street:  "3197 Woodrow Way",
+			City:    "Springfield",
+			Country: "US",
+		},
+		Password: "736563726574e3b0c44298fc1c",
+		Created:  time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		Updated:  time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC),
+		Version:  3,
+	}
+}
+
+func TestGetUserV2(t *testing.T) {
+	user := versionedUser()
+
+	w := runVersioned(t, http.MethodGet, "/api/v2/users/"+user.UUID.String(), nil, "", func(us *mocks.UserService) {
+		us.On("GetUser", user.UUID.String()).Return(user, nil)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+	assert.Empty(t, w.Header().Get("Sunset"))
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.NotContains(t, doc, "password")
+
+	var res UserResponseV2
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, AddressV2{Street: "3197 Woodrow Way", City: "Springfield", Country: "US"}, res.Address)
+	assert.True(t, user.Created.Equal(res.CreatedAt))
+	assert.True(t, user.Updated.Equal(res.UpdatedAt))
+}
+
+func TestGetUserV2SingleLineAddress(t *testing.T) {
+	user := versionedUser()
+	user.Address, user.AddressParts = "3197 Woodrow Way", nil
+
+	w := runVersioned(t, http.MethodGet, "/api/v2/users/"+user.UUID.String(), nil, "", func(us *mocks.UserService) {
+		us.On("GetUser", user.UUID.String()).Return(user, nil)
+	})
+
+	var res UserResponseV2
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, AddressV2{Street: "3197 Woodrow Way"}, res.Address)
+}
+
+func TestGetUserV1IsDeprecated(t *testing.T) {
+	user := versionedUser()
+
+	w := runVersioned(t, http.MethodGet, "/api/v1/users/"+user.UUID.String(), nil, "", func(us *mocks.UserService) {
+		us.On("GetUser", user.UUID.String()).Return(user, nil)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "@1792368000", w.Header().Get("Deprecation"))
+	assert.Equal(t, "Fri, 30 Apr 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+
+	var res UserResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, user.Address, res.Address)
+	assert.Equal(t, user.Password, res.Password)
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	user := versionedUser()
+	url := "/api/users/" + user.UUID.String()
+	setup := func(us *mocks.UserService) {
+		us.On("GetUser", user.UUID.String()).Return(user, nil)
+	}
+
+	w := runVersioned(t, http.MethodGet, url, map[string]string{"Accept": V2_MEDIA_TYPE}, "", setup)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, V2_MEDIA_TYPE, w.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	assert.Empty(t, w.Header().Get("Deprecation"))
+
+	var res UserResponseV2
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, "Springfield", res.Address.City)
+
+	w = runVersioned(t, http.MethodGet, url, map[string]string{"Accept": "application/json"}, "", setup)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Deprecation"))
+
+	var v1 UserResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &v1))
+	assert.Equal(t, user.Address, v1.Address)
+}
+
+func TestNegotiateUnsupportedVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		accept string
+	}{
+		{"unknown version", http.MethodGet, "/api/users/d035e79d-ffe9-4ebf-b665-747353b3ea40", "application/vnd.user-crud.v3+json"},
+		{"v1 only endpoint", http.MethodGet, "/api/users/export", V2_MEDIA_TYPE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := runVersioned(t, tt.method, tt.url, map[string]string{"Accept": tt.accept}, "", func(us *mocks.UserService) {})
+
+			assert.Equal(t, http.StatusNotAcceptable, w.Code)
+
+			var res problems.Problem
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			assert.Equal(t, problems.Type("unsupported-version"), res.Type)
+		})
+	}
+}
+
+func TestCreateUserV2(t *testing.T) {
+	user := versionedUser()
+	body := `{
+		"name": "John Doe",
+		"birth_date": "1970-01-31",
+		"email": "joe25@mailprovider.com",
+		"address": {"street": "3197 Woodrow Way", "city": "Springfield", "country": "US"},
+		"password": "secret"
+	}`
+
+	w := runVersioned(t, http.MethodPost, "/api/v2/users/", map[string]string{"Content-Type": "application/json"}, body, func(us *mocks.UserService) {
+		us.On("CreateUserWithParams", map[string]string{
+			"name":                "John Doe",
+			"birth_date":          "1970-01-31",
+			"email":               "joe25@mailprovider.com",
+			"password":            "secret",
+			"address.street":      "3197 Woodrow Way",
+			"address.city":        "Springfield",
+			"address.region":      "",
+			"address.postal_code": "",
+			"address.country":     "US",
+		}).Return(user, nil)
+	})
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var res UserResponseV2
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, user.UUID.String(), res.UUID)
+	assert.Equal(t, "US", res.Address.Country)
+}
+
+func TestUpdateUserV2MergePatch(t *testing.T) {
+	current := versionedUser()
+	updated := current
+	updated.Version = 4
+
+	w := runVersioned(t, http.MethodPatch, "/api/v2/users/"+current.UUID.String(), map[string]string{"Content-Type": MERGE_PATCH_CONTENT_TYPE}, `{"address": {"region": "IL"}, "password": "new"}`, func(us *mocks.UserService) {
+		us.On("GetCurrentUser", current.UUID.String()).Return(current, nil)
+		us.On("UpdateUserIfMatch", current.UUID.String(), int64(3), map[string]string{"address.region": "IL", "password": "new"}).Return(updated, nil)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"v2-4"`, w.Header().Get("ETag"))
+}
+
+func TestGetUserETagPerVersion(t *testing.T) {
+	user := versionedUser()
+	setup := func(us *mocks.UserService) {
+		us.On("GetUser", user.UUID.String()).Return(user, nil)
+		us.On("GetCurrentUser", user.UUID.String()).Return(user, nil)
+	}
+
+	w := runVersioned(t, http.MethodGet, "/api/v2/users/"+user.UUID.String(), nil, "", setup)
+	assert.Equal(t, `"v2-3"`, w.Header().Get("ETag"))
+
+	// A v1 tag of the same version does not validate the v2 document.
+	w = runVersioned(t, http.MethodGet, "/api/v2/users/"+user.UUID.String(), map[string]string{"If-None-Match": `"3"`}, "", setup)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = runVersioned(t, http.MethodGet, "/api/v2/users/"+user.UUID.String(), map[string]string{"If-None-Match": `"v2-3"`}, "", setup)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
+
+func TestParseETagOfEitherVersion(t *testing.T) {
+	for _, tag := range []string{`"3"`, `"v2-3"`} {
+		version, ok := parseETag(tag)
+		assert.True(t, ok, tag)
+		assert.Equal(t, int64(3), version, tag)
+	}
+
+	_, ok := parseETag(`"v3-3"`)
+	assert.False(t, ok)
+}
+
+func TestUpdateUserV2ReadOnlyField(t *testing.T) {
+	current := versionedUser()
+
+	w := runVersioned(t, http.MethodPatch, "/api/v2/users/"+current.UUID.String(), map[string]string{"Content-Type": MERGE_PATCH_CONTENT_TYPE}, `{"created_at": "2020-01-01T00:00:00Z"}`, func(us *mocks.UserService) {
+		us.On("GetCurrentUser", current.UUID.String()).Return(current, nil)
+	})
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestPutUserV2(t *testing.T) {
+	user := versionedUser()
+	body := `{
+		"uuid": "d035e79d-ffe9-4ebf-b665-747353b3ea40",
+		"name": "John Doe",
+		"birth_date": "1970-01-31",
+		"email": "joe25@mailprovider.com",
+		"address": {"street": "3197 Woodrow Way"},
+		"created_at": "2024-03-01T10:00:00Z"
+	}`
+
+	w := runVersioned(t, http.MethodPut, "/api/v2/users/"+user.UUID.String(), map[string]string{"Content-Type": "application/json"}, body, func(us *mocks.UserService) {
+		us.On("ReplaceUser", user.UUID.String(), map[string]string{
+			"name":                "John Doe",
+			"birth_date":          "1970-01-31",
+			"email":               "joe25@mailprovider.com",
+			"address.street":      "3197 Woodrow Way",
+			"address.city":        "",
+			"address.region":      "",
+			"address.postal_code": "",
+			"address.country":     "",
+		}).Return(user, false, nil)
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.NotContains(t, doc, "password")
+}
+
+func TestDocumentFieldsV2(t *testing.T) {
+	fields, err := documentFieldsV2([]byte(`{"name": "John Doe", "address": null}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"name":                "John Doe",
+		"address.street":      "",
+		"address.city":        "",
+		"address.region":      "",
+		"address.postal_code": "",
+		"address.country":     "",
+	}, fields)
+
+	_, err = documentFieldsV2([]byte(`{"address": {"planet": "Earth"}}`))
+	assert.ErrorIs(t, err, ErrUnknownField)
+
+	_, err = documentFieldsV2([]byte(`{"address": "3197 Woodrow Way"}`))
+	assert.ErrorIs(t, err, ErrInvalidField)
+}
@@ -0,0 +1,76 @@
+package emails
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+var ErrInvalidEmail = errors.New("invalid email")
+
+// provider describes how a mailbox provider ignores parts of the local part.
+type provider struct {
+	// canonical replaces the domain, for providers with several domains
+	// delivering to the same mailboxes.
+	canonical     string
+	ignoreDots    bool
+	plusAddresses bool
+}
+
+var providers = map[string]provider{
+	"gmail.com":      {ignoreDots: true, plusAddresses: true},
+	"googlemail.com": {canonical: "gmail.com", ignoreDots: true, plusAddresses: true},
+	"outlook.com":    {plusAddresses: true},
+	"hotmail.com":    {plusAddresses: true},
+	"live.com":       {plusAddresses: true},
+	"icloud.com":     {plusAddresses: true},
+	"me.com":         {canonical: "icloud.com", plusAddresses: true},
+	"fastmail.com":   {plusAddresses: true},
+	"protonmail.com": {plusAddresses: true},
+	"proton.me":      {canonical: "protonmail.com", plusAddresses: true},
+}
+
+// Normalizer derives the canonical form of an email address, used to decide
+// whether two addresses belong to the same person.
+type Normalizer struct {
+	// ProviderRules applies the rules of known providers, such as Gmail
+	// ignoring dots and +tags, so j.o.e+news@gmail.com and joe@gmail.com
+	// are the same address.
+	ProviderRules bool
+}
+
+// Canonical returns address trimmed and lowercased, with provider rules
+// applied if enabled. Mail servers may treat the local part as case
+// sensitive, but no provider in practice does, so it is lowercased too.
+func (n Normalizer) Canonical(address string) (string, error) {
+	parsed, err := mail.ParseAddress(strings.TrimSpace(address))
+	if err != nil {
+		return "", ErrInvalidEmail
+	}
+
+	at := strings.LastIndex(parsed.Address, "@")
+	if at < 1 {
+		return "", ErrInvalidEmail
+	}
+
+	local := strings.ToLower(parsed.Address[:at])
+	domain := strings.ToLower(parsed.Address[at+1:])
+
+	if n.ProviderRules {
+		if p, ok := providers[domain]; ok {
+			if p.plusAddresses {
+				if plus := strings.Index(local, "+"); plus > 0 {
+					local = local[:plus]
+				}
+			}
+			if p.ignoreDots {
+				local = strings.ReplaceAll(local, ".", "")
+			}
+			if p.canonical != "" {
+				domain = p.canonical
+			}
+		}
+	}
+
+	return local + "@" + domain, nil
+}
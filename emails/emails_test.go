@@ -0,0 +1,52 @@
+package emails
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonical(t *testing.T) {
+	cases := map[string]string{
+		"joe25@mailprovider.com":     "joe25@mailprovider.com",
+		"  Joe@Mail.com ":            "joe@mail.com",
+		"JOE@MAIL.COM":               "joe@mail.com",
+		"John Doe <Joe@Mail.com>":    "joe@mail.com",
+		"j.o.e+news@gmail.com":       "j.o.e+news@gmail.com",
+		"first.last+tag@outlook.com": "first.last+tag@outlook.com",
+	}
+
+	for address, expected := range cases {
+		canonical, err := Normalizer{}.Canonical(address)
+
+		assert.NoError(t, err, address)
+		assert.Equal(t, expected, canonical, address)
+	}
+}
+
+func TestCanonicalWithProviderRules(t *testing.T) {
+	cases := map[string]string{
+		"J.O.E+news@Gmail.com":       "joe@gmail.com",
+		"joe@googlemail.com":         "joe@gmail.com",
+		"first.last+tag@outlook.com": "first.last@outlook.com",
+		"first.last+tag@example.com": "first.last+tag@example.com",
+		"+tag@gmail.com":             "+tag@gmail.com",
+	}
+
+	n := Normalizer{ProviderRules: true}
+
+	for address, expected := range cases {
+		canonical, err := n.Canonical(address)
+
+		assert.NoError(t, err, address)
+		assert.Equal(t, expected, canonical, address)
+	}
+}
+
+func TestCanonicalInvalid(t *testing.T) {
+	for _, address := range []string{"", "not_valid_email", "@mail.com", "joe@"} {
+		_, err := Normalizer{}.Canonical(address)
+
+		assert.Equal(t, ErrInvalidEmail, err, address)
+	}
+}
@@ -0,0 +1,65 @@
+package infrastructures
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const DEFAULT_BACKOFF_INITIAL = 500 * time.Millisecond
+const DEFAULT_BACKOFF_MAX = 30 * time.Second
+
+// Backoff computes exponentially growing delays between attempts, capped at
+// Max. Each delay is randomized between half and the full value so that
+// instances started together do not retry in lockstep.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Delay returns how long to wait after the given failed attempt, counted
+// from 1.
+func (b Backoff) Delay(attempt int) time.Duration {
+	initial, max := b.Initial, b.Max
+	if initial <= 0 {
+		initial = DEFAULT_BACKOFF_INITIAL
+	}
+	if max <= 0 {
+		max = DEFAULT_BACKOFF_MAX
+	}
+
+	d := initial
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Retry calls fn until it succeeds or ctx is done, waiting Delay between
+// attempts. onError, if set, is told about each failure and the wait before
+// the next attempt. The last error is returned when ctx ends first.
+func (b Backoff) Retry(ctx context.Context, fn func(context.Context) error, onError func(attempt int, err error, wait time.Duration)) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		wait := b.Delay(attempt)
+		if onError != nil {
+			onError(attempt, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
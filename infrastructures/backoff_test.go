@@ -0,0 +1,56 @@
+package infrastructures
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelayGrowsWithJitterUpToMax(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+
+	for i := 0; i < 100; i++ {
+		d := b.Delay(1)
+		assert.True(t, d >= 50*time.Millisecond && d <= 100*time.Millisecond, d)
+
+		d = b.Delay(3)
+		assert.True(t, d >= 200*time.Millisecond && d <= 400*time.Millisecond, d)
+
+		d = b.Delay(50)
+		assert.True(t, d >= 500*time.Millisecond && d <= time.Second, d)
+	}
+}
+
+func TestBackoffRetryUntilSuccess(t *testing.T) {
+	b := Backoff{Initial: time.Millisecond, Max: time.Millisecond}
+	calls := 0
+	failures := 0
+
+	err := b.Retry(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("unreachable")
+		}
+		return nil
+	}, func(attempt int, err error, wait time.Duration) {
+		failures++
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 2, failures)
+}
+
+func TestBackoffRetryStopsWithContext(t *testing.T) {
+	b := Backoff{Initial: time.Hour, Max: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	unreachable := errors.New("unreachable")
+	err := b.Retry(ctx, func(ctx context.Context) error { return unreachable }, nil)
+
+	assert.ErrorIs(t, err, unreachable)
+}
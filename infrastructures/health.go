@@ -0,0 +1,77 @@
+package infrastructures
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const DEFAULT_HEALTH_INTERVAL = 10 * time.Second
+const PING_TIMEOUT = 5 * time.Second
+
+// Readiness records whether the service can handle requests. It starts
+// not ready.
+type Readiness struct {
+	ready atomic.Bool
+}
+
+func (r *Readiness) Ready() bool {
+	return r.ready.Load()
+}
+
+func (r *Readiness) Set(ready bool) {
+	r.ready.Store(ready)
+}
+
+// MongoMonitor keeps Readiness in line with whether Mongo answers pings.
+// The driver reconnects on its own; the monitor only reports, so load
+// balancers stop routing to an instance that cannot reach the database.
+type MongoMonitor struct {
+	Client    *mongo.Client
+	Readiness *Readiness
+	Interval  time.Duration
+}
+
+// Run pings every Interval until ctx is done.
+func (m MongoMonitor) Run(ctx context.Context) {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = DEFAULT_HEALTH_INTERVAL
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := Ping(ctx, m.Client)
+		ready := err == nil
+
+		if ready != m.Readiness.Ready() {
+			if ready {
+				log.Print("mongo reachable again, ready")
+			} else {
+				log.Printf("mongo unreachable, not ready: %v", err)
+			}
+		}
+
+		m.Readiness.Set(ready)
+	}
+}
+
+// Ping checks that the primary, or the server selected by the read
+// preference, answers within PING_TIMEOUT.
+func Ping(ctx context.Context, client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, PING_TIMEOUT)
+	defer cancel()
+
+	return client.Ping(ctx, nil)
+}
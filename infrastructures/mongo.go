@@ -2,24 +2,192 @@ package infrastructures
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
-func CreateMongoClient(uri, username, password string) (*mongo.Client, error) {
+// MongoConfig holds the client settings. Zero values leave the setting to
+// the URI, or to the driver default when the URI does not set it either.
+type MongoConfig struct {
+	URI      string
+	Username string
+	Password string
 
-	credential := options.Credential{
-		Username: username,
-		Password: password,
+	MinPoolSize     uint64
+	MaxPoolSize     uint64
+	MaxConnIdleTime time.Duration
+
+	// ReadPreference is a mode such as primary, primaryPreferred,
+	// secondary, secondaryPreferred or nearest.
+	ReadPreference string
+	// ReadConcern is a level such as local, majority or linearizable.
+	ReadConcern string
+	// WriteConcern is majority or the number of members that must
+	// acknowledge a write.
+	WriteConcern string
+	WriteJournal bool
+	WriteTimeout time.Duration
+
+	TLS TLSConfig
+
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	SocketTimeout          time.Duration
+}
+
+type TLSConfig struct {
+	Enabled bool
+	// CAFile verifies the server certificate instead of the system roots.
+	CAFile string
+	// CertFile and KeyFile hold the client certificate, for clusters that
+	// authenticate clients with x.509.
+	CertFile string
+	KeyFile  string
+	// Insecure skips verification of the server certificate. Only meant
+	// for development.
+	Insecure bool
+}
+
+// CreateMongoClient creates a client without waiting for Mongo. Connections
+// are opened in the background and reopened after failures, so the client
+// can be created before the database is reachable; use Backoff.Retry with
+// Ping to wait for it.
+func CreateMongoClient(config MongoConfig) (*mongo.Client, error) {
+
+	clientOpts, err := ClientOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return mongo.Connect(context.Background(), clientOpts)
+}
+
+// ClientOptions builds the driver options for config.
+func ClientOptions(config MongoConfig) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(config.URI)
+
+	if config.Username != "" || config.Password != "" {
+		opts.SetAuth(options.Credential{
+			Username: config.Username,
+			Password: config.Password,
+		})
+	}
+
+	if config.MinPoolSize > 0 {
+		opts.SetMinPoolSize(config.MinPoolSize)
+	}
+	if config.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(config.MaxPoolSize)
+	}
+	if config.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(config.MaxConnIdleTime)
+	}
+
+	if config.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(config.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(rp)
+	}
+
+	if config.ReadConcern != "" {
+		opts.SetReadConcern(readconcern.New(readconcern.Level(config.ReadConcern)))
+	}
+
+	if config.WriteConcern != "" || config.WriteJournal || config.WriteTimeout > 0 {
+		wc, err := writeConcern(config)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetWriteConcern(wc)
+	}
+
+	if config.TLS.Enabled {
+		tlsConfig, err := tlsConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	if config.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(config.ConnectTimeout)
+	}
+	if config.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(config.ServerSelectionTimeout)
+	}
+	if config.SocketTimeout > 0 {
+		opts.SetSocketTimeout(config.SocketTimeout)
+	}
+
+	return opts, opts.Validate()
+}
+
+func writeConcern(config MongoConfig) (*writeconcern.WriteConcern, error) {
+	var wcOpts []writeconcern.Option
+
+	switch config.WriteConcern {
+	case "":
+	case "majority":
+		wcOpts = append(wcOpts, writeconcern.WMajority())
+	default:
+		w, err := strconv.Atoi(config.WriteConcern)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid write concern %q", config.WriteConcern)
+		}
+		wcOpts = append(wcOpts, writeconcern.W(w))
+	}
+
+	if config.WriteJournal {
+		wcOpts = append(wcOpts, writeconcern.J(true))
 	}
-	clientOpts := options.Client().ApplyURI(uri).SetAuth(credential)
-	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
-	defer cancel()
-	client, err := mongo.Connect(ctx, clientOpts)
+	if config.WriteTimeout > 0 {
+		wcOpts = append(wcOpts, writeconcern.WTimeout(config.WriteTimeout))
+	}
+
+	return writeconcern.New(wcOpts...), nil
+}
 
-	err = client.Ping(ctx, nil)
+func tlsConfig(config TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.Insecure,
+	}
+
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + config.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
 
-	return client, err
+	return tlsConfig, nil
 }
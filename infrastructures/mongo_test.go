@@ -0,0 +1,59 @@
+package infrastructures
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestClientOptionsAppliesSettings(t *testing.T) {
+	opts, err := ClientOptions(MongoConfig{
+		URI:                    "mongodb://localhost:27017",
+		MaxPoolSize:            50,
+		ReadPreference:         "secondaryPreferred",
+		ReadConcern:            "majority",
+		WriteConcern:           "majority",
+		WriteJournal:           true,
+		ServerSelectionTimeout: 2 * time.Second,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(50), *opts.MaxPoolSize)
+	assert.Equal(t, readpref.SecondaryPreferredMode, opts.ReadPreference.Mode())
+	assert.Equal(t, "majority", opts.ReadConcern.GetLevel())
+	assert.Equal(t, 2*time.Second, *opts.ServerSelectionTimeout)
+
+	assert.Equal(t, "majority", opts.WriteConcern.GetW())
+	assert.True(t, opts.WriteConcern.GetJ())
+}
+
+func TestClientOptionsLeavesURISettings(t *testing.T) {
+	opts, err := ClientOptions(MongoConfig{URI: "mongodb://localhost:27017/?maxPoolSize=7"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(7), *opts.MaxPoolSize)
+	assert.Nil(t, opts.Auth)
+}
+
+func TestClientOptionsNumericWriteConcern(t *testing.T) {
+	opts, err := ClientOptions(MongoConfig{URI: "mongodb://localhost:27017", WriteConcern: "2"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, opts.WriteConcern.GetW())
+}
+
+func TestClientOptionsRejectsInvalidSettings(t *testing.T) {
+	_, err := ClientOptions(MongoConfig{URI: "mongodb://localhost:27017", ReadPreference: "fastest"})
+	assert.Error(t, err)
+
+	_, err = ClientOptions(MongoConfig{URI: "mongodb://localhost:27017", WriteConcern: "all"})
+	assert.Error(t, err)
+
+	_, err = ClientOptions(MongoConfig{
+		URI: "mongodb://localhost:27017",
+		TLS: TLSConfig{Enabled: true, CAFile: "does-not-exist.pem"},
+	})
+	assert.Error(t, err)
+}
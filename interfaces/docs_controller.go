@@ -0,0 +1,8 @@
+package interfaces
+
+import "github.com/gin-gonic/gin"
+
+type DocsController interface {
+	Spec(ctx *gin.Context)
+	UI(ctx *gin.Context)
+}
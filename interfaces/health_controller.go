@@ -0,0 +1,8 @@
+package interfaces
+
+import "github.com/gin-gonic/gin"
+
+type HealthController interface {
+	Live(ctx *gin.Context)
+	Ready(ctx *gin.Context)
+}
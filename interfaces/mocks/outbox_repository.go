@@ -0,0 +1,53 @@
+package mocks
+
+import (
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	mock "github.com/stretchr/testify/mock"
+)
+
+type OutboxRepository struct {
+	mock.Mock
+}
+
+func (o *OutboxRepository) PendingEvents(limit int) ([]models.Event, error) {
+	ret := o.Called(limit)
+
+	var events []models.Event
+	if rf, ok := ret.Get(0).(func(int) []models.Event); ok {
+		events = rf(limit)
+	} else if ret.Get(0) != nil {
+		events = ret.Get(0).([]models.Event)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(int) error); ok {
+		err = rf(limit)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return events, err
+}
+
+func (o *OutboxRepository) MarkPublished(id uuid.UUID) error {
+	ret := o.Called(id)
+	var err error
+	if rf, ok := ret.Get(0).(func(uuid.UUID) error); ok {
+		err = rf(id)
+	} else {
+		err = ret.Error(0)
+	}
+	return err
+}
+
+func (o *OutboxRepository) MarkFailed(id uuid.UUID) error {
+	ret := o.Called(id)
+	var err error
+	if rf, ok := ret.Get(0).(func(uuid.UUID) error); ok {
+		err = rf(id)
+	} else {
+		err = ret.Error(0)
+	}
+	return err
+}
@@ -0,0 +1,21 @@
+package mocks
+
+import (
+	"github.com/ffardo/user-crud/models"
+	mock "github.com/stretchr/testify/mock"
+)
+
+type Publisher struct {
+	mock.Mock
+}
+
+func (p *Publisher) Publish(event models.Event) error {
+	ret := p.Called(event)
+	var err error
+	if rf, ok := ret.Get(0).(func(models.Event) error); ok {
+		err = rf(event)
+	} else {
+		err = ret.Error(0)
+	}
+	return err
+}
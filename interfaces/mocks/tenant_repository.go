@@ -0,0 +1,86 @@
+package mocks
+
+import (
+	"github.com/ffardo/user-crud/models"
+	mock "github.com/stretchr/testify/mock"
+)
+
+type TenantRepository struct {
+	mock.Mock
+}
+
+func (t *TenantRepository) GetTenant(id string) (models.Tenant, error) {
+	ret := t.Called(id)
+	return tenantResult(ret, id)
+}
+
+func (t *TenantRepository) GetTenantByAPIKeyHash(hash string) (models.Tenant, error) {
+	ret := t.Called(hash)
+	return tenantResult(ret, hash)
+}
+
+func (t *TenantRepository) ListTenants() ([]models.Tenant, error) {
+	ret := t.Called()
+
+	var tenants []models.Tenant
+	if rf, ok := ret.Get(0).(func() []models.Tenant); ok {
+		tenants = rf()
+	} else if ret.Get(0) != nil {
+		tenants = ret.Get(0).([]models.Tenant)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		err = rf()
+	} else {
+		err = ret.Error(1)
+	}
+
+	return tenants, err
+}
+
+func (t *TenantRepository) CreateTenant(tenant models.Tenant) (models.Tenant, error) {
+	ret := t.Called(tenant)
+	return tenantWriteResult(ret, tenant)
+}
+
+func (t *TenantRepository) UpdateTenant(tenant models.Tenant) (models.Tenant, error) {
+	ret := t.Called(tenant)
+	return tenantWriteResult(ret, tenant)
+}
+
+func tenantResult(ret mock.Arguments, arg string) (models.Tenant, error) {
+	var tenant models.Tenant
+	if rf, ok := ret.Get(0).(func(string) models.Tenant); ok {
+		tenant = rf(arg)
+	} else {
+		tenant = ret.Get(0).(models.Tenant)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		err = rf(arg)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return tenant, err
+}
+
+func tenantWriteResult(ret mock.Arguments, arg models.Tenant) (models.Tenant, error) {
+	var tenant models.Tenant
+	if rf, ok := ret.Get(0).(func(models.Tenant) models.Tenant); ok {
+		tenant = rf(arg)
+	} else {
+		tenant = ret.Get(0).(models.Tenant)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(models.Tenant) error); ok {
+		err = rf(arg)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return tenant, err
+}
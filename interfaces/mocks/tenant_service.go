@@ -0,0 +1,71 @@
+package mocks
+
+import (
+	"github.com/ffardo/user-crud/models"
+	mock "github.com/stretchr/testify/mock"
+)
+
+type TenantService struct {
+	mock.Mock
+}
+
+func (s *TenantService) GetTenant(id string) (models.Tenant, error) {
+	ret := s.Called(id)
+	return tenantResult(ret, id)
+}
+
+func (s *TenantService) Authenticate(apiKey string) (models.Tenant, error) {
+	ret := s.Called(apiKey)
+	return tenantResult(ret, apiKey)
+}
+
+func (s *TenantService) ListTenants() ([]models.Tenant, error) {
+	ret := s.Called()
+
+	var tenants []models.Tenant
+	if rf, ok := ret.Get(0).(func() []models.Tenant); ok {
+		tenants = rf()
+	} else if ret.Get(0) != nil {
+		tenants = ret.Get(0).([]models.Tenant)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		err = rf()
+	} else {
+		err = ret.Error(1)
+	}
+
+	return tenants, err
+}
+
+func (s *TenantService) CreateTenant(id, name string, settings models.TenantSettings) (models.Tenant, string, error) {
+	ret := s.Called(id, name, settings)
+
+	var tenant models.Tenant
+	if rf, ok := ret.Get(0).(func(string, string, models.TenantSettings) models.Tenant); ok {
+		tenant = rf(id, name, settings)
+	} else {
+		tenant = ret.Get(0).(models.Tenant)
+	}
+
+	return tenant, ret.String(1), ret.Error(2)
+}
+
+func (s *TenantService) UpdateTenant(id string, name *string, settings *models.TenantSettings) (models.Tenant, error) {
+	ret := s.Called(id, name, settings)
+
+	var tenant models.Tenant
+	if rf, ok := ret.Get(0).(func(string, *string, *models.TenantSettings) models.Tenant); ok {
+		tenant = rf(id, name, settings)
+	} else {
+		tenant = ret.Get(0).(models.Tenant)
+	}
+
+	return tenant, ret.Error(1)
+}
+
+func (s *TenantService) RotateAPIKey(id string) (string, error) {
+	ret := s.Called(id)
+	return ret.String(0), ret.Error(1)
+}
@@ -1,6 +1,9 @@
 package mocks
 
 import (
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
 	"github.com/ffardo/user-crud/models"
 	"github.com/google/uuid"
 	mock "github.com/stretchr/testify/mock"
@@ -10,7 +13,7 @@ type UserRepository struct {
 	mock.Mock
 }
 
-func (u UserRepository) GetUserByUUID(user_uuid uuid.UUID) (models.User, error) {
+func (u *UserRepository) GetUserByUUID(user_uuid uuid.UUID) (models.User, error) {
 	ret := u.Called(user_uuid)
 
 	var user models.User
@@ -30,7 +33,7 @@ func (u UserRepository) GetUserByUUID(user_uuid uuid.UUID) (models.User, error)
 	return user, err
 }
 
-func (u UserRepository) UserExistsWithEmail(email string) (bool, error) {
+func (u *UserRepository) UserExistsWithEmail(email string) (bool, error) {
 	ret := u.Called(email)
 
 	var exists bool
@@ -50,7 +53,7 @@ func (u UserRepository) UserExistsWithEmail(email string) (bool, error) {
 	return exists, err
 }
 
-func (u UserRepository) UserExistsWithEmailAndNotUuid(email string, user_uuid uuid.UUID) (bool, error) {
+func (u *UserRepository) UserExistsWithEmailAndNotUuid(email string, user_uuid uuid.UUID) (bool, error) {
 	ret := u.Called(email, user_uuid)
 
 	var exists bool
@@ -70,7 +73,7 @@ func (u UserRepository) UserExistsWithEmailAndNotUuid(email string, user_uuid uu
 	return exists, err
 }
 
-func (u UserRepository) CreateUser(user models.User) (models.User, error) {
+func (u *UserRepository) CreateUser(user models.User) (models.User, error) {
 	ret := u.Called(user)
 
 	var mockedUser models.User
@@ -90,7 +93,7 @@ func (u UserRepository) CreateUser(user models.User) (models.User, error) {
 	return mockedUser, err
 }
 
-func (u UserRepository) UpdateUser(user models.User) (models.User, error) {
+func (u *UserRepository) UpdateUser(user models.User) (models.User, error) {
 	ret := u.Called(user)
 
 	var mockedUser models.User
@@ -110,7 +113,7 @@ func (u UserRepository) UpdateUser(user models.User) (models.User, error) {
 	return mockedUser, err
 }
 
-func (u UserRepository) DeleteUser(user_uuid uuid.UUID) error {
+func (u *UserRepository) DeleteUser(user_uuid uuid.UUID) error {
 	ret := u.Called(user_uuid)
 	var err error
 	if rf, ok := ret.Get(0).(func(uuid.UUID) error); ok {
@@ -120,3 +123,170 @@ func (u UserRepository) DeleteUser(user_uuid uuid.UUID) error {
 	}
 	return err
 }
+
+func (u *UserRepository) RestoreUser(user_uuid uuid.UUID) (models.User, error) {
+	ret := u.Called(user_uuid)
+
+	var user models.User
+	if rf, ok := ret.Get(0).(func(uuid.UUID) models.User); ok {
+		user = rf(user_uuid)
+	} else {
+		user = ret.Get(0).(models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(uuid.UUID) error); ok {
+		err = rf(user_uuid)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return user, err
+}
+
+func (u *UserRepository) ListDeletedUsers() ([]models.User, error) {
+	ret := u.Called()
+
+	var users []models.User
+	if rf, ok := ret.Get(0).(func() []models.User); ok {
+		users = rf()
+	} else if ret.Get(0) != nil {
+		users = ret.Get(0).([]models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		err = rf()
+	} else {
+		err = ret.Error(1)
+	}
+
+	return users, err
+}
+
+func (u *UserRepository) PurgeDeletedUsers(before time.Time) (int64, error) {
+	ret := u.Called(before)
+
+	var purged int64
+	if rf, ok := ret.Get(0).(func(time.Time) int64); ok {
+		purged = rf(before)
+	} else {
+		purged = ret.Get(0).(int64)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(time.Time) error); ok {
+		err = rf(before)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return purged, err
+}
+
+func (u *UserRepository) AddEvent(event models.Event) error {
+	ret := u.Called(event)
+	var err error
+	if rf, ok := ret.Get(0).(func(models.Event) error); ok {
+		err = rf(event)
+	} else {
+		err = ret.Error(0)
+	}
+	return err
+}
+
+// WithTransaction runs fn against the mock itself, so expectations set on it
+// apply to calls made inside the transaction.
+func (u *UserRepository) WithTransaction(fn func(interfaces.UserRepository) error) error {
+	return fn(u)
+}
+
+func (u *UserRepository) ForTenant(tenantID string) interfaces.UserRepository {
+	ret := u.Called(tenantID)
+
+	if rf, ok := ret.Get(0).(func(string) interfaces.UserRepository); ok {
+		return rf(tenantID)
+	}
+
+	return ret.Get(0).(interfaces.UserRepository)
+}
+
+func (u *UserRepository) ListUsers(query interfaces.UserQuery) ([]models.User, error) {
+	ret := u.Called(query)
+
+	var users []models.User
+	if rf, ok := ret.Get(0).(func(interfaces.UserQuery) []models.User); ok {
+		users = rf(query)
+	} else if ret.Get(0) != nil {
+		users = ret.Get(0).([]models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(interfaces.UserQuery) error); ok {
+		err = rf(query)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return users, err
+}
+
+func (u *UserRepository) SearchUsers(text string, limit int) ([]interfaces.UserMatch, error) {
+	ret := u.Called(text, limit)
+
+	var matches []interfaces.UserMatch
+	if rf, ok := ret.Get(0).(func(string, int) []interfaces.UserMatch); ok {
+		matches = rf(text, limit)
+	} else if ret.Get(0) != nil {
+		matches = ret.Get(0).([]interfaces.UserMatch)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		err = rf(text, limit)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return matches, err
+}
+
+func (u *UserRepository) SimilarUsers(trigrams []string, limit int) ([]interfaces.UserMatch, error) {
+	ret := u.Called(trigrams, limit)
+
+	var matches []interfaces.UserMatch
+	if rf, ok := ret.Get(0).(func([]string, int) []interfaces.UserMatch); ok {
+		matches = rf(trigrams, limit)
+	} else if ret.Get(0) != nil {
+		matches = ret.Get(0).([]interfaces.UserMatch)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func([]string, int) error); ok {
+		err = rf(trigrams, limit)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return matches, err
+}
+
+func (u *UserRepository) WriteUsers(writes []interfaces.UserWrite) ([]models.User, error) {
+	ret := u.Called(writes)
+
+	var users []models.User
+	if rf, ok := ret.Get(0).(func([]interfaces.UserWrite) []models.User); ok {
+		users = rf(writes)
+	} else if ret.Get(0) != nil {
+		users = ret.Get(0).([]models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func([]interfaces.UserWrite) error); ok {
+		err = rf(writes)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return users, err
+}
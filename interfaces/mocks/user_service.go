@@ -1,6 +1,7 @@
 package mocks
 
 import (
+	"github.com/ffardo/user-crud/interfaces"
 	"github.com/ffardo/user-crud/models"
 	mock "github.com/stretchr/testify/mock"
 )
@@ -9,7 +10,7 @@ type UserService struct {
 	mock.Mock
 }
 
-func (s UserService) GetUser(user_uuid string) (models.User, error) {
+func (s *UserService) GetUser(user_uuid string) (models.User, error) {
 	ret := s.Called(user_uuid)
 	var user models.User
 
@@ -29,7 +30,27 @@ func (s UserService) GetUser(user_uuid string) (models.User, error) {
 	return user, err
 }
 
-func (s UserService) CreateUser(name, birthDate, email, address, password string) (models.User, error) {
+func (s *UserService) GetCurrentUser(user_uuid string) (models.User, error) {
+	ret := s.Called(user_uuid)
+	var user models.User
+
+	if rf, ok := ret.Get(0).(func(string) models.User); ok {
+		user = rf(user_uuid)
+	} else {
+		user = ret.Get(0).(models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		err = rf(user_uuid)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return user, err
+}
+
+func (s *UserService) CreateUser(name, birthDate, email, address, password string) (models.User, error) {
 	ret := s.Called(name, birthDate, email, address, password)
 	var user models.User
 
@@ -49,7 +70,114 @@ func (s UserService) CreateUser(name, birthDate, email, address, password string
 	return user, err
 }
 
-func (s UserService) UpdateUser(user_uuid string, params map[string]string) (models.User, error) {
+func (s *UserService) CreateUserWithParams(params map[string]string) (models.User, error) {
+	ret := s.Called(params)
+	var user models.User
+
+	if rf, ok := ret.Get(0).(func(map[string]string) models.User); ok {
+		user = rf(params)
+	} else {
+		user = ret.Get(0).(models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(map[string]string) error); ok {
+		err = rf(params)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return user, err
+}
+
+func (s *UserService) UpdateUser(user_uuid string, params map[string]string) (models.User, error) {
+	ret := s.Called(user_uuid, params)
+	var user models.User
+
+	if rf, ok := ret.Get(0).(func(string, map[string]string) models.User); ok {
+		user = rf(user_uuid, params)
+	} else {
+		user = ret.Get(0).(models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(string, map[string]string) error); ok {
+		err = rf(user_uuid, params)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return user, err
+}
+
+func (s *UserService) UpdateUserIfMatch(user_uuid string, version int64, params map[string]string) (models.User, error) {
+	ret := s.Called(user_uuid, version, params)
+	var user models.User
+
+	if rf, ok := ret.Get(0).(func(string, int64, map[string]string) models.User); ok {
+		user = rf(user_uuid, version, params)
+	} else {
+		user = ret.Get(0).(models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(string, int64, map[string]string) error); ok {
+		err = rf(user_uuid, version, params)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return user, err
+}
+
+func (s *UserService) ReplaceUser(user_uuid string, params map[string]string) (models.User, bool, error) {
+	ret := s.Called(user_uuid, params)
+	var user models.User
+
+	if rf, ok := ret.Get(0).(func(string, map[string]string) models.User); ok {
+		user = rf(user_uuid, params)
+	} else {
+		user = ret.Get(0).(models.User)
+	}
+
+	var created bool
+	if rf, ok := ret.Get(1).(func(string, map[string]string) bool); ok {
+		created = rf(user_uuid, params)
+	} else {
+		created = ret.Bool(1)
+	}
+
+	var err error
+	if rf, ok := ret.Get(2).(func(string, map[string]string) error); ok {
+		err = rf(user_uuid, params)
+	} else {
+		err = ret.Error(2)
+	}
+
+	return user, created, err
+}
+
+func (s *UserService) ReplaceUserIfMatch(user_uuid string, version int64, params map[string]string) (models.User, error) {
+	ret := s.Called(user_uuid, version, params)
+	var user models.User
+
+	if rf, ok := ret.Get(0).(func(string, int64, map[string]string) models.User); ok {
+		user = rf(user_uuid, version, params)
+	} else {
+		user = ret.Get(0).(models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(string, int64, map[string]string) error); ok {
+		err = rf(user_uuid, version, params)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return user, err
+}
+
+func (s *UserService) ReplaceUserIfExists(user_uuid string, params map[string]string) (models.User, error) {
 	ret := s.Called(user_uuid, params)
 	var user models.User
 
@@ -69,7 +197,27 @@ func (s UserService) UpdateUser(user_uuid string, params map[string]string) (mod
 	return user, err
 }
 
-func (s UserService) DeleteUser(user_uuid string) error {
+func (s *UserService) CreateUserIfAbsent(user_uuid string, params map[string]string) (models.User, error) {
+	ret := s.Called(user_uuid, params)
+	var user models.User
+
+	if rf, ok := ret.Get(0).(func(string, map[string]string) models.User); ok {
+		user = rf(user_uuid, params)
+	} else {
+		user = ret.Get(0).(models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(string, map[string]string) error); ok {
+		err = rf(user_uuid, params)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return user, err
+}
+
+func (s *UserService) DeleteUser(user_uuid string) error {
 	ret := s.Called(user_uuid)
 
 	var err error
@@ -81,3 +229,133 @@ func (s UserService) DeleteUser(user_uuid string) error {
 
 	return err
 }
+
+func (s *UserService) RestoreUser(user_uuid string) (models.User, error) {
+	ret := s.Called(user_uuid)
+	var user models.User
+
+	if rf, ok := ret.Get(0).(func(string) models.User); ok {
+		user = rf(user_uuid)
+	} else {
+		user = ret.Get(0).(models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		err = rf(user_uuid)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return user, err
+}
+
+func (s *UserService) ListDeletedUsers() ([]models.User, error) {
+	ret := s.Called()
+	var users []models.User
+
+	if rf, ok := ret.Get(0).(func() []models.User); ok {
+		users = rf()
+	} else if ret.Get(0) != nil {
+		users = ret.Get(0).([]models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		err = rf()
+	} else {
+		err = ret.Error(1)
+	}
+
+	return users, err
+}
+
+func (s *UserService) ForTenant(tenant models.Tenant) interfaces.UserService {
+	ret := s.Called(tenant)
+
+	if rf, ok := ret.Get(0).(func(models.Tenant) interfaces.UserService); ok {
+		return rf(tenant)
+	}
+
+	return ret.Get(0).(interfaces.UserService)
+}
+
+func (s *UserService) ListUsers(request interfaces.ListUsersRequest) (interfaces.UserPage, error) {
+	ret := s.Called(request)
+
+	var page interfaces.UserPage
+	if rf, ok := ret.Get(0).(func(interfaces.ListUsersRequest) interfaces.UserPage); ok {
+		page = rf(request)
+	} else {
+		page = ret.Get(0).(interfaces.UserPage)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(interfaces.ListUsersRequest) error); ok {
+		err = rf(request)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return page, err
+}
+
+func (s *UserService) SearchUsers(query string, limit int) ([]interfaces.SearchResult, error) {
+	ret := s.Called(query, limit)
+
+	var results []interfaces.SearchResult
+	if rf, ok := ret.Get(0).(func(string, int) []interfaces.SearchResult); ok {
+		results = rf(query, limit)
+	} else if ret.Get(0) != nil {
+		results = ret.Get(0).([]interfaces.SearchResult)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		err = rf(query, limit)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return results, err
+}
+
+func (s *UserService) BatchUsers(operations []interfaces.BatchOperation, atomic bool) ([]interfaces.BatchResult, error) {
+	ret := s.Called(operations, atomic)
+
+	var results []interfaces.BatchResult
+	if rf, ok := ret.Get(0).(func([]interfaces.BatchOperation, bool) []interfaces.BatchResult); ok {
+		results = rf(operations, atomic)
+	} else if ret.Get(0) != nil {
+		results = ret.Get(0).([]interfaces.BatchResult)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func([]interfaces.BatchOperation, bool) error); ok {
+		err = rf(operations, atomic)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return results, err
+}
+
+func (s *UserService) ImportUsers(reader interfaces.UserReader, dryRun bool) (interfaces.ImportSummary, error) {
+	ret := s.Called(reader, dryRun)
+
+	var summary interfaces.ImportSummary
+	if rf, ok := ret.Get(0).(func(interfaces.UserReader, bool) interfaces.ImportSummary); ok {
+		summary = rf(reader, dryRun)
+	} else {
+		summary = ret.Get(0).(interfaces.ImportSummary)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(interfaces.UserReader, bool) error); ok {
+		err = rf(reader, dryRun)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return summary, err
+}
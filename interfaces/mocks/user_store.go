@@ -0,0 +1,63 @@
+package mocks
+
+import (
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+)
+
+type UserStore struct {
+	UserRepository
+}
+
+func (u *UserStore) LoadUser(user_uuid uuid.UUID) (models.User, error) {
+	ret := u.Called(user_uuid)
+
+	var user models.User
+	if rf, ok := ret.Get(0).(func(uuid.UUID) models.User); ok {
+		user = rf(user_uuid)
+	} else {
+		user = ret.Get(0).(models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(uuid.UUID) error); ok {
+		err = rf(user_uuid)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return user, err
+}
+
+func (u *UserStore) SaveUser(user models.User) error {
+	ret := u.Called(user)
+
+	var err error
+	if rf, ok := ret.Get(0).(func(models.User) error); ok {
+		err = rf(user)
+	} else {
+		err = ret.Error(0)
+	}
+
+	return err
+}
+
+func (u *UserStore) ScanUsers(after uuid.UUID, limit int) ([]models.User, error) {
+	ret := u.Called(after, limit)
+
+	var users []models.User
+	if rf, ok := ret.Get(0).(func(uuid.UUID, int) []models.User); ok {
+		users = rf(after, limit)
+	} else if ret.Get(0) != nil {
+		users = ret.Get(0).([]models.User)
+	}
+
+	var err error
+	if rf, ok := ret.Get(1).(func(uuid.UUID, int) error); ok {
+		err = rf(after, limit)
+	} else {
+		err = ret.Error(1)
+	}
+
+	return users, err
+}
@@ -0,0 +1,15 @@
+package interfaces
+
+import (
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+)
+
+// OutboxRepository reads the events written by UserRepository.AddEvent so
+// they can be published.
+type OutboxRepository interface {
+	// PendingEvents returns up to limit unpublished events, oldest first.
+	PendingEvents(int) ([]models.Event, error)
+	MarkPublished(uuid.UUID) error
+	MarkFailed(uuid.UUID) error
+}
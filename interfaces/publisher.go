@@ -0,0 +1,7 @@
+package interfaces
+
+import "github.com/ffardo/user-crud/models"
+
+type Publisher interface {
+	Publish(models.Event) error
+}
@@ -0,0 +1,11 @@
+package interfaces
+
+import "github.com/gin-gonic/gin"
+
+type TenantController interface {
+	Post(ctx *gin.Context)
+	List(ctx *gin.Context)
+	Get(ctx *gin.Context)
+	Patch(ctx *gin.Context)
+	RotateKey(ctx *gin.Context)
+}
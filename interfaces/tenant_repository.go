@@ -0,0 +1,15 @@
+package interfaces
+
+import "github.com/ffardo/user-crud/models"
+
+// TenantRepository stores tenants. It returns ErrNotFound for unknown
+// tenants and ErrConflict when creating a tenant whose ID or API key is
+// taken.
+type TenantRepository interface {
+	GetTenant(string) (models.Tenant, error)
+	// GetTenantByAPIKeyHash finds the tenant by the SHA-256 of its API key.
+	GetTenantByAPIKeyHash(string) (models.Tenant, error)
+	ListTenants() ([]models.Tenant, error)
+	CreateTenant(models.Tenant) (models.Tenant, error)
+	UpdateTenant(models.Tenant) (models.Tenant, error)
+}
@@ -0,0 +1,20 @@
+package interfaces
+
+import "github.com/ffardo/user-crud/models"
+
+// TENANT_CONTEXT_KEY is the gin context key under which the authentication
+// middleware stores the models.Tenant a request is made for.
+const TENANT_CONTEXT_KEY = "tenant"
+
+type TenantService interface {
+	GetTenant(string) (models.Tenant, error)
+	// Authenticate returns the tenant the API key belongs to.
+	Authenticate(string) (models.Tenant, error)
+	ListTenants() ([]models.Tenant, error)
+	// CreateTenant returns the new tenant and its API key, which is not
+	// stored and cannot be retrieved later.
+	CreateTenant(id, name string, settings models.TenantSettings) (models.Tenant, string, error)
+	UpdateTenant(id string, name *string, settings *models.TenantSettings) (models.Tenant, error)
+	// RotateAPIKey replaces the tenant's API key and returns the new one.
+	RotateAPIKey(string) (string, error)
+}
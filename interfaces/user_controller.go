@@ -5,6 +5,23 @@ import "github.com/gin-gonic/gin"
 type UserController interface {
 	Post(ctx *gin.Context)
 	Get(ctx *gin.Context)
+	List(ctx *gin.Context)
+	Search(ctx *gin.Context)
+	Batch(ctx *gin.Context)
+	Import(ctx *gin.Context)
+	Export(ctx *gin.Context)
+	Put(ctx *gin.Context)
 	Patch(ctx *gin.Context)
 	Delete(ctx *gin.Context)
+	Restore(ctx *gin.Context)
+	ListDeleted(ctx *gin.Context)
 }
+
+// API versions of the user endpoints. The routes serving a request store
+// its version under API_VERSION_CONTEXT_KEY.
+const (
+	API_V1 = "v1"
+	API_V2 = "v2"
+)
+
+const API_VERSION_CONTEXT_KEY = "api_version"
@@ -1,15 +1,126 @@
 package interfaces
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/ffardo/user-crud/models"
 	"github.com/google/uuid"
 )
 
+// Errors returned by UserRepository implementations. Callers should test for
+// them with errors.Is, since implementations wrap them with driver details.
+var (
+	ErrNotFound    = errors.New("user not found")
+	ErrConflict    = errors.New("conflicting write")
+	ErrUnavailable = errors.New("storage unavailable")
+	ErrTimeout     = errors.New("storage timeout")
+)
+
+// ErrEmailRegistered is returned by CreateUser and UpdateUser when the
+// write would violate the unique email index.
+var ErrEmailRegistered = fmt.Errorf("%w: email already registered", ErrConflict)
+
+// ErrVersionConflict is returned by UpdateUser when the stored document no
+// longer has the version the caller read.
+var ErrVersionConflict = fmt.Errorf("%w: version mismatch", ErrConflict)
+
+// Fields users can be listed by. They are backed by indexes.
+const (
+	SORT_CREATED = "created"
+	SORT_NAME    = "name"
+	SORT_EMAIL   = "email"
+)
+
+// UserQuery selects a page of active users. Zero fields do not filter, and
+// ranges include both ends.
+type UserQuery struct {
+	EmailCanonical string
+	NamePrefix     string
+	CreatedFrom    time.Time
+	CreatedTo      time.Time
+	BirthDateFrom  time.Time
+	BirthDateTo    time.Time
+	// Sort is one of the SORT_ fields. Users with the same value are
+	// ordered by UUID, in the same direction.
+	Sort       string
+	Descending bool
+	// After is the last user of the previous page, nil for the first page.
+	// Only its UUID and sort field are used.
+	After *models.User
+	Limit int
+}
+
+// Kinds of UserWrite.
+const (
+	WRITE_CREATE = "create"
+	WRITE_UPDATE = "update"
+	WRITE_DELETE = "delete"
+)
+
+// UserWrite is a write of a WriteUsers batch. Creates store User as
+// CreateUser does, updates replace it as UpdateUser does, and deletes soft
+// delete the user with User.UUID at User.Version.
+type UserWrite struct {
+	Kind string
+	User models.User
+}
+
+// UserWriteError is returned by WriteUsers when the write at Index failed.
+type UserWriteError struct {
+	Index int
+	Err   error
+}
+
+func (e UserWriteError) Error() string {
+	return fmt.Sprintf("write %d: %v", e.Index, e.Err)
+}
+
+func (e UserWriteError) Unwrap() error {
+	return e.Err
+}
+
+// UserMatch is a user found by a search, with how well it matched.
+type UserMatch struct {
+	User  models.User
+	Score float64
+}
+
 type UserRepository interface {
 	GetUserByUUID(uuid.UUID) (models.User, error)
 	UserExistsWithEmail(string) (bool, error)
 	UserExistsWithEmailAndNotUuid(string, uuid.UUID) (bool, error)
+	// CreateUser stores a new user, under a new UUID unless User.UUID is
+	// set. A UUID already in use, even by a deleted user, is an ErrConflict.
 	CreateUser(models.User) (models.User, error)
 	UpdateUser(models.User) (models.User, error)
 	DeleteUser(uuid.UUID) error
+	RestoreUser(uuid.UUID) (models.User, error)
+	ListDeletedUsers() ([]models.User, error)
+	ListUsers(UserQuery) ([]models.User, error)
+	// SearchUsers returns up to limit active users matching the words of
+	// the text, best matches first, scored by the text index.
+	SearchUsers(text string, limit int) ([]UserMatch, error)
+	// SimilarUsers returns up to limit active users sharing trigrams with
+	// the ones given, scored by the number they share.
+	SimilarUsers(trigrams []string, limit int) ([]UserMatch, error)
+	PurgeDeletedUsers(time.Time) (int64, error)
+	// WriteUsers applies the writes in order, in as few round trips as
+	// possible, and returns the users as stored. It stops at the first
+	// write that fails, returning a UserWriteError, so it should be called
+	// inside WithTransaction to apply all of the writes or none. Updates and
+	// deletes of users no longer at their version fail with
+	// ErrVersionConflict, which does not tell which write it was.
+	WriteUsers([]UserWrite) ([]models.User, error)
+	// AddEvent stores an event in the outbox. Called inside WithTransaction,
+	// the event is stored only if the transaction commits.
+	AddEvent(models.Event) error
+	// WithTransaction runs fn so that every call it makes on the repository
+	// it receives commits or aborts together.
+	WithTransaction(fn func(UserRepository) error) error
+	// ForTenant returns a repository restricted to the users of the tenant.
+	// Users it creates belong to the tenant, and users of other tenants
+	// are not found. The default tenant has an empty ID.
+	ForTenant(string) UserRepository
 }
@@ -2,9 +2,134 @@ package interfaces
 
 import "github.com/ffardo/user-crud/models"
 
+// ListUsersRequest holds the listing parameters as clients send them. Empty
+// fields take their defaults.
+type ListUsersRequest struct {
+	Email string
+	// NamePrefix matches names starting with it, case sensitively.
+	NamePrefix    string
+	CreatedFrom   string
+	CreatedTo     string
+	BirthDateFrom string
+	BirthDateTo   string
+	// Sort is a SORT_ field, prefixed with - for descending order.
+	Sort string
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+	Limit  int
+}
+
+type UserPage struct {
+	Users []models.User
+	// NextCursor fetches the next page, empty on the last one.
+	NextCursor string
+}
+
+// Ways a search result matched the query.
+const (
+	MATCH_TEXT  = "text"
+	MATCH_FUZZY = "fuzzy"
+)
+
+type SearchResult struct {
+	User  models.User
+	Score float64
+	// Match is MATCH_TEXT for users found by their words, MATCH_FUZZY for
+	// the ones found despite typos.
+	Match string
+	// Highlights holds the matching fields, HTML escaped, with the
+	// matching words wrapped in <em> tags.
+	Highlights map[string]string
+}
+
+// Operations of a batch.
+const (
+	BATCH_CREATE = "create"
+	BATCH_UPDATE = "update"
+	BATCH_DELETE = "delete"
+)
+
+// BatchOperation is an operation of a batch. Creates take the fields
+// CreateUser does in Params, by their JSON names, and updates take the
+// fields UpdateUser does. Updates and deletes name the user by UUID.
+type BatchOperation struct {
+	Op     string
+	UUID   string
+	Params map[string]string
+}
+
+// BatchResult is the outcome of the operation at the same position: the
+// user created, updated or deleted, or the error it failed with.
+type BatchResult struct {
+	User models.User
+	Err  error
+}
+
+// ImportRecord is a user read from an import.
+type ImportRecord struct {
+	// Line is the line the record starts on, counting from 1.
+	Line int
+	// Params holds the fields CreateUser takes, by their JSON names.
+	Params map[string]string
+	// Err is set when the record could not be read.
+	Err error
+}
+
+// UserReader reads the users of an import one at a time. Next returns
+// io.EOF after the last one, and other errors when the input cannot be
+// read any further.
+type UserReader interface {
+	Next() (ImportRecord, error)
+}
+
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+type ImportSummary struct {
+	Records int
+	// Imported counts the users created, or that would be on a dry run.
+	Imported int
+	Failed   int
+	// Errors holds the errors of the first failed records.
+	Errors []ImportError
+}
+
 type UserService interface {
+	// GetUser may return a cached user, as stale as the cache allows.
 	GetUser(string) (models.User, error)
+	// GetCurrentUser returns the user as stored, bypassing any cache. It
+	// is meant for reads a write or a revalidation is decided on.
+	GetCurrentUser(string) (models.User, error)
 	CreateUser(string, string, string, string, string) (models.User, error)
+	// CreateUserWithParams creates the user in params, by the fields'
+	// JSON names. The address may be given by its parts, named
+	// address.street, address.city, address.region, address.postal_code
+	// and address.country.
+	CreateUserWithParams(map[string]string) (models.User, error)
 	UpdateUser(string, map[string]string) (models.User, error)
+	UpdateUserIfMatch(string, int64, map[string]string) (models.User, error)
+	// ReplaceUser sets every field of the user, creating it under the UUID
+	// given when it does not exist, and reports whether it did.
+	ReplaceUser(user_uuid string, params map[string]string) (user models.User, created bool, err error)
+	ReplaceUserIfMatch(user_uuid string, version int64, params map[string]string) (models.User, error)
+	// ReplaceUserIfExists replaces the user only if there is one, and
+	// CreateUserIfAbsent creates it only if there is none.
+	ReplaceUserIfExists(user_uuid string, params map[string]string) (models.User, error)
+	CreateUserIfAbsent(user_uuid string, params map[string]string) (models.User, error)
 	DeleteUser(string) error
+	RestoreUser(string) (models.User, error)
+	ListDeletedUsers() ([]models.User, error)
+	ListUsers(ListUsersRequest) (UserPage, error)
+	SearchUsers(query string, limit int) ([]SearchResult, error)
+	// BatchUsers applies the operations, returning the outcome of each.
+	// When atomic, either every operation is applied or none is. The error
+	// is only set when the batch as a whole could not be processed.
+	BatchUsers(operations []BatchOperation, atomic bool) ([]BatchResult, error)
+	// ImportUsers creates the users read, reporting the records that fail.
+	// On a dry run, they are only validated.
+	ImportUsers(reader UserReader, dryRun bool) (ImportSummary, error)
+	// ForTenant returns a service restricted to the users of the tenant.
+	ForTenant(models.Tenant) UserService
 }
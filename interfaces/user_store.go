@@ -0,0 +1,21 @@
+package interfaces
+
+import (
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+)
+
+// UserStore is a UserRepository that can also hold an exact copy of the
+// users of another, as needed to move users between backends. Its
+// ForTenant returns a UserStore as well.
+type UserStore interface {
+	UserRepository
+	// LoadUser returns the user whether soft deleted or not.
+	LoadUser(uuid.UUID) (models.User, error)
+	// SaveUser stores the user exactly as given, tenant included, replacing
+	// the stored copy unless that copy has a newer version.
+	SaveUser(models.User) error
+	// ScanUsers returns up to limit users, soft deleted ones included,
+	// whose UUIDs sort after the given one, in UUID order.
+	ScanUsers(after uuid.UUID, limit int) ([]models.User, error)
+}
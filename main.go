@@ -1,7 +1,14 @@
 package main
 
+import "os"
+
 func main() {
 
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	r := setup()
 	r.Run()
 
@@ -0,0 +1,169 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const MIGRATIONS_COLLECTION = "migrations"
+
+// Migration brings the users collection from one schema version to the next.
+// Both functions must be idempotent: a migration interrupted half way is run
+// again in full.
+type Migration struct {
+	Version     int
+	Description string
+	// Pending counts the documents Apply would change.
+	Pending func(ctx context.Context, users *mongo.Collection) (int64, error)
+	// Apply changes the documents and returns how many were modified.
+	Apply func(ctx context.Context, users *mongo.Collection) (int64, error)
+}
+
+// Result reports what a migration did, or would do on a dry run.
+type Result struct {
+	Version     int
+	Description string
+	Documents   int64
+	Applied     bool
+}
+
+type record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	Documents   int64     `bson:"documents"`
+	Applied     time.Time `bson:"applied"`
+}
+
+type Runner struct {
+	Database *mongo.Database
+	// Collection is the name of the users collection, USERS_COLLECTION
+	// when empty.
+	Collection string
+	Migrations []Migration
+	DryRun     bool
+}
+
+// Run applies, in version order, every migration not yet recorded in the
+// migrations collection. On a dry run nothing is written and the results
+// report how many documents each pending migration would change.
+func (r Runner) Run(ctx context.Context) ([]Result, error) {
+	if err := validate(r.Migrations); err != nil {
+		return nil, err
+	}
+
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	users := r.users()
+
+	results := []Result{}
+	for _, m := range pending(r.Migrations, applied) {
+		result := Result{Version: m.Version, Description: m.Description}
+
+		if r.DryRun {
+			result.Documents, err = m.Pending(ctx, users)
+		} else {
+			result.Documents, err = m.Apply(ctx, users)
+		}
+
+		if err != nil {
+			return results, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+
+		if !r.DryRun {
+			if err := r.record(ctx, result); err != nil {
+				return results, err
+			}
+			result.Applied = true
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (r Runner) users() *mongo.Collection {
+	if r.Collection == "" {
+		return r.Database.Collection(USERS_COLLECTION)
+	}
+	return r.Database.Collection(r.Collection)
+}
+
+func (r Runner) applied(ctx context.Context) (map[int]bool, error) {
+	cursor, err := r.Database.Collection(MIGRATIONS_COLLECTION).Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]bool, len(records))
+	for _, rec := range records {
+		applied[rec.Version] = true
+	}
+
+	return applied, nil
+}
+
+func (r Runner) record(ctx context.Context, result Result) error {
+	rec := record{
+		Version:     result.Version,
+		Description: result.Description,
+		Documents:   result.Documents,
+		Applied:     time.Now(),
+	}
+
+	_, err := r.Database.Collection(MIGRATIONS_COLLECTION).InsertOne(ctx, rec)
+
+	// Another instance finished the same migration first. Migrations are
+	// idempotent, so both runs leave the documents in the same state.
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+
+	return err
+}
+
+func validate(migrations []Migration) error {
+	seen := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		if m.Version <= 0 {
+			return fmt.Errorf("migration %q has invalid version %d", m.Description, m.Version)
+		}
+		if seen[m.Version] {
+			return fmt.Errorf("duplicate migration version %d", m.Version)
+		}
+		if m.Pending == nil || m.Apply == nil {
+			return fmt.Errorf("migration %d is missing Pending or Apply", m.Version)
+		}
+		seen[m.Version] = true
+	}
+	return nil
+}
+
+// pending returns the migrations missing from applied, sorted by version.
+func pending(migrations []Migration, applied map[int]bool) []Migration {
+	var p []Migration
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			p = append(p, m)
+		}
+	}
+
+	sort.Slice(p, func(i, j int) bool {
+		return p[i].Version < p[j].Version
+	})
+
+	return p
+}
@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ffardo/user-crud/emails"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func noop(ctx context.Context, users *mongo.Collection) (int64, error) {
+	return 0, nil
+}
+
+func migration(version int) Migration {
+	return Migration{Version: version, Description: "test", Pending: noop, Apply: noop}
+}
+
+func TestPendingSortsAndSkipsApplied(t *testing.T) {
+	all := []Migration{migration(3), migration(1), migration(2), migration(4)}
+
+	p := pending(all, map[int]bool{2: true})
+
+	versions := []int{}
+	for _, m := range p {
+		versions = append(versions, m.Version)
+	}
+
+	assert.Equal(t, []int{1, 3, 4}, versions)
+}
+
+func TestPendingNothingLeft(t *testing.T) {
+	all := []Migration{migration(1), migration(2)}
+
+	p := pending(all, map[int]bool{1: true, 2: true})
+
+	assert.Empty(t, p)
+}
+
+func TestValidateRejectsDuplicateVersions(t *testing.T) {
+	err := validate([]Migration{migration(1), migration(1)})
+
+	assert.Error(t, err)
+}
+
+func TestValidateRejectsInvalidVersion(t *testing.T) {
+	err := validate([]Migration{migration(0)})
+
+	assert.Error(t, err)
+}
+
+func TestValidateRejectsMissingFunctions(t *testing.T) {
+	m := migration(1)
+	m.Apply = nil
+
+	err := validate([]Migration{m})
+
+	assert.Error(t, err)
+}
+
+func TestAllMigrationsAreValid(t *testing.T) {
+	assert.NoError(t, validate(All(emails.Normalizer{})))
+}
+
+func TestCollisionsGroupsActiveCanonicalEmails(t *testing.T) {
+	docs := []canonicalEmail{
+		{emailDocument: emailDocument{UUID: "a"}, Canonical: "joe@mail.com"},
+		{emailDocument: emailDocument{UUID: "b"}, Canonical: "joe@mail.com"},
+		{emailDocument: emailDocument{UUID: "c"}, Canonical: "ann@mail.com"},
+		{emailDocument: emailDocument{UUID: "d"}, Canonical: ""},
+		{emailDocument: emailDocument{UUID: "e"}, Canonical: ""},
+		{emailDocument: emailDocument{UUID: "f", TenantID: "acme"}, Canonical: "joe@mail.com"},
+	}
+
+	c := collisions(docs)
+
+	assert.Equal(t, EmailCollisions{"joe@mail.com": {"a", "b"}}, c)
+	assert.Contains(t, c.Error(), "joe@mail.com: a, b")
+}
@@ -0,0 +1,342 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ffardo/user-crud/emails"
+	"github.com/ffardo/user-crud/search"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const USERS_COLLECTION = "users"
+
+const CANONICAL_EMAIL_BATCH_SIZE = 500
+
+const SEARCH_TRIGRAMS_BATCH_SIZE = 500
+
+// All lists every migration of the users collection. Append new migrations
+// with the next version; never renumber or remove one that has shipped.
+// normalizer must be the one the service uses, so stored canonical emails
+// match the ones looked up.
+func All(normalizer emails.Normalizer) []Migration {
+	return []Migration{
+		normalizeUsers,
+		canonicalEmails(normalizer),
+		defaultTenant,
+		searchTrigrams,
+	}
+}
+
+// olderThan matches users whose schema_version is below version, including
+// documents written before the field existed.
+func olderThan(version int) bson.D {
+	return bson.D{{Key: "schema_version", Value: bson.D{
+		{Key: "$not", Value: bson.D{{Key: "$gte", Value: version}}},
+	}}}
+}
+
+// normalizeUsers fills in the fields added after the first release so every
+// document can be matched by the queries and indexes that rely on them:
+// deleted is null rather than missing, version starts at 0, and documents
+// missing timestamps take them from their ObjectId.
+var normalizeUsers = Migration{
+	Version:     1,
+	Description: "normalize user documents",
+	Pending: func(ctx context.Context, users *mongo.Collection) (int64, error) {
+		return users.CountDocuments(ctx, olderThan(1))
+	},
+	Apply: func(ctx context.Context, users *mongo.Collection) (int64, error) {
+		created := bson.D{{Key: "$ifNull", Value: bson.A{
+			"$created", bson.D{{Key: "$toDate", Value: "$_id"}},
+		}}}
+
+		update := mongo.Pipeline{
+			{{Key: "$set", Value: bson.D{
+				{Key: "deleted", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$deleted", nil}}}},
+				{Key: "version", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$version", 0}}}},
+				{Key: "created", Value: created},
+				{Key: "updated", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$updated", created}}}},
+				{Key: "schema_version", Value: 1},
+			}}},
+		}
+
+		res, err := users.UpdateMany(ctx, olderThan(1), update)
+		if err != nil {
+			return 0, err
+		}
+
+		return res.ModifiedCount, nil
+	},
+}
+
+// defaultTenant assigns users stored before tenants existed to the default
+// tenant. The service also does so on startup with AssignDefaultTenant.
+var defaultTenant = Migration{
+	Version:     3,
+	Description: "assign users to the default tenant",
+	Pending: func(ctx context.Context, users *mongo.Collection) (int64, error) {
+		return users.CountDocuments(ctx, olderThan(3))
+	},
+	Apply: func(ctx context.Context, users *mongo.Collection) (int64, error) {
+		update := mongo.Pipeline{
+			{{Key: "$set", Value: bson.D{
+				{Key: "tenant_id", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$tenant_id", ""}}}},
+				{Key: "schema_version", Value: 3},
+			}}},
+		}
+
+		res, err := users.UpdateMany(ctx, olderThan(3), update)
+		if err != nil {
+			return 0, err
+		}
+
+		return res.ModifiedCount, nil
+	},
+}
+
+// AssignDefaultTenant gives users stored before tenants existed the empty
+// tenant_id of the default tenant, and returns how many it changed. Until
+// then the per-tenant email indexes key them apart from the default tenant's
+// new users, so the service runs it before creating indexes, whether or not
+// migrations are applied on startup. Unlike migration 3, it leaves
+// schema_version alone, so it does not stand for the migrations before it.
+// It fails with a duplicate key error when such a user shares an email with
+// a user of the default tenant.
+func AssignDefaultTenant(ctx context.Context, users *mongo.Collection) (int64, error) {
+	res, err := users.UpdateMany(ctx,
+		bson.D{{Key: "tenant_id", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "tenant_id", Value: ""}}}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.ModifiedCount, nil
+}
+
+type searchDocument struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	Name    string             `bson:"name"`
+	Address string             `bson:"address"`
+}
+
+// searchTrigrams stores the trigrams of every user's name and address in
+// search_trigrams, which typo tolerant searches look users up by. Until it
+// runs, older users are only found by exact words.
+var searchTrigrams = Migration{
+	Version:     4,
+	Description: "store search trigrams",
+	Pending: func(ctx context.Context, users *mongo.Collection) (int64, error) {
+		return users.CountDocuments(ctx, olderThan(4))
+	},
+	Apply: func(ctx context.Context, users *mongo.Collection) (int64, error) {
+		opts := options.Find().SetProjection(bson.D{{Key: "name", Value: 1}, {Key: "address", Value: 1}})
+
+		cursor, err := users.Find(ctx, olderThan(4), opts)
+		if err != nil {
+			return 0, err
+		}
+		defer cursor.Close(ctx)
+
+		var modified int64
+		batch := make([]mongo.WriteModel, 0, SEARCH_TRIGRAMS_BATCH_SIZE)
+
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			res, err := users.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false))
+			if err != nil {
+				return err
+			}
+			modified += res.ModifiedCount
+			batch = batch[:0]
+			return nil
+		}
+
+		for cursor.Next(ctx) {
+			var doc searchDocument
+			if err := cursor.Decode(&doc); err != nil {
+				return modified, err
+			}
+
+			set := bson.D{
+				{Key: "search_trigrams", Value: search.Trigrams(doc.Name, doc.Address)},
+				{Key: "schema_version", Value: 4},
+			}
+
+			batch = append(batch, mongo.NewUpdateOneModel().
+				SetFilter(bson.D{{Key: "_id", Value: doc.ID}}).
+				SetUpdate(bson.D{{Key: "$set", Value: set}}))
+
+			if len(batch) == SEARCH_TRIGRAMS_BATCH_SIZE {
+				if err := flush(); err != nil {
+					return modified, err
+				}
+			}
+		}
+
+		if err := cursor.Err(); err != nil {
+			return modified, err
+		}
+
+		return modified, flush()
+	},
+}
+
+type emailDocument struct {
+	ID            primitive.ObjectID  `bson:"_id"`
+	UUID          string              `bson:"uuid"`
+	TenantID      string              `bson:"tenant_id"`
+	Email         string              `bson:"email"`
+	Deleted       *primitive.DateTime `bson:"deleted"`
+	SchemaVersion int                 `bson:"schema_version"`
+}
+
+type canonicalEmail struct {
+	emailDocument
+	Canonical string
+}
+
+// canonicalEmails stores the normalized email of every user in
+// email_canonical, the field the email unique index and lookups use. Active
+// users whose emails collapse to the same canonical form cannot both be
+// indexed, so the migration refuses to run, dry run included, and lists
+// them until they are resolved by hand. Emails that fail to parse are left
+// without a canonical form.
+func canonicalEmails(normalizer emails.Normalizer) Migration {
+	return Migration{
+		Version:     2,
+		Description: "store canonical emails",
+		Pending: func(ctx context.Context, users *mongo.Collection) (int64, error) {
+			docs, err := loadCanonicalEmails(ctx, users, normalizer)
+			if err != nil {
+				return 0, err
+			}
+
+			return int64(len(docs)), nil
+		},
+		Apply: func(ctx context.Context, users *mongo.Collection) (int64, error) {
+			docs, err := loadCanonicalEmails(ctx, users, normalizer)
+			if err != nil {
+				return 0, err
+			}
+
+			var modified int64
+			for start := 0; start < len(docs); start += CANONICAL_EMAIL_BATCH_SIZE {
+				end := start + CANONICAL_EMAIL_BATCH_SIZE
+				if end > len(docs) {
+					end = len(docs)
+				}
+
+				models := make([]mongo.WriteModel, 0, end-start)
+				for _, doc := range docs[start:end] {
+					set := bson.D{{Key: "schema_version", Value: 2}}
+					if doc.Canonical != "" {
+						set = append(set, bson.E{Key: "email_canonical", Value: doc.Canonical})
+					}
+
+					models = append(models, mongo.NewUpdateOneModel().
+						SetFilter(bson.D{{Key: "_id", Value: doc.ID}}).
+						SetUpdate(bson.D{{Key: "$set", Value: set}}))
+				}
+
+				res, err := users.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+				if err != nil {
+					return modified, err
+				}
+				modified += res.ModifiedCount
+			}
+
+			return modified, nil
+		},
+	}
+}
+
+// loadCanonicalEmails reads the users not yet migrated and computes their
+// canonical emails, failing if any two active users would collide.
+func loadCanonicalEmails(ctx context.Context, users *mongo.Collection, normalizer emails.Normalizer) ([]canonicalEmail, error) {
+	// Already migrated users take part in the collision check too: a new
+	// canonical email may collide with one stored by an earlier run.
+	cursor, err := users.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	var all []emailDocument
+	if err := cursor.All(ctx, &all); err != nil {
+		return nil, err
+	}
+
+	docs := []canonicalEmail{}
+	active := []canonicalEmail{}
+	for _, d := range all {
+		canonical, err := normalizer.Canonical(d.Email)
+		if err != nil {
+			canonical = ""
+		}
+
+		doc := canonicalEmail{emailDocument: d, Canonical: canonical}
+		if d.SchemaVersion < 2 {
+			docs = append(docs, doc)
+		}
+		if d.Deleted == nil {
+			active = append(active, doc)
+		}
+	}
+
+	if c := collisions(active); len(c) > 0 {
+		return nil, c
+	}
+
+	return docs, nil
+}
+
+// EmailCollisions maps a canonical email to the UUIDs of the active users
+// sharing it. Emails of tenants other than the default one are prefixed
+// with the tenant ID and a slash.
+type EmailCollisions map[string][]string
+
+func (c EmailCollisions) Error() string {
+	canonicals := make([]string, 0, len(c))
+	for canonical := range c {
+		canonicals = append(canonicals, canonical)
+	}
+	sort.Strings(canonicals)
+
+	lines := make([]string, 0, len(c))
+	for _, canonical := range canonicals {
+		lines = append(lines, fmt.Sprintf("%s: %s", canonical, strings.Join(c[canonical], ", ")))
+	}
+
+	return fmt.Sprintf("%d canonical emails are shared by several active users:\n%s", len(c), strings.Join(lines, "\n"))
+}
+
+func collisions(docs []canonicalEmail) EmailCollisions {
+	byCanonical := map[string][]string{}
+	for _, doc := range docs {
+		if doc.Canonical == "" {
+			continue
+		}
+		key := doc.Canonical
+		if doc.TenantID != "" {
+			key = doc.TenantID + "/" + key
+		}
+		byCanonical[key] = append(byCanonical[key], doc.UUID)
+	}
+
+	c := EmailCollisions{}
+	for canonical, uuids := range byCanonical {
+		if len(uuids) > 1 {
+			c[canonical] = uuids
+		}
+	}
+
+	return c
+}
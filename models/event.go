@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types emitted when users change.
+const (
+	EVENT_USER_CREATED  = "UserCreated"
+	EVENT_USER_UPDATED  = "UserUpdated"
+	EVENT_USER_DELETED  = "UserDeleted"
+	EVENT_USER_RESTORED = "UserRestored"
+)
+
+// Event records a change to a user. It is stored in the outbox together with
+// the change and published afterwards, so consumers may see an event more
+// than once and should deduplicate on ID.
+type Event struct {
+	ID       uuid.UUID `bson:"id" json:"id"`
+	Type     string    `bson:"type" json:"type"`
+	UserUUID uuid.UUID `bson:"user_uuid" json:"user_uuid"`
+	TenantID string    `bson:"tenant_id" json:"tenant_id,omitempty"`
+	// Fields names the fields changed by a UserUpdated event.
+	Fields   []string  `bson:"fields,omitempty" json:"fields,omitempty"`
+	Occurred time.Time `bson:"occurred" json:"occurred"`
+
+	Published *time.Time `bson:"published" json:"-"`
+	Attempts  int        `bson:"attempts" json:"-"`
+}
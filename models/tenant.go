@@ -0,0 +1,45 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Tenant is a product with its own namespace of users. Users of different
+// tenants never see each other and may share an email.
+type Tenant struct {
+	ID   string `bson:"_id" json:"id"`
+	Name string `bson:"name" json:"name"`
+	// APIKeyHash is the SHA-256 of the tenant's API key. The key itself is
+	// only shown when it is created.
+	APIKeyHash string         `bson:"api_key_hash" json:"-"`
+	Settings   TenantSettings `bson:"settings" json:"settings"`
+	Created    time.Time      `bson:"created" json:"created"`
+	Updated    time.Time      `bson:"updated" json:"updated"`
+}
+
+type TenantSettings struct {
+	// Disabled tenants are refused every request.
+	Disabled bool `bson:"disabled" json:"disabled"`
+	// EmailDomains restricts user emails to these domains. Any domain is
+	// accepted when empty.
+	EmailDomains []string `bson:"email_domains" json:"email_domains"`
+}
+
+// AllowsEmail reports whether a user of the tenant may have email.
+func (s TenantSettings) AllowsEmail(email string) bool {
+	if len(s.EmailDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	domain := strings.ToLower(email[at+1:])
+
+	for _, d := range s.EmailDomains {
+		if strings.ToLower(d) == domain {
+			return true
+		}
+	}
+
+	return false
+}
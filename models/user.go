@@ -1,18 +1,77 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// USER_SCHEMA_VERSION is the document shape written by this version of the
+// service. Older documents are brought up to it by the migrations package.
+const USER_SCHEMA_VERSION = 4
+
+// DATE_FORMAT is the format of dates, such as birth dates, exchanged with
+// clients.
+const DATE_FORMAT = "2006-01-02"
+
+// The bson names match the ones the driver derived before tags were added, so
+// renaming a Go field does not change the stored document.
 type User struct {
-	UUID      uuid.UUID
-	BirthDate time.Time
-	Name      string
-	Email     string
-	Password  string
-	Address   string
-	Created   time.Time
-	Updated   time.Time
+	UUID uuid.UUID `bson:"uuid"`
+	// TenantID is the tenant the user belongs to. Users of the default
+	// tenant have an empty one.
+	TenantID  string    `bson:"tenant_id"`
+	BirthDate time.Time `bson:"birthdate"`
+	Name      string    `bson:"name"`
+	Email     string    `bson:"email"`
+	// EmailCanonical identifies the mailbox Email delivers to, so addresses
+	// differing only in case or provider specific details are the same. It
+	// is not stored when empty, as for emails that fail to parse, so such
+	// users stay out of the email unique index.
+	EmailCanonical string `bson:"email_canonical,omitempty"`
+	Password       string `bson:"password"`
+	// Address is the address on a single line. Users given a structured
+	// address also have its parts in AddressParts, which Address is then
+	// formatted from.
+	Address      string   `bson:"address"`
+	AddressParts *Address `bson:"address_parts,omitempty"`
+	// SearchTrigrams are the trigrams of Name and Address, which typo
+	// tolerant searches look users up by.
+	SearchTrigrams []string   `bson:"search_trigrams"`
+	Created        time.Time  `bson:"created"`
+	Updated        time.Time  `bson:"updated"`
+	Version        int64      `bson:"version"`
+	Deleted        *time.Time `bson:"deleted"`
+	SchemaVersion  int        `bson:"schema_version"`
+}
+
+// Address is an address by its parts.
+type Address struct {
+	Street     string `bson:"street"`
+	City       string `bson:"city"`
+	Region     string `bson:"region"`
+	PostalCode string `bson:"postal_code"`
+	Country    string `bson:"country"`
+}
+
+// String formats the address on a single line, its parts separated by
+// commas.
+func (a Address) String() string {
+	parts := make([]string, 0, 5)
+	for _, p := range []string{a.Street, a.City, a.Region, a.PostalCode, a.Country} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// StructuredAddress returns the address of the user by its parts. An
+// address only known on a single line is returned as the street.
+func (u User) StructuredAddress() Address {
+	if u.AddressParts != nil {
+		return *u.AddressParts
+	}
+	return Address{Street: u.Address}
 }
@@ -0,0 +1,334 @@
+// Package openapi builds OpenAPI 3.1 documents from descriptions of gin
+// routes, deriving the schemas of bodies from the Go types they are encoded
+// from.
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const VERSION = "3.1.0"
+
+// JSON_CONTENT_TYPE is the content type of bodies described without one.
+const JSON_CONTENT_TYPE = "application/json"
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Tags       []Tag               `json:"tags,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme is an API key sent in a header, the only kind of
+// authentication the service has.
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations of a path by their lowercase method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON Schema the bodies of the service need.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// String returns the schema of strings in format, if any.
+func String(format string) *Schema {
+	return &Schema{Type: "string", Format: format}
+}
+
+// Route describes the operation a gin route serves.
+type Route struct {
+	Method string
+	// Path is the path the route is registered under in gin. Its
+	// parameters are documented as required strings.
+	Path        string
+	ID          string
+	Summary     string
+	Description string
+	Tag         string
+	// Security names the security scheme authenticating the route, if any.
+	Security string
+	// Parameters are the query and header parameters of the route.
+	Parameters []Parameter
+	// Request holds a value of the type of the body by content type. A
+	// string value describes a body that is not JSON.
+	Request map[string]interface{}
+	// Responses are the responses of the route by status.
+	Responses map[int]Reply
+}
+
+// Reply describes a response.
+type Reply struct {
+	Description string
+	// Headers describes the headers set by name.
+	Headers map[string]string
+	// Content holds a value of the type of the body by content type, as in
+	// Route.Request.
+	Content map[string]interface{}
+}
+
+// New returns a document without paths.
+func New(info Info) *Document {
+	return &Document{
+		OpenAPI: VERSION,
+		Info:    info,
+		Paths:   map[string]PathItem{},
+		Components: Components{
+			Schemas:         map[string]*Schema{},
+			SecuritySchemes: map[string]SecurityScheme{},
+		},
+	}
+}
+
+// Add documents route, registering the schemas of its bodies.
+func (d *Document) Add(route Route) {
+	op := &Operation{
+		OperationID: route.ID,
+		Summary:     route.Summary,
+		Description: route.Description,
+		Responses:   map[string]Response{},
+	}
+
+	if route.Tag != "" {
+		op.Tags = []string{route.Tag}
+	}
+	if route.Security != "" {
+		op.Security = []map[string][]string{{route.Security: {}}}
+	}
+
+	for _, name := range pathParameters(route.Path) {
+		op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: String("")})
+	}
+	op.Parameters = append(op.Parameters, route.Parameters...)
+
+	if len(route.Request) > 0 {
+		op.RequestBody = &RequestBody{Required: true, Content: d.content(route.Request)}
+	}
+
+	for status, reply := range route.Responses {
+		r := Response{Description: reply.Description, Content: d.content(reply.Content)}
+		for name, description := range reply.Headers {
+			if r.Headers == nil {
+				r.Headers = map[string]Header{}
+			}
+			r.Headers[name] = Header{Description: description, Schema: String("")}
+		}
+		op.Responses[strconv.Itoa(status)] = r
+	}
+
+	path := Path(route.Path)
+	if d.Paths[path] == nil {
+		d.Paths[path] = PathItem{}
+	}
+	d.Paths[path][strings.ToLower(route.Method)] = op
+}
+
+func (d *Document) content(bodies map[string]interface{}) map[string]MediaType {
+	if len(bodies) == 0 {
+		return nil
+	}
+
+	content := make(map[string]MediaType, len(bodies))
+	for contentType, body := range bodies {
+		content[contentType] = MediaType{Schema: d.Schema(reflect.TypeOf(body))}
+	}
+	return content
+}
+
+// Path returns the OpenAPI path of a gin path, with its parameters in
+// braces and without a trailing slash.
+func Path(ginPath string) string {
+	segments := strings.Split(ginPath, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+
+	path := strings.Join(segments, "/")
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path
+}
+
+func pathParameters(ginPath string) []string {
+	var names []string
+	for _, s := range strings.Split(ginPath, "/") {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			names = append(names, s[1:])
+		}
+	}
+	return names
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// Schema returns the schema of the JSON encoding of t. Named structs are
+// registered in the components and referred to.
+func (d *Document) Schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return String("date-time")
+	case t == rawMessageType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return String("")
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: d.Schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.Schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.object(t)
+		}
+		if _, ok := d.Components.Schemas[t.Name()]; !ok {
+			// Registered before its fields, so types referring to
+			// themselves end.
+			d.Components.Schemas[t.Name()] = &Schema{}
+			*d.Components.Schemas[t.Name()] = *d.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+
+	return &Schema{}
+}
+
+// object returns the schema of a struct, with the fields of embedded
+// structs as its own, as encoding/json does.
+func (d *Document) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for n, p := range d.object(ft).Properties {
+					s.Properties[n] = p
+				}
+				continue
+			}
+		}
+
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		s.Properties[name] = d.Schema(f.Type)
+	}
+
+	return s
+}
+
+// Operations lists the method and OpenAPI path of every operation, sorted.
+func (d *Document) Operations() []string {
+	var operations []string
+	for path, item := range d.Paths {
+		for method := range item {
+			operations = append(operations, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(operations)
+	return operations
+}
@@ -0,0 +1,81 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type base struct {
+	ID string `json:"id"`
+}
+
+type node struct {
+	base
+	Name     string            `json:"name,omitempty"`
+	Created  time.Time         `json:"created"`
+	Parent   *node             `json:"parent"`
+	Children []node            `json:"children"`
+	Labels   map[string]string `json:"labels"`
+	Score    float64           `json:"score"`
+	Count    int               `json:"count"`
+	Hidden   string            `json:"-"`
+	internal string
+}
+
+func TestPath(t *testing.T) {
+	assert.Equal(t, "/api/users", Path("/api/users/"))
+	assert.Equal(t, "/api/users/{uuid}/restore", Path("/api/users/:uuid/restore"))
+	assert.Equal(t, "/files/{path}", Path("/files/*path"))
+	assert.Equal(t, "/api/users:batch", Path("/api/users:batch"))
+	assert.Equal(t, "/", Path("/"))
+}
+
+func TestSchema(t *testing.T) {
+	d := New(Info{Title: "test", Version: "1"})
+
+	s := d.Schema(reflect.TypeOf(&node{}))
+
+	assert.Equal(t, &Schema{Ref: "#/components/schemas/node"}, s)
+	assert.Equal(t, &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"id":       {Type: "string"},
+			"name":     {Type: "string"},
+			"created":  {Type: "string", Format: "date-time"},
+			"parent":   {Ref: "#/components/schemas/node"},
+			"children": {Type: "array", Items: &Schema{Ref: "#/components/schemas/node"}},
+			"labels":   {Type: "object", AdditionalProperties: &Schema{Type: "string"}},
+			"score":    {Type: "number"},
+			"count":    {Type: "integer"},
+		},
+	}, d.Components.Schemas["node"])
+	assert.NotContains(t, d.Components.Schemas, "base")
+}
+
+func TestAdd(t *testing.T) {
+	d := New(Info{Title: "test", Version: "1"})
+
+	d.Add(Route{
+		Method:   http.MethodPut,
+		Path:     "/nodes/:id",
+		ID:       "putNode",
+		Security: "ApiKey",
+		Request:  map[string]interface{}{JSON_CONTENT_TYPE: node{}},
+		Responses: map[int]Reply{
+			http.StatusOK: {Description: "Replaced", Headers: map[string]string{"ETag": "Version"}, Content: map[string]interface{}{"text/csv": ""}},
+		},
+	})
+
+	op := d.Paths["/nodes/{id}"]["put"]
+
+	assert.Equal(t, []string{"PUT /nodes/{id}"}, d.Operations())
+	assert.Equal(t, []Parameter{{Name: "id", In: "path", Required: true, Schema: String("")}}, op.Parameters)
+	assert.Equal(t, []map[string][]string{{"ApiKey": {}}}, op.Security)
+	assert.Equal(t, &Schema{Ref: "#/components/schemas/node"}, op.RequestBody.Content[JSON_CONTENT_TYPE].Schema)
+	assert.Equal(t, String(""), op.Responses["200"].Content["text/csv"].Schema)
+	assert.Contains(t, op.Responses["200"].Headers, "ETag")
+}
@@ -0,0 +1,8 @@
+package openapi
+
+import _ "embed"
+
+// UI is a Redoc page rendering the document served at /openapi.json.
+//
+//go:embed ui.html
+var UI []byte
@@ -0,0 +1,319 @@
+// Package patch applies JSON Merge Patches (RFC 7396) and JSON Patches
+// (RFC 6902) to JSON documents.
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidPatch = errors.New("Invalid patch")
+var ErrPathNotFound = errors.New("Patch path not found")
+var ErrTestFailed = errors.New("Patch test failed")
+
+// Merge applies the merge patch to doc: members of patch objects replace
+// the ones of doc, recursively, and null members remove them.
+func Merge(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = merge(t[key], value)
+	}
+
+	return t
+}
+
+// operation is a JSON Patch operation. Value is nil when absent, and holds
+// the literal null when null.
+type operation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Apply applies the operations of the JSON patch to doc in order. When one
+// fails, none is applied.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var target interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	var operations []operation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	for i, op := range operations {
+		var err error
+		if target, err = apply(target, op); err != nil {
+			return nil, fmt.Errorf("%w: operation %d", err, i)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func apply(doc interface{}, op operation) (interface{}, error) {
+	if op.Path == nil {
+		return nil, ErrInvalidPatch
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, ErrInvalidPatch
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, ErrInvalidPatch
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, ErrInvalidPatch
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return add(doc, path, value)
+
+	case "remove":
+		return remove(doc, path)
+
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		if doc, err = remove(doc, path); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+
+	case "move":
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		// A value cannot be moved into itself.
+		if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+			return nil, ErrInvalidPatch
+		}
+
+		if value, err = get(doc, from); err != nil {
+			return nil, err
+		}
+		if doc, err = remove(doc, from); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+
+	case "copy":
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		if value, err = get(doc, from); err != nil {
+			return nil, err
+		}
+		return add(doc, path, clone(value))
+
+	case "test":
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	}
+
+	return nil, ErrInvalidPatch
+}
+
+// parsePointer splits a JSON pointer into its unescaped reference tokens.
+// The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if pointer[0] != '/' {
+		return nil, ErrInvalidPatch
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+// index parses an array index, which must be below max.
+func index(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, ErrPathNotFound
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= max {
+		return 0, ErrPathNotFound
+	}
+
+	return i, nil
+}
+
+// child returns the member or element token refers to in node.
+func child(node interface{}, token string) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if value, ok := n[token]; ok {
+			return value, nil
+		}
+	case []interface{}:
+		i, err := index(token, len(n))
+		if err != nil {
+			return nil, err
+		}
+		return n[i], nil
+	}
+	return nil, ErrPathNotFound
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		var err error
+		if doc, err = child(doc, token); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// edit calls change with the container the last token of path refers into,
+// and returns doc with the container change returns in its place.
+func edit(doc interface{}, path []string, change func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return change(doc, path[0])
+	}
+
+	next, err := child(doc, path[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if next, err = edit(next, path[1:], change); err != nil {
+		return nil, err
+	}
+
+	switch n := doc.(type) {
+	case map[string]interface{}:
+		n[path[0]] = next
+	case []interface{}:
+		i, _ := index(path[0], len(n))
+		n[i] = next
+	}
+
+	return doc, nil
+}
+
+// add sets the member at path, or inserts the element at path, "-" standing
+// for the end of arrays.
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return edit(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			if token == "-" {
+				return append(c, value), nil
+			}
+			i, err := index(token, len(c)+1)
+			if err != nil {
+				return nil, err
+			}
+			added := make([]interface{}, 0, len(c)+1)
+			added = append(added, c[:i]...)
+			added = append(added, value)
+			return append(added, c[i:]...), nil
+		}
+		return nil, ErrPathNotFound
+	})
+}
+
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, ErrInvalidPatch
+	}
+
+	return edit(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			if _, ok := c[token]; !ok {
+				return nil, ErrPathNotFound
+			}
+			delete(c, token)
+			return c, nil
+		case []interface{}:
+			i, err := index(token, len(c))
+			if err != nil {
+				return nil, err
+			}
+			removed := make([]interface{}, 0, len(c)-1)
+			removed = append(removed, c[:i]...)
+			return append(removed, c[i+1:]...), nil
+		}
+		return nil, ErrPathNotFound
+	})
+}
+
+// clone returns a copy of value sharing nothing with it.
+func clone(value interface{}) interface{} {
+	b, _ := json.Marshal(value)
+
+	var copied interface{}
+	json.Unmarshal(b, &copied)
+
+	return copied
+}
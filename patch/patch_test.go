@@ -0,0 +1,63 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const doc = `{"name": "John Doe", "address": "3197 Woodrow Way", "tags": ["a", "b"]}`
+
+func TestMerge(t *testing.T) {
+	patched, err := Merge([]byte(doc), []byte(`{"name": "John Nobody", "address": null, "nested": {"a": 1, "b": null}}`))
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name": "John Nobody", "tags": ["a", "b"], "nested": {"a": 1}}`, string(patched))
+
+	patched, err = Merge([]byte(doc), []byte(`["replaced"]`))
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `["replaced"]`, string(patched))
+
+	_, err = Merge([]byte(doc), []byte(`{"name"`))
+	assert.ErrorIs(t, err, ErrInvalidPatch)
+}
+
+func TestApply(t *testing.T) {
+	patched, err := Apply([]byte(doc), []byte(`[
+		{"op": "test", "path": "/name", "value": "John Doe"},
+		{"op": "replace", "path": "/name", "value": "John Nobody"},
+		{"op": "remove", "path": "/address"},
+		{"op": "add", "path": "/tags/1", "value": "c"},
+		{"op": "add", "path": "/tags/-", "value": "d"},
+		{"op": "move", "from": "/tags/0", "path": "/first"},
+		{"op": "copy", "from": "/tags", "path": "/a~1b"}
+	]`))
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name": "John Nobody", "first": "a", "tags": ["c", "b", "d"], "a/b": ["c", "b", "d"]}`, string(patched))
+}
+
+func TestApplyFailures(t *testing.T) {
+	cases := map[string]error{
+		`{"op": "add"}`:                                                                             ErrInvalidPatch,
+		`[{"op": "add", "path": "/name"}]`:                                                          ErrInvalidPatch,
+		`[{"op": "merge", "path": "/name", "value": 1}]`:                                            ErrInvalidPatch,
+		`[{"op": "add", "path": "name", "value": 1}]`:                                               ErrInvalidPatch,
+		`[{"op": "move", "from": "/tags", "path": "/tags/0"}]`:                                      ErrInvalidPatch,
+		`[{"op": "remove", "path": "/email"}]`:                                                      ErrPathNotFound,
+		`[{"op": "replace", "path": "/tags/2", "value": "c"}]`:                                      ErrPathNotFound,
+		`[{"op": "add", "path": "/tags/01", "value": "c"}]`:                                         ErrPathNotFound,
+		`[{"op": "add", "path": "/missing/name", "value": "c"}]`:                                    ErrPathNotFound,
+		`[{"op": "test", "path": "/name", "value": "Mary Doe"}]`:                                    ErrTestFailed,
+		`[{"op": "test", "path": "/tags", "value": ["b", "a"]}]`:                                    ErrTestFailed,
+		`[{"op": "test", "path": "/address", "value": null}]`:                                       ErrTestFailed,
+		`[{"op": "copy", "from": "/email", "path": "/contact"}]`:                                    ErrPathNotFound,
+		`[{"op": "remove", "path": "/name"}, {"op": "test", "path": "/name", "value": "John Doe"}]`: ErrPathNotFound,
+	}
+
+	for patch, expected := range cases {
+		_, err := Apply([]byte(doc), []byte(patch))
+		assert.ErrorIs(t, err, expected, patch)
+	}
+}
@@ -0,0 +1,119 @@
+// Package problems reports request failures as RFC 7807 problem details,
+// each carrying the trace ID of its request.
+package problems
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const CONTENT_TYPE = "application/problem+json"
+
+// TYPE_PREFIX starts the type URI of the problems this service defines,
+// followed by their code. Problems only described by their status have the
+// type about:blank.
+const TYPE_PREFIX = "urn:user-crud:problem:"
+
+// TRACE_HEADER carries the trace ID of every response. A valid ID sent in
+// it, or in a W3C traceparent header, is kept.
+const TRACE_HEADER = "X-Trace-ID"
+
+// TRACE_CONTEXT_KEY is the gin context key the trace ID is stored under.
+const TRACE_CONTEXT_KEY = "trace_id"
+
+var traceID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+var traceparent = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// FieldError is a failure concerning a single field of the request.
+type FieldError struct {
+	Field string `json:"field"`
+	// Code names the failure, as the code of the problem type.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Problem is the body of failed responses.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail explains this occurrence of the problem. It is omitted when
+	// no more can be told than the title.
+	Detail  string       `json:"detail,omitempty"`
+	TraceID string       `json:"trace_id"`
+	Errors  []FieldError `json:"errors"`
+}
+
+// Type returns the type URI of the problem named code.
+func Type(code string) string {
+	if code == "" {
+		return "about:blank"
+	}
+	return TYPE_PREFIX + code
+}
+
+// TraceID returns the trace ID of the request, picking one if Trace did not.
+func TraceID(ctx *gin.Context) string {
+	if id := ctx.GetString(TRACE_CONTEXT_KEY); id != "" {
+		return id
+	}
+
+	id := ctx.GetHeader(TRACE_HEADER)
+	if !traceID.MatchString(id) {
+		id = ""
+		if m := traceparent.FindStringSubmatch(ctx.GetHeader("traceparent")); m != nil {
+			id = m[1]
+		}
+	}
+
+	if id == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+
+	ctx.Set(TRACE_CONTEXT_KEY, id)
+	return id
+}
+
+// Trace assigns the request its trace ID and sends it in TRACE_HEADER.
+func Trace() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header(TRACE_HEADER, TraceID(ctx))
+	}
+}
+
+// Abort responds with problem, filling in its trace ID, and stops the
+// handlers that follow.
+func Abort(ctx *gin.Context, problem Problem) {
+	problem.TraceID = TraceID(ctx)
+	if problem.Errors == nil {
+		problem.Errors = []FieldError{}
+	}
+
+	// gin keeps the content type set before rendering.
+	ctx.Header("Content-Type", CONTENT_TYPE)
+	ctx.Header(TRACE_HEADER, problem.TraceID)
+	ctx.Abort()
+	ctx.IndentedJSON(problem.Status, problem)
+}
+
+// AbortWithStatus responds with a problem described by status alone.
+func AbortWithStatus(ctx *gin.Context, status int) {
+	Abort(ctx, Problem{
+		Type:   Type(""),
+		Title:  http.StatusText(status),
+		Status: status,
+	})
+}
+
+// Recovery responds to panics with an Internal Server Error problem.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(ctx *gin.Context, err interface{}) {
+		AbortWithStatus(ctx, http.StatusInternalServerError)
+	})
+}
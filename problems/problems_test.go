@@ -0,0 +1,70 @@
+package problems
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newContext(headers map[string]string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode("test")
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+	for key, value := range headers {
+		ctx.Request.Header.Set(key, value)
+	}
+	return ctx, w
+}
+
+func TestTraceID(t *testing.T) {
+	ctx, _ := newContext(map[string]string{TRACE_HEADER: "4bf92f3577b34da6a3ce929d0e0e4736"})
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", TraceID(ctx))
+
+	ctx, _ = newContext(map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"})
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", TraceID(ctx))
+
+	ctx, _ = newContext(map[string]string{TRACE_HEADER: "not a trace id"})
+	id := TraceID(ctx)
+	assert.Regexp(t, "^[0-9a-f]{32}$", id)
+	assert.Equal(t, id, TraceID(ctx))
+}
+
+func TestAbort(t *testing.T) {
+	ctx, w := newContext(map[string]string{TRACE_HEADER: "4bf92f3577b34da6a3ce929d0e0e4736"})
+
+	AbortWithStatus(ctx, http.StatusNotFound)
+
+	assert.True(t, ctx.IsAborted())
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, CONTENT_TYPE, w.Header().Get("Content-Type"))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", w.Header().Get(TRACE_HEADER))
+	assert.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Not Found",
+		"status": 404,
+		"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"errors": []
+	}`, w.Body.String())
+}
+
+func TestRecovery(t *testing.T) {
+	gin.SetMode("test")
+	r := gin.New()
+	r.Use(Recovery())
+	r.GET("/", func(ctx *gin.Context) { panic("boom") })
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.ServeHTTP(w, req)
+
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, http.StatusInternalServerError, problem.Status)
+	assert.NotEmpty(t, problem.TraceID)
+}
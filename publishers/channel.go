@@ -0,0 +1,28 @@
+package publishers
+
+import (
+	"errors"
+
+	"github.com/ffardo/user-crud/models"
+)
+
+var ErrChannelFull = errors.New("event channel is full")
+
+// Channel publishes events to in-process consumers reading from C. Publish
+// never blocks: when C is full the event is left for the relay to retry.
+type Channel struct {
+	C chan models.Event
+}
+
+func NewChannel(buffer int) Channel {
+	return Channel{C: make(chan models.Event, buffer)}
+}
+
+func (c Channel) Publish(event models.Event) error {
+	select {
+	case c.C <- event:
+		return nil
+	default:
+		return ErrChannelFull
+	}
+}
@@ -0,0 +1,46 @@
+package publishers
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/ffardo/user-crud/models"
+)
+
+// File appends events to a file as newline delimited JSON.
+type File struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func OpenFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{file: f}, nil
+}
+
+// Publish writes the event and syncs the file, so a published event
+// survives a crash.
+func (f *File) Publish(event models.Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return f.file.Sync()
+}
+
+func (f *File) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,20 @@
+package publishers
+
+import (
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+)
+
+// Multi publishes every event to each of its publishers in turn. If one
+// fails the event is retried on all of them, so publishers before it see
+// the event again.
+type Multi []interfaces.Publisher
+
+func (m Multi) Publish(event models.Event) error {
+	for _, p := range m {
+		if err := p.Publish(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package publishers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ffardo/user-crud/models"
+	"github.com/nats-io/nats.go"
+)
+
+const NATS_FLUSH_TIMEOUT = 5 * time.Second
+
+// NATS publishes each event as JSON on the subject Prefix.<event type>, for
+// example users.UserCreated.
+type NATS struct {
+	Conn   *nats.Conn
+	Prefix string
+}
+
+func ConnectNATS(url, prefix string) (NATS, error) {
+	conn, err := nats.Connect(url, nats.Name("user-crud"), nats.MaxReconnects(-1))
+	if err != nil {
+		return NATS{}, err
+	}
+
+	return NATS{Conn: conn, Prefix: prefix}, nil
+}
+
+// Publish returns once the server has received the event, so a nil error
+// means it was not lost in the client's buffers.
+func (n NATS) Publish(event models.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if err := n.Conn.Publish(n.Prefix+"."+event.Type, data); err != nil {
+		return err
+	}
+
+	return n.Conn.FlushTimeout(NATS_FLUSH_TIMEOUT)
+}
@@ -0,0 +1,75 @@
+package publishers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runNATSServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	require.NoError(t, err)
+
+	go s.Start()
+	t.Cleanup(s.Shutdown)
+
+	require.True(t, s.ReadyForConnections(5*time.Second))
+
+	return s
+}
+
+func TestNATSPublish(t *testing.T) {
+	s := runNATSServer(t)
+
+	publisher, err := ConnectNATS(s.ClientURL(), "users")
+	require.NoError(t, err)
+	defer publisher.Conn.Close()
+
+	subscriber, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer subscriber.Close()
+
+	sub, err := subscriber.SubscribeSync("users.>")
+	require.NoError(t, err)
+	require.NoError(t, subscriber.Flush())
+
+	event := models.Event{
+		ID:       uuid.New(),
+		Type:     models.EVENT_USER_UPDATED,
+		UserUUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"),
+		Fields:   []string{"name", "email"},
+		Occurred: time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	require.NoError(t, publisher.Publish(event))
+
+	msg, err := sub.NextMsg(5 * time.Second)
+	require.NoError(t, err)
+
+	assert.Equal(t, "users.UserUpdated", msg.Subject)
+
+	var received models.Event
+	require.NoError(t, json.Unmarshal(msg.Data, &received))
+
+	assert.Equal(t, event, received)
+}
+
+func TestNATSPublishOnClosedConnection(t *testing.T) {
+	s := runNATSServer(t)
+
+	publisher, err := ConnectNATS(s.ClientURL(), "users")
+	require.NoError(t, err)
+
+	publisher.Conn.Close()
+
+	event := models.Event{ID: uuid.New(), Type: models.EVENT_USER_CREATED}
+
+	assert.Error(t, publisher.Publish(event))
+}
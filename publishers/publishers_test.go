@@ -0,0 +1,70 @@
+package publishers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelPublish(t *testing.T) {
+	c := NewChannel(1)
+	event := models.Event{ID: uuid.New(), Type: models.EVENT_USER_CREATED}
+
+	assert.NoError(t, c.Publish(event))
+	assert.Equal(t, ErrChannelFull, c.Publish(event))
+	assert.Equal(t, event, <-c.C)
+}
+
+func TestFilePublish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+
+	f, err := OpenFile(path)
+	require.NoError(t, err)
+
+	first := models.Event{ID: uuid.New(), Type: models.EVENT_USER_CREATED}
+	second := models.Event{ID: uuid.New(), Type: models.EVENT_USER_DELETED}
+
+	require.NoError(t, f.Publish(first))
+	require.NoError(t, f.Publish(second))
+	require.NoError(t, f.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var events []models.Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e models.Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		events = append(events, e)
+	}
+
+	assert.Equal(t, []models.Event{first, second}, events)
+}
+
+func TestMultiStopsAtFirstFailure(t *testing.T) {
+	event := models.Event{ID: uuid.New(), Type: models.EVENT_USER_CREATED}
+	failure := errors.New("unreachable")
+
+	first := new(mocks.Publisher)
+	second := new(mocks.Publisher)
+	third := new(mocks.Publisher)
+
+	first.On("Publish", event).Return(nil)
+	second.On("Publish", event).Return(failure)
+
+	err := Multi{first, second, third}.Publish(event)
+
+	assert.Equal(t, failure, err)
+	third.AssertNotCalled(t, "Publish", event)
+}
@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/google/uuid"
+)
+
+const DEFAULT_BACKFILL_BATCH_SIZE = 500
+
+// Checkpoint remembers how far a Backfill got, so an interrupted one
+// resumes instead of starting over.
+type Checkpoint interface {
+	// Load returns the last UUID copied, or the nil UUID to start over.
+	Load() (uuid.UUID, error)
+	Save(uuid.UUID) error
+}
+
+// Backfill copies every user from Source to Target in UUID order. Users are
+// saved with their version, so running it while dual writes are on never
+// replaces a newer copy, and running it again is harmless.
+type Backfill struct {
+	Source     interfaces.UserStore
+	Target     interfaces.UserStore
+	Checkpoint Checkpoint
+	BatchSize  int
+}
+
+// Run copies the users after the checkpoint and returns how many were
+// copied. progress, if set, is called after each batch.
+func (b Backfill) Run(progress func(copied int, last uuid.UUID)) (int, error) {
+	batchSize := b.BatchSize
+	if batchSize <= 0 {
+		batchSize = DEFAULT_BACKFILL_BATCH_SIZE
+	}
+
+	last, err := b.Checkpoint.Load()
+	if err != nil {
+		return 0, err
+	}
+
+	copied := 0
+	for {
+		users, err := b.Source.ScanUsers(last, batchSize)
+		if err != nil {
+			return copied, err
+		}
+
+		for _, user := range users {
+			if err := b.Target.SaveUser(user); err != nil {
+				return copied, err
+			}
+			copied++
+			last = user.UUID
+		}
+
+		if len(users) > 0 {
+			if err := b.Checkpoint.Save(last); err != nil {
+				return copied, err
+			}
+			if progress != nil {
+				progress(copied, last)
+			}
+		}
+
+		if len(users) < batchSize {
+			return copied, nil
+		}
+	}
+}
+
+// FileCheckpoint keeps the checkpoint in a file. A missing file means the
+// backfill has not started.
+type FileCheckpoint struct {
+	Path string
+}
+
+func (f FileCheckpoint) Load() (uuid.UUID, error) {
+	b, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(strings.TrimSpace(string(b)))
+}
+
+// Save replaces the file through a rename, so a crash leaves either the old
+// or the new checkpoint.
+func (f FileCheckpoint) Save(last uuid.UUID) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(last.String() + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.Path)
+}
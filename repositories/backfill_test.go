@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestBackfillCopiesInBatchesAndCheckpoints(t *testing.T) {
+	source := new(mocks.UserStore)
+	target := new(mocks.UserStore)
+	checkpoint := FileCheckpoint{Path: filepath.Join(t.TempDir(), "backfill")}
+
+	a := models.User{UUID: uuid.MustParse("00000000-0000-4000-8000-000000000001")}
+	b := models.User{UUID: uuid.MustParse("00000000-0000-4000-8000-000000000002")}
+	c := models.User{UUID: uuid.MustParse("00000000-0000-4000-8000-000000000003")}
+
+	source.On("ScanUsers", uuid.Nil, 2).Return([]models.User{a, b}, nil)
+	source.On("ScanUsers", b.UUID, 2).Return([]models.User{c}, nil)
+	target.On("SaveUser", mock.Anything).Return(nil)
+
+	backfill := Backfill{Source: source, Target: target, Checkpoint: checkpoint, BatchSize: 2}
+
+	copied, err := backfill.Run(nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, copied)
+	target.AssertNumberOfCalls(t, "SaveUser", 3)
+
+	last, err := checkpoint.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, c.UUID, last)
+}
+
+func TestBackfillResumesFromCheckpoint(t *testing.T) {
+	source := new(mocks.UserStore)
+	target := new(mocks.UserStore)
+	checkpoint := FileCheckpoint{Path: filepath.Join(t.TempDir(), "backfill")}
+
+	a := models.User{UUID: uuid.MustParse("00000000-0000-4000-8000-000000000001")}
+	b := models.User{UUID: uuid.MustParse("00000000-0000-4000-8000-000000000002")}
+
+	source.On("ScanUsers", uuid.Nil, 10).Return([]models.User{a, b}, nil)
+	target.On("SaveUser", a).Return(nil)
+	target.On("SaveUser", b).Return(interfaces.ErrUnavailable).Once()
+
+	backfill := Backfill{Source: source, Target: target, Checkpoint: checkpoint, BatchSize: 10}
+
+	copied, err := backfill.Run(nil)
+	assert.ErrorIs(t, err, interfaces.ErrUnavailable)
+	assert.Equal(t, 1, copied)
+
+	// The failed batch was not checkpointed, so it is read again.
+	target.On("SaveUser", b).Return(nil)
+
+	copied, err = backfill.Run(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, copied)
+}
+
+func TestFileCheckpointMissingStartsOver(t *testing.T) {
+	checkpoint := FileCheckpoint{Path: filepath.Join(t.TempDir(), "missing")}
+
+	last, err := checkpoint.Load()
+
+	assert.NoError(t, err)
+	assert.Equal(t, uuid.Nil, last)
+}
@@ -0,0 +1,250 @@
+package repositories
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+)
+
+type CacheStats struct {
+	Hits         uint64
+	NegativeHits uint64
+	Misses       uint64
+	Evictions    uint64
+	Size         int
+}
+
+// CachedUserRepository serves GetUserByUUID from an in-memory LRU cache in
+// front of another repository. Users not found are cached for NegativeTTL so
+// repeated lookups of unknown UUIDs do not reach the database, and concurrent
+// misses for the same UUID share a single lookup.
+//
+// Writes made through this repository invalidate the cache immediately.
+// Writes made by other instances become visible once the entry expires, so
+// a cached read can be up to TTL stale. That suits reads only answered to
+// clients, such as UserService.GetUser. Reads a write is decided on must
+// not use it: they go through WithTransaction, which bypasses the cache, as
+// the service's writes and UserService.GetCurrentUser do. Email checks and
+// writes always go to the underlying repository.
+//
+// The repositories returned by ForTenant share the cache. Entries remember
+// the tenant they were looked up for and only serve that tenant.
+type CachedUserRepository struct {
+	interfaces.UserRepository
+	*userCache
+
+	// tenant is the scope of the underlying repository, nil when it is not
+	// restricted to a tenant.
+	tenant *string
+}
+
+type userCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu    sync.Mutex
+	cache *lru
+	// generation changes on every invalidation, so a lookup that started
+	// before a write does not put the pre-write user back in the cache.
+	generation uint64
+	group      singleflight.Group
+
+	hits         atomic.Uint64
+	negativeHits atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
+}
+
+func NewCachedUserRepository(repository interfaces.UserRepository, size int, ttl, negativeTTL time.Duration) *CachedUserRepository {
+	return &CachedUserRepository{
+		UserRepository: repository,
+		userCache: &userCache{
+			ttl:         ttl,
+			negativeTTL: negativeTTL,
+			now:         time.Now,
+			cache:       newLRU(size),
+		},
+	}
+}
+
+func (c *CachedUserRepository) ForTenant(tenantID string) interfaces.UserRepository {
+	return &CachedUserRepository{
+		UserRepository: c.UserRepository.ForTenant(tenantID),
+		userCache:      c.userCache,
+		tenant:         &tenantID,
+	}
+}
+
+// scope names the tenant entries are looked up for. Tenant IDs cannot
+// contain a slash, so unscoped lookups never share an entry with a tenant's.
+func (c *CachedUserRepository) scope() string {
+	if c.tenant == nil {
+		return "/"
+	}
+	return *c.tenant
+}
+
+func (c *CachedUserRepository) GetUserByUUID(user_uuid uuid.UUID) (models.User, error) {
+	scope := c.scope()
+
+	c.mu.Lock()
+	entry, ok := c.cache.get(user_uuid, c.now())
+	generation := c.generation
+	c.mu.Unlock()
+
+	if ok && entry.scope == scope {
+		if entry.err != nil {
+			c.negativeHits.Add(1)
+		} else {
+			c.hits.Add(1)
+		}
+		return entry.user, entry.err
+	}
+
+	c.misses.Add(1)
+
+	v, err, _ := c.group.Do(scope+" "+user_uuid.String(), func() (interface{}, error) {
+		user, err := c.UserRepository.GetUserByUUID(user_uuid)
+
+		switch {
+		case err == nil:
+			c.store(cacheEntry{key: user_uuid, scope: scope, user: user, expires: c.now().Add(c.ttl)}, generation)
+		case errors.Is(err, interfaces.ErrNotFound):
+			c.store(cacheEntry{key: user_uuid, scope: scope, err: err, expires: c.now().Add(c.negativeTTL)}, generation)
+		}
+
+		return user, err
+	})
+
+	return v.(models.User), err
+}
+
+func (c *CachedUserRepository) CreateUser(user models.User) (models.User, error) {
+	user, err := c.UserRepository.CreateUser(user)
+	c.invalidate(user.UUID)
+	return user, err
+}
+
+func (c *CachedUserRepository) UpdateUser(user models.User) (models.User, error) {
+	defer c.invalidate(user.UUID)
+	return c.UserRepository.UpdateUser(user)
+}
+
+func (c *CachedUserRepository) DeleteUser(user_uuid uuid.UUID) error {
+	defer c.invalidate(user_uuid)
+	return c.UserRepository.DeleteUser(user_uuid)
+}
+
+func (c *CachedUserRepository) RestoreUser(user_uuid uuid.UUID) (models.User, error) {
+	defer c.invalidate(user_uuid)
+	return c.UserRepository.RestoreUser(user_uuid)
+}
+
+func (c *CachedUserRepository) WriteUsers(writes []interfaces.UserWrite) ([]models.User, error) {
+	users, err := c.UserRepository.WriteUsers(writes)
+	for _, user := range users {
+		c.invalidate(user.UUID)
+	}
+	return users, err
+}
+
+// WithTransaction runs fn against the underlying repository's transaction,
+// bypassing the cache for reads, and invalidates every user fn wrote once the
+// transaction is over.
+func (c *CachedUserRepository) WithTransaction(fn func(interfaces.UserRepository) error) error {
+	tx := &transactionRecorder{}
+
+	err := c.UserRepository.WithTransaction(func(r interfaces.UserRepository) error {
+		tx.UserRepository = r
+		return fn(tx)
+	})
+
+	for _, user_uuid := range tx.written {
+		c.invalidate(user_uuid)
+	}
+
+	return err
+}
+
+func (c *userCache) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.cache.len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		Size:         size,
+	}
+}
+
+func (c *userCache) store(entry cacheEntry, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if c.cache.add(entry) {
+		c.evictions.Add(1)
+	}
+}
+
+// invalidate drops the user whatever the tenant it was cached for. Lookups
+// in flight are not forgotten, as their keys depend on the tenant; the
+// generation change keeps them from being cached.
+func (c *userCache) invalidate(user_uuid uuid.UUID) {
+	c.mu.Lock()
+	c.generation++
+	c.cache.remove(user_uuid)
+	c.mu.Unlock()
+}
+
+// transactionRecorder remembers the users written inside a transaction.
+type transactionRecorder struct {
+	interfaces.UserRepository
+	written []uuid.UUID
+}
+
+func (t *transactionRecorder) CreateUser(user models.User) (models.User, error) {
+	user, err := t.UserRepository.CreateUser(user)
+	t.written = append(t.written, user.UUID)
+	return user, err
+}
+
+func (t *transactionRecorder) UpdateUser(user models.User) (models.User, error) {
+	t.written = append(t.written, user.UUID)
+	return t.UserRepository.UpdateUser(user)
+}
+
+func (t *transactionRecorder) DeleteUser(user_uuid uuid.UUID) error {
+	t.written = append(t.written, user_uuid)
+	return t.UserRepository.DeleteUser(user_uuid)
+}
+
+func (t *transactionRecorder) RestoreUser(user_uuid uuid.UUID) (models.User, error) {
+	t.written = append(t.written, user_uuid)
+	return t.UserRepository.RestoreUser(user_uuid)
+}
+
+func (t *transactionRecorder) WriteUsers(writes []interfaces.UserWrite) ([]models.User, error) {
+	users, err := t.UserRepository.WriteUsers(writes)
+	for _, user := range users {
+		t.written = append(t.written, user.UUID)
+	}
+	return users, err
+}
+
+func (t *transactionRecorder) WithTransaction(fn func(interfaces.UserRepository) error) error {
+	return fn(t)
+}
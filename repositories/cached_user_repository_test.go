@@ -0,0 +1,280 @@
+package repositories
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestCache(repository interfaces.UserRepository, size int) (*CachedUserRepository, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)}
+	c := NewCachedUserRepository(repository, size, time.Minute, 10*time.Second)
+	c.now = clock.now
+	return c, clock
+}
+
+func TestCachedGetUserByUUID(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	user := models.User{UUID: u, Name: "John Doe"}
+
+	userRepository.On("GetUserByUUID", u).Return(user, nil)
+
+	c, _ := newTestCache(userRepository, 10)
+
+	first, err := c.GetUserByUUID(u)
+	assert.Equal(t, err, nil)
+	second, err := c.GetUserByUUID(u)
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, user, first)
+	assert.Equal(t, user, second)
+	userRepository.AssertNumberOfCalls(t, "GetUserByUUID", 1)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1, Size: 1}, c.Stats())
+}
+
+func TestCachedGetUserByUUIDExpires(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	userRepository.On("GetUserByUUID", u).Return(models.User{UUID: u}, nil)
+
+	c, clock := newTestCache(userRepository, 10)
+
+	c.GetUserByUUID(u)
+	clock.t = clock.t.Add(time.Minute)
+	c.GetUserByUUID(u)
+
+	userRepository.AssertNumberOfCalls(t, "GetUserByUUID", 2)
+}
+
+func TestCachedGetUserByUUIDNegative(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	userRepository.On("GetUserByUUID", u).Return(models.User{}, interfaces.ErrNotFound)
+
+	c, clock := newTestCache(userRepository, 10)
+
+	_, err := c.GetUserByUUID(u)
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+	_, err = c.GetUserByUUID(u)
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+
+	userRepository.AssertNumberOfCalls(t, "GetUserByUUID", 1)
+	assert.Equal(t, uint64(1), c.Stats().NegativeHits)
+
+	clock.t = clock.t.Add(10 * time.Second)
+	c.GetUserByUUID(u)
+
+	userRepository.AssertNumberOfCalls(t, "GetUserByUUID", 2)
+}
+
+func TestCachedGetUserByUUIDDoesNotCacheFailures(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	userRepository.On("GetUserByUUID", u).Return(models.User{}, interfaces.ErrUnavailable)
+
+	c, _ := newTestCache(userRepository, 10)
+
+	c.GetUserByUUID(u)
+	c.GetUserByUUID(u)
+
+	userRepository.AssertNumberOfCalls(t, "GetUserByUUID", 2)
+}
+
+func TestCachedEvictsLeastRecentlyUsed(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	a := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	b := uuid.MustParse("6f1d8a3c-2b4e-4c1a-9a52-0f3e7d9c1b2a")
+	d := uuid.MustParse("0b3e7c52-8f0d-4f6a-b2c1-5e9d4a7f3c10")
+
+	for _, u := range []uuid.UUID{a, b, d} {
+		userRepository.On("GetUserByUUID", u).Return(models.User{UUID: u}, nil)
+	}
+
+	c, _ := newTestCache(userRepository, 2)
+
+	c.GetUserByUUID(a)
+	c.GetUserByUUID(b)
+	c.GetUserByUUID(a)
+	c.GetUserByUUID(d)
+	c.GetUserByUUID(a)
+	c.GetUserByUUID(b)
+
+	userRepository.AssertNumberOfCalls(t, "GetUserByUUID", 4)
+	assert.Equal(t, uint64(2), c.Stats().Evictions)
+	assert.Equal(t, 2, c.Stats().Size)
+}
+
+func TestCachedInvalidatesOnWrites(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	user := models.User{UUID: u, Name: "John Doe"}
+
+	userRepository.On("GetUserByUUID", u).Return(user, nil)
+	userRepository.On("UpdateUser", user).Return(user, nil)
+	userRepository.On("DeleteUser", u).Return(nil)
+	userRepository.On("RestoreUser", u).Return(user, nil)
+
+	c, _ := newTestCache(userRepository, 10)
+
+	c.GetUserByUUID(u)
+	c.UpdateUser(user)
+	c.GetUserByUUID(u)
+	c.DeleteUser(u)
+	c.GetUserByUUID(u)
+	c.RestoreUser(u)
+	c.GetUserByUUID(u)
+
+	userRepository.AssertNumberOfCalls(t, "GetUserByUUID", 4)
+}
+
+func TestCachedInvalidatesOnBatchWrites(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	user := models.User{UUID: u, Name: "John Doe"}
+	writes := []interfaces.UserWrite{{Kind: interfaces.WRITE_UPDATE, User: user}}
+
+	userRepository.On("GetUserByUUID", u).Return(user, nil)
+	userRepository.On("WriteUsers", writes).Return([]models.User{user}, nil)
+
+	c, _ := newTestCache(userRepository, 10)
+
+	c.GetUserByUUID(u)
+	c.WriteUsers(writes)
+	c.GetUserByUUID(u)
+	c.WithTransaction(func(tx interfaces.UserRepository) error {
+		_, err := tx.WriteUsers(writes)
+		return err
+	})
+	c.GetUserByUUID(u)
+
+	userRepository.AssertNumberOfCalls(t, "GetUserByUUID", 3)
+}
+
+func TestCachedEntriesServeOnlyTheirTenant(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	acmeRepository := new(mocks.UserRepository)
+	otherRepository := new(mocks.UserRepository)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	user := models.User{UUID: u, TenantID: "acme"}
+
+	userRepository.On("ForTenant", "acme").Return(acmeRepository)
+	userRepository.On("ForTenant", "other").Return(otherRepository)
+	acmeRepository.On("GetUserByUUID", u).Return(user, nil)
+	acmeRepository.On("UpdateUser", user).Return(user, nil)
+	otherRepository.On("GetUserByUUID", u).Return(models.User{}, interfaces.ErrNotFound)
+
+	c, _ := newTestCache(userRepository, 10)
+	acme := c.ForTenant("acme")
+	other := c.ForTenant("other")
+
+	got, err := acme.GetUserByUUID(u)
+	assert.NoError(t, err)
+	assert.Equal(t, user, got)
+
+	_, err = other.GetUserByUUID(u)
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+
+	acme.GetUserByUUID(u)
+	acme.UpdateUser(user)
+	acme.GetUserByUUID(u)
+
+	acmeRepository.AssertNumberOfCalls(t, "GetUserByUUID", 3)
+	otherRepository.AssertNumberOfCalls(t, "GetUserByUUID", 1)
+}
+
+func TestCachedCoalescesConcurrentMisses(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	release := make(chan struct{})
+
+	userRepository.On("GetUserByUUID", u).Return(func(uuid.UUID) models.User {
+		<-release
+		return models.User{UUID: u}
+	}, nil)
+
+	c, _ := newTestCache(userRepository, 10)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.GetUserByUUID(u)
+			errs <- err
+		}()
+	}
+
+	// Let the goroutines pile up on the in-flight lookup before it returns.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.Equal(t, err, nil)
+	}
+
+	userRepository.AssertNumberOfCalls(t, "GetUserByUUID", 1)
+}
+
+func TestCachedPassesThroughEmailChecks(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	checkErr := errors.New("connection refused")
+
+	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(false, checkErr)
+
+	c, _ := newTestCache(userRepository, 10)
+
+	_, err := c.UserExistsWithEmail("joe25@mailprovider.com")
+
+	assert.Equal(t, checkErr, err)
+}
+
+func TestCachedInvalidatesAfterTransaction(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	user := models.User{UUID: u, Name: "John Doe"}
+
+	userRepository.On("GetUserByUUID", u).Return(user, nil)
+	userRepository.On("UpdateUser", user).Return(user, nil)
+
+	c, _ := newTestCache(userRepository, 10)
+
+	c.GetUserByUUID(u)
+
+	err := c.WithTransaction(func(tx interfaces.UserRepository) error {
+		current, err := tx.GetUserByUUID(u)
+		if err != nil {
+			return err
+		}
+		_, err = tx.UpdateUser(current)
+		return err
+	})
+	assert.Equal(t, err, nil)
+
+	c.GetUserByUUID(u)
+
+	// One read to fill the cache, one inside the transaction and one after
+	// the transaction invalidated the entry.
+	userRepository.AssertNumberOfCalls(t, "GetUserByUUID", 3)
+}
@@ -0,0 +1,377 @@
+package repositories
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+)
+
+// Mismatch is a difference found between the primary and the secondary of
+// a DualUserRepository.
+type Mismatch struct {
+	// Operation is the read that was shadowed, or "replicate" when copying
+	// a write to the secondary failed.
+	Operation string
+	UUID      uuid.UUID
+	Email     string
+	// Fields lists the fields, by bson name, that differ.
+	Fields []string
+	// Err is the error the secondary returned where the primary did not,
+	// or the other way around.
+	Err error
+}
+
+// MAX_SHADOW_READS bounds the shadow reads in flight. Reads beyond it, as
+// when the secondary is slow, are not shadowed but counted as dropped.
+const MAX_SHADOW_READS = 64
+
+type DualStats struct {
+	ShadowReads         uint64
+	DroppedShadowReads  uint64
+	Mismatches          uint64
+	ReplicationFailures uint64
+}
+
+// DualUserRepository moves users between two backends without downtime.
+// Every operation is served by the primary. Writes are then copied to the
+// secondary, and reads are repeated on the secondary in the background and
+// compared. Differences and failed copies are passed to onMismatch.
+//
+// The primary stays the source of truth: failures of the secondary never
+// fail a request, they are only reported. Users that existed before dual
+// writes started, or whose copy failed, are brought over by a Backfill.
+//
+// The repositories returned by ForTenant share the callback and statistics.
+type DualUserRepository struct {
+	primary   interfaces.UserStore
+	secondary interfaces.UserStore
+	*dualState
+}
+
+type dualState struct {
+	// onMismatch is called from the goroutine that found the mismatch.
+	onMismatch func(Mismatch)
+
+	shadows sync.WaitGroup
+	// slots holds a value for each shadow read in flight.
+	slots chan struct{}
+
+	shadowReads         atomic.Uint64
+	droppedShadowReads  atomic.Uint64
+	mismatches          atomic.Uint64
+	replicationFailures atomic.Uint64
+}
+
+func NewDualUserRepository(primary, secondary interfaces.UserStore, onMismatch func(Mismatch)) *DualUserRepository {
+	return &DualUserRepository{
+		primary:   primary,
+		secondary: secondary,
+		dualState: &dualState{onMismatch: onMismatch, slots: make(chan struct{}, MAX_SHADOW_READS)},
+	}
+}
+
+func (d *DualUserRepository) ForTenant(tenantID string) interfaces.UserRepository {
+	return &DualUserRepository{
+		primary:   d.primary.ForTenant(tenantID).(interfaces.UserStore),
+		secondary: d.secondary.ForTenant(tenantID).(interfaces.UserStore),
+		dualState: d.dualState,
+	}
+}
+
+func (d *DualUserRepository) GetUserByUUID(user_uuid uuid.UUID) (models.User, error) {
+	user, err := d.primary.GetUserByUUID(user_uuid)
+
+	d.shadow(func() {
+		shadow, shadowErr := d.secondary.GetUserByUUID(user_uuid)
+		d.compare(Mismatch{Operation: "GetUserByUUID", UUID: user_uuid}, err, shadowErr, func() []string {
+			return diffUsers(user, shadow)
+		})
+	})
+
+	return user, err
+}
+
+func (d *DualUserRepository) UserExistsWithEmail(email string) (bool, error) {
+	exists, err := d.primary.UserExistsWithEmail(email)
+
+	d.shadow(func() {
+		shadow, shadowErr := d.secondary.UserExistsWithEmail(email)
+		d.compare(Mismatch{Operation: "UserExistsWithEmail", Email: email}, err, shadowErr, func() []string {
+			return diffExists(exists, shadow)
+		})
+	})
+
+	return exists, err
+}
+
+func (d *DualUserRepository) UserExistsWithEmailAndNotUuid(email string, user_uuid uuid.UUID) (bool, error) {
+	exists, err := d.primary.UserExistsWithEmailAndNotUuid(email, user_uuid)
+
+	d.shadow(func() {
+		shadow, shadowErr := d.secondary.UserExistsWithEmailAndNotUuid(email, user_uuid)
+		d.compare(Mismatch{Operation: "UserExistsWithEmailAndNotUuid", UUID: user_uuid, Email: email}, err, shadowErr, func() []string {
+			return diffExists(exists, shadow)
+		})
+	})
+
+	return exists, err
+}
+
+func (d *DualUserRepository) CreateUser(user models.User) (models.User, error) {
+	user, err := d.primary.CreateUser(user)
+	if err == nil {
+		d.replicate(user.UUID)
+	}
+	return user, err
+}
+
+func (d *DualUserRepository) UpdateUser(user models.User) (models.User, error) {
+	user, err := d.primary.UpdateUser(user)
+	if err == nil {
+		d.replicate(user.UUID)
+	}
+	return user, err
+}
+
+func (d *DualUserRepository) DeleteUser(user_uuid uuid.UUID) error {
+	err := d.primary.DeleteUser(user_uuid)
+	if err == nil {
+		d.replicate(user_uuid)
+	}
+	return err
+}
+
+func (d *DualUserRepository) RestoreUser(user_uuid uuid.UUID) (models.User, error) {
+	user, err := d.primary.RestoreUser(user_uuid)
+	if err == nil {
+		d.replicate(user_uuid)
+	}
+	return user, err
+}
+
+// WriteUsers copies the users written before the first failure. Outside a
+// transaction, those writes are stored by the primary.
+func (d *DualUserRepository) WriteUsers(writes []interfaces.UserWrite) ([]models.User, error) {
+	users, err := d.primary.WriteUsers(writes)
+
+	written := users
+	var we interfaces.UserWriteError
+	if errors.As(err, &we) {
+		written = users[:we.Index]
+	} else if err != nil {
+		written = nil
+	}
+
+	for _, user := range written {
+		d.replicate(user.UUID)
+	}
+
+	return users, err
+}
+
+func (d *DualUserRepository) ListDeletedUsers() ([]models.User, error) {
+	return d.primary.ListDeletedUsers()
+}
+
+// ListUsers is served by the primary only. Pages of the secondary may
+// legitimately differ while users are backfilled.
+func (d *DualUserRepository) ListUsers(query interfaces.UserQuery) ([]models.User, error) {
+	return d.primary.ListUsers(query)
+}
+
+// SearchUsers is served by the primary only, like ListUsers.
+func (d *DualUserRepository) SearchUsers(text string, limit int) ([]interfaces.UserMatch, error) {
+	return d.primary.SearchUsers(text, limit)
+}
+
+func (d *DualUserRepository) SimilarUsers(trigrams []string, limit int) ([]interfaces.UserMatch, error) {
+	return d.primary.SimilarUsers(trigrams, limit)
+}
+
+func (d *DualUserRepository) PurgeDeletedUsers(before time.Time) (int64, error) {
+	purged, err := d.primary.PurgeDeletedUsers(before)
+	if err != nil {
+		return purged, err
+	}
+
+	if _, err := d.secondary.PurgeDeletedUsers(before); err != nil {
+		d.replicationFailed(Mismatch{Operation: "replicate", Err: err})
+	}
+
+	return purged, nil
+}
+
+// AddEvent stores the event in the primary only; its outbox is the one the
+// relay publishes from.
+func (d *DualUserRepository) AddEvent(event models.Event) error {
+	return d.primary.AddEvent(event)
+}
+
+// WithTransaction runs fn in a primary transaction and copies the users it
+// wrote once the transaction commits, so the secondary never sees writes
+// that are rolled back. Reads inside fn are not shadowed.
+func (d *DualUserRepository) WithTransaction(fn func(interfaces.UserRepository) error) error {
+	tx := &transactionRecorder{}
+
+	err := d.primary.WithTransaction(func(r interfaces.UserRepository) error {
+		tx.UserRepository = r
+		tx.written = nil
+		return fn(tx)
+	})
+
+	if err == nil {
+		for _, user_uuid := range tx.written {
+			d.replicate(user_uuid)
+		}
+	}
+
+	return err
+}
+
+// Wait blocks until the shadow reads in flight have been compared.
+func (d *DualUserRepository) Wait() {
+	d.shadows.Wait()
+}
+
+func (d *DualUserRepository) Stats() DualStats {
+	return DualStats{
+		ShadowReads:         d.shadowReads.Load(),
+		DroppedShadowReads:  d.droppedShadowReads.Load(),
+		Mismatches:          d.mismatches.Load(),
+		ReplicationFailures: d.replicationFailures.Load(),
+	}
+}
+
+// replicate copies the primary's current state of the user to the
+// secondary. Reading it back, rather than repeating the write, keeps
+// generated fields such as UUID, timestamps and version identical.
+func (d *DualUserRepository) replicate(user_uuid uuid.UUID) {
+	user, err := d.primary.LoadUser(user_uuid)
+	if err == nil {
+		err = d.secondary.SaveUser(user)
+	}
+
+	if err != nil {
+		d.replicationFailed(Mismatch{Operation: "replicate", UUID: user_uuid, Err: err})
+	}
+}
+
+func (d *DualUserRepository) replicationFailed(m Mismatch) {
+	d.replicationFailures.Add(1)
+	if d.onMismatch != nil {
+		d.onMismatch(m)
+	}
+}
+
+// shadow runs read in the background, unless MAX_SHADOW_READS are already
+// in flight.
+func (d *DualUserRepository) shadow(read func()) {
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		d.droppedShadowReads.Add(1)
+		return
+	}
+
+	d.shadowReads.Add(1)
+	d.shadows.Add(1)
+
+	go func() {
+		defer d.shadows.Done()
+		defer func() { <-d.slots }()
+		read()
+	}()
+}
+
+// compare reports a mismatch when only one side failed, when both failed
+// differently, or when both succeeded with different results.
+func (d *DualUserRepository) compare(m Mismatch, err, shadowErr error, diff func() []string) {
+	switch {
+	case err == nil && shadowErr == nil:
+		m.Fields = diff()
+		if len(m.Fields) == 0 {
+			return
+		}
+	case err != nil && shadowErr != nil:
+		if errors.Is(err, interfaces.ErrNotFound) == errors.Is(shadowErr, interfaces.ErrNotFound) {
+			return
+		}
+		m.Err = shadowErr
+	case shadowErr != nil:
+		m.Err = shadowErr
+	default:
+		m.Err = err
+	}
+
+	d.report(m)
+}
+
+func (d *DualUserRepository) report(m Mismatch) {
+	d.mismatches.Add(1)
+	if d.onMismatch != nil {
+		d.onMismatch(m)
+	}
+}
+
+func diffExists(primary, secondary bool) []string {
+	if primary != secondary {
+		return []string{"exists"}
+	}
+	return nil
+}
+
+// diffUsers returns the bson names of the fields that differ. Times are
+// compared at the millisecond precision backends such as Mongo store.
+func diffUsers(a, b models.User) []string {
+	var fields []string
+
+	sameTime := func(x, y time.Time) bool {
+		return x.Truncate(time.Millisecond).Equal(y.Truncate(time.Millisecond))
+	}
+
+	sameOptionalTime := func(x, y *time.Time) bool {
+		if x == nil || y == nil {
+			return x == y
+		}
+		return sameTime(*x, *y)
+	}
+
+	sameAddressParts := func(x, y *models.Address) bool {
+		if x == nil || y == nil {
+			return x == y
+		}
+		return *x == *y
+	}
+
+	checks := []struct {
+		field string
+		same  bool
+	}{
+		{"uuid", a.UUID == b.UUID},
+		{"tenant_id", a.TenantID == b.TenantID},
+		{"birthdate", sameTime(a.BirthDate, b.BirthDate)},
+		{"name", a.Name == b.Name},
+		{"email", a.Email == b.Email},
+		{"email_canonical", a.EmailCanonical == b.EmailCanonical},
+		{"password", a.Password == b.Password},
+		{"address", a.Address == b.Address},
+		{"address_parts", sameAddressParts(a.AddressParts, b.AddressParts)},
+		{"created", sameTime(a.Created, b.Created)},
+		{"updated", sameTime(a.Updated, b.Updated)},
+		{"version", a.Version == b.Version},
+		{"deleted", sameOptionalTime(a.Deleted, b.Deleted)},
+	}
+
+	for _, c := range checks {
+		if !c.same {
+			fields = append(fields, c.field)
+		}
+	}
+
+	return fields
+}
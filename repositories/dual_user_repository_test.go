@@ -0,0 +1,245 @@
+package repositories
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type mismatchRecorder struct {
+	mu         sync.Mutex
+	mismatches []Mismatch
+}
+
+func (r *mismatchRecorder) record(m Mismatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mismatches = append(r.mismatches, m)
+}
+
+func newTestDual() (*DualUserRepository, *mocks.UserStore, *mocks.UserStore, *mismatchRecorder) {
+	primary := new(mocks.UserStore)
+	secondary := new(mocks.UserStore)
+	recorder := &mismatchRecorder{}
+	return NewDualUserRepository(primary, secondary, recorder.record), primary, secondary, recorder
+}
+
+func TestDualGetUserByUUIDServesPrimary(t *testing.T) {
+	d, primary, secondary, recorder := newTestDual()
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	user := models.User{UUID: u, Name: "John Doe", Version: 2}
+
+	primary.On("GetUserByUUID", u).Return(user, nil)
+	secondary.On("GetUserByUUID", u).Return(user, nil)
+
+	got, err := d.GetUserByUUID(u)
+	d.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, user, got)
+	assert.Empty(t, recorder.mismatches)
+	assert.Equal(t, DualStats{ShadowReads: 1}, d.Stats())
+}
+
+func TestDualGetUserByUUIDReportsDifferentFields(t *testing.T) {
+	d, primary, secondary, recorder := newTestDual()
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	primary.On("GetUserByUUID", u).Return(models.User{UUID: u, Name: "John Doe", Version: 2}, nil)
+	secondary.On("GetUserByUUID", u).Return(models.User{UUID: u, Name: "Jon Doe", Version: 1}, nil)
+
+	got, err := d.GetUserByUUID(u)
+	d.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "John Doe", got.Name)
+	assert.Equal(t, []Mismatch{{Operation: "GetUserByUUID", UUID: u, Fields: []string{"name", "version"}}}, recorder.mismatches)
+	assert.Equal(t, uint64(1), d.Stats().Mismatches)
+}
+
+func TestDualDropsShadowReadsOverLimit(t *testing.T) {
+	d, primary, secondary, recorder := newTestDual()
+	d.slots = make(chan struct{}, 1)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	user := models.User{UUID: u, Name: "John Doe", Version: 2}
+
+	// The secondary is stuck until released, keeping the only slot taken.
+	release := make(chan time.Time)
+	primary.On("GetUserByUUID", u).Return(user, nil)
+	secondary.On("GetUserByUUID", u).WaitUntil(release).Return(user, nil)
+
+	_, err := d.GetUserByUUID(u)
+	assert.NoError(t, err)
+
+	got, err := d.GetUserByUUID(u)
+	assert.NoError(t, err)
+	assert.Equal(t, user, got)
+
+	close(release)
+	d.Wait()
+
+	assert.Empty(t, recorder.mismatches)
+	assert.Equal(t, DualStats{ShadowReads: 1, DroppedShadowReads: 1}, d.Stats())
+	secondary.AssertNumberOfCalls(t, "GetUserByUUID", 1)
+}
+
+func TestDualGetUserByUUIDReportsMissingSecondary(t *testing.T) {
+	d, primary, secondary, recorder := newTestDual()
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	primary.On("GetUserByUUID", u).Return(models.User{UUID: u}, nil)
+	secondary.On("GetUserByUUID", u).Return(models.User{}, interfaces.ErrNotFound)
+
+	_, err := d.GetUserByUUID(u)
+	d.Wait()
+
+	assert.NoError(t, err)
+	assert.Len(t, recorder.mismatches, 1)
+	assert.ErrorIs(t, recorder.mismatches[0].Err, interfaces.ErrNotFound)
+}
+
+func TestDualGetUserByUUIDBothNotFound(t *testing.T) {
+	d, primary, secondary, recorder := newTestDual()
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	primary.On("GetUserByUUID", u).Return(models.User{}, interfaces.ErrNotFound)
+	secondary.On("GetUserByUUID", u).Return(models.User{}, interfaces.ErrNotFound)
+
+	_, err := d.GetUserByUUID(u)
+	d.Wait()
+
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+	assert.Empty(t, recorder.mismatches)
+}
+
+func TestDualCreateUserCopiesPrimaryState(t *testing.T) {
+	d, primary, secondary, recorder := newTestDual()
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	created := models.User{UUID: u, Name: "John Doe", Version: 1}
+
+	primary.On("CreateUser", models.User{Name: "John Doe"}).Return(created, nil)
+	primary.On("LoadUser", u).Return(created, nil)
+	secondary.On("SaveUser", created).Return(nil)
+
+	got, err := d.CreateUser(models.User{Name: "John Doe"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, created, got)
+	secondary.AssertExpectations(t)
+	assert.Empty(t, recorder.mismatches)
+}
+
+func TestDualSecondaryFailureDoesNotFailWrite(t *testing.T) {
+	d, primary, secondary, recorder := newTestDual()
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	deleted := models.User{UUID: u, Version: 3}
+
+	primary.On("DeleteUser", u).Return(nil)
+	primary.On("LoadUser", u).Return(deleted, nil)
+	secondary.On("SaveUser", deleted).Return(interfaces.ErrUnavailable)
+
+	err := d.DeleteUser(u)
+
+	assert.NoError(t, err)
+	assert.Equal(t, DualStats{ReplicationFailures: 1}, d.Stats())
+	assert.Equal(t, "replicate", recorder.mismatches[0].Operation)
+}
+
+func TestDualPrimaryFailureSkipsSecondary(t *testing.T) {
+	d, primary, secondary, _ := newTestDual()
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	primary.On("UpdateUser", models.User{UUID: u}).Return(models.User{}, interfaces.ErrVersionConflict)
+
+	_, err := d.UpdateUser(models.User{UUID: u})
+
+	assert.ErrorIs(t, err, interfaces.ErrVersionConflict)
+	secondary.AssertNotCalled(t, "SaveUser")
+}
+
+func TestDualTransactionCopiesAfterCommit(t *testing.T) {
+	d, primary, secondary, _ := newTestDual()
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	updated := models.User{UUID: u, Version: 2}
+
+	primary.On("UpdateUser", models.User{UUID: u, Version: 1}).Return(updated, nil)
+	primary.On("LoadUser", u).Return(updated, nil)
+	secondary.On("SaveUser", updated).Return(nil)
+
+	err := d.WithTransaction(func(tx interfaces.UserRepository) error {
+		_, err := tx.UpdateUser(models.User{UUID: u, Version: 1})
+		secondary.AssertNotCalled(t, "SaveUser")
+		return err
+	})
+
+	assert.NoError(t, err)
+	secondary.AssertCalled(t, "SaveUser", updated)
+}
+
+func TestDualTransactionCopiesBatchWrites(t *testing.T) {
+	d, primary, secondary, _ := newTestDual()
+	created := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), Version: 1}
+	deleted := models.User{UUID: uuid.MustParse("4c5b4d8e-3fd1-4d3b-9a0e-6f1b2c3d4e5f"), Version: 4}
+	writes := []interfaces.UserWrite{
+		{Kind: interfaces.WRITE_CREATE, User: models.User{Name: "John Doe"}},
+		{Kind: interfaces.WRITE_DELETE, User: models.User{UUID: deleted.UUID, Version: 3}},
+	}
+
+	primary.On("WriteUsers", writes).Return([]models.User{created, deleted}, nil)
+	primary.On("LoadUser", created.UUID).Return(created, nil)
+	primary.On("LoadUser", deleted.UUID).Return(deleted, nil)
+	secondary.On("SaveUser", created).Return(nil)
+	secondary.On("SaveUser", deleted).Return(nil)
+
+	err := d.WithTransaction(func(tx interfaces.UserRepository) error {
+		_, err := tx.WriteUsers(writes)
+		return err
+	})
+
+	assert.NoError(t, err)
+	secondary.AssertExpectations(t)
+}
+
+func TestDualAbortedTransactionIsNotCopied(t *testing.T) {
+	d, primary, secondary, _ := newTestDual()
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	failure := errors.New("event rejected")
+
+	primary.On("DeleteUser", u).Return(nil)
+
+	err := d.WithTransaction(func(tx interfaces.UserRepository) error {
+		tx.DeleteUser(u)
+		return failure
+	})
+
+	assert.ErrorIs(t, err, failure)
+	secondary.AssertNotCalled(t, "SaveUser")
+	primary.AssertNotCalled(t, "LoadUser")
+}
+
+func TestDualForTenantScopesBothSides(t *testing.T) {
+	d, primary, secondary, _ := newTestDual()
+	primaryAcme := new(mocks.UserStore)
+	secondaryAcme := new(mocks.UserStore)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	user := models.User{UUID: u, TenantID: "acme", Version: 1}
+
+	primary.On("ForTenant", "acme").Return(primaryAcme)
+	secondary.On("ForTenant", "acme").Return(secondaryAcme)
+	primaryAcme.On("CreateUser", models.User{}).Return(user, nil)
+	primaryAcme.On("LoadUser", u).Return(user, nil)
+	secondaryAcme.On("SaveUser", user).Return(errors.New("secondary down"))
+
+	_, err := d.ForTenant("acme").CreateUser(models.User{})
+
+	assert.NoError(t, err)
+	secondaryAcme.AssertCalled(t, "SaveUser", user)
+	assert.Equal(t, uint64(1), d.Stats().ReplicationFailures)
+}
@@ -0,0 +1,109 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// Server error codes reported when the configured credentials are rejected.
+const (
+	codeUnauthorized         = 13
+	codeAuthenticationFailed = 18
+)
+
+// Server error codes for dropping an index or collection that does not exist.
+const (
+	codeNamespaceNotFound = 26
+	codeIndexNotFound     = 27
+)
+
+// mapError translates driver errors into the errors declared by
+// interfaces.UserRepository, keeping the original error in the chain.
+func mapError(err error) error {
+	if err == nil || isMapped(err) {
+		return err
+	}
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: %w", interfaces.ErrNotFound, err)
+	}
+
+	// Besides the email indexes, only the uuid index is unique. Its
+	// violations are reported as plain conflicts, any other duplicate key
+	// means the email is taken.
+	if mongo.IsDuplicateKeyError(err) {
+		if strings.Contains(err.Error(), "index: "+UUID_INDEX) {
+			return fmt.Errorf("%w: %w", interfaces.ErrConflict, err)
+		}
+		return fmt.Errorf("%w: %w", interfaces.ErrEmailRegistered, err)
+	}
+
+	if mongo.IsTimeout(err) {
+		return fmt.Errorf("%w: %w", interfaces.ErrTimeout, err)
+	}
+
+	if isUnavailable(err) {
+		return fmt.Errorf("%w: %w", interfaces.ErrUnavailable, err)
+	}
+
+	return err
+}
+
+// isMapped reports whether err already carries one of the repository errors,
+// as happens when an error returned inside a transaction is returned again
+// from WithTransaction.
+func isMapped(err error) bool {
+	for _, target := range []error{
+		interfaces.ErrNotFound,
+		interfaces.ErrConflict,
+		interfaces.ErrUnavailable,
+		interfaces.ErrTimeout,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasErrorLabel reports whether a driver error in err's chain carries label.
+// The driver's own helpers only follow single-error chains, which the
+// mapped errors above are not.
+func hasErrorLabel(err error, label string) bool {
+	var le interface{ HasErrorLabel(string) bool }
+	if errors.As(err, &le) {
+		return le.HasErrorLabel(label)
+	}
+	return false
+}
+
+func isUnavailable(err error) bool {
+	if mongo.IsNetworkError(err) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return true
+	}
+
+	var sse topology.ServerSelectionError
+	if errors.As(err, &sse) {
+		return true
+	}
+
+	var se mongo.ServerError
+	if errors.As(err, &se) {
+		return se.HasErrorCode(codeUnauthorized) || se.HasErrorCode(codeAuthenticationFailed)
+	}
+
+	return false
+}
+
+func isIndexNotFound(err error) bool {
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		return ce.HasErrorCode(codeNamespaceNotFound) || ce.HasErrorCode(codeIndexNotFound)
+	}
+	return false
+}
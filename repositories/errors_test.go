@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+)
+
+func TestMapErrorNoDocuments(t *testing.T) {
+	err := mapError(mongo.ErrNoDocuments)
+
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+}
+
+func TestMapErrorDuplicateKey(t *testing.T) {
+	err := mapError(mongo.WriteException{
+		WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}},
+	})
+
+	assert.ErrorIs(t, err, interfaces.ErrEmailRegistered)
+	assert.ErrorIs(t, err, interfaces.ErrConflict)
+	assert.Contains(t, err.Error(), "E11000 duplicate key error")
+}
+
+func TestMapErrorAuthenticationFailed(t *testing.T) {
+	err := mapError(mongo.CommandError{Code: codeAuthenticationFailed, Message: "Authentication failed."})
+
+	assert.ErrorIs(t, err, interfaces.ErrUnavailable)
+}
+
+func TestMapErrorLeavesMappedErrors(t *testing.T) {
+	err := mapError(interfaces.ErrNotFound)
+
+	assert.Equal(t, interfaces.ErrNotFound, err)
+}
+
+func TestMapErrorKeepsLabels(t *testing.T) {
+	cause := mongo.CommandError{
+		Code:   112,
+		Labels: []string{driver.TransientTransactionError, "NetworkError"},
+	}
+
+	err := mapError(fmt.Errorf("write: %w", cause))
+
+	assert.ErrorIs(t, err, interfaces.ErrUnavailable)
+	assert.True(t, hasErrorLabel(err, driver.TransientTransactionError))
+}
+
+func TestHasErrorLabelWithoutDriverError(t *testing.T) {
+	assert.False(t, hasErrorLabel(errors.New("boom"), driver.TransientTransactionError))
+}
+
+func TestMapErrorDuplicateUUID(t *testing.T) {
+	err := mapError(mongo.WriteException{
+		WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error collection: user_service.users index: uuid_unique dup key"}},
+	})
+
+	assert.ErrorIs(t, err, interfaces.ErrConflict)
+	assert.NotErrorIs(t, err, interfaces.ErrEmailRegistered)
+}
@@ -0,0 +1,530 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const INDEX_TIMEOUT = time.Minute
+
+const UUID_INDEX = "uuid_unique"
+
+// Index declares an index the repositories rely on. Text indexes list their
+// fields in Keys with the value "text", in alphabetical order as the server
+// lists them.
+type Index struct {
+	Name    string
+	Keys    bson.D
+	Unique  bool
+	Partial bson.D
+	// ExpireAfter makes a TTL index when set.
+	ExpireAfter *time.Duration
+	// Weights and DefaultLanguage configure text indexes.
+	Weights         bson.D
+	DefaultLanguage string
+}
+
+type IndexDiffKind string
+
+const (
+	// IndexMissing is declared but does not exist. Ensure creates it.
+	IndexMissing IndexDiffKind = "missing"
+	// IndexRetired is no longer declared and known to be safe to drop.
+	// Ensure drops it.
+	IndexRetired IndexDiffKind = "retired"
+	// IndexChanged exists with a different configuration than declared.
+	// Replacing it could leave the collection unprotected, so it is only
+	// reported.
+	IndexChanged IndexDiffKind = "changed"
+	// IndexUnexpected exists without being declared. It is only reported.
+	IndexUnexpected IndexDiffKind = "unexpected"
+	// IndexFailed is missing and could not be built from the stored
+	// documents, as when a unique index finds duplicates. It is reported
+	// rather than failing Ensure; the documents need fixing by hand, and
+	// the server stays not ready until the index is built.
+	IndexFailed IndexDiffKind = "failed"
+)
+
+// IndexDiff is one difference between the declared and existing indexes.
+type IndexDiff struct {
+	Kind       IndexDiffKind
+	Collection string
+	Name       string
+	Declared   *Index
+	Existing   *Index
+	// Err is why an IndexFailed index could not be built.
+	Err error
+}
+
+func (d IndexDiff) String() string {
+	switch d.Kind {
+	case IndexChanged:
+		return fmt.Sprintf("%s %s.%s: declared %s, found %s", d.Kind, d.Collection, d.Name, d.Declared, d.Existing)
+	case IndexMissing:
+		return fmt.Sprintf("%s %s.%s: %s", d.Kind, d.Collection, d.Name, d.Declared)
+	case IndexFailed:
+		return fmt.Sprintf("%s %s.%s: %s: %v", d.Kind, d.Collection, d.Name, d.Declared, d.Err)
+	default:
+		return fmt.Sprintf("%s %s.%s: %s", d.Kind, d.Collection, d.Name, d.Existing)
+	}
+}
+
+func (i Index) String() string {
+	s := fmt.Sprint(i.Keys)
+	if i.Unique {
+		s += " unique"
+	}
+	if len(i.Partial) > 0 {
+		s += fmt.Sprintf(" partial %v", i.Partial)
+	}
+	if i.ExpireAfter != nil {
+		s += fmt.Sprintf(" expires after %s", *i.ExpireAfter)
+	}
+	if len(i.Weights) > 0 {
+		s += fmt.Sprintf(" weights %v", i.Weights)
+	}
+	if i.DefaultLanguage != "" {
+		s += " language " + i.DefaultLanguage
+	}
+	return s
+}
+
+// notDeletedPartial matches users whose deleted field is null or, as for
+// users stored before the field existed, missing.
+func notDeletedPartial(filter ...bson.E) bson.D {
+	return append(bson.D{{Key: "deleted", Value: nil}}, filter...)
+}
+
+func expireAfter(d time.Duration) *time.Duration {
+	return &d
+}
+
+// userIndexes are the indexes of the users collection. Emails are unique
+// within a tenant. Soft deleted users keep their email, so uniqueness is
+// only enforced among users not deleted.
+var userIndexes = []Index{
+	{
+		Name:    "tenant_email_not_deleted_unique",
+		Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "email", Value: 1}},
+		Unique:  true,
+		Partial: notDeletedPartial(),
+	},
+	{
+		// Documents written before canonical emails existed have no
+		// email_canonical and are left out until migrated, rather than
+		// colliding with each other on a missing value.
+		Name:   "tenant_email_canonical_not_deleted_unique",
+		Keys:   bson.D{{Key: "tenant_id", Value: 1}, {Key: "email_canonical", Value: 1}},
+		Unique: true,
+		Partial: notDeletedPartial(
+			bson.E{Key: "email_canonical", Value: bson.D{{Key: "$type", Value: "string"}}},
+		),
+	},
+	{
+		Name:   UUID_INDEX,
+		Keys:   bson.D{{Key: "uuid", Value: 1}},
+		Unique: true,
+	},
+	{
+		Name: "created",
+		Keys: bson.D{{Key: "created", Value: 1}},
+	},
+	{
+		// The list indexes serve each sort order of ListUsers, with the
+		// UUID breaking ties.
+		Name: "tenant_list_created",
+		Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created", Value: 1}, {Key: "uuid", Value: 1}},
+	},
+	{
+		Name: "tenant_list_name",
+		Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "name", Value: 1}, {Key: "uuid", Value: 1}},
+	},
+	{
+		Name: "tenant_list_email",
+		Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "email_canonical", Value: 1}, {Key: "uuid", Value: 1}},
+	},
+	{
+		// Names and addresses are mostly proper nouns, so words are
+		// neither stemmed nor dropped as stop words. Text indexes ignore
+		// case and accents.
+		Name:            "search_text",
+		Keys:            bson.D{{Key: "address", Value: "text"}, {Key: "name", Value: "text"}},
+		Weights:         bson.D{{Key: "address", Value: 1}, {Key: "name", Value: 3}},
+		DefaultLanguage: "none",
+	},
+	{
+		Name: "search_trigrams",
+		Keys: bson.D{{Key: "search_trigrams", Value: 1}},
+	},
+}
+
+// retiredUserIndexes replaced by the ones above. email_1 covered soft
+// deleted users as well, the next two made emails unique across tenants,
+// name_text left addresses out, and the tenant ones left out users without
+// a deleted field.
+var retiredUserIndexes = []string{
+	"email_1",
+	"email_active_unique",
+	"email_canonical_active_unique",
+	"name_text",
+	"tenant_email_active_unique",
+	"tenant_email_canonical_active_unique",
+}
+
+var outboxIndexes = []Index{
+	{
+		Name: "published_1_occurred_1",
+		Keys: bson.D{{Key: "published", Value: 1}, {Key: "occurred", Value: 1}},
+	},
+	{
+		Name:   "id_1",
+		Keys:   bson.D{{Key: "id", Value: 1}},
+		Unique: true,
+	},
+	{
+		// Unpublished events have a null published field, which TTL
+		// indexes ignore, so only published events expire.
+		Name:        "published_ttl",
+		Keys:        bson.D{{Key: "published", Value: 1}},
+		ExpireAfter: expireAfter(PUBLISHED_EVENT_RETENTION),
+	},
+}
+
+// tokenIndexes expire token records, such as password resets, once their
+// expires time has passed.
+var tokenIndexes = []Index{
+	{
+		Name:        "expires_ttl",
+		Keys:        bson.D{{Key: "expires", Value: 1}},
+		ExpireAfter: expireAfter(0),
+	},
+}
+
+// tenantIndexes find the tenant an API key belongs to.
+var tenantIndexes = []Index{
+	{
+		Name:   "api_key_hash_unique",
+		Keys:   bson.D{{Key: "api_key_hash", Value: 1}},
+		Unique: true,
+	},
+}
+
+// IndexManager compares the declared indexes with the ones in the database
+// and creates those missing.
+type IndexManager struct {
+	Client  *mongo.Client
+	Storage Storage
+}
+
+type collectionIndexes struct {
+	collection *mongo.Collection
+	declared   []Index
+	retired    []string
+}
+
+func (m IndexManager) collections() []collectionIndexes {
+	return []collectionIndexes{
+		{m.Storage.users(m.Client), userIndexes, retiredUserIndexes},
+		{m.Storage.outbox(m.Client), outboxIndexes, nil},
+		{m.Storage.tokens(m.Client), tokenIndexes, nil},
+		{m.Storage.tenants(m.Client), tenantIndexes, nil},
+	}
+}
+
+// Plan returns the differences Ensure would act on or report, without
+// changing anything.
+func (m IndexManager) Plan(ctx context.Context) ([]IndexDiff, error) {
+	var diffs []IndexDiff
+
+	for _, c := range m.collections() {
+		existing, err := listIndexes(ctx, c.collection)
+		if err != nil {
+			return nil, mapError(err)
+		}
+
+		diffs = append(diffs, diffIndexes(c.collection.Name(), c.declared, c.retired, existing)...)
+	}
+
+	return diffs, nil
+}
+
+// Ensure creates missing indexes and drops retired ones. It returns the
+// differences left, which need to be resolved by hand, including the
+// indexes the stored documents prevent from being built.
+//
+// Indexes are created before retired ones are dropped, so a collection is
+// never left without a unique index. Retired text indexes are the exception:
+// a collection holds a single text index, so they are dropped first.
+func (m IndexManager) Ensure(ctx context.Context) ([]IndexDiff, error) {
+	diffs, err := m.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return retiredText(diffs[i]) && !retiredText(diffs[j])
+	})
+
+	collections := map[string]*mongo.Collection{}
+	for _, c := range m.collections() {
+		collections[c.collection.Name()] = c.collection
+	}
+
+	// Retired indexes are kept in collections where an index replacing
+	// them could not be built.
+	failed := map[string]bool{}
+
+	var left []IndexDiff
+	for _, d := range diffs {
+		indexes := collections[d.Collection].Indexes()
+
+		switch d.Kind {
+		case IndexMissing:
+			_, err = indexes.CreateOne(ctx, d.Declared.model())
+			if f, ok := failedBuild(d, err); ok {
+				failed[d.Collection] = true
+				left = append(left, f)
+				continue
+			}
+		case IndexRetired:
+			if failed[d.Collection] && !retiredText(d) {
+				left = append(left, d)
+				continue
+			}
+			_, err = indexes.DropOne(ctx, d.Name)
+			if isIndexNotFound(err) {
+				err = nil
+			}
+		default:
+			left = append(left, d)
+			continue
+		}
+
+		if err != nil {
+			return left, fmt.Errorf("%s index %s.%s: %w", d.Kind, d.Collection, d.Name, mapError(err))
+		}
+	}
+
+	return left, nil
+}
+
+// failedBuild returns d as an IndexFailed diff when err tells its index
+// could not be built from the stored documents.
+func failedBuild(d IndexDiff, err error) (IndexDiff, bool) {
+	if !mongo.IsDuplicateKeyError(err) {
+		return d, false
+	}
+
+	d.Kind = IndexFailed
+	d.Err = err
+	return d, true
+}
+
+func retiredText(d IndexDiff) bool {
+	return d.Kind == IndexRetired && len(d.Existing.Weights) > 0
+}
+
+func (i Index) model() mongo.IndexModel {
+	opts := options.Index().SetName(i.Name)
+
+	if i.Unique {
+		opts.SetUnique(true)
+	}
+	if len(i.Partial) > 0 {
+		opts.SetPartialFilterExpression(i.Partial)
+	}
+	if i.ExpireAfter != nil {
+		opts.SetExpireAfterSeconds(int32(i.ExpireAfter.Seconds()))
+	}
+	if len(i.Weights) > 0 {
+		opts.SetWeights(i.Weights)
+	}
+	if i.DefaultLanguage != "" {
+		opts.SetDefaultLanguage(i.DefaultLanguage)
+	}
+
+	return mongo.IndexModel{Keys: i.Keys, Options: opts}
+}
+
+// indexDocument is an index as listed by the server.
+type indexDocument struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	Partial            bson.D `bson:"partialFilterExpression"`
+	ExpireAfterSeconds *int32 `bson:"expireAfterSeconds"`
+	Weights            bson.D `bson:"weights"`
+	DefaultLanguage    string `bson:"default_language"`
+}
+
+func listIndexes(ctx context.Context, collection *mongo.Collection) ([]Index, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []indexDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	indexes := make([]Index, 0, len(docs))
+	for _, doc := range docs {
+		indexes = append(indexes, doc.index())
+	}
+
+	return indexes, nil
+}
+
+func (doc indexDocument) index() Index {
+	i := Index{
+		Name:    doc.Name,
+		Keys:    doc.Key,
+		Unique:  doc.Unique,
+		Partial: doc.Partial,
+	}
+
+	if doc.ExpireAfterSeconds != nil {
+		i.ExpireAfter = expireAfter(time.Duration(*doc.ExpireAfterSeconds) * time.Second)
+	}
+
+	// Text indexes are listed by their internal keys, with the indexed
+	// fields in weights.
+	if len(doc.Weights) > 0 {
+		weights := append(bson.D{}, doc.Weights...)
+		sort.Slice(weights, func(a, b int) bool { return weights[a].Key < weights[b].Key })
+
+		keys := bson.D{}
+		for _, e := range doc.Key {
+			if e.Key == "_fts" || e.Key == "_ftsx" {
+				continue
+			}
+			keys = append(keys, e)
+		}
+		for _, w := range weights {
+			keys = append(keys, bson.E{Key: w.Key, Value: "text"})
+		}
+		i.Keys = keys
+		i.Weights = weights
+		i.DefaultLanguage = doc.DefaultLanguage
+	}
+
+	return i
+}
+
+// diffIndexes compares the declared indexes of a collection with the
+// existing ones, matching them by name.
+func diffIndexes(collection string, declared []Index, retired []string, existing []Index) []IndexDiff {
+	byName := map[string]Index{}
+	for _, e := range existing {
+		byName[e.Name] = e
+	}
+
+	var diffs []IndexDiff
+	for _, d := range declared {
+		d := d
+		e, ok := byName[d.Name]
+		delete(byName, d.Name)
+
+		if !ok {
+			diffs = append(diffs, IndexDiff{Kind: IndexMissing, Collection: collection, Name: d.Name, Declared: &d})
+		} else if !sameIndex(d, e) {
+			diffs = append(diffs, IndexDiff{Kind: IndexChanged, Collection: collection, Name: d.Name, Declared: &d, Existing: &e})
+		}
+	}
+
+	for _, name := range retired {
+		if e, ok := byName[name]; ok {
+			diffs = append(diffs, IndexDiff{Kind: IndexRetired, Collection: collection, Name: name, Existing: &e})
+			delete(byName, name)
+		}
+	}
+
+	// Every collection has an index on _id that nobody declares.
+	delete(byName, "_id_")
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		e := byName[name]
+		diffs = append(diffs, IndexDiff{Kind: IndexUnexpected, Collection: collection, Name: name, Existing: &e})
+	}
+
+	return diffs
+}
+
+func sameIndex(a, b Index) bool {
+	if a.Unique != b.Unique || !sameValue(a.Keys, b.Keys) || !sameValue(a.Partial, b.Partial) {
+		return false
+	}
+
+	if a.DefaultLanguage != b.DefaultLanguage || !sameValue(a.Weights, b.Weights) {
+		return false
+	}
+
+	if a.ExpireAfter == nil || b.ExpireAfter == nil {
+		return a.ExpireAfter == b.ExpireAfter
+	}
+
+	return *a.ExpireAfter == *b.ExpireAfter
+}
+
+// sameValue compares documents as the server does, so 1 declared as an int
+// matches the 1.0 or int32 the server may list.
+func sameValue(a, b interface{}) bool {
+	switch a := a.(type) {
+	case bson.D:
+		b, ok := b.(bson.D)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i].Key != b[i].Key || !sameValue(a[i].Value, b[i].Value) {
+				return false
+			}
+		}
+		return true
+	case bson.A:
+		b, ok := b.(bson.A)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !sameValue(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if an, ok := number(a); ok {
+		bn, ok := number(b)
+		return ok && an == bn
+	}
+
+	return a == b
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
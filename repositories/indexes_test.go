@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func kinds(diffs []IndexDiff) map[string]IndexDiffKind {
+	k := map[string]IndexDiffKind{}
+	for _, d := range diffs {
+		k[d.Name] = d.Kind
+	}
+	return k
+}
+
+func TestDiffIndexesMatchesServerListing(t *testing.T) {
+	// As listed by the server: int32 keys, and text indexes by their
+	// internal keys.
+	existing := []Index{
+		{Name: "_id_", Keys: bson.D{{Key: "_id", Value: int32(1)}}},
+		indexDocument{
+			Name:    "tenant_email_not_deleted_unique",
+			Key:     bson.D{{Key: "tenant_id", Value: int32(1)}, {Key: "email", Value: int32(1)}},
+			Unique:  true,
+			Partial: bson.D{{Key: "deleted", Value: nil}},
+		}.index(),
+		indexDocument{
+			Name:            "search_text",
+			Key:             bson.D{{Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: int32(1)}},
+			Weights:         bson.D{{Key: "name", Value: int32(3)}, {Key: "address", Value: int32(1)}},
+			DefaultLanguage: "none",
+		}.index(),
+	}
+
+	declared := []Index{userIndexes[0], searchText()}
+
+	assert.Empty(t, diffIndexes("users", declared, nil, existing))
+}
+
+func searchText() Index {
+	for _, i := range userIndexes {
+		if i.Name == "search_text" {
+			return i
+		}
+	}
+	panic("search_text is not declared")
+}
+
+func TestDiffIndexesReportsChangedWeights(t *testing.T) {
+	existing := searchText()
+	existing.Weights = bson.D{{Key: "address", Value: 1}, {Key: "name", Value: 1}}
+
+	diffs := diffIndexes("users", []Index{searchText()}, nil, []Index{existing})
+
+	assert.Len(t, diffs, 1)
+	assert.Equal(t, IndexChanged, diffs[0].Kind)
+}
+
+func TestDiffIndexesReportsEveryKind(t *testing.T) {
+	declared := []Index{
+		{Name: "created", Keys: bson.D{{Key: "created", Value: 1}}},
+		{Name: "uuid_unique", Keys: bson.D{{Key: "uuid", Value: 1}}, Unique: true},
+		{Name: "published_ttl", Keys: bson.D{{Key: "published", Value: 1}}, ExpireAfter: expireAfter(time.Hour)},
+	}
+
+	existing := []Index{
+		{Name: "_id_", Keys: bson.D{{Key: "_id", Value: 1}}},
+		{Name: "uuid_unique", Keys: bson.D{{Key: "uuid", Value: 1}}},
+		{Name: "published_ttl", Keys: bson.D{{Key: "published", Value: 1}}, ExpireAfter: expireAfter(time.Minute)},
+		{Name: "email_1", Keys: bson.D{{Key: "email", Value: 1}}, Unique: true},
+		{Name: "address_1", Keys: bson.D{{Key: "address", Value: 1}}},
+	}
+
+	diffs := diffIndexes("users", declared, []string{"email_1"}, existing)
+
+	assert.Equal(t, map[string]IndexDiffKind{
+		"created":       IndexMissing,
+		"uuid_unique":   IndexChanged,
+		"published_ttl": IndexChanged,
+		"email_1":       IndexRetired,
+		"address_1":     IndexUnexpected,
+	}, kinds(diffs))
+}
+
+func TestDeclaredIndexesHaveUniqueNames(t *testing.T) {
+	for _, declared := range [][]Index{userIndexes, outboxIndexes, tokenIndexes, tenantIndexes} {
+		names := map[string]bool{}
+		for _, i := range declared {
+			assert.NotEmpty(t, i.Name)
+			assert.False(t, names[i.Name], i.Name)
+			names[i.Name] = true
+		}
+	}
+}
+
+func TestFailedBuildReportsDuplicates(t *testing.T) {
+	declared := Index{Name: UUID_INDEX, Keys: bson.D{{Key: "uuid", Value: 1}}, Unique: true}
+	missing := IndexDiff{Kind: IndexMissing, Collection: "users", Name: declared.Name, Declared: &declared}
+
+	duplicate := mongo.CommandError{Code: 11000, Message: "E11000 duplicate key error collection: test.users index: uuid_unique"}
+
+	failed, ok := failedBuild(missing, duplicate)
+	assert.True(t, ok)
+	assert.Equal(t, IndexFailed, failed.Kind)
+	assert.Contains(t, failed.String(), "failed users.uuid_unique")
+
+	_, ok = failedBuild(missing, mongo.CommandError{Code: 13, Message: "unauthorized"})
+	assert.False(t, ok)
+
+	_, ok = failedBuild(missing, nil)
+	assert.False(t, ok)
+}
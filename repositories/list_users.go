@@ -0,0 +1,130 @@
+package repositories
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sortFields maps the fields users are listed by to their bson names.
+var sortFields = map[string]string{
+	interfaces.SORT_CREATED: "created",
+	interfaces.SORT_NAME:    "name",
+	interfaces.SORT_EMAIL:   "email_canonical",
+}
+
+// sortValue returns the value of user the field is sorted on, nil for
+// users without a canonical email, which do not store one.
+func sortValue(sort string, user models.User) interface{} {
+	switch sort {
+	case interfaces.SORT_NAME:
+		return user.Name
+	case interfaces.SORT_EMAIL:
+		if user.EmailCanonical == "" {
+			return nil
+		}
+		return user.EmailCanonical
+	}
+	return user.Created
+}
+
+// afterFilter matches the users sorted after the one with value and
+// user_uuid. Users without a value sort before all others, so they come
+// first when ascending and last when descending.
+func afterFilter(field string, value interface{}, user_uuid uuid.UUID, descending bool) bson.A {
+	after := "$gt"
+	if descending {
+		after = "$lt"
+	}
+
+	sameValue := bson.D{
+		{Key: field, Value: value},
+		{Key: "uuid", Value: bson.D{{Key: after, Value: user_uuid}}},
+	}
+
+	if value == nil {
+		if descending {
+			return bson.A{sameValue}
+		}
+		return bson.A{sameValue, bson.D{{Key: field, Value: bson.D{{Key: "$ne", Value: nil}}}}}
+	}
+
+	filter := bson.A{bson.D{{Key: field, Value: bson.D{{Key: after, Value: value}}}}, sameValue}
+	if descending {
+		filter = append(filter, bson.D{{Key: field, Value: nil}})
+	}
+	return filter
+}
+
+// ListUsers pages through users by keyset: each page starts after the sort
+// value and UUID of the last user of the previous one, so pages stay
+// consistent while users are written, and any page costs the same to read.
+func (u UserRepository) ListUsers(query interfaces.UserQuery) ([]models.User, error) {
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
+
+	defer cancel()
+
+	field, ok := sortFields[query.Sort]
+	if !ok {
+		field = sortFields[interfaces.SORT_CREATED]
+	}
+
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+
+	filter := bson.D{}
+
+	if query.EmailCanonical != "" {
+		filter = append(filter, bson.E{Key: "email_canonical", Value: query.EmailCanonical})
+	}
+	if query.NamePrefix != "" {
+		filter = append(filter, bson.E{Key: "name", Value: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query.NamePrefix)}})
+	}
+	if r := between(query.CreatedFrom, query.CreatedTo); len(r) > 0 {
+		filter = append(filter, bson.E{Key: "created", Value: r})
+	}
+	if r := between(query.BirthDateFrom, query.BirthDateTo); len(r) > 0 {
+		filter = append(filter, bson.E{Key: "birthdate", Value: r})
+	}
+
+	if query.After != nil {
+		value := sortValue(query.Sort, *query.After)
+		filter = append(filter, bson.E{Key: "$or", Value: afterFilter(field, value, query.After.UUID, query.Descending)})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: field, Value: direction}, {Key: "uuid", Value: direction}}).
+		SetLimit(int64(query.Limit))
+
+	cursor, err := collection.Find(ctx, u.scoped(notDeleted(filter)), opts)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	users := []models.User{}
+	err = cursor.All(ctx, &users)
+
+	return users, mapError(err)
+}
+
+// between matches values from from to to, both included. Zero times leave
+// that end open.
+func between(from, to time.Time) bson.D {
+	r := bson.D{}
+	if !from.IsZero() {
+		r = append(r, bson.E{Key: "$gte", Value: from})
+	}
+	if !to.IsZero() {
+		r = append(r, bson.E{Key: "$lte", Value: to})
+	}
+	return r
+}
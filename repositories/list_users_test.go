@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSortValueOfUserWithoutCanonicalEmail(t *testing.T) {
+	assert.Nil(t, sortValue(interfaces.SORT_EMAIL, models.User{Email: "joe@mail.com"}))
+	assert.Equal(t, "joe@mail.com", sortValue(interfaces.SORT_EMAIL, models.User{EmailCanonical: "joe@mail.com"}))
+}
+
+func TestAfterFilter(t *testing.T) {
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	tests := []struct {
+		name       string
+		value      interface{}
+		descending bool
+		want       bson.A
+	}{
+		{
+			name:  "ascending",
+			value: "joe@mail.com",
+			want: bson.A{
+				bson.D{{Key: "email_canonical", Value: bson.D{{Key: "$gt", Value: "joe@mail.com"}}}},
+				bson.D{{Key: "email_canonical", Value: "joe@mail.com"}, {Key: "uuid", Value: bson.D{{Key: "$gt", Value: u}}}},
+			},
+		},
+		{
+			name:       "descending reaches users without a value",
+			value:      "joe@mail.com",
+			descending: true,
+			want: bson.A{
+				bson.D{{Key: "email_canonical", Value: bson.D{{Key: "$lt", Value: "joe@mail.com"}}}},
+				bson.D{{Key: "email_canonical", Value: "joe@mail.com"}, {Key: "uuid", Value: bson.D{{Key: "$lt", Value: u}}}},
+				bson.D{{Key: "email_canonical", Value: nil}},
+			},
+		},
+		{
+			name: "ascending from a user without a value",
+			want: bson.A{
+				bson.D{{Key: "email_canonical", Value: nil}, {Key: "uuid", Value: bson.D{{Key: "$gt", Value: u}}}},
+				bson.D{{Key: "email_canonical", Value: bson.D{{Key: "$ne", Value: nil}}}},
+			},
+		},
+		{
+			name:       "descending from a user without a value",
+			descending: true,
+			want: bson.A{
+				bson.D{{Key: "email_canonical", Value: nil}, {Key: "uuid", Value: bson.D{{Key: "$lt", Value: u}}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, afterFilter("email_canonical", tt.value, u, tt.descending))
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+)
+
+// cacheEntry holds either a user or the error GetUserByUUID returned for it.
+type cacheEntry struct {
+	key uuid.UUID
+	// scope is the tenant the user was looked up for.
+	scope   string
+	user    models.User
+	err     error
+	expires time.Time
+}
+
+// lru is a fixed size least recently used cache of entries with expiry. It
+// is not safe for concurrent use.
+type lru struct {
+	size  int
+	ll    *list.List
+	items map[uuid.UUID]*list.Element
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[uuid.UUID]*list.Element),
+	}
+}
+
+// get returns the entry for key unless it is missing or expired at now.
+func (c *lru) get(key uuid.UUID, now time.Time) (cacheEntry, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+
+	entry := el.Value.(cacheEntry)
+	if !now.Before(entry.expires) {
+		c.removeElement(el)
+		return cacheEntry{}, false
+	}
+
+	c.ll.MoveToFront(el)
+	return entry, true
+}
+
+// add stores entry, replacing any entry for the same key, and reports
+// whether the least recently used entry was evicted to make room.
+func (c *lru) add(entry cacheEntry) bool {
+	if el, ok := c.items[entry.key]; ok {
+		el.Value = entry
+		c.ll.MoveToFront(el)
+		return false
+	}
+
+	c.items[entry.key] = c.ll.PushFront(entry)
+
+	if c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+		return true
+	}
+
+	return false
+}
+
+func (c *lru) remove(key uuid.UUID) {
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *lru) len() int {
+	return c.ll.Len()
+}
+
+func (c *lru) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(cacheEntry).key)
+}
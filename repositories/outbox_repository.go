@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PUBLISHED_EVENT_RETENTION is how long published events stay in the outbox.
+const PUBLISHED_EVENT_RETENTION = 7 * 24 * time.Hour
+
+type OutboxRepository struct {
+	Client  *mongo.Client
+	Storage Storage
+}
+
+func (u UserRepository) AddEvent(event models.Event) error {
+	collection := u.Storage.outbox(u.Client)
+
+	ctx, cancel := u.context()
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, event)
+
+	return mapError(err)
+}
+
+func (o OutboxRepository) PendingEvents(limit int) ([]models.Event, error) {
+	collection := o.Storage.outbox(o.Client)
+
+	ctx, cancel := o.Storage.context(context.Background())
+	defer cancel()
+
+	filter := bson.D{{Key: "published", Value: nil}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "occurred", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	events := []models.Event{}
+	err = cursor.All(ctx, &events)
+
+	return events, mapError(err)
+}
+
+func (o OutboxRepository) MarkPublished(id uuid.UUID) error {
+	return o.update(id, bson.D{{Key: "$set", Value: bson.D{{Key: "published", Value: time.Now()}}}})
+}
+
+func (o OutboxRepository) MarkFailed(id uuid.UUID) error {
+	return o.update(id, bson.D{{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}}})
+}
+
+func (o OutboxRepository) update(id uuid.UUID, update bson.D) error {
+	collection := o.Storage.outbox(o.Client)
+
+	ctx, cancel := o.Storage.context(context.Background())
+	defer cancel()
+
+	res, err := collection.UpdateOne(ctx, bson.D{{Key: "id", Value: id}}, update)
+	if err != nil {
+		return mapError(err)
+	}
+
+	if res.MatchedCount == 0 {
+		return interfaces.ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// scoredUser is a user document with the score a search gave it.
+type scoredUser struct {
+	models.User `bson:",inline"`
+	Score       float64 `bson:"search_score"`
+}
+
+// SearchUsers looks users up by the search_text index, which ignores case
+// and accents and weighs names above addresses.
+func (u UserRepository) SearchUsers(text string, limit int) ([]interfaces.UserMatch, error) {
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
+
+	defer cancel()
+
+	score := bson.D{{Key: "$meta", Value: "textScore"}}
+
+	opts := options.Find().
+		SetProjection(bson.D{{Key: "search_score", Value: score}}).
+		SetSort(bson.D{{Key: "search_score", Value: score}, {Key: "uuid", Value: 1}}).
+		SetLimit(int64(limit))
+
+	filter := bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: text}}}}
+
+	cursor, err := collection.Find(ctx, u.scoped(notDeleted(filter)), opts)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return decodeMatches(ctx, cursor)
+}
+
+// SimilarUsers looks users up by the search_trigrams index. Sharing
+// trigrams is necessary but not sufficient for a user to match, so callers
+// should rescore the candidates.
+func (u UserRepository) SimilarUsers(trigrams []string, limit int) ([]interfaces.UserMatch, error) {
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
+
+	defer cancel()
+
+	filter := bson.D{{Key: "search_trigrams", Value: bson.D{{Key: "$in", Value: trigrams}}}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: u.scoped(notDeleted(filter))}},
+		{{Key: "$addFields", Value: bson.D{{Key: "search_score", Value: bson.D{
+			{Key: "$size", Value: bson.D{{Key: "$setIntersection", Value: bson.A{"$search_trigrams", trigrams}}}},
+		}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "search_score", Value: -1}, {Key: "uuid", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return decodeMatches(ctx, cursor)
+}
+
+func decodeMatches(ctx context.Context, cursor *mongo.Cursor) ([]interfaces.UserMatch, error) {
+	var users []scoredUser
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, mapError(err)
+	}
+
+	matches := make([]interfaces.UserMatch, 0, len(users))
+	for _, user := range users {
+		matches = append(matches, interfaces.UserMatch{User: user.User, Score: user.Score})
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const DEFAULT_DATABASE = "user_service"
+const DEFAULT_USERS_COLLECTION = "users"
+const DEFAULT_OUTBOX_COLLECTION = "outbox"
+const DEFAULT_TOKENS_COLLECTION = "tokens"
+const DEFAULT_TENANTS_COLLECTION = "tenants"
+
+// Storage locates the collections the repositories use and bounds their
+// operations. Empty names and a zero timeout fall back to the defaults, so
+// several environments can share a cluster by using different databases.
+type Storage struct {
+	Database string
+	Users    string
+	Outbox   string
+	Tokens   string
+	Tenants  string
+	// Timeout bounds each operation outside a transaction, DB_TIMEOUT
+	// seconds when zero.
+	Timeout time.Duration
+}
+
+// DatabaseName returns the database name, with the default applied.
+func (s Storage) DatabaseName() string {
+	return orDefault(s.Database, DEFAULT_DATABASE)
+}
+
+// UsersName returns the users collection name, with the default applied.
+func (s Storage) UsersName() string {
+	return orDefault(s.Users, DEFAULT_USERS_COLLECTION)
+}
+
+func (s Storage) database(client *mongo.Client) *mongo.Database {
+	return client.Database(s.DatabaseName())
+}
+
+func (s Storage) users(client *mongo.Client) *mongo.Collection {
+	return s.database(client).Collection(s.UsersName())
+}
+
+func (s Storage) outbox(client *mongo.Client) *mongo.Collection {
+	return s.database(client).Collection(orDefault(s.Outbox, DEFAULT_OUTBOX_COLLECTION))
+}
+
+func (s Storage) tokens(client *mongo.Client) *mongo.Collection {
+	return s.database(client).Collection(orDefault(s.Tokens, DEFAULT_TOKENS_COLLECTION))
+}
+
+func (s Storage) tenants(client *mongo.Client) *mongo.Collection {
+	return s.database(client).Collection(orDefault(s.Tenants, DEFAULT_TENANTS_COLLECTION))
+}
+
+// context returns the context for a single operation derived from parent.
+func (s Storage) context(parent context.Context) (context.Context, context.CancelFunc) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DB_TIMEOUT * time.Second
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+func orDefault(name, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+	return name
+}
@@ -0,0 +1,102 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TenantRepository struct {
+	Client  *mongo.Client
+	Storage Storage
+}
+
+func (t TenantRepository) GetTenant(id string) (models.Tenant, error) {
+	return t.getTenantByFilter(bson.D{{Key: "_id", Value: id}})
+}
+
+func (t TenantRepository) GetTenantByAPIKeyHash(hash string) (models.Tenant, error) {
+	return t.getTenantByFilter(bson.D{{Key: "api_key_hash", Value: hash}})
+}
+
+func (t TenantRepository) getTenantByFilter(filter bson.D) (models.Tenant, error) {
+	collection := t.Storage.tenants(t.Client)
+	ctx, cancel := t.Storage.context(context.Background())
+
+	defer cancel()
+
+	var tenant models.Tenant
+
+	err := collection.FindOne(ctx, filter).Decode(&tenant)
+
+	return tenant, mapError(err)
+}
+
+// ListTenants returns every tenant, ordered by ID.
+func (t TenantRepository) ListTenants() ([]models.Tenant, error) {
+	collection := t.Storage.tenants(t.Client)
+	ctx, cancel := t.Storage.context(context.Background())
+
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	tenants := []models.Tenant{}
+	err = cursor.All(ctx, &tenants)
+
+	return tenants, mapError(err)
+}
+
+func (t TenantRepository) CreateTenant(tenant models.Tenant) (models.Tenant, error) {
+	collection := t.Storage.tenants(t.Client)
+	ctx, cancel := t.Storage.context(context.Background())
+
+	defer cancel()
+
+	now := time.Now()
+	tenant.Created = now
+	tenant.Updated = now
+
+	_, err := collection.InsertOne(ctx, tenant)
+
+	// mapError reports duplicate keys as taken emails, which only holds for
+	// the users collection.
+	if mongo.IsDuplicateKeyError(err) {
+		return tenant, fmt.Errorf("%w: %w", interfaces.ErrConflict, err)
+	}
+
+	return tenant, mapError(err)
+}
+
+func (t TenantRepository) UpdateTenant(tenant models.Tenant) (models.Tenant, error) {
+	collection := t.Storage.tenants(t.Client)
+	ctx, cancel := t.Storage.context(context.Background())
+
+	defer cancel()
+
+	tenant.Updated = time.Now()
+
+	res, err := collection.ReplaceOne(ctx, bson.D{{Key: "_id", Value: tenant.ID}}, tenant)
+	if mongo.IsDuplicateKeyError(err) {
+		return tenant, fmt.Errorf("%w: %w", interfaces.ErrConflict, err)
+	}
+	if err != nil {
+		return tenant, mapError(err)
+	}
+
+	if res.MatchedCount == 0 {
+		return tenant, interfaces.ErrNotFound
+	}
+
+	return tenant, nil
+}
@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+)
+
+// TRANSACTION_ATTEMPTS bounds how many times a transaction, or its commit, is
+// retried after an error the server labels as safe to retry.
+const TRANSACTION_ATTEMPTS = 3
+
+const TRANSACTION_TIMEOUT = 30 * time.Second
+
+// WithTransaction runs fn in a multi-document transaction. The repository
+// passed to fn performs its operations inside the transaction, which commits
+// if fn returns nil and aborts otherwise. fn may be called again when the
+// transaction fails with a transient error, so it must not have effects
+// outside the repository.
+//
+// Called on a repository that is already inside a transaction, fn joins it.
+func (u UserRepository) WithTransaction(fn func(interfaces.UserRepository) error) error {
+	if u.session != nil {
+		return fn(u)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), TRANSACTION_TIMEOUT)
+	defer cancel()
+
+	session, err := u.Client.StartSession()
+	if err != nil {
+		return mapError(err)
+	}
+	defer session.EndSession(ctx)
+
+	for attempt := 1; ; attempt++ {
+		err = mongo.WithSession(ctx, session, func(sc mongo.SessionContext) error {
+			if err := sc.StartTransaction(); err != nil {
+				return err
+			}
+
+			tx := u
+			tx.session = sc
+
+			if err := fn(tx); err != nil {
+				// The transaction context may already be done, which
+				// would leave the transaction open until it times out.
+				session.AbortTransaction(context.Background())
+				return err
+			}
+
+			return commit(sc)
+		})
+
+		if err == nil || attempt == TRANSACTION_ATTEMPTS || !hasErrorLabel(err, driver.TransientTransactionError) {
+			return mapError(err)
+		}
+	}
+}
+
+// commit commits the transaction in sc, retrying while the outcome of the
+// commit is unknown.
+func commit(sc mongo.SessionContext) error {
+	var err error
+	for attempt := 1; attempt <= TRANSACTION_ATTEMPTS; attempt++ {
+		err = sc.CommitTransaction(sc)
+		if err == nil || !hasErrorLabel(err, driver.UnknownTransactionCommitResult) {
+			return err
+		}
+	}
+	return err
+}
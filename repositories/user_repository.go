@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/ffardo/user-crud/interfaces"
 	"github.com/ffardo/user-crud/models"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,25 +16,90 @@ import (
 const DB_TIMEOUT = 5
 
 type UserRepository struct {
-	Client *mongo.Client
+	Client  *mongo.Client
+	Storage Storage
+	// session is set on the repository handed to a WithTransaction callback,
+	// so its operations run inside that transaction.
+	session mongo.SessionContext
+	// tenant restricts every query to one tenant's users when set. Only
+	// background jobs, such as purging, use an unrestricted repository.
+	tenant *string
+}
+
+// ForTenant returns a copy of the repository restricted to the users of
+// tenantID.
+func (u UserRepository) ForTenant(tenantID string) interfaces.UserRepository {
+	u.tenant = &tenantID
+	return u
+}
+
+// scoped restricts filter to the repository's tenant, if any. Users stored
+// before tenants existed have no tenant_id and belong to the default tenant.
+func (u UserRepository) scoped(filter primitive.D) primitive.D {
+	if u.tenant == nil {
+		return filter
+	}
+
+	if *u.tenant == "" {
+		return append(filter, bson.E{Key: "tenant_id", Value: bson.D{{Key: "$in", Value: bson.A{"", nil}}}})
+	}
+
+	return append(filter, bson.E{Key: "tenant_id", Value: *u.tenant})
+}
+
+// context returns the context for a single database operation, bound to the
+// current transaction if there is one.
+func (u UserRepository) context() (context.Context, context.CancelFunc) {
+	if u.session != nil {
+		return u.Storage.context(u.session)
+	}
+	return u.Storage.context(context.Background())
 }
 
 func (u UserRepository) CreateUser(user models.User) (models.User, error) {
-	collection := u.Client.Database("user_service").Collection("users")
+	collection := u.Storage.users(u.Client)
 
-	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT*time.Second)
+	ctx, cancel := u.context()
 	defer cancel()
 
-	now := time.Now()
-	user.UUID = uuid.New()
-	user.Created = now
-	user.Updated = now
+	user = u.created(user, time.Now())
 
 	doc, err := bson.Marshal(user)
+	if err != nil {
+		return user, err
+	}
 
 	_, err = collection.InsertOne(ctx, doc)
 
-	return user, err
+	return user, mapError(err)
+}
+
+// created returns user as CreateUser stores it, with a new UUID unless the
+// caller chose one.
+func (u UserRepository) created(user models.User, now time.Time) models.User {
+	if user.UUID == uuid.Nil {
+		user.UUID = uuid.New()
+	}
+	user.Created = now
+	user.Updated = now
+	user.Version = 1
+	user.SchemaVersion = models.USER_SCHEMA_VERSION
+	if u.tenant != nil {
+		user.TenantID = *u.tenant
+	}
+	return user
+}
+
+// updated returns user as UpdateUser stores it. The schema version is kept
+// as read: a document not yet migrated still lacks what the migrations
+// above it would have added, and must stay pending for them.
+func (u UserRepository) updated(user models.User, now time.Time) models.User {
+	user.Updated = now
+	user.Version++
+	if u.tenant != nil {
+		user.TenantID = *u.tenant
+	}
+	return user
 }
 
 func (u UserRepository) GetUserByUUID(user_uuid uuid.UUID) (models.User, error) {
@@ -42,21 +108,27 @@ func (u UserRepository) GetUserByUUID(user_uuid uuid.UUID) (models.User, error)
 
 }
 
+// notDeleted restricts filter to users that have not been soft deleted.
+func notDeleted(filter primitive.D) primitive.D {
+	return append(filter, bson.E{Key: "deleted", Value: nil})
+}
+
 func (u UserRepository) getUserByFilter(filter primitive.D) (models.User, error) {
-	collection := u.Client.Database("user_service").Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT*time.Second)
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
 
 	defer cancel()
 
 	var user models.User
 
-	err := collection.FindOne(ctx, filter).Decode(&user)
+	err := collection.FindOne(ctx, u.scoped(notDeleted(filter))).Decode(&user)
 
-	return user, err
+	return user, mapError(err)
 }
 
+// UserExistsWithEmail reports whether an active user has the canonical email.
 func (u UserRepository) UserExistsWithEmail(email string) (bool, error) {
-	filter := bson.D{{Key: "email", Value: email}}
+	filter := bson.D{{Key: "email_canonical", Value: email}}
 	return u.userExistsByFilter(filter)
 }
 
@@ -65,7 +137,7 @@ func (u UserRepository) UserExistsWithEmailAndNotUuid(email string, uuid uuid.UU
 	filter := bson.D{
 		{Key: "$and",
 			Value: bson.A{
-				bson.D{{Key: "email", Value: email}},
+				bson.D{{Key: "email_canonical", Value: email}},
 				bson.D{{Key: "uuid", Value: bson.D{{Key: "$ne", Value: uuid}}}},
 			},
 		},
@@ -76,57 +148,155 @@ func (u UserRepository) UserExistsWithEmailAndNotUuid(email string, uuid uuid.UU
 
 func (u UserRepository) userExistsByFilter(filter primitive.D) (bool, error) {
 
-	collection := u.Client.Database("user_service").Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT*time.Second)
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
 
 	defer cancel()
 
-	total, err := collection.CountDocuments(ctx, filter)
+	total, err := collection.CountDocuments(ctx, u.scoped(notDeleted(filter)))
 
-	return total > 0, err
+	return total > 0, mapError(err)
 }
 
+// UpdateUser replaces the stored document only if it still has user.Version,
+// and stores it with the version incremented.
 func (u UserRepository) UpdateUser(user models.User) (models.User, error) {
-	collection := u.Client.Database("user_service").Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT*time.Second)
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
 
 	defer cancel()
-	filter := bson.D{{Key: "uuid", Value: user.UUID}}
+	filter := u.scoped(notDeleted(bson.D{
+		{Key: "uuid", Value: user.UUID},
+		{Key: "version", Value: versionFilter(user.Version)},
+	}))
 
-	user.Updated = time.Now()
+	user = u.updated(user, time.Now())
 
 	doc, err := bson.Marshal(user)
+	if err != nil {
+		return user, err
+	}
+
+	res, err := collection.ReplaceOne(ctx, filter, doc)
+	if err != nil {
+		return user, mapError(err)
+	}
+
+	if res.MatchedCount == 0 {
+		exists, err := u.userExistsByFilter(bson.D{{Key: "uuid", Value: user.UUID}})
+		if err != nil {
+			return user, err
+		}
+		if exists {
+			return user, interfaces.ErrVersionConflict
+		}
+		return user, interfaces.ErrNotFound
+	}
+
+	return user, nil
+}
 
-	_, err = collection.ReplaceOne(ctx, filter, doc)
-	return user, err
+// versionFilter matches version. Documents written before versioning have no
+// version field and are treated as version 0.
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.D{{Key: "$in", Value: bson.A{0, nil}}}
+	}
+	return version
 }
 
+// DeleteUser soft deletes the user. It stays in the collection, hidden from
+// every other query, until RestoreUser brings it back or PurgeDeletedUsers
+// removes it.
 func (u UserRepository) DeleteUser(user_uuid uuid.UUID) error {
-	collection := u.Client.Database("user_service").Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT*time.Second)
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
 
 	defer cancel()
-	filter := bson.D{{Key: "uuid", Value: user_uuid}}
+	filter := u.scoped(notDeleted(bson.D{{Key: "uuid", Value: user_uuid}}))
 
-	_, err := collection.DeleteOne(ctx, filter)
-	return err
+	now := time.Now()
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "deleted", Value: now},
+			{Key: "updated", Value: now},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return mapError(err)
+	}
+
+	if res.MatchedCount == 0 {
+		return interfaces.ErrNotFound
+	}
+
+	return nil
 }
 
-func (u UserRepository) Init() error {
-	collection := u.Client.Database("user_service").Collection("users")
+func (u UserRepository) RestoreUser(user_uuid uuid.UUID) (models.User, error) {
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
 
-	ctx, cancel := context.WithTimeout(context.Background(), DB_TIMEOUT*time.Second)
+	defer cancel()
+	filter := u.scoped(bson.D{
+		{Key: "uuid", Value: user_uuid},
+		{Key: "deleted", Value: bson.D{{Key: "$ne", Value: nil}}},
+	})
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "deleted", Value: nil},
+			{Key: "updated", Value: time.Now()},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var user models.User
+
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+
+	return user, mapError(err)
+}
+
+// ListDeletedUsers returns every soft deleted user, most recently deleted
+// first.
+func (u UserRepository) ListDeletedUsers() ([]models.User, error) {
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
 
 	defer cancel()
+	filter := u.scoped(bson.D{{Key: "deleted", Value: bson.D{{Key: "$ne", Value: nil}}}})
+	opts := options.Find().SetSort(bson.D{{Key: "deleted", Value: -1}})
 
-	index := mongo.IndexModel{
-		Keys: bson.M{
-			"email": 1, // index in ascending order
-		}, Options: options.Index().SetUnique(true),
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, mapError(err)
 	}
 
-	_, err := collection.Indexes().CreateOne(ctx, index)
+	users := []models.User{}
+	err = cursor.All(ctx, &users)
+
+	return users, mapError(err)
+}
+
+// PurgeDeletedUsers permanently removes users soft deleted before the given
+// time and returns how many were removed.
+func (u UserRepository) PurgeDeletedUsers(before time.Time) (int64, error) {
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
 
-	return err
+	defer cancel()
+	filter := u.scoped(bson.D{{Key: "deleted", Value: bson.D{{Key: "$lt", Value: before}}}})
+
+	res, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, mapError(err)
+	}
 
+	return res.DeletedCount, nil
 }
@@ -0,0 +1,32 @@
+package repositories
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ffardo/user-crud/models"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestUpdatedKeepsSchemaVersion(t *testing.T) {
+	now := time.Now()
+
+	updated := UserRepository{}.updated(models.User{Version: 2, SchemaVersion: 1}, now)
+
+	assert.Equal(t, 1, updated.SchemaVersion)
+	assert.Equal(t, int64(3), updated.Version)
+	assert.Equal(t, now, updated.Updated)
+
+	created := UserRepository{}.created(models.User{}, now)
+
+	assert.Equal(t, models.USER_SCHEMA_VERSION, created.SchemaVersion)
+}
+
+func TestEmptyCanonicalEmailIsNotStored(t *testing.T) {
+	doc, err := bson.Marshal(models.User{Email: "not an email"})
+	assert.NoError(t, err)
+
+	_, err = bson.Raw(doc).LookupErr("email_canonical")
+	assert.Error(t, err)
+}
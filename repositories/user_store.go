@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"strings"
+
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (u UserRepository) LoadUser(user_uuid uuid.UUID) (models.User, error) {
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
+
+	defer cancel()
+
+	var user models.User
+
+	err := collection.FindOne(ctx, u.scoped(bson.D{{Key: "uuid", Value: user_uuid}})).Decode(&user)
+
+	return user, mapError(err)
+}
+
+// SaveUser stores the user as given, ignoring the repository's tenant. A
+// stored copy with a newer version is kept. The copy is replaced when at an
+// older version, and the user is inserted only when no copy is stored, so
+// existing users are never duplicated even without the uuid index. Only two
+// concurrent saves of a user not stored yet rely on the index, which keeps
+// the first one.
+func (u UserRepository) SaveUser(user models.User) error {
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
+
+	defer cancel()
+	filter := bson.D{
+		{Key: "uuid", Value: user.UUID},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "version", Value: bson.D{{Key: "$lte", Value: user.Version}}}},
+			bson.D{{Key: "version", Value: nil}},
+		}},
+	}
+
+	doc, err := bson.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	res, err := collection.ReplaceOne(ctx, filter, doc)
+	if err != nil || res.MatchedCount > 0 {
+		return mapError(err)
+	}
+
+	stored, err := collection.CountDocuments(ctx, bson.D{{Key: "uuid", Value: user.UUID}}, options.Count().SetLimit(1))
+	if err != nil || stored > 0 {
+		return mapError(err)
+	}
+
+	_, err = collection.InsertOne(ctx, doc)
+
+	if mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "index: "+UUID_INDEX) {
+		return nil
+	}
+
+	return mapError(err)
+}
+
+// ScanUsers skips users with the nil UUID, which are invalid and cannot be
+// told apart.
+func (u UserRepository) ScanUsers(after uuid.UUID, limit int) ([]models.User, error) {
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
+
+	defer cancel()
+	filter := u.scoped(bson.D{{Key: "uuid", Value: bson.D{{Key: "$gt", Value: after}}}})
+	opts := options.Find().
+		SetSort(bson.D{{Key: "uuid", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	users := []models.User{}
+	err = cursor.All(ctx, &users)
+
+	return users, mapError(err)
+}
@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WriteUsers sends the writes as a single ordered bulk write. Stored
+// documents are built as CreateUser, UpdateUser and DeleteUser build them.
+func (u UserRepository) WriteUsers(writes []interfaces.UserWrite) ([]models.User, error) {
+	collection := u.Storage.users(u.Client)
+	ctx, cancel := u.context()
+
+	defer cancel()
+
+	now := time.Now()
+	users := make([]models.User, 0, len(writes))
+	operations := make([]mongo.WriteModel, 0, len(writes))
+	matched := int64(0)
+
+	for i, write := range writes {
+		user := write.User
+
+		filter := u.scoped(notDeleted(bson.D{
+			{Key: "uuid", Value: user.UUID},
+			{Key: "version", Value: versionFilter(user.Version)},
+		}))
+
+		switch write.Kind {
+		case interfaces.WRITE_CREATE:
+			user = u.created(user, now)
+			operations = append(operations, mongo.NewInsertOneModel().SetDocument(user))
+		case interfaces.WRITE_UPDATE:
+			user = u.updated(user, now)
+			operations = append(operations, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(user))
+			matched++
+		case interfaces.WRITE_DELETE:
+			user.Deleted = &now
+			user.Updated = now
+			user.Version++
+			operations = append(operations, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.D{
+				{Key: "$set", Value: bson.D{
+					{Key: "deleted", Value: now},
+					{Key: "updated", Value: now},
+				}},
+				{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+			}))
+			matched++
+		default:
+			return users, interfaces.UserWriteError{Index: i, Err: fmt.Errorf("unknown write kind %q", write.Kind)}
+		}
+
+		users = append(users, user)
+	}
+
+	if len(operations) == 0 {
+		return users, nil
+	}
+
+	res, err := collection.BulkWrite(ctx, operations, options.BulkWrite().SetOrdered(true))
+
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && len(bwe.WriteErrors) > 0 {
+		return users, interfaces.UserWriteError{Index: bwe.WriteErrors[0].Index, Err: mapError(err)}
+	}
+	if err != nil {
+		return users, mapError(err)
+	}
+
+	if res.MatchedCount < matched {
+		return users, fmt.Errorf("%w: %d of %d users not at their version", interfaces.ErrVersionConflict, matched-res.MatchedCount, matched)
+	}
+
+	return users, nil
+}
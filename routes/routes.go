@@ -1,24 +1,290 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/problems"
+	"github.com/ffardo/user-crud/services"
 	"github.com/gin-gonic/gin"
 )
 
-func InitRouter(uc interfaces.UserController, apiKey string) *gin.Engine {
+// InitRouter registers the user endpoints. Failures, including panics and
+// unknown routes, are answered with problem details. auth, such as TenantAuth,
+// authenticates each request and picks its tenant. middleware runs before
+// it, so checks such as RequireReady spare auth from looking up tenants
+// while Mongo is unavailable.
+//
+// The endpoints are served in each API version under /api/v1/users and
+// /api/v2/users, and under /api/users in the version asked for in Accept,
+// v1 by default.
+func InitRouter(uc interfaces.UserController, auth gin.HandlerFunc, middleware ...gin.HandlerFunc) *gin.Engine {
 
-	r := gin.Default()
-	g := r.Group("/api/users", func(ctx *gin.Context) {
-		if ctx.Request.Header.Get("X-API-KEY") != apiKey {
-			ctx.AbortWithStatus(http.StatusUnauthorized)
-		}
+	r := gin.New()
+	r.Use(gin.Logger(), problems.Recovery(), problems.Trace())
+	r.NoRoute(func(ctx *gin.Context) {
+		problems.AbortWithStatus(ctx, http.StatusNotFound)
 	})
-	g.POST("/", uc.Post)
-	g.GET("/:uuid", uc.Get)
-	g.PATCH("/:uuid", uc.Patch)
-	g.DELETE("/:uuid", uc.Delete)
+
+	both := []string{interfaces.API_V1, interfaces.API_V2}
+	// Batches, imports and exports keep the v1 shape of users, and are not
+	// served in v2 yet.
+	v1 := []string{interfaces.API_V1}
+
+	endpoints := []struct {
+		method   string
+		path     string
+		handler  gin.HandlerFunc
+		versions []string
+	}{
+		{http.MethodPost, "/", uc.Post, both},
+		// Listing is served with and without the trailing slash, as clients
+		// commonly query the collection by its bare name.
+		{http.MethodGet, "", uc.List, both},
+		{http.MethodGet, "/", uc.List, both},
+		{http.MethodGet, "/search", uc.Search, both},
+		{http.MethodPost, "/import", uc.Import, v1},
+		{http.MethodGet, "/export", uc.Export, v1},
+		{http.MethodGet, "/:uuid", uc.Get, both},
+		{http.MethodPut, "/:uuid", uc.Put, both},
+		{http.MethodPatch, "/:uuid", uc.Patch, both},
+		{http.MethodDelete, "/:uuid", uc.Delete, both},
+		{http.MethodPost, "/:uuid/restore", uc.Restore, both},
+	}
+
+	unversioned := r.Group("/api/users", middleware...)
+	unversioned.Use(auth)
+
+	versioned := map[string]*gin.RouterGroup{}
+	for _, v := range both {
+		versioned[v] = r.Group("/api/"+v+"/users", middleware...)
+		versioned[v].Use(auth)
+	}
+
+	for _, e := range endpoints {
+		unversioned.Handle(e.method, e.path, negotiateVersion(e.versions...), e.handler)
+		for _, v := range e.versions {
+			versioned[v].Handle(e.method, e.path, useVersion(v), e.handler)
+		}
+	}
+
+	// Custom methods follow the collection after a colon, as in
+	// /api/users:batch. gin reads the colon as the start of a parameter,
+	// whose value is then the colon and the method name.
+	methods := map[string]gin.HandlerFunc{
+		":batch": uc.Batch,
+	}
+	customMethodHandlers := func(version gin.HandlerFunc) []gin.HandlerFunc {
+		handlers := append([]gin.HandlerFunc{}, middleware...)
+		return append(handlers, auth, version, customMethod(methods))
+	}
+	r.POST("/api/users:method", customMethodHandlers(negotiateVersion(v1...))...)
+	r.POST("/api/v1/users:method", customMethodHandlers(useVersion(interfaces.API_V1))...)
 
 	return r
 }
+
+// VERSION_MEDIA_TYPE_PREFIX starts the media types asking for an API
+// version in Accept, followed by the version and +json, as in
+// application/vnd.user-crud.v2+json.
+const VERSION_MEDIA_TYPE_PREFIX = "application/vnd.user-crud."
+
+// V1_DEPRECATED and V1_SUNSET are when v1 was deprecated and when it will
+// stop being served, sent in the Deprecation and Sunset headers of its
+// responses.
+var V1_DEPRECATED = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+var V1_SUNSET = time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC)
+
+func versionMediaType(version string) string {
+	return VERSION_MEDIA_TYPE_PREFIX + version + "+json"
+}
+
+// useVersion serves the request in version, announcing when it is
+// deprecated.
+func useVersion(version string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(interfaces.API_VERSION_CONTEXT_KEY, version)
+
+		if version == interfaces.API_V1 {
+			// Deprecation is an RFC 9745 date, in seconds since the epoch.
+			ctx.Header("Deprecation", "@"+strconv.FormatInt(V1_DEPRECATED.Unix(), 10))
+			ctx.Header("Sunset", V1_SUNSET.Format(http.TimeFormat))
+		}
+	}
+}
+
+// negotiateVersion serves the request in the first of versions asked for
+// in Accept, responding with its media type, or in the first of versions
+// when Accept asks for none. Accept asking only for other versions gets
+// 406 Not Acceptable.
+func negotiateVersion(versions ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("Vary", "Accept")
+
+		accepted := acceptedVersions(ctx.GetHeader("Accept"))
+
+		if len(accepted) == 0 {
+			useVersion(versions[0])(ctx)
+			return
+		}
+
+		for _, a := range accepted {
+			for _, v := range versions {
+				if a == v {
+					ctx.Header("Content-Type", versionMediaType(v))
+					useVersion(v)(ctx)
+					return
+				}
+			}
+		}
+
+		problems.Abort(ctx, problems.Problem{
+			Type:   problems.Type("unsupported-version"),
+			Title:  "Unsupported API version",
+			Status: http.StatusNotAcceptable,
+			Detail: "Served in " + strings.Join(versions, ", "),
+		})
+	}
+}
+
+// acceptedVersions returns the API versions an Accept header asks for, in
+// its order. Quality values are not taken into account.
+func acceptedVersions(accept string) []string {
+	var versions []string
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(mediaRange, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+		if v, ok := strings.CutPrefix(mediaType, VERSION_MEDIA_TYPE_PREFIX); ok {
+			if v, ok = strings.CutSuffix(v, "+json"); ok {
+				versions = append(versions, v)
+			}
+		}
+	}
+
+	return versions
+}
+
+// customMethod dispatches to the handler of the custom method named by the
+// method parameter, responding 404 to unknown ones.
+func customMethod(methods map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		handler, ok := methods[ctx.Param("method")]
+		if !ok {
+			problems.AbortWithStatus(ctx, http.StatusNotFound)
+			return
+		}
+		handler(ctx)
+	}
+}
+
+// InitAdminRoutes registers the administration endpoints on r, guarded by
+// adminKey. Nothing is registered when adminKey is empty.
+func InitAdminRoutes(r *gin.Engine, uc interfaces.UserController, adminKey string, middleware ...gin.HandlerFunc) {
+	if adminKey == "" {
+		return
+	}
+
+	g := r.Group("/api/admin", adminAuth(adminKey))
+	g.Use(middleware...)
+	g.GET("/users/deleted", uc.ListDeleted)
+}
+
+// InitTenantAdminRoutes registers the tenant administration endpoints on r,
+// guarded by adminKey. Nothing is registered when adminKey is empty.
+func InitTenantAdminRoutes(r *gin.Engine, tc interfaces.TenantController, adminKey string, middleware ...gin.HandlerFunc) {
+	if adminKey == "" {
+		return
+	}
+
+	g := r.Group("/api/admin/tenants", adminAuth(adminKey))
+	g.Use(middleware...)
+	g.POST("/", tc.Post)
+	g.GET("/", tc.List)
+	g.GET("/:id", tc.Get)
+	g.PATCH("/:id", tc.Patch)
+	g.POST("/:id/rotate-key", tc.RotateKey)
+}
+
+func adminAuth(adminKey string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.Header.Get("X-ADMIN-KEY") != adminKey {
+			problems.AbortWithStatus(ctx, http.StatusUnauthorized)
+		}
+	}
+}
+
+// TenantAuth accepts either the service API key or a tenant's own key in
+// X-API-KEY, and stores the tenant the request acts for under
+// interfaces.TENANT_CONTEXT_KEY. With the service key, that is the tenant
+// named in X-TENANT-ID, or the default tenant without one. tenants may be
+// nil when only the default tenant exists; X-TENANT-ID is then refused.
+func TenantAuth(apiKey string, tenants interfaces.TenantService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.Request.Header.Get("X-API-KEY")
+		id := ctx.Request.Header.Get("X-TENANT-ID")
+
+		var tenant models.Tenant
+		var err error
+
+		switch {
+		case key == apiKey && id == "":
+		case key == apiKey && tenants != nil:
+			tenant, err = tenants.GetTenant(id)
+		case key == apiKey:
+			err = services.ErrTenantNotFound
+		case tenants != nil:
+			tenant, err = tenants.Authenticate(key)
+			if err == nil && id != "" && id != tenant.ID {
+				err = services.ErrInvalidAPIKey
+			}
+		default:
+			err = services.ErrInvalidAPIKey
+		}
+
+		switch {
+		case errors.Is(err, services.ErrInvalidAPIKey):
+			problems.AbortWithStatus(ctx, http.StatusUnauthorized)
+		case errors.Is(err, services.ErrTenantNotFound), errors.Is(err, services.ErrInvalidTenantID):
+			problems.Abort(ctx, problems.Problem{Type: problems.Type("unknown-tenant"), Title: "Unknown tenant", Status: http.StatusUnauthorized})
+		case err != nil:
+			ctx.Error(err)
+			problems.AbortWithStatus(ctx, http.StatusServiceUnavailable)
+		case tenant.Settings.Disabled:
+			problems.Abort(ctx, problems.Problem{Type: problems.Type("tenant-disabled"), Title: "Tenant disabled", Status: http.StatusForbidden})
+		default:
+			ctx.Set(interfaces.TENANT_CONTEXT_KEY, tenant)
+		}
+	}
+}
+
+// InitHealthRoutes registers the liveness and readiness probes on r. They
+// need no API key.
+func InitHealthRoutes(r *gin.Engine, hc interfaces.HealthController) {
+	r.GET("/health/live", hc.Live)
+	r.GET("/health/ready", hc.Ready)
+}
+
+// InitDocsRoutes registers the OpenAPI document and the page rendering it
+// on r. They need no API key.
+func InitDocsRoutes(r *gin.Engine, dc interfaces.DocsController) {
+	r.GET("/openapi.json", dc.Spec)
+	r.GET("/docs", dc.UI)
+}
+
+// RequireReady answers 503 while ready reports false, so requests are not
+// served before the database is prepared.
+func RequireReady(ready func() bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !ready() {
+			ctx.Header("Retry-After", "5")
+			problems.AbortWithStatus(ctx, http.StatusServiceUnavailable)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Package search folds text for accent- and case-insensitive comparison and
+// scores approximate matches by trigram similarity.
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// THRESHOLD is the similarity above which a word is considered a match for a
+// search term despite typos.
+const THRESHOLD = 0.3
+
+// fold lowercases r and strips its accents.
+func fold(r rune) string {
+	var b strings.Builder
+	for _, d := range norm.NFD.String(string(r)) {
+		if unicode.Is(unicode.Mn, d) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(d))
+	}
+	return b.String()
+}
+
+// Fold returns s lowercased and without accents, so "José" and "jose"
+// compare equal.
+func Fold(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteString(fold(r))
+	}
+	return b.String()
+}
+
+// word is a word of a text, with its position in the text in bytes.
+type word struct {
+	folded     string
+	start, end int
+}
+
+func words(text string) []word {
+	var ws []word
+	start := -1
+	var folded strings.Builder
+
+	flush := func(end int) {
+		if start >= 0 {
+			ws = append(ws, word{folded: folded.String(), start: start, end: end})
+			start = -1
+			folded.Reset()
+		}
+	}
+
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			folded.WriteString(fold(r))
+		} else {
+			flush(i)
+		}
+	}
+	flush(len(text))
+
+	return ws
+}
+
+// Terms returns the folded words of a search query.
+func Terms(query string) []string {
+	var terms []string
+	for _, w := range words(query) {
+		terms = append(terms, w.folded)
+	}
+	return terms
+}
+
+// Trigrams returns the sorted, distinct trigrams of the folded words of the
+// texts. Words are padded as PostgreSQL's pg_trgm does, two spaces before
+// and one after, so short words and word starts weigh in.
+func Trigrams(texts ...string) []string {
+	set := map[string]bool{}
+	for _, text := range texts {
+		for _, w := range words(text) {
+			for _, t := range wordTrigrams(w.folded) {
+				set[t] = true
+			}
+		}
+	}
+
+	trigrams := make([]string, 0, len(set))
+	for t := range set {
+		trigrams = append(trigrams, t)
+	}
+	sort.Strings(trigrams)
+
+	return trigrams
+}
+
+func wordTrigrams(folded string) []string {
+	runes := []rune("  " + folded + " ")
+	trigrams := make([]string, 0, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		trigrams = append(trigrams, string(runes[i:i+3]))
+	}
+	return trigrams
+}
+
+// Similarity is the share of trigrams a and b have in common, from 0 for
+// none to 1 for the same trigrams. Both must be sorted.
+func Similarity(a, b []string) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+
+	common := 0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			common++
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// Matches reports whether a folded word matches a folded search term: it
+// contains the term, as when searching by part of a name, or is similar
+// enough to be the term with a typo.
+func Matches(word, term string) bool {
+	if strings.Contains(word, term) {
+		return true
+	}
+	return Similarity(Trigrams(word), Trigrams(term)) >= THRESHOLD
+}
+
+// Score rates how well the texts match the folded search terms, from 0 to
+// 1. Each term scores as its best matching word, 1 for a word containing it
+// and its similarity otherwise, and the terms' scores are averaged.
+func Score(terms []string, texts ...string) float64 {
+	if len(terms) == 0 {
+		return 0
+	}
+
+	var ws []string
+	for _, text := range texts {
+		for _, w := range words(text) {
+			ws = append(ws, w.folded)
+		}
+	}
+
+	total := 0.0
+	for _, term := range terms {
+		trigrams := Trigrams(term)
+		best := 0.0
+		for _, w := range ws {
+			if strings.Contains(w, term) {
+				best = 1
+				break
+			}
+			if s := Similarity(Trigrams(w), trigrams); s > best {
+				best = s
+			}
+		}
+		total += best
+	}
+
+	return total / float64(len(terms))
+}
+
+// Highlight returns text HTML escaped, with the words matching any of the
+// terms wrapped in <em> tags, and whether any did.
+func Highlight(text string, terms []string) (string, bool) {
+	var b strings.Builder
+	last := 0
+	found := false
+
+	for _, w := range words(text) {
+		for _, term := range terms {
+			if Matches(w.folded, term) {
+				b.WriteString(html.EscapeString(text[last:w.start]))
+				b.WriteString("<em>")
+				b.WriteString(html.EscapeString(text[w.start:w.end]))
+				b.WriteString("</em>")
+				last = w.end
+				found = true
+				break
+			}
+		}
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+
+	return b.String(), found
+}
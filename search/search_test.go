@@ -0,0 +1,51 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFold(t *testing.T) {
+	assert.Equal(t, "jose muller", Fold("José MÜLLER"))
+}
+
+func TestTerms(t *testing.T) {
+	assert.Equal(t, []string{"sao", "paulo", "42"}, Terms(" São-Paulo, 42 "))
+}
+
+func TestTrigrams(t *testing.T) {
+	assert.Equal(t, []string{"  j", " jo", "joe", "oe "}, Trigrams("Joe", "JOE"))
+}
+
+func TestSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, Similarity(Trigrams("john"), Trigrams("John")))
+	assert.Equal(t, 0.0, Similarity(Trigrams("john"), Trigrams("mary")))
+	assert.Greater(t, Similarity(Trigrams("john"), Trigrams("jhon")), 0.0)
+}
+
+func TestMatches(t *testing.T) {
+	assert.True(t, Matches("woodrow", "wood"))
+	assert.True(t, Matches("johnson", "jonson"))
+	assert.False(t, Matches("mary", "john"))
+}
+
+func TestHighlight(t *testing.T) {
+	highlighted, found := Highlight("José <Jr> Doe", []string{"jose"})
+
+	assert.True(t, found)
+	assert.Equal(t, "<em>José</em> &lt;Jr&gt; Doe", highlighted)
+
+	_, found = Highlight("Mary Smith", []string{"jose"})
+	assert.False(t, found)
+}
+
+func TestScore(t *testing.T) {
+	assert.Equal(t, 1.0, Score([]string{"jose"}, "José Doe", "Main St"))
+	assert.Equal(t, 0.5, Score([]string{"jose", "main"}, "José Doe"))
+	assert.Equal(t, 0.0, Score(nil, "José Doe"))
+
+	fuzzy := Score([]string{"jonson"}, "Mary Johnson")
+	assert.Greater(t, fuzzy, THRESHOLD)
+	assert.Less(t, fuzzy, 1.0)
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/ffardo/user-crud/models"
+)
+
+// ADDRESS_PREFIX starts the params setting a part of a structured address,
+// followed by the JSON name of the part, as in address.city.
+const ADDRESS_PREFIX = "address."
+
+// ADDRESS_FIELDS are the params setting the parts of a structured address.
+var ADDRESS_FIELDS = []string{
+	ADDRESS_PREFIX + "street",
+	ADDRESS_PREFIX + "city",
+	ADDRESS_PREFIX + "region",
+	ADDRESS_PREFIX + "postal_code",
+	ADDRESS_PREFIX + "country",
+}
+
+// resolveAddress sets the parts of current given in params. It returns
+// params with the address they make up as "address", replacing the parts
+// and any address sent on a single line, so it is validated as such an
+// address would be. The parts returned are nil when all empty, or when
+// params hold none, params then being returned unchanged.
+func resolveAddress(current models.Address, params map[string]string) (map[string]string, *models.Address) {
+	if !hasAddressParts(params) {
+		return params, nil
+	}
+
+	parts := map[string]*string{
+		ADDRESS_FIELDS[0]: &current.Street,
+		ADDRESS_FIELDS[1]: &current.City,
+		ADDRESS_FIELDS[2]: &current.Region,
+		ADDRESS_FIELDS[3]: &current.PostalCode,
+		ADDRESS_FIELDS[4]: &current.Country,
+	}
+
+	resolved := make(map[string]string, len(params))
+
+	for field, value := range params {
+		if part, isPart := parts[field]; isPart {
+			*part = strings.TrimSpace(value)
+			continue
+		}
+		resolved[field] = value
+	}
+
+	resolved["address"] = current.String()
+
+	if current == (models.Address{}) {
+		return resolved, nil
+	}
+	return resolved, &current
+}
+
+// hasAddressParts reports whether params set a part of the address.
+func hasAddressParts(params map[string]string) bool {
+	for _, field := range ADDRESS_FIELDS {
+		if _, ok := params[field]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestResolveAddress(t *testing.T) {
+	current := models.Address{Street: "3197 Woodrow Way", City: "Springfield"}
+
+	tests := []struct {
+		name    string
+		params  map[string]string
+		address string
+		parts   *models.Address
+	}{
+		{
+			name:    "no parts",
+			params:  map[string]string{"address": "742 Evergreen Terrace"},
+			address: "742 Evergreen Terrace",
+		},
+		{
+			name:    "some parts",
+			params:  map[string]string{"address.region": " IL ", "address.country": "US"},
+			address: "3197 Woodrow Way, Springfield, IL, US",
+			parts:   &models.Address{Street: "3197 Woodrow Way", City: "Springfield", Region: "IL", Country: "US"},
+		},
+		{
+			name:    "parts replace a single line",
+			params:  map[string]string{"address": "742 Evergreen Terrace", "address.city": "Shelbyville"},
+			address: "3197 Woodrow Way, Shelbyville",
+			parts:   &models.Address{Street: "3197 Woodrow Way", City: "Shelbyville"},
+		},
+		{
+			name:   "every part cleared",
+			params: map[string]string{"address.street": "", "address.city": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, parts := resolveAddress(current, tt.params)
+
+			assert.Equal(t, tt.address, params["address"])
+			assert.Equal(t, tt.parts, parts)
+			for _, field := range ADDRESS_FIELDS {
+				assert.NotContains(t, params, field)
+			}
+		})
+	}
+}
+
+func TestCreateUserWithAddressParts(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(false, nil)
+	userRepository.On("CreateUser", mock.Anything).Return(func(user models.User) models.User {
+		return user
+	}, nil)
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	user, err := userService.CreateUserWithParams(map[string]string{
+		"name":            "John Doe",
+		"birth_date":      "1970-01-31",
+		"email":           "joe25@mailprovider.com",
+		"password":        "secret",
+		"address.street":  "3197 Woodrow Way",
+		"address.city":    "Springfield",
+		"address.country": "US",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "3197 Woodrow Way, Springfield, US", user.Address)
+	assert.Equal(t, &models.Address{Street: "3197 Woodrow Way", City: "Springfield", Country: "US"}, user.AddressParts)
+}
+
+func TestUpdateUserAddressOnSingleLineClearsParts(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	current := models.User{
+		UUID:         user_uuid,
+		Name:         "John Doe",
+		Address:      "3197 Woodrow Way, Springfield",
+		AddressParts: &models.Address{Street: "3197 Woodrow Way", City: "Springfield"},
+	}
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(current, nil)
+	userRepository.On("UpdateUser", mock.Anything).Return(func(user models.User) models.User {
+		return user
+	}, nil)
+	userRepository.On("AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return assert.ObjectsAreEqual([]string{"address"}, e.Fields)
+	})).Return(nil)
+
+	user, err := userService.UpdateUser(user_uuid.String(), map[string]string{"address": "742 Evergreen Terrace"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "742 Evergreen Terrace", user.Address)
+	assert.Nil(t, user.AddressParts)
+}
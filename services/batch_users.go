@@ -0,0 +1,275 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidBatch = errors.New("Invalid batch")
+var ErrBatchTooLarge = errors.New("Batch too large")
+var ErrInvalidOperation = errors.New("Invalid batch operation")
+var ErrDuplicateOperation = errors.New("User appears more than once in batch")
+var ErrBatchAborted = errors.New("Batch aborted")
+
+// MAX_BATCH_SIZE bounds the operations of a batch, which are applied in a
+// single transaction.
+const MAX_BATCH_SIZE = 1000
+
+// errBatchFailed aborts the transaction of an atomic batch once one of its
+// operations failed validation.
+var errBatchFailed = errors.New("batch failed")
+
+// batchPlan holds the writes the pending operations of a batch resolved to.
+type batchPlan struct {
+	writes []interfaces.UserWrite
+	// operations holds, for each write, the index of its operation.
+	operations []int
+	// changes holds, for each write, the fields it changes.
+	changes [][]string
+}
+
+// BatchUsers validates every operation as CreateUser, UpdateUser and
+// DeleteUser do, then applies the valid ones in a single bulk write inside a
+// transaction, along with their events.
+//
+// When not atomic, operations failing validation are reported and the rest
+// applied. A write the database refuses, such as an email taken
+// concurrently, aborts the transaction; it is then reported and the
+// remaining operations are tried again.
+func (s UserService) BatchUsers(operations []interfaces.BatchOperation, atomic bool) ([]interfaces.BatchResult, error) {
+	if len(operations) == 0 {
+		return nil, ErrInvalidBatch
+	}
+	if len(operations) > MAX_BATCH_SIZE {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]interfaces.BatchResult, len(operations))
+
+	pending := make([]int, len(operations))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	for attempt := 0; len(pending) > 0; {
+		var plan batchPlan
+		var stored []models.User
+		var failed map[int]error
+
+		err := s.UserRepository.WithTransaction(func(tx interfaces.UserRepository) error {
+			failed = map[int]error{}
+
+			var err error
+			plan, err = s.planBatch(tx, operations, pending, results, failed, map[string]bool{})
+			if err != nil {
+				return err
+			}
+
+			if atomic && len(failed) > 0 {
+				return errBatchFailed
+			}
+
+			stored, err = tx.WriteUsers(plan.writes)
+
+			var we interfaces.UserWriteError
+			if errors.As(err, &we) {
+				failed[plan.operations[we.Index]] = translateError(we.Err)
+			}
+			if err != nil {
+				return err
+			}
+
+			for k, user := range stored {
+				if err := tx.AddEvent(s.batchEvent(plan.writes[k].Kind, user.UUID, plan.changes[k])); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		switch {
+		case err == nil:
+			for k, user := range stored {
+				results[plan.operations[k]] = interfaces.BatchResult{User: user}
+			}
+			for i, err := range failed {
+				results[i] = interfaces.BatchResult{Err: err}
+			}
+			return results, nil
+
+		case errors.Is(err, interfaces.ErrVersionConflict):
+			// A user changed between being read and written.
+			attempt++
+			if attempt == UPDATE_ATTEMPTS {
+				return nil, ErrConcurrentUpdate
+			}
+
+		case len(failed) > 0:
+			for i, err := range failed {
+				results[i] = interfaces.BatchResult{Err: err}
+			}
+
+			if atomic {
+				for i := range results {
+					if results[i].Err == nil {
+						results[i] = interfaces.BatchResult{Err: ErrBatchAborted}
+					}
+				}
+				return results, nil
+			}
+
+			remaining := []int{}
+			for _, i := range pending {
+				if _, ok := failed[i]; !ok {
+					remaining = append(remaining, i)
+				}
+			}
+			pending = remaining
+
+		default:
+			return nil, translateError(err)
+		}
+	}
+
+	return results, nil
+}
+
+// planBatch validates the pending operations. Those failing are added to
+// failed, and updates changing nothing get their result right away. Errors
+// not specific to an operation, such as storage failures, are returned.
+// emails holds the canonical emails already taken by earlier operations,
+// and receives the ones these take.
+func (s UserService) planBatch(tx interfaces.UserRepository, operations []interfaces.BatchOperation, pending []int, results []interfaces.BatchResult, failed map[int]error, emails map[string]bool) (batchPlan, error) {
+	plan := batchPlan{}
+	users := map[uuid.UUID]bool{}
+
+	for _, i := range pending {
+		write, changed, err := s.planOperation(tx, operations[i], users, emails)
+
+		switch {
+		case err != nil && !operationError(err):
+			return plan, err
+		case err != nil:
+			failed[i] = err
+		case write.Kind == "":
+			results[i] = interfaces.BatchResult{User: write.User}
+		default:
+			plan.writes = append(plan.writes, write)
+			plan.operations = append(plan.operations, i)
+			plan.changes = append(plan.changes, changed)
+		}
+	}
+
+	return plan, nil
+}
+
+// planOperation returns the write operation resolves to, with no kind when
+// it changes nothing, and the fields it changes. users and emails hold the
+// UUIDs and canonical emails taken by the operations planned before it.
+func (s UserService) planOperation(tx interfaces.UserRepository, operation interfaces.BatchOperation, users map[uuid.UUID]bool, emails map[string]bool) (interfaces.UserWrite, []string, error) {
+	params := operation.Params
+
+	if operation.Op == interfaces.BATCH_CREATE {
+		if operation.UUID != "" {
+			return interfaces.UserWrite{}, nil, ErrInvalidOperation
+		}
+
+		user, err := s.newUser(params)
+		if err != nil {
+			return interfaces.UserWrite{}, nil, err
+		}
+
+		if emails[user.EmailCanonical] {
+			return interfaces.UserWrite{}, nil, ErrEmailRegistered
+		}
+
+		exists, err := tx.UserExistsWithEmail(user.EmailCanonical)
+		if err != nil {
+			return interfaces.UserWrite{}, nil, err
+		}
+		if exists {
+			return interfaces.UserWrite{}, nil, ErrEmailRegistered
+		}
+
+		emails[user.EmailCanonical] = true
+
+		return interfaces.UserWrite{Kind: interfaces.WRITE_CREATE, User: user}, nil, nil
+	}
+
+	if operation.Op != interfaces.BATCH_UPDATE && operation.Op != interfaces.BATCH_DELETE {
+		return interfaces.UserWrite{}, nil, ErrInvalidOperation
+	}
+
+	u, err := uuid.Parse(operation.UUID)
+	if err != nil {
+		return interfaces.UserWrite{}, nil, ErrInvalidUuidFormat
+	}
+
+	if users[u] {
+		return interfaces.UserWrite{}, nil, ErrDuplicateOperation
+	}
+	users[u] = true
+
+	current, err := tx.GetUserByUUID(u)
+	if err != nil {
+		return interfaces.UserWrite{}, nil, translateError(err)
+	}
+
+	if operation.Op == interfaces.BATCH_DELETE {
+		return interfaces.UserWrite{Kind: interfaces.WRITE_DELETE, User: current}, nil, nil
+	}
+
+	updated, err := s.applyParams(tx, current, params)
+	if err != nil {
+		return interfaces.UserWrite{}, nil, err
+	}
+
+	if updated.EmailCanonical != current.EmailCanonical {
+		if emails[updated.EmailCanonical] {
+			return interfaces.UserWrite{}, nil, ErrEmailRegistered
+		}
+		emails[updated.EmailCanonical] = true
+	}
+
+	changed := changedFields(current, updated)
+	if len(changed) == 0 {
+		return interfaces.UserWrite{User: current}, nil, nil
+	}
+
+	return interfaces.UserWrite{Kind: interfaces.WRITE_UPDATE, User: updated}, changed, nil
+}
+
+// operationError reports whether err concerns a single operation of a
+// batch rather than the batch as a whole.
+func operationError(err error) bool {
+	for _, target := range []error{
+		ErrInvalidOperation,
+		ErrDuplicateOperation,
+		ErrInvalidUuidFormat,
+		ErrValidationFailed,
+		ErrInvalidDateFormat,
+		ErrInvalidEmailFormat,
+		ErrEmailDomainNotAllowed,
+		ErrEmailRegistered,
+		ErrUserNotFound,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s UserService) batchEvent(kind string, user_uuid uuid.UUID, changed []string) models.Event {
+	switch kind {
+	case interfaces.WRITE_CREATE:
+		return s.newEvent(models.EVENT_USER_CREATED, user_uuid, nil)
+	case interfaces.WRITE_DELETE:
+		return s.newEvent(models.EVENT_USER_DELETED, user_uuid, nil)
+	}
+	return s.newEvent(models.EVENT_USER_UPDATED, user_uuid, changed)
+}
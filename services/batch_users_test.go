@@ -0,0 +1,222 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func createOperation(email string) interfaces.BatchOperation {
+	return interfaces.BatchOperation{
+		Op: interfaces.BATCH_CREATE,
+		Params: map[string]string{
+			"name":       "John Doe",
+			"birth_date": "1970-01-31",
+			"email":      email,
+			"address":    "3197 Woodrow Way",
+			"password":   "secret",
+		},
+	}
+}
+
+// storeWrites returns the users of the writes as the repository would
+// store them.
+func storeWrites(writes []interfaces.UserWrite) []models.User {
+	users := []models.User{}
+	for _, w := range writes {
+		user := w.User
+		if w.Kind == interfaces.WRITE_CREATE {
+			user.UUID = uuid.New()
+		}
+		user.Version++
+		users = append(users, user)
+	}
+	return users
+}
+
+func TestBatchUsersAppliesEveryKind(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	updated := models.User{UUID: uuid.New(), Name: "John Doe", Version: 1}
+	deleted := models.User{UUID: uuid.New(), Name: "Mary Doe", Version: 3}
+
+	userRepository.On("UserExistsWithEmail", "joe@mail.com").Return(false, nil)
+	userRepository.On("GetUserByUUID", updated.UUID).Return(updated, nil)
+	userRepository.On("GetUserByUUID", deleted.UUID).Return(deleted, nil)
+	userRepository.On("WriteUsers", mock.MatchedBy(func(writes []interfaces.UserWrite) bool {
+		return len(writes) == 3 &&
+			writes[0].Kind == interfaces.WRITE_CREATE && writes[0].User.EmailCanonical == "joe@mail.com" &&
+			writes[1].Kind == interfaces.WRITE_UPDATE && writes[1].User.Name == "John Nobody" &&
+			writes[2].Kind == interfaces.WRITE_DELETE && writes[2].User.Version == 3
+	})).Return(storeWrites, nil)
+	userRepository.On("AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return e.Type == models.EVENT_USER_CREATED
+	})).Return(nil).Once()
+	userRepository.On("AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return e.Type == models.EVENT_USER_UPDATED && e.UserUUID == updated.UUID && assert.ObjectsAreEqual([]string{"name"}, e.Fields)
+	})).Return(nil).Once()
+	userRepository.On("AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return e.Type == models.EVENT_USER_DELETED && e.UserUUID == deleted.UUID
+	})).Return(nil).Once()
+
+	results, err := userService.BatchUsers([]interfaces.BatchOperation{
+		createOperation("joe@mail.com"),
+		{Op: interfaces.BATCH_UPDATE, UUID: updated.UUID.String(), Params: map[string]string{"name": "John Nobody"}},
+		{Op: interfaces.BATCH_DELETE, UUID: deleted.UUID.String()},
+	}, false)
+
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	for _, r := range results {
+		assert.NoError(t, r.Err)
+	}
+	assert.NotEqual(t, uuid.Nil, results[0].User.UUID)
+	assert.Equal(t, "John Nobody", results[1].User.Name)
+	userRepository.AssertExpectations(t)
+}
+
+func TestBatchUsersReportsInvalidOperations(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	missing := uuid.New()
+
+	userRepository.On("UserExistsWithEmail", "joe@mail.com").Return(false, nil)
+	userRepository.On("UserExistsWithEmail", "ann@mail.com").Return(true, nil)
+	userRepository.On("GetUserByUUID", missing).Return(models.User{}, interfaces.ErrNotFound)
+	userRepository.On("WriteUsers", mock.MatchedBy(func(writes []interfaces.UserWrite) bool {
+		return len(writes) == 1
+	})).Return(storeWrites, nil)
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	bad := createOperation("joe@mail.com")
+	bad.Params["birth_date"] = "31/01/1970"
+
+	results, err := userService.BatchUsers([]interfaces.BatchOperation{
+		createOperation("joe@mail.com"),
+		createOperation("Joe@Mail.com"),
+		createOperation("ann@mail.com"),
+		bad,
+		{Op: interfaces.BATCH_DELETE, UUID: missing.String()},
+		{Op: interfaces.BATCH_DELETE, UUID: missing.String()},
+		{Op: interfaces.BATCH_DELETE, UUID: "not a uuid"},
+		{Op: "upsert"},
+	}, false)
+
+	assert.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, ErrEmailRegistered)
+	assert.ErrorIs(t, results[2].Err, ErrEmailRegistered)
+	assert.ErrorIs(t, results[3].Err, ErrInvalidDateFormat)
+	assert.ErrorIs(t, results[4].Err, ErrUserNotFound)
+	assert.ErrorIs(t, results[5].Err, ErrDuplicateOperation)
+	assert.ErrorIs(t, results[6].Err, ErrInvalidUuidFormat)
+	assert.ErrorIs(t, results[7].Err, ErrInvalidOperation)
+}
+
+func TestBatchUsersAtomicAbortsOnInvalidOperation(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("UserExistsWithEmail", "joe@mail.com").Return(false, nil)
+
+	results, err := userService.BatchUsers([]interfaces.BatchOperation{
+		createOperation("joe@mail.com"),
+		createOperation("not an email"),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, ErrBatchAborted)
+	assert.ErrorIs(t, results[1].Err, ErrInvalidEmailFormat)
+	userRepository.AssertNotCalled(t, "WriteUsers", mock.Anything)
+}
+
+func TestBatchUsersRetriesAfterRefusedWrite(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("UserExistsWithEmail", mock.Anything).Return(false, nil)
+	userRepository.On("WriteUsers", mock.MatchedBy(func(writes []interfaces.UserWrite) bool {
+		return len(writes) == 2
+	})).Return(nil, interfaces.UserWriteError{Index: 0, Err: interfaces.ErrEmailRegistered}).Once()
+	userRepository.On("WriteUsers", mock.MatchedBy(func(writes []interfaces.UserWrite) bool {
+		return len(writes) == 1 && writes[0].User.Email == "ann@mail.com"
+	})).Return(storeWrites, nil).Once()
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	results, err := userService.BatchUsers([]interfaces.BatchOperation{
+		createOperation("joe@mail.com"),
+		createOperation("ann@mail.com"),
+	}, false)
+
+	assert.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, ErrEmailRegistered)
+	assert.NoError(t, results[1].Err)
+	assert.Equal(t, "ann@mail.com", results[1].User.Email)
+	userRepository.AssertExpectations(t)
+}
+
+func TestBatchUsersAtomicAbortsOnRefusedWrite(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("UserExistsWithEmail", mock.Anything).Return(false, nil)
+	userRepository.On("WriteUsers", mock.Anything).Return(nil, interfaces.UserWriteError{Index: 1, Err: interfaces.ErrEmailRegistered}).Once()
+
+	results, err := userService.BatchUsers([]interfaces.BatchOperation{
+		createOperation("joe@mail.com"),
+		createOperation("ann@mail.com"),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, ErrBatchAborted)
+	assert.ErrorIs(t, results[1].Err, ErrEmailRegistered)
+	userRepository.AssertExpectations(t)
+}
+
+func TestBatchUsersUnchangedUpdate(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	user := models.User{UUID: uuid.New(), Name: "John Doe", BirthDate: time.Date(1970, 1, 31, 0, 0, 0, 0, time.UTC)}
+
+	userRepository.On("GetUserByUUID", user.UUID).Return(user, nil)
+	userRepository.On("WriteUsers", []interfaces.UserWrite(nil)).Return([]models.User{}, nil)
+
+	results, err := userService.BatchUsers([]interfaces.BatchOperation{
+		{Op: interfaces.BATCH_UPDATE, UUID: user.UUID.String(), Params: map[string]string{"name": "John Doe"}},
+	}, false)
+
+	assert.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, user.UUID, results[0].User.UUID)
+	userRepository.AssertNotCalled(t, "AddEvent", mock.Anything)
+}
+
+func TestBatchUsersInvalid(t *testing.T) {
+	userService := UserService{UserRepository: new(mocks.UserRepository)}
+
+	_, err := userService.BatchUsers(nil, false)
+	assert.ErrorIs(t, err, ErrInvalidBatch)
+
+	_, err = userService.BatchUsers(make([]interfaces.BatchOperation, MAX_BATCH_SIZE+1), false)
+	assert.ErrorIs(t, err, ErrBatchTooLarge)
+}
+
+func TestBatchUsersStorageUnavailable(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("UserExistsWithEmail", "joe@mail.com").Return(false, interfaces.ErrUnavailable)
+
+	_, err := userService.BatchUsers([]interfaces.BatchOperation{createOperation("joe@mail.com")}, false)
+
+	assert.ErrorIs(t, err, ErrStorageUnavailable)
+}
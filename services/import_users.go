@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"io"
+
+	"github.com/ffardo/user-crud/interfaces"
+)
+
+var ErrInvalidRecord = errors.New("Invalid record")
+
+// IMPORT_BATCH_SIZE is the number of records an import validates and
+// writes at a time, so its memory use does not grow with its size.
+const IMPORT_BATCH_SIZE = 500
+
+// MAX_IMPORT_ERRORS bounds the errors an import reports. Failed records
+// beyond it are only counted.
+const MAX_IMPORT_ERRORS = 100
+
+// ImportUsers creates the users read in batches of IMPORT_BATCH_SIZE, as
+// BatchUsers does. Batches are not applied atomically with each other: when
+// reading or storage fails, the users of the batches before are kept.
+func (s UserService) ImportUsers(reader interfaces.UserReader, dryRun bool) (interfaces.ImportSummary, error) {
+	summary := interfaces.ImportSummary{}
+	batch := make([]interfaces.ImportRecord, 0, IMPORT_BATCH_SIZE)
+
+	// A dry run writes nothing, so emails taken by earlier batches are
+	// tracked here.
+	emails := map[string]bool{}
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		operations := make([]interfaces.BatchOperation, 0, len(batch))
+		for _, record := range batch {
+			operations = append(operations, interfaces.BatchOperation{Op: interfaces.BATCH_CREATE, Params: record.Params})
+		}
+
+		var results []interfaces.BatchResult
+		var err error
+		if dryRun {
+			results, err = s.checkBatch(operations, emails)
+		} else {
+			results, err = s.BatchUsers(operations, false)
+		}
+		if err != nil {
+			return err
+		}
+
+		for i, result := range results {
+			if result.Err != nil {
+				failImport(&summary, batch[i].Line, result.Err)
+				continue
+			}
+			summary.Imported++
+		}
+
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return summary, err
+		}
+
+		summary.Records++
+
+		if record.Err != nil {
+			failImport(&summary, record.Line, record.Err)
+			continue
+		}
+
+		batch = append(batch, record)
+
+		if len(batch) == IMPORT_BATCH_SIZE {
+			if err := flush(); err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	return summary, flush()
+}
+
+// checkBatch validates operations as BatchUsers does, without writing.
+func (s UserService) checkBatch(operations []interfaces.BatchOperation, emails map[string]bool) ([]interfaces.BatchResult, error) {
+	results := make([]interfaces.BatchResult, len(operations))
+	failed := map[int]error{}
+
+	pending := make([]int, len(operations))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	if _, err := s.planBatch(s.UserRepository, operations, pending, results, failed, emails); err != nil {
+		return nil, translateError(err)
+	}
+
+	for i, err := range failed {
+		results[i].Err = err
+	}
+
+	return results, nil
+}
+
+func failImport(summary *interfaces.ImportSummary, line int, err error) {
+	summary.Failed++
+	if len(summary.Errors) < MAX_IMPORT_ERRORS {
+		summary.Errors = append(summary.Errors, interfaces.ImportError{Line: line, Err: err})
+	}
+}
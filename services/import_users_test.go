@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// sliceReader reads records from a slice, then fails with err if set.
+type sliceReader struct {
+	records []interfaces.ImportRecord
+	err     error
+}
+
+func (r *sliceReader) Next() (interfaces.ImportRecord, error) {
+	if len(r.records) == 0 {
+		if r.err != nil {
+			return interfaces.ImportRecord{}, r.err
+		}
+		return interfaces.ImportRecord{}, io.EOF
+	}
+
+	record := r.records[0]
+	r.records = r.records[1:]
+	return record, nil
+}
+
+func importRecord(line int, email string) interfaces.ImportRecord {
+	return interfaces.ImportRecord{Line: line, Params: createOperation(email).Params}
+}
+
+func TestImportUsersWritesInBatches(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	reader := &sliceReader{}
+	for i := 0; i < IMPORT_BATCH_SIZE+1; i++ {
+		reader.records = append(reader.records, importRecord(i+1, fmt.Sprintf("user%d@mail.com", i)))
+	}
+	reader.records = append(reader.records, interfaces.ImportRecord{Line: IMPORT_BATCH_SIZE + 2, Err: ErrInvalidRecord})
+
+	userRepository.On("UserExistsWithEmail", mock.Anything).Return(false, nil)
+	userRepository.On("WriteUsers", mock.Anything).Return(storeWrites, nil)
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	summary, err := userService.ImportUsers(reader, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, IMPORT_BATCH_SIZE+2, summary.Records)
+	assert.Equal(t, IMPORT_BATCH_SIZE+1, summary.Imported)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, []interfaces.ImportError{{Line: IMPORT_BATCH_SIZE + 2, Err: ErrInvalidRecord}}, summary.Errors)
+	userRepository.AssertNumberOfCalls(t, "WriteUsers", 2)
+}
+
+func TestImportUsersDryRun(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	invalid := importRecord(3, "ann@mail.com")
+	invalid.Params["birth_date"] = "1970-31-01"
+
+	reader := &sliceReader{records: []interfaces.ImportRecord{
+		importRecord(1, "joe@mail.com"),
+		importRecord(2, "Joe@Mail.com"),
+		invalid,
+	}}
+
+	userRepository.On("UserExistsWithEmail", "joe@mail.com").Return(false, nil)
+
+	summary, err := userService.ImportUsers(reader, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, summary.Records)
+	assert.Equal(t, 1, summary.Imported)
+	assert.Equal(t, 2, summary.Failed)
+	assert.Equal(t, 2, summary.Errors[0].Line)
+	assert.ErrorIs(t, summary.Errors[0].Err, ErrEmailRegistered)
+	assert.Equal(t, 3, summary.Errors[1].Line)
+	assert.ErrorIs(t, summary.Errors[1].Err, ErrInvalidDateFormat)
+	userRepository.AssertNotCalled(t, "WriteUsers", mock.Anything)
+}
+
+func TestImportUsersReadFailure(t *testing.T) {
+	userService := UserService{UserRepository: new(mocks.UserRepository)}
+	failure := errors.New("connection reset")
+
+	summary, err := userService.ImportUsers(&sliceReader{err: failure}, false)
+
+	assert.ErrorIs(t, err, failure)
+	assert.Equal(t, 0, summary.Records)
+}
+
+func TestImportUsersBoundsErrors(t *testing.T) {
+	userService := UserService{UserRepository: new(mocks.UserRepository)}
+
+	reader := &sliceReader{}
+	for i := 0; i < MAX_IMPORT_ERRORS+5; i++ {
+		reader.records = append(reader.records, interfaces.ImportRecord{Line: i + 1, Err: ErrInvalidRecord})
+	}
+
+	summary, err := userService.ImportUsers(reader, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, MAX_IMPORT_ERRORS+5, summary.Failed)
+	assert.Len(t, summary.Errors, MAX_IMPORT_ERRORS)
+}
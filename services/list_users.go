@@ -0,0 +1,176 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCursor = errors.New("Invalid cursor")
+var ErrInvalidPageSize = errors.New("Invalid page size")
+var ErrInvalidSort = errors.New("Invalid sort field")
+
+const DEFAULT_PAGE_SIZE = 20
+const MAX_PAGE_SIZE = 100
+
+// listCursor is the position after which the next page starts. Clients get
+// it encoded and treat it as opaque, so its shape can change.
+type listCursor struct {
+	Sort       string     `json:"s"`
+	Descending bool       `json:"d,omitempty"`
+	UUID       uuid.UUID  `json:"u"`
+	Name       string     `json:"n,omitempty"`
+	Email      string     `json:"e,omitempty"`
+	Created    *time.Time `json:"c,omitempty"`
+}
+
+// ListUsers returns a page of active users. A cursor only continues the
+// listing it came from: using it with another sort order fails with
+// ErrInvalidCursor.
+func (s UserService) ListUsers(request interfaces.ListUsersRequest) (interfaces.UserPage, error) {
+	limit := request.Limit
+	if limit == 0 {
+		limit = DEFAULT_PAGE_SIZE
+	}
+	if limit < 0 || limit > MAX_PAGE_SIZE {
+		return interfaces.UserPage{}, ErrInvalidPageSize
+	}
+
+	query := interfaces.UserQuery{
+		NamePrefix: request.NamePrefix,
+		Sort:       interfaces.SORT_CREATED,
+		// One more user than asked for tells whether there is a next page.
+		Limit: limit + 1,
+	}
+
+	if request.Sort != "" {
+		query.Sort = strings.TrimPrefix(request.Sort, "-")
+		query.Descending = strings.HasPrefix(request.Sort, "-")
+
+		switch query.Sort {
+		case interfaces.SORT_CREATED, interfaces.SORT_NAME, interfaces.SORT_EMAIL:
+		default:
+			return interfaces.UserPage{}, ErrInvalidSort
+		}
+	}
+
+	if request.Email != "" {
+		canonical, err := s.Emails.Canonical(request.Email)
+		if err != nil {
+			return interfaces.UserPage{}, ErrInvalidEmailFormat
+		}
+		query.EmailCanonical = canonical
+	}
+
+	var err error
+	if query.CreatedFrom, err = parseTime(request.CreatedFrom); err != nil {
+		return interfaces.UserPage{}, err
+	}
+	if query.CreatedTo, err = parseTime(request.CreatedTo); err != nil {
+		return interfaces.UserPage{}, err
+	}
+	if query.BirthDateFrom, err = parseDate(request.BirthDateFrom); err != nil {
+		return interfaces.UserPage{}, err
+	}
+	if query.BirthDateTo, err = parseDate(request.BirthDateTo); err != nil {
+		return interfaces.UserPage{}, err
+	}
+
+	// A date alone ends a range at the end of that day.
+	if len(request.CreatedTo) == len(models.DATE_FORMAT) {
+		query.CreatedTo = query.CreatedTo.Add(24*time.Hour - time.Millisecond)
+	}
+
+	if request.Cursor != "" {
+		c, err := decodeCursor(request.Cursor)
+		if err != nil || c.Sort != query.Sort || c.Descending != query.Descending {
+			return interfaces.UserPage{}, ErrInvalidCursor
+		}
+		query.After = &models.User{UUID: c.UUID, Name: c.Name, EmailCanonical: c.Email}
+		if c.Created != nil {
+			query.After.Created = *c.Created
+		}
+	}
+
+	users, err := s.UserRepository.ListUsers(query)
+
+	if err != nil {
+		return interfaces.UserPage{}, translateError(err)
+	}
+
+	page := interfaces.UserPage{Users: users}
+
+	if len(users) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = encodeCursor(query, page.Users[limit-1])
+	}
+
+	return page, nil
+}
+
+func encodeCursor(query interfaces.UserQuery, last models.User) string {
+	c := listCursor{Sort: query.Sort, Descending: query.Descending, UUID: last.UUID}
+
+	switch query.Sort {
+	case interfaces.SORT_NAME:
+		c.Name = last.Name
+	case interfaces.SORT_EMAIL:
+		c.Email = last.EmailCanonical
+	default:
+		c.Created = &last.Created
+	}
+
+	b, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string) (listCursor, error) {
+	var c listCursor
+
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(b, &c)
+
+	return c, err
+}
+
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(models.DATE_FORMAT, value)
+	if err != nil {
+		return time.Time{}, ErrInvalidDateFormat
+	}
+
+	return t, nil
+}
+
+// parseTime accepts RFC 3339 times or dates, which stand for midnight UTC.
+func parseTime(value string) (time.Time, error) {
+	if len(value) == len(models.DATE_FORMAT) {
+		return parseDate(value)
+	}
+
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, ErrInvalidDateFormat
+	}
+
+	return t, nil
+}
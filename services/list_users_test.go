@@ -0,0 +1,146 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func listedUsers(n int) []models.User {
+	users := make([]models.User, n)
+	for i := range users {
+		users[i] = models.User{
+			UUID:    uuid.New(),
+			Name:    "John Doe",
+			Created: time.Date(2023, 4, 1, 12, i, 0, 0, time.UTC),
+		}
+	}
+	return users
+}
+
+func TestListUsersDefaults(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	users := listedUsers(3)
+
+	userRepository.On("ListUsers", interfaces.UserQuery{
+		Sort:  interfaces.SORT_CREATED,
+		Limit: DEFAULT_PAGE_SIZE + 1,
+	}).Return(users, nil)
+
+	page, err := userService.ListUsers(interfaces.ListUsersRequest{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, users, page.Users)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestListUsersCursorContinuesAfterLastUser(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	users := listedUsers(3)
+
+	userRepository.On("ListUsers", mock.MatchedBy(func(q interfaces.UserQuery) bool {
+		return q.After == nil
+	})).Return(users, nil)
+	userRepository.On("ListUsers", mock.MatchedBy(func(q interfaces.UserQuery) bool {
+		return q.After != nil
+	})).Return(users[2:], nil)
+
+	first, err := userService.ListUsers(interfaces.ListUsersRequest{Sort: "-created", Limit: 2})
+
+	assert.NoError(t, err)
+	assert.Equal(t, users[:2], first.Users)
+	assert.NotEmpty(t, first.NextCursor)
+
+	second, err := userService.ListUsers(interfaces.ListUsersRequest{Sort: "-created", Limit: 2, Cursor: first.NextCursor})
+
+	assert.NoError(t, err)
+	assert.Equal(t, users[2:], second.Users)
+	assert.Empty(t, second.NextCursor)
+
+	userRepository.AssertCalled(t, "ListUsers", mock.MatchedBy(func(q interfaces.UserQuery) bool {
+		return q.After != nil && q.After.UUID == users[1].UUID && q.After.Created.Equal(users[1].Created) && q.Descending
+	}))
+}
+
+func TestEncodeCursorOmitsCreatedOfOtherSorts(t *testing.T) {
+	user := listedUsers(1)[0]
+
+	c, err := decodeCursor(encodeCursor(interfaces.UserQuery{Sort: interfaces.SORT_NAME}, user))
+
+	assert.NoError(t, err)
+	assert.Nil(t, c.Created)
+	assert.Equal(t, "John Doe", c.Name)
+
+	c, err = decodeCursor(encodeCursor(interfaces.UserQuery{Sort: interfaces.SORT_CREATED}, user))
+
+	assert.NoError(t, err)
+	assert.True(t, user.Created.Equal(*c.Created))
+}
+
+func TestListUsersCursorOfAnotherSort(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("ListUsers", mock.Anything).Return(listedUsers(2), nil)
+
+	first, _ := userService.ListUsers(interfaces.ListUsersRequest{Limit: 1})
+
+	_, err := userService.ListUsers(interfaces.ListUsersRequest{Sort: "name", Limit: 1, Cursor: first.NextCursor})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	_, err = userService.ListUsers(interfaces.ListUsersRequest{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestListUsersFilters(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("ListUsers", interfaces.UserQuery{
+		EmailCanonical: "joe@mail.com",
+		NamePrefix:     "Jo",
+		CreatedFrom:    time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC),
+		CreatedTo:      time.Date(2023, 4, 30, 23, 59, 59, 999000000, time.UTC),
+		BirthDateFrom:  time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+		BirthDateTo:    time.Date(1979, 12, 31, 0, 0, 0, 0, time.UTC),
+		Sort:           interfaces.SORT_NAME,
+		Limit:          11,
+	}).Return([]models.User{}, nil)
+
+	_, err := userService.ListUsers(interfaces.ListUsersRequest{
+		Email:         " Joe@Mail.com",
+		NamePrefix:    "Jo",
+		CreatedFrom:   "2023-04-01T00:00:00Z",
+		CreatedTo:     "2023-04-30",
+		BirthDateFrom: "1970-01-01",
+		BirthDateTo:   "1979-12-31",
+		Sort:          "name",
+		Limit:         10,
+	})
+
+	assert.NoError(t, err)
+}
+
+func TestListUsersRejectsInvalidParameters(t *testing.T) {
+	userService := UserService{UserRepository: new(mocks.UserRepository)}
+
+	cases := map[error]interfaces.ListUsersRequest{
+		ErrInvalidPageSize:    {Limit: MAX_PAGE_SIZE + 1},
+		ErrInvalidSort:        {Sort: "password"},
+		ErrInvalidDateFormat:  {BirthDateFrom: "01/01/1970"},
+		ErrInvalidEmailFormat: {Email: "not an email"},
+	}
+
+	for expected, request := range cases {
+		_, err := userService.ListUsers(request)
+		assert.ErrorIs(t, err, expected)
+	}
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Purger periodically removes users that have been soft deleted for longer
+// than Retention.
+type Purger struct {
+	UserService UserService
+	Retention   time.Duration
+	Interval    time.Duration
+}
+
+// Run purges once immediately and then every Interval until ctx is done.
+func (p Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.purge()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p Purger) purge() {
+	purged, err := p.UserService.PurgeDeletedUsers(p.Retention)
+
+	if err != nil {
+		log.Printf("purging deleted users: %v", err)
+		return
+	}
+
+	if purged > 0 {
+		log.Printf("purged %d deleted users", purged)
+	}
+}
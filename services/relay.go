@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+)
+
+// Relay publishes the events stored in the outbox. Events are published in
+// the order they occurred and a failed event blocks the ones after it until
+// it succeeds. Delivery is at least once: an event published just before
+// the relay stops is published again on the next run.
+type Relay struct {
+	Outbox    interfaces.OutboxRepository
+	Publisher interfaces.Publisher
+	BatchSize int
+	Interval  time.Duration
+}
+
+// Run relays pending events every Interval until ctx is done. While there
+// is a backlog it keeps relaying full batches without waiting.
+func (r Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		relayed, err := r.RelayPending()
+
+		if err != nil {
+			log.Printf("relaying events: %v", err)
+		}
+
+		if err == nil && relayed == r.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayPending publishes one batch of pending events and returns how many
+// were published.
+func (r Relay) RelayPending() (int, error) {
+	events, err := r.Outbox.PendingEvents(r.BatchSize)
+
+	if err != nil {
+		return 0, err
+	}
+
+	for i, event := range events {
+		if err := r.Publisher.Publish(event); err != nil {
+			if markErr := r.Outbox.MarkFailed(event.ID); markErr != nil {
+				log.Printf("recording failed event %s: %v", event.ID, markErr)
+			}
+			return i, err
+		}
+
+		if err := r.Outbox.MarkPublished(event.ID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(events), nil
+}
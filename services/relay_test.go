@@ -0,0 +1,60 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayPending(t *testing.T) {
+	outbox := new(mocks.OutboxRepository)
+	publisher := new(mocks.Publisher)
+
+	events := []models.Event{
+		{ID: uuid.New(), Type: models.EVENT_USER_CREATED},
+		{ID: uuid.New(), Type: models.EVENT_USER_UPDATED, Fields: []string{"name"}},
+	}
+
+	outbox.On("PendingEvents", 10).Return(events, nil)
+	for _, e := range events {
+		publisher.On("Publish", e).Return(nil)
+		outbox.On("MarkPublished", e.ID).Return(nil)
+	}
+
+	relay := Relay{Outbox: outbox, Publisher: publisher, BatchSize: 10}
+
+	relayed, err := relay.RelayPending()
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, relayed)
+	outbox.AssertExpectations(t)
+	publisher.AssertExpectations(t)
+}
+
+func TestRelayPendingStopsAtFailure(t *testing.T) {
+	outbox := new(mocks.OutboxRepository)
+	publisher := new(mocks.Publisher)
+	failure := errors.New("unreachable")
+
+	events := []models.Event{
+		{ID: uuid.New(), Type: models.EVENT_USER_CREATED},
+		{ID: uuid.New(), Type: models.EVENT_USER_DELETED},
+	}
+
+	outbox.On("PendingEvents", 10).Return(events, nil)
+	publisher.On("Publish", events[0]).Return(failure)
+	outbox.On("MarkFailed", events[0].ID).Return(nil)
+
+	relay := Relay{Outbox: outbox, Publisher: publisher, BatchSize: 10}
+
+	relayed, err := relay.RelayPending()
+
+	assert.Equal(t, failure, err)
+	assert.Equal(t, 0, relayed)
+	publisher.AssertNotCalled(t, "Publish", events[1])
+	outbox.AssertNotCalled(t, "MarkPublished", events[0].ID)
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/google/uuid"
+)
+
+var ErrMissingField = errors.New("Missing user field")
+var ErrUuidTaken = errors.New("Uuid already in use")
+var ErrUserExists = errors.New("User already exists")
+
+// REPLACE_FIELDS are the fields a replacement must hold, by their JSON
+// names: every field clients can change but the password, which clients
+// cannot read back. It is kept when not sent, although new users still need
+// one. The address may be given by its parts, as in ADDRESS_FIELDS.
+var REPLACE_FIELDS = []string{"name", "birth_date", "email", "address"}
+
+// ReplaceUser sets every field of the user to the ones in params, creating
+// the user under user_uuid when there is none. created reports whether it
+// did. Clients choosing the UUID of a deleted user, or of another tenant's,
+// get ErrUuidTaken.
+func (s UserService) ReplaceUser(user_uuid string, params map[string]string) (models.User, bool, error) {
+	return s.replaceUser(user_uuid, replaceCondition{}, params)
+}
+
+// ReplaceUserIfMatch replaces the user only if it is stored at version,
+// returning ErrVersionMismatch otherwise, including when there is no user.
+func (s UserService) ReplaceUserIfMatch(user_uuid string, version int64, params map[string]string) (models.User, error) {
+	user, _, err := s.replaceUser(user_uuid, replaceCondition{version: &version}, params)
+	return user, err
+}
+
+// ReplaceUserIfExists replaces the user at whatever version it is stored,
+// returning ErrVersionMismatch when there is no user.
+func (s UserService) ReplaceUserIfExists(user_uuid string, params map[string]string) (models.User, error) {
+	user, _, err := s.replaceUser(user_uuid, replaceCondition{exists: true}, params)
+	return user, err
+}
+
+// CreateUserIfAbsent creates the user under user_uuid as ReplaceUser does,
+// returning ErrUserExists when there is a user already.
+func (s UserService) CreateUserIfAbsent(user_uuid string, params map[string]string) (models.User, error) {
+	user, _, err := s.replaceUser(user_uuid, replaceCondition{absent: true}, params)
+	return user, err
+}
+
+// replaceCondition is what must hold of the stored user for a replacement
+// to go ahead. The zero value holds of any.
+type replaceCondition struct {
+	// version is the version the user must be stored at, if any.
+	version *int64
+	// exists requires the user to be stored, absent requires it not to be.
+	exists bool
+	absent bool
+}
+
+func (s UserService) replaceUser(user_uuid string, condition replaceCondition, params map[string]string) (models.User, bool, error) {
+	u, err := uuid.Parse(user_uuid)
+
+	if err != nil || u == uuid.Nil {
+		return models.User{}, false, ErrInvalidUuidFormat
+	}
+
+	for _, field := range REPLACE_FIELDS {
+		if _, ok := params[field]; !ok && !(field == "address" && hasAddressParts(params)) {
+			return models.User{}, false, FieldError{field, ErrMissingField}
+		}
+	}
+
+	for attempt := 0; attempt < UPDATE_ATTEMPTS; attempt++ {
+		var user models.User
+		created := false
+
+		err := s.UserRepository.WithTransaction(func(tx interfaces.UserRepository) error {
+			current, err := tx.GetUserByUUID(u)
+
+			if errors.Is(err, interfaces.ErrNotFound) {
+				if condition.version != nil || condition.exists {
+					return ErrVersionMismatch
+				}
+
+				created = true
+				user, err = s.createWithUUID(tx, u, params)
+				return err
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if condition.absent {
+				return ErrUserExists
+			}
+
+			if condition.version != nil && current.Version != *condition.version {
+				return ErrVersionMismatch
+			}
+
+			updated, err := s.applyParams(tx, current, keepStoredPassword(current, params))
+
+			if err != nil {
+				return err
+			}
+
+			changed := changedFields(current, updated)
+
+			if len(changed) == 0 {
+				user = current
+				return nil
+			}
+
+			user, err = tx.UpdateUser(updated)
+
+			if err != nil {
+				return err
+			}
+
+			return tx.AddEvent(s.newEvent(models.EVENT_USER_UPDATED, user.UUID, changed))
+		})
+
+		if errors.Is(err, interfaces.ErrVersionConflict) {
+			if condition.version != nil {
+				return models.User{}, false, ErrVersionMismatch
+			}
+			continue
+		}
+
+		if err != nil {
+			if errors.Is(err, interfaces.ErrConflict) && !errors.Is(err, interfaces.ErrEmailRegistered) {
+				return models.User{}, false, serviceError{ErrUuidTaken, err}
+			}
+			return models.User{}, false, translateError(err)
+		}
+
+		return user, created, nil
+	}
+
+	return models.User{}, false, ErrConcurrentUpdate
+}
+
+// keepStoredPassword drops the password from params when it is the hash
+// stored for user, as v1 Get returns it, so replacing a user with the one
+// read does not hash its password again.
+func keepStoredPassword(user models.User, params map[string]string) map[string]string {
+	if password, ok := params["password"]; !ok || password != user.Password {
+		return params
+	}
+
+	kept := make(map[string]string, len(params))
+	for field, value := range params {
+		if field != "password" {
+			kept[field] = value
+		}
+	}
+	return kept
+}
+
+// createWithUUID creates the user in params, as CreateUser does, under the
+// UUID u.
+func (s UserService) createWithUUID(tx interfaces.UserRepository, u uuid.UUID, params map[string]string) (models.User, error) {
+	user, err := s.newUser(params)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.UUID = u
+
+	exists, err := tx.UserExistsWithEmail(user.EmailCanonical)
+
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if exists {
+		return models.User{}, ErrEmailRegistered
+	}
+
+	user, err = tx.CreateUser(user)
+
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, tx.AddEvent(s.newEvent(models.EVENT_USER_CREATED, user.UUID, nil))
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/search"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func replaceParams() map[string]string {
+	return map[string]string{
+		"name":       "John Doe",
+		"birth_date": "1970-01-01",
+		"email":      "joe25@mailprovider.com",
+		"address":    "3197 Woodrow Way",
+		"password":   "secret",
+	}
+}
+
+func TestReplaceUserCreatesMissingUser(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	user_bd, _ := time.Parse("2006-01-02", "1970-01-01")
+
+	user := models.User{
+		UUID:           user_uuid,
+		BirthDate:      user_bd,
+		Name:           "John Doe",
+		Email:          "joe25@mailprovider.com",
+		EmailCanonical: "joe25@mailprovider.com",
+		Password:       hashPassword("secret"),
+		Address:        "3197 Woodrow Way",
+		SearchTrigrams: search.Trigrams("John Doe", "3197 Woodrow Way"),
+	}
+
+	stored := user
+	stored.Version = 1
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(models.User{}, interfaces.ErrNotFound)
+	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(false, nil)
+	userRepository.On("CreateUser", user).Return(stored, nil)
+	userRepository.On("AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return e.Type == models.EVENT_USER_CREATED && e.UserUUID == user_uuid
+	})).Return(nil)
+
+	replaced, created, err := userService.ReplaceUser(user_uuid.String(), replaceParams())
+
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, stored, replaced)
+}
+
+func TestReplaceUserReplacesEveryField(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	current := models.User{
+		UUID:     user_uuid,
+		Name:     "Mary Doe",
+		Email:    "mary@mailprovider.com",
+		Password: hashPassword("other"),
+		Version:  4,
+	}
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(current, nil)
+	userRepository.On("UserExistsWithEmailAndNotUuid", "joe25@mailprovider.com", user_uuid).Return(false, nil)
+	userRepository.On("UpdateUser", mock.Anything).Return(func(user models.User) models.User {
+		user.Version++
+		return user
+	}, nil)
+	userRepository.On("AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return e.Type == models.EVENT_USER_UPDATED && len(e.Fields) == 5
+	})).Return(nil)
+
+	replaced, created, err := userService.ReplaceUser(user_uuid.String(), replaceParams())
+
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "John Doe", replaced.Name)
+	assert.Equal(t, "3197 Woodrow Way", replaced.Address)
+	assert.Equal(t, hashPassword("secret"), replaced.Password)
+	assert.Equal(t, int64(5), replaced.Version)
+}
+
+func TestReplaceUserKeepsPasswordNotSent(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	current := models.User{
+		UUID:     user_uuid,
+		Name:     "Mary Doe",
+		Email:    "joe25@mailprovider.com",
+		Password: hashPassword("other"),
+		Version:  4,
+	}
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(current, nil)
+	userRepository.On("UserExistsWithEmailAndNotUuid", "joe25@mailprovider.com", user_uuid).Return(false, nil)
+	userRepository.On("UpdateUser", mock.Anything).Return(func(user models.User) models.User {
+		return user
+	}, nil)
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	params := replaceParams()
+	delete(params, "password")
+
+	replaced, _, err := userService.ReplaceUser(user_uuid.String(), params)
+
+	assert.NoError(t, err)
+	assert.Equal(t, hashPassword("other"), replaced.Password)
+}
+
+func TestReplaceUserWithUserRead(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+	user_bd, _ := time.Parse("2006-01-02", "1970-01-01")
+
+	current := models.User{
+		UUID:           user_uuid,
+		BirthDate:      user_bd,
+		Name:           "John Doe",
+		Email:          "joe25@mailprovider.com",
+		EmailCanonical: "joe25@mailprovider.com",
+		Password:       hashPassword("secret"),
+		Address:        "3197 Woodrow Way",
+		Version:        4,
+	}
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(current, nil)
+	userRepository.On("UserExistsWithEmailAndNotUuid", "joe25@mailprovider.com", user_uuid).Return(false, nil)
+	userRepository.On("UpdateUser", mock.Anything).Return(func(user models.User) models.User {
+		user.Version++
+		return user
+	}, nil)
+	userRepository.On("AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return assert.ObjectsAreEqual([]string{"name"}, e.Fields)
+	})).Return(nil)
+
+	// As v1 Get returns the user, with the name edited.
+	params := map[string]string{
+		"name":       "John Nobody",
+		"birth_date": current.BirthDate.Format("2006-01-02"),
+		"email":      current.Email,
+		"address":    current.Address,
+		"password":   current.Password,
+	}
+
+	replaced, _, err := userService.ReplaceUser(user_uuid.String(), params)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "John Nobody", replaced.Name)
+	assert.Equal(t, hashPassword("secret"), replaced.Password)
+}
+
+func TestReplaceUserMissingField(t *testing.T) {
+	userService := UserService{UserRepository: new(mocks.UserRepository)}
+
+	params := replaceParams()
+	delete(params, "address")
+
+	_, _, err := userService.ReplaceUser("d035e79d-ffe9-4ebf-b665-747353b3ea40", params)
+
+	assert.ErrorIs(t, err, ErrMissingField)
+	assert.EqualError(t, err, "Missing user field: address")
+}
+
+func TestReplaceUserWithInvalidUUID(t *testing.T) {
+	userService := UserService{UserRepository: new(mocks.UserRepository)}
+
+	for _, u := range []string{"not a uuid", uuid.Nil.String()} {
+		_, _, err := userService.ReplaceUser(u, replaceParams())
+		assert.Equal(t, ErrInvalidUuidFormat, err)
+	}
+}
+
+func TestReplaceUserIfMatchMissingUser(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(models.User{}, interfaces.ErrNotFound)
+
+	_, err := userService.ReplaceUserIfMatch(user_uuid.String(), 1, replaceParams())
+
+	assert.Equal(t, ErrVersionMismatch, err)
+	userRepository.AssertNotCalled(t, "CreateUser", mock.Anything)
+}
+
+func TestReplaceUserIfExistsMissingUser(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(models.User{}, interfaces.ErrNotFound)
+
+	_, err := userService.ReplaceUserIfExists(user_uuid.String(), replaceParams())
+
+	assert.Equal(t, ErrVersionMismatch, err)
+	userRepository.AssertNotCalled(t, "CreateUser", mock.Anything)
+}
+
+func TestReplaceUserIfExistsAnyVersion(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(models.User{UUID: user_uuid, Name: "Mary Doe", Version: 4}, nil)
+	userRepository.On("UserExistsWithEmailAndNotUuid", "joe25@mailprovider.com", user_uuid).Return(false, nil)
+	userRepository.On("UpdateUser", mock.Anything).Return(func(user models.User) models.User {
+		user.Version++
+		return user
+	}, nil)
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	replaced, err := userService.ReplaceUserIfExists(user_uuid.String(), replaceParams())
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), replaced.Version)
+}
+
+func TestCreateUserIfAbsentExistingUser(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(models.User{UUID: user_uuid, Version: 4}, nil)
+
+	_, err := userService.CreateUserIfAbsent(user_uuid.String(), replaceParams())
+
+	assert.Equal(t, ErrUserExists, err)
+	userRepository.AssertNotCalled(t, "UpdateUser", mock.Anything)
+}
+
+func TestCreateUserIfAbsentCreates(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(models.User{}, interfaces.ErrNotFound)
+	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(false, nil)
+	userRepository.On("CreateUser", mock.Anything).Return(func(user models.User) models.User {
+		user.Version = 1
+		return user
+	}, nil)
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	created, err := userService.CreateUserIfAbsent(user_uuid.String(), replaceParams())
+
+	assert.NoError(t, err)
+	assert.Equal(t, user_uuid, created.UUID)
+}
+
+func TestReplaceUserUuidTaken(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(models.User{}, interfaces.ErrNotFound)
+	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(false, nil)
+	userRepository.On("CreateUser", mock.Anything).Return(models.User{}, fmt.Errorf("%w: duplicate key", interfaces.ErrConflict))
+
+	_, _, err := userService.ReplaceUser(user_uuid.String(), replaceParams())
+
+	assert.ErrorIs(t, err, ErrUuidTaken)
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/search"
+)
+
+var ErrInvalidSearch = errors.New("Invalid search query")
+
+// FUZZY_CANDIDATES bounds how many users sharing trigrams with the query
+// are rescored when the text index finds too few.
+const FUZZY_CANDIDATES = 200
+
+// SearchUsers returns up to limit active users matching query by name or
+// address, ignoring case and accents. Users containing the words of the
+// query come first. When they are fewer than limit, the rest is filled with
+// users whose words are similar to the query's, so typos still find them.
+func (s UserService) SearchUsers(query string, limit int) ([]interfaces.SearchResult, error) {
+	terms := search.Terms(query)
+	if len(terms) == 0 {
+		return nil, ErrInvalidSearch
+	}
+
+	if limit == 0 {
+		limit = DEFAULT_PAGE_SIZE
+	}
+	if limit < 0 || limit > MAX_PAGE_SIZE {
+		return nil, ErrInvalidPageSize
+	}
+
+	matches, err := s.UserRepository.SearchUsers(query, limit)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	results := make([]interfaces.SearchResult, 0, limit)
+	found := map[string]bool{}
+
+	for _, m := range matches {
+		results = append(results, searchResult(m.User, m.Score, interfaces.MATCH_TEXT, terms))
+		found[m.User.UUID.String()] = true
+	}
+
+	if len(results) >= limit {
+		return results, nil
+	}
+
+	candidates, err := s.UserRepository.SimilarUsers(search.Trigrams(query), FUZZY_CANDIDATES)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	fuzzy := []interfaces.SearchResult{}
+	for _, c := range candidates {
+		if found[c.User.UUID.String()] {
+			continue
+		}
+
+		score := search.Score(terms, c.User.Name, c.User.Address)
+		if score < search.THRESHOLD {
+			continue
+		}
+
+		fuzzy = append(fuzzy, searchResult(c.User, score, interfaces.MATCH_FUZZY, terms))
+	}
+
+	sort.SliceStable(fuzzy, func(i, j int) bool { return fuzzy[i].Score > fuzzy[j].Score })
+
+	if len(fuzzy) > limit-len(results) {
+		fuzzy = fuzzy[:limit-len(results)]
+	}
+
+	return append(results, fuzzy...), nil
+}
+
+func searchResult(user models.User, score float64, match string, terms []string) interfaces.SearchResult {
+	highlights := map[string]string{}
+
+	if h, ok := search.Highlight(user.Name, terms); ok {
+		highlights["name"] = h
+	}
+	if h, ok := search.Highlight(user.Address, terms); ok {
+		highlights["address"] = h
+	}
+
+	return interfaces.SearchResult{User: user, Score: score, Match: match, Highlights: highlights}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/search"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchUsersTextMatchesFirst(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	jose := models.User{UUID: uuid.New(), Name: "José Doe", Address: "3197 Woodrow Way"}
+	joseph := models.User{UUID: uuid.New(), Name: "Joseph Doe", Address: "1 Infinite Loop"}
+	mary := models.User{UUID: uuid.New(), Name: "Mary Smith", Address: "1 Infinite Loop"}
+
+	userRepository.On("SearchUsers", "jose", DEFAULT_PAGE_SIZE).Return([]interfaces.UserMatch{{User: jose, Score: 3}}, nil)
+	userRepository.On("SimilarUsers", search.Trigrams("jose"), FUZZY_CANDIDATES).Return([]interfaces.UserMatch{
+		{User: mary, Score: 1},
+		{User: jose, Score: 4},
+		{User: joseph, Score: 3},
+	}, nil)
+
+	results, err := userService.SearchUsers("jose", 0)
+
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	assert.Equal(t, jose, results[0].User)
+	assert.Equal(t, 3.0, results[0].Score)
+	assert.Equal(t, interfaces.MATCH_TEXT, results[0].Match)
+	assert.Equal(t, map[string]string{"name": "<em>José</em> Doe"}, results[0].Highlights)
+
+	assert.Equal(t, joseph, results[1].User)
+	assert.Equal(t, 1.0, results[1].Score)
+	assert.Equal(t, interfaces.MATCH_FUZZY, results[1].Match)
+	assert.Equal(t, map[string]string{"name": "<em>Joseph</em> Doe"}, results[1].Highlights)
+}
+
+func TestSearchUsersToleratesTypos(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	johnson := models.User{UUID: uuid.New(), Name: "Mary Johnson", Address: "3197 Woodrow Way"}
+
+	userRepository.On("SearchUsers", "jonson", 5).Return([]interfaces.UserMatch{}, nil)
+	userRepository.On("SimilarUsers", search.Trigrams("jonson"), FUZZY_CANDIDATES).Return([]interfaces.UserMatch{
+		{User: johnson, Score: 4},
+	}, nil)
+
+	results, err := userService.SearchUsers("jonson", 5)
+
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, interfaces.MATCH_FUZZY, results[0].Match)
+	assert.Equal(t, "Mary <em>Johnson</em>", results[0].Highlights["name"])
+}
+
+func TestSearchUsersSkipsFuzzyWhenFull(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("SearchUsers", "doe", 1).Return([]interfaces.UserMatch{
+		{User: models.User{UUID: uuid.New(), Name: "John Doe"}, Score: 1},
+	}, nil)
+
+	results, err := userService.SearchUsers("doe", 1)
+
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	userRepository.AssertNotCalled(t, "SimilarUsers")
+}
+
+func TestSearchUsersInvalid(t *testing.T) {
+	userService := UserService{UserRepository: new(mocks.UserRepository)}
+
+	_, err := userService.SearchUsers(" ,. ", 0)
+	assert.ErrorIs(t, err, ErrInvalidSearch)
+
+	_, err = userService.SearchUsers("doe", MAX_PAGE_SIZE+1)
+	assert.ErrorIs(t, err, ErrInvalidPageSize)
+}
+
+func TestSearchUsersStorageUnavailable(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("SearchUsers", "doe", DEFAULT_PAGE_SIZE).Return(nil, interfaces.ErrUnavailable)
+
+	_, err := userService.SearchUsers("doe", 0)
+
+	assert.ErrorIs(t, err, ErrStorageUnavailable)
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/models"
+)
+
+var ErrTenantNotFound = errors.New("Could not find tenant")
+var ErrTenantExists = errors.New("Tenant already exists")
+var ErrInvalidTenantID = errors.New("Invalid tenant id")
+var ErrInvalidAPIKey = errors.New("Invalid API key")
+var ErrInvalidEmailDomain = errors.New("Invalid email domain")
+
+// API_KEY_BYTES is the length of generated tenant API keys before hex
+// encoding.
+const API_KEY_BYTES = 32
+
+var tenantID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// translateTenantError maps repository errors to the service errors exposed
+// to controllers.
+func translateTenantError(err error) error {
+	switch {
+	case errors.Is(err, interfaces.ErrNotFound):
+		return serviceError{ErrTenantNotFound, err}
+	case errors.Is(err, interfaces.ErrConflict):
+		return serviceError{ErrTenantExists, err}
+	}
+	return translateError(err)
+}
+
+type TenantService struct {
+	interfaces.TenantRepository
+}
+
+func (s TenantService) GetTenant(id string) (models.Tenant, error) {
+	if !tenantID.MatchString(id) {
+		return models.Tenant{}, ErrInvalidTenantID
+	}
+
+	tenant, err := s.TenantRepository.GetTenant(id)
+
+	if err != nil {
+		return models.Tenant{}, translateTenantError(err)
+	}
+
+	return tenant, nil
+}
+
+// Authenticate returns ErrInvalidAPIKey for keys of no tenant.
+func (s TenantService) Authenticate(apiKey string) (models.Tenant, error) {
+	if apiKey == "" {
+		return models.Tenant{}, ErrInvalidAPIKey
+	}
+
+	tenant, err := s.TenantRepository.GetTenantByAPIKeyHash(hashAPIKey(apiKey))
+
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return models.Tenant{}, ErrInvalidAPIKey
+	}
+
+	if err != nil {
+		return models.Tenant{}, translateTenantError(err)
+	}
+
+	return tenant, nil
+}
+
+func (s TenantService) ListTenants() ([]models.Tenant, error) {
+	tenants, err := s.TenantRepository.ListTenants()
+
+	if err != nil {
+		return nil, translateTenantError(err)
+	}
+
+	return tenants, nil
+}
+
+// CreateTenant accepts IDs of lowercase letters, digits and dashes, up to
+// 64 characters. The empty ID belongs to the default tenant, which needs no
+// record.
+func (s TenantService) CreateTenant(id, name string, settings models.TenantSettings) (models.Tenant, string, error) {
+	if !tenantID.MatchString(id) {
+		return models.Tenant{}, "", ErrInvalidTenantID
+	}
+
+	settings, err := normalizeSettings(settings)
+	if err != nil {
+		return models.Tenant{}, "", err
+	}
+
+	apiKey, err := newAPIKey()
+	if err != nil {
+		return models.Tenant{}, "", err
+	}
+
+	tenant := models.Tenant{
+		ID:         id,
+		Name:       name,
+		APIKeyHash: hashAPIKey(apiKey),
+		Settings:   settings,
+	}
+
+	tenant, err = s.TenantRepository.CreateTenant(tenant)
+
+	if err != nil {
+		return models.Tenant{}, "", translateTenantError(err)
+	}
+
+	return tenant, apiKey, nil
+}
+
+// UpdateTenant changes the name and settings that are not nil.
+func (s TenantService) UpdateTenant(id string, name *string, settings *models.TenantSettings) (models.Tenant, error) {
+	tenant, err := s.GetTenant(id)
+	if err != nil {
+		return models.Tenant{}, err
+	}
+
+	if name != nil {
+		tenant.Name = *name
+	}
+
+	if settings != nil {
+		tenant.Settings, err = normalizeSettings(*settings)
+		if err != nil {
+			return models.Tenant{}, err
+		}
+	}
+
+	tenant, err = s.TenantRepository.UpdateTenant(tenant)
+
+	if err != nil {
+		return models.Tenant{}, translateTenantError(err)
+	}
+
+	return tenant, nil
+}
+
+// RotateAPIKey invalidates the tenant's current key immediately.
+func (s TenantService) RotateAPIKey(id string) (string, error) {
+	tenant, err := s.GetTenant(id)
+	if err != nil {
+		return "", err
+	}
+
+	apiKey, err := newAPIKey()
+	if err != nil {
+		return "", err
+	}
+
+	tenant.APIKeyHash = hashAPIKey(apiKey)
+
+	if _, err := s.TenantRepository.UpdateTenant(tenant); err != nil {
+		return "", translateTenantError(err)
+	}
+
+	return apiKey, nil
+}
+
+// normalizeSettings lowercases the allowed email domains, rejecting any
+// that is empty or contains an @.
+func normalizeSettings(settings models.TenantSettings) (models.TenantSettings, error) {
+	domains := make([]string, 0, len(settings.EmailDomains))
+
+	for _, d := range settings.EmailDomains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == "" || strings.Contains(d, "@") {
+			return settings, ErrInvalidEmailDomain
+		}
+		domains = append(domains, d)
+	}
+
+	settings.EmailDomains = domains
+
+	return settings, nil
+}
+
+func newAPIKey() (string, error) {
+	b := make([]byte, API_KEY_BYTES)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashAPIKey returns the form API keys are stored and looked up in. Keys are
+// random, so a plain hash is enough to keep a leaked database from
+// revealing them.
+func hashAPIKey(apiKey string) string {
+	h := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateTenantStoresKeyHash(t *testing.T) {
+	tenantRepository := new(mocks.TenantRepository)
+	tenantService := TenantService{TenantRepository: tenantRepository}
+
+	tenantRepository.On("CreateTenant", mock.Anything).Return(func(tenant models.Tenant) models.Tenant {
+		return tenant
+	}, nil)
+
+	tenant, apiKey, err := tenantService.CreateTenant("acme", "Acme", models.TenantSettings{
+		EmailDomains: []string{" Acme.com "},
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, apiKey, 2*API_KEY_BYTES)
+	assert.Equal(t, hashAPIKey(apiKey), tenant.APIKeyHash)
+	assert.NotContains(t, tenant.APIKeyHash, apiKey)
+	assert.Equal(t, []string{"acme.com"}, tenant.Settings.EmailDomains)
+}
+
+func TestCreateTenantRejectsInvalidID(t *testing.T) {
+	tenantService := TenantService{TenantRepository: new(mocks.TenantRepository)}
+
+	for _, id := range []string{"", "Acme", "acme/users", "-acme"} {
+		_, _, err := tenantService.CreateTenant(id, "Acme", models.TenantSettings{})
+		assert.ErrorIs(t, err, ErrInvalidTenantID, id)
+	}
+}
+
+func TestCreateTenantExists(t *testing.T) {
+	tenantRepository := new(mocks.TenantRepository)
+	tenantService := TenantService{TenantRepository: tenantRepository}
+
+	tenantRepository.On("CreateTenant", mock.Anything).Return(models.Tenant{}, interfaces.ErrConflict)
+
+	_, _, err := tenantService.CreateTenant("acme", "Acme", models.TenantSettings{})
+
+	assert.ErrorIs(t, err, ErrTenantExists)
+}
+
+func TestAuthenticate(t *testing.T) {
+	tenantRepository := new(mocks.TenantRepository)
+	tenantService := TenantService{TenantRepository: tenantRepository}
+	tenant := models.Tenant{ID: "acme", APIKeyHash: hashAPIKey("secret")}
+
+	tenantRepository.On("GetTenantByAPIKeyHash", hashAPIKey("secret")).Return(tenant, nil)
+	tenantRepository.On("GetTenantByAPIKeyHash", mock.Anything).Return(models.Tenant{}, interfaces.ErrNotFound)
+
+	authenticated, err := tenantService.Authenticate("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, tenant, authenticated)
+
+	_, err = tenantService.Authenticate("wrong")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	_, err = tenantService.Authenticate("")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+}
+
+func TestRotateAPIKeyReplacesHash(t *testing.T) {
+	tenantRepository := new(mocks.TenantRepository)
+	tenantService := TenantService{TenantRepository: tenantRepository}
+	tenant := models.Tenant{ID: "acme", APIKeyHash: hashAPIKey("old")}
+
+	var updated models.Tenant
+	tenantRepository.On("GetTenant", "acme").Return(tenant, nil)
+	tenantRepository.On("UpdateTenant", mock.Anything).Return(func(tenant models.Tenant) models.Tenant {
+		updated = tenant
+		return tenant
+	}, nil)
+
+	apiKey, err := tenantService.RotateAPIKey("acme")
+
+	assert.NoError(t, err)
+	assert.Equal(t, hashAPIKey(apiKey), updated.APIKeyHash)
+}
+
+func TestUpdateTenantMissing(t *testing.T) {
+	tenantRepository := new(mocks.TenantRepository)
+	tenantService := TenantService{TenantRepository: tenantRepository}
+
+	tenantRepository.On("GetTenant", "acme").Return(models.Tenant{}, interfaces.ErrNotFound)
+
+	name := "Acme"
+	_, err := tenantService.UpdateTenant("acme", &name, nil)
+
+	assert.ErrorIs(t, err, ErrTenantNotFound)
+}
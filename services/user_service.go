@@ -4,11 +4,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"net/mail"
+	"strings"
 	"time"
 
+	"github.com/ffardo/user-crud/emails"
 	"github.com/ffardo/user-crud/interfaces"
 	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/search"
 	"github.com/google/uuid"
 )
 
@@ -17,9 +19,81 @@ var ErrEmailRegistered = errors.New("Email already registered")
 var ErrInvalidDateFormat = errors.New("Invalid date format")
 var ErrInvalidUuidFormat = errors.New("Invalid uuid format")
 var ErrInvalidEmailFormat = errors.New("Invalid email format")
+var ErrStorageUnavailable = errors.New("Storage unavailable")
+var ErrStorageTimeout = errors.New("Storage timeout")
+var ErrVersionMismatch = errors.New("User version does not match")
+var ErrConcurrentUpdate = errors.New("User was modified concurrently")
+var ErrEmailDomainNotAllowed = errors.New("Email domain not allowed")
+
+// UPDATE_ATTEMPTS bounds how many times an unconditional update is retried
+// after losing a race with another writer.
+const UPDATE_ATTEMPTS = 3
+
+// serviceError reports kind to clients while keeping the repository error
+// that caused it reachable through errors.Is and errors.As.
+type serviceError struct {
+	kind  error
+	cause error
+}
+
+func (e serviceError) Error() string {
+	return e.kind.Error()
+}
+
+func (e serviceError) Unwrap() []error {
+	return []error{e.kind, e.cause}
+}
+
+// FieldError is a failure concerning a single field, named as clients know
+// it. errors.Is sees through it to Err.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e FieldError) Error() string {
+	return e.Err.Error() + ": " + e.Field
+}
+
+func (e FieldError) Unwrap() error {
+	return e.Err
+}
+
+// translateError maps repository errors to the service errors exposed to
+// controllers. Unrecognized errors are returned unchanged.
+func translateError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, interfaces.ErrNotFound):
+		return serviceError{ErrUserNotFound, err}
+	case errors.Is(err, interfaces.ErrEmailRegistered):
+		return serviceError{ErrEmailRegistered, err}
+	case errors.Is(err, interfaces.ErrVersionConflict):
+		return serviceError{ErrConcurrentUpdate, err}
+	case errors.Is(err, interfaces.ErrTimeout):
+		return serviceError{ErrStorageTimeout, err}
+	case errors.Is(err, interfaces.ErrUnavailable):
+		return serviceError{ErrStorageUnavailable, err}
+	}
+	return err
+}
 
 type UserService struct {
 	interfaces.UserRepository
+	Emails emails.Normalizer
+	// Tenant is the tenant the service acts for, set by ForTenant. Its
+	// settings restrict the users it creates and updates.
+	Tenant models.Tenant
+	// Rules validates the fields of users, DefaultRules when nil.
+	Rules Rules
+}
+
+// ForTenant returns a service restricted to the users of tenant.
+func (s UserService) ForTenant(tenant models.Tenant) interfaces.UserService {
+	s.UserRepository = s.UserRepository.ForTenant(tenant.ID)
+	s.Tenant = tenant
+	return s
 }
 
 func (s UserService) GetUser(user_uuid string) (models.User, error) {
@@ -31,58 +105,246 @@ func (s UserService) GetUser(user_uuid string) (models.User, error) {
 	user, err := s.UserRepository.GetUserByUUID(u)
 
 	if err != nil {
-		return models.User{}, ErrUserNotFound
+		return models.User{}, translateError(err)
 	}
 
-	return user, err
+	return user, nil
 }
 
-func (s UserService) CreateUser(name, birthDate, email, address, password string) (models.User, error) {
-	bd, err := time.Parse("2006-01-02", birthDate)
+// GetCurrentUser reads the user inside a transaction, which a cached
+// repository serves from the database.
+func (s UserService) GetCurrentUser(user_uuid string) (models.User, error) {
+	u, err := uuid.Parse(user_uuid)
+
 	if err != nil {
-		return models.User{}, ErrInvalidDateFormat
+		return models.User{}, ErrInvalidUuidFormat
 	}
 
-	_, err = mail.ParseAddress(email)
+	var user models.User
+	err = s.UserRepository.WithTransaction(func(tx interfaces.UserRepository) error {
+		user, err = tx.GetUserByUUID(u)
+		return err
+	})
+
 	if err != nil {
-		return models.User{}, ErrInvalidEmailFormat
+		return models.User{}, translateError(err)
 	}
 
-	exists, _ := s.UserRepository.UserExistsWithEmail(email)
+	return user, nil
+}
 
-	if exists == true {
-		return models.User{}, ErrEmailRegistered
+func (s UserService) CreateUser(name, birthDate, email, address, password string) (models.User, error) {
+	return s.CreateUserWithParams(map[string]string{
+		"name":       name,
+		"birth_date": birthDate,
+		"email":      email,
+		"address":    address,
+		"password":   password,
+	})
+}
+
+// CreateUserWithParams creates the user in params, which may give the
+// address by its parts, as in ADDRESS_FIELDS, rather than on a single line.
+func (s UserService) CreateUserWithParams(params map[string]string) (models.User, error) {
+	user, err := s.newUser(params)
+	if err != nil {
+		return models.User{}, err
 	}
 
-	c := sha256.New()
-	h := c.Sum([]byte(password))
+	err = s.UserRepository.WithTransaction(func(tx interfaces.UserRepository) error {
+		exists, err := tx.UserExistsWithEmail(user.EmailCanonical)
+
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			return ErrEmailRegistered
+		}
 
-	p := hex.EncodeToString(h)
+		user, err = tx.CreateUser(user)
 
-	user := models.User{
-		Name:      name,
-		BirthDate: bd,
-		Email:     email,
-		Address:   address,
-		Password:  p,
+		if err != nil {
+			return err
+		}
+
+		return tx.AddEvent(s.newEvent(models.EVENT_USER_CREATED, user.UUID, nil))
+	})
+
+	if err != nil {
+		return models.User{}, translateError(err)
 	}
 
-	user, err = s.UserRepository.CreateUser(user)
+	return user, nil
+}
+
+// newUser validates the fields of a user to create, in params, and returns
+// it as it is to be stored.
+func (s UserService) newUser(params map[string]string) (models.User, error) {
+	params, parts := resolveAddress(models.Address{}, params)
+	email := strings.TrimSpace(params["email"])
+
+	err := s.validateFields(map[string]string{
+		"name":       params["name"],
+		"birth_date": params["birth_date"],
+		"email":      email,
+		"address":    params["address"],
+		"password":   params["password"],
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	canonical, _ := s.Emails.Canonical(email)
+
+	return models.User{
+		Name:           params["name"],
+		BirthDate:      parseBirthDate(params["birth_date"]),
+		Email:          email,
+		EmailCanonical: canonical,
+		Address:        params["address"],
+		AddressParts:   parts,
+		SearchTrigrams: search.Trigrams(params["name"], params["address"]),
+		Password:       hashPassword(params["password"]),
+	}, nil
+}
+
+// parseBirthDate parses a validated birth date, which is empty when rules
+// make it optional.
+func parseBirthDate(birthDate string) time.Time {
+	if birthDate == "" {
+		return time.Time{}
+	}
+	t, _ := time.Parse(models.DATE_FORMAT, birthDate)
+	return t
+}
+
+func hashPassword(password string) string {
+	c := sha256.New()
+	h := c.Sum([]byte(password))
 
-	return user, err
+	return hex.EncodeToString(h)
 }
 
 func (s UserService) UpdateUser(user_uuid string, params map[string]string) (models.User, error) {
+	return s.updateUser(user_uuid, nil, params)
+}
+
+// UpdateUserIfMatch applies params only if the stored user is at version,
+// returning ErrVersionMismatch otherwise.
+func (s UserService) UpdateUserIfMatch(user_uuid string, version int64, params map[string]string) (models.User, error) {
+	return s.updateUser(user_uuid, &version, params)
+}
+
+func (s UserService) updateUser(user_uuid string, version *int64, params map[string]string) (models.User, error) {
 	u, err := uuid.Parse(user_uuid)
 
 	if err != nil {
 		return models.User{}, ErrInvalidUuidFormat
 	}
 
-	user, err := s.UserRepository.GetUserByUUID(u)
+	for attempt := 0; attempt < UPDATE_ATTEMPTS; attempt++ {
+		var user models.User
 
-	if err != nil {
-		return models.User{}, ErrUserNotFound
+		err := s.UserRepository.WithTransaction(func(tx interfaces.UserRepository) error {
+			current, err := tx.GetUserByUUID(u)
+
+			if err != nil {
+				return err
+			}
+
+			if version != nil && current.Version != *version {
+				return ErrVersionMismatch
+			}
+
+			updated, err := s.applyParams(tx, current, params)
+
+			if err != nil {
+				return err
+			}
+
+			changed := changedFields(current, updated)
+
+			if len(changed) == 0 {
+				user = current
+				return nil
+			}
+
+			user, err = tx.UpdateUser(updated)
+
+			if err != nil {
+				return err
+			}
+
+			return tx.AddEvent(s.newEvent(models.EVENT_USER_UPDATED, user.UUID, changed))
+		})
+
+		if errors.Is(err, interfaces.ErrVersionConflict) {
+			if version != nil {
+				return models.User{}, ErrVersionMismatch
+			}
+			continue
+		}
+
+		if err != nil {
+			return models.User{}, translateError(err)
+		}
+
+		return user, nil
+	}
+
+	return models.User{}, ErrConcurrentUpdate
+}
+
+// changedFields names, as clients know them, the fields that differ between
+// before and after.
+func changedFields(before, after models.User) []string {
+	changed := []string{}
+
+	if before.Name != after.Name {
+		changed = append(changed, "name")
+	}
+	if !before.BirthDate.Equal(after.BirthDate) {
+		changed = append(changed, "birth_date")
+	}
+	if before.Email != after.Email {
+		changed = append(changed, "email")
+	}
+	if before.Address != after.Address || before.StructuredAddress() != after.StructuredAddress() {
+		changed = append(changed, "address")
+	}
+	if before.Password != after.Password {
+		changed = append(changed, "password")
+	}
+
+	return changed
+}
+
+func (s UserService) newEvent(eventType string, user_uuid uuid.UUID, fields []string) models.Event {
+	return models.Event{
+		ID:       uuid.New(),
+		Type:     eventType,
+		UserUUID: user_uuid,
+		TenantID: s.Tenant.ID,
+		Fields:   fields,
+		Occurred: time.Now(),
+	}
+}
+
+func (s UserService) applyParams(repository interfaces.UserRepository, user models.User, params map[string]string) (models.User, error) {
+	params, parts := resolveAddress(user.StructuredAddress(), params)
+
+	if email, ok := params["email"]; ok {
+		trimmed := make(map[string]string, len(params))
+		for field, value := range params {
+			trimmed[field] = value
+		}
+		trimmed["email"] = strings.TrimSpace(email)
+		params = trimmed
+	}
+
+	if err := s.validateFields(params); err != nil {
+		return user, err
 	}
 
 	name, ok := params["name"]
@@ -92,45 +354,57 @@ func (s UserService) UpdateUser(user_uuid string, params map[string]string) (mod
 
 	bd, ok := params["birth_date"]
 	if ok {
-		t, err := time.Parse("2006-01-01", bd)
-
-		if err != nil {
-			return user, ErrInvalidDateFormat
-		}
-		user.BirthDate = t
+		user.BirthDate = parseBirthDate(bd)
 	}
 
 	password, ok := params["password"]
 	if ok {
-		s := sha256.New()
-		h := s.Sum([]byte(password))
-		p := hex.EncodeToString(h)
-		user.Password = p
+		user.Password = hashPassword(password)
 	}
 
+	// An address sent on a single line has no parts.
 	address, ok := params["address"]
 	if ok {
 		user.Address = address
+		user.AddressParts = parts
 	}
 
 	email, ok := params["email"]
 	if ok {
-		_, err = mail.ParseAddress(email)
+		canonical, _ := s.Emails.Canonical(email)
+
+		exists, err := repository.UserExistsWithEmailAndNotUuid(canonical, user.UUID)
+
 		if err != nil {
-			return models.User{}, ErrInvalidEmailFormat
+			return models.User{}, err
 		}
 
-		exists, _ := s.UserRepository.UserExistsWithEmailAndNotUuid(email, u)
-
 		if exists {
 			return models.User{}, ErrEmailRegistered
 		}
 		user.Email = email
+		user.EmailCanonical = canonical
+	} else if user.EmailCanonical == "" {
+		// Users stored before canonical emails existed are given theirs,
+		// unless another user already has it. They are then saved without
+		// one, as migration 2 reports such users rather than merging them.
+		canonical, err := s.Emails.Canonical(user.Email)
+		if err == nil {
+			exists, err := repository.UserExistsWithEmailAndNotUuid(canonical, user.UUID)
+
+			if err != nil {
+				return models.User{}, err
+			}
+
+			if !exists {
+				user.EmailCanonical = canonical
+			}
+		}
 	}
 
-	user, err = s.UserRepository.UpdateUser(user)
+	user.SearchTrigrams = search.Trigrams(user.Name, user.Address)
 
-	return user, err
+	return user, nil
 }
 
 func (s UserService) DeleteUser(user_uuid string) error {
@@ -140,11 +414,70 @@ func (s UserService) DeleteUser(user_uuid string) error {
 		return ErrInvalidUuidFormat
 	}
 
-	_, err = s.UserRepository.GetUserByUUID(u)
+	err = s.UserRepository.WithTransaction(func(tx interfaces.UserRepository) error {
+		_, err := tx.GetUserByUUID(u)
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.DeleteUser(u)
+
+		if err != nil {
+			return err
+		}
+
+		return tx.AddEvent(s.newEvent(models.EVENT_USER_DELETED, u, nil))
+	})
+
+	return translateError(err)
+
+}
+
+func (s UserService) RestoreUser(user_uuid string) (models.User, error) {
+	u, err := uuid.Parse(user_uuid)
+
+	if err != nil {
+		return models.User{}, ErrInvalidUuidFormat
+	}
+
+	var user models.User
+
+	err = s.UserRepository.WithTransaction(func(tx interfaces.UserRepository) error {
+		user, err = tx.RestoreUser(u)
+
+		if err != nil {
+			return err
+		}
+
+		return tx.AddEvent(s.newEvent(models.EVENT_USER_RESTORED, u, nil))
+	})
+
+	if err != nil {
+		return models.User{}, translateError(err)
+	}
+
+	return user, nil
+}
+
+func (s UserService) ListDeletedUsers() ([]models.User, error) {
+	users, err := s.UserRepository.ListDeletedUsers()
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return users, nil
+}
+
+// PurgeDeletedUsers permanently removes users that were soft deleted more
+// than retention ago.
+func (s UserService) PurgeDeletedUsers(retention time.Duration) (int64, error) {
+	purged, err := s.UserRepository.PurgeDeletedUsers(time.Now().Add(-retention))
 
 	if err != nil {
-		return ErrUserNotFound
+		return 0, translateError(err)
 	}
-	return s.UserRepository.DeleteUser(u)
 
+	return purged, nil
 }
@@ -5,10 +5,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ffardo/user-crud/interfaces"
 	"github.com/ffardo/user-crud/interfaces/mocks"
 	"github.com/ffardo/user-crud/models"
+	"github.com/ffardo/user-crud/repositories"
+	"github.com/ffardo/user-crud/search"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestGetUser(t *testing.T) {
@@ -28,7 +32,7 @@ func TestGetUser(t *testing.T) {
 
 	userRepository.On("GetUserByUUID", u).Return(user, nil)
 
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 
 	expectedResult := user
 
@@ -45,19 +49,73 @@ func TestGetMissingUser(t *testing.T) {
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 	u := uuid.MustParse(user_uuid)
 
-	userRepository.On("GetUserByUUID", u).Return(user, errors.New("could not find document"))
+	userRepository.On("GetUserByUUID", u).Return(user, interfaces.ErrNotFound)
 
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 
 	_, err := userService.GetUser(user_uuid)
 
-	assert.Equal(t, err, ErrUserNotFound)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	assert.ErrorIs(t, err, interfaces.ErrNotFound)
+}
+
+func TestGetCurrentUserBypassesStaleCache(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	// Another instance updates the user after this one cached it.
+	userRepository.On("GetUserByUUID", u).Return(models.User{UUID: u, Version: 3}, nil).Once()
+	userRepository.On("GetUserByUUID", u).Return(models.User{UUID: u, Version: 4}, nil)
+
+	userService := UserService{UserRepository: repositories.NewCachedUserRepository(userRepository, 10, time.Minute, time.Minute)}
+
+	userService.GetUser(u.String())
+	cached, err := userService.GetUser(u.String())
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), cached.Version)
+
+	current, err := userService.GetCurrentUser(u.String())
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), current.Version)
+}
+
+func TestGetUserStorageUnavailable(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+	u := uuid.MustParse(user_uuid)
+
+	userRepository.On("GetUserByUUID", u).Return(models.User{}, interfaces.ErrUnavailable)
+
+	userService := UserService{UserRepository: userRepository}
+
+	_, err := userService.GetUser(user_uuid)
+
+	assert.ErrorIs(t, err, ErrStorageUnavailable)
+	assert.NotErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestGetUserStorageTimeout(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+	u := uuid.MustParse(user_uuid)
+
+	userRepository.On("GetUserByUUID", u).Return(models.User{}, interfaces.ErrTimeout)
+
+	userService := UserService{UserRepository: userRepository}
+
+	_, err := userService.GetUser(user_uuid)
+
+	assert.ErrorIs(t, err, ErrStorageTimeout)
 }
 
 func TestGetUserWithInvalidUUID(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
 
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 
 	_, err := userService.GetUser("not an uuid")
 
@@ -66,7 +124,7 @@ func TestGetUserWithInvalidUUID(t *testing.T) {
 
 func TestCreateUser(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 	user_bd, _ := time.Parse("2006-01-02", "1970-01-31")
 
@@ -76,11 +134,13 @@ func TestCreateUser(t *testing.T) {
 	name := "John Doe"
 
 	user := models.User{
-		BirthDate: user_bd,
-		Name:      name,
-		Email:     email,
-		Password:  password,
-		Address:   address,
+		BirthDate:      user_bd,
+		Name:           name,
+		Email:          email,
+		EmailCanonical: email,
+		Password:       password,
+		Address:        address,
+		SearchTrigrams: search.Trigrams(name, address),
 	}
 
 	created_user := models.User{
@@ -98,6 +158,10 @@ func TestCreateUser(t *testing.T) {
 		"CreateUser", user,
 	).Return(created_user, nil)
 
+	userRepository.On("AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return e.Type == models.EVENT_USER_CREATED && e.UserUUID == created_user.UUID
+	})).Return(nil)
+
 	ret_user, err := userService.CreateUser(
 		"John Doe", "1970-01-31", "joe25@mailprovider.com", "3197 Woodrow Way", "secret",
 	)
@@ -107,9 +171,23 @@ func TestCreateUser(t *testing.T) {
 
 }
 
+func TestCreateUserChecksCanonicalEmail(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("UserExistsWithEmail", "joe@mail.com").Return(true, nil)
+
+	_, err := userService.CreateUser(
+		"John Doe", "1970-01-31", " Joe@Mail.com ", "3197 Woodrow Way", "secret",
+	)
+
+	assert.Equal(t, err, ErrEmailRegistered)
+	userRepository.AssertExpectations(t)
+}
+
 func TestCreateUserWithInvalidBirthDate(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 
 	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(false, nil)
 
@@ -119,13 +197,14 @@ func TestCreateUserWithInvalidBirthDate(t *testing.T) {
 
 	userRepository.AssertNotCalled(t, "CreateUser")
 
-	assert.Equal(t, err, ErrInvalidDateFormat)
+	assert.ErrorIs(t, err, ErrInvalidDateFormat)
+	assert.ErrorIs(t, err, ErrValidationFailed)
 
 }
 
 func TestCreateUserWithInvalidEmail(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 
 	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(false, nil)
 
@@ -135,13 +214,14 @@ func TestCreateUserWithInvalidEmail(t *testing.T) {
 
 	userRepository.AssertNotCalled(t, "CreateUser")
 
-	assert.Equal(t, err, ErrInvalidEmailFormat)
+	assert.ErrorIs(t, err, ErrInvalidEmailFormat)
+	assert.ErrorIs(t, err, ErrValidationFailed)
 
 }
 
 func TestCreateUserWithExistingEmail(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 
 	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(true, nil)
 
@@ -156,7 +236,7 @@ func TestCreateUserWithExistingEmail(t *testing.T) {
 
 func TestUpdateUser(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 
 	address := "3197 Woodrow Way"
@@ -165,25 +245,31 @@ func TestUpdateUser(t *testing.T) {
 	name := "John Doe"
 
 	original_user := models.User{
-		UUID:      uuid.MustParse(user_uuid),
-		BirthDate: time.Now(),
-		Name:      name,
-		Email:     email,
-		Password:  password,
-		Address:   address,
+		UUID:           uuid.MustParse(user_uuid),
+		BirthDate:      time.Now(),
+		Name:           name,
+		Email:          email,
+		EmailCanonical: email,
+		Password:       password,
+		Address:        address,
 	}
 
 	modified_user := models.User{
-		UUID:      original_user.UUID,
-		BirthDate: original_user.BirthDate,
-		Name:      "John Nobody",
-		Email:     email,
-		Password:  password,
-		Address:   address,
+		UUID:           original_user.UUID,
+		BirthDate:      original_user.BirthDate,
+		Name:           "John Nobody",
+		Email:          email,
+		EmailCanonical: email,
+		Password:       password,
+		Address:        address,
+		SearchTrigrams: search.Trigrams("John Nobody", address),
 	}
 
 	userRepository.On("GetUserByUUID", uuid.MustParse(user_uuid)).Return(original_user, nil)
 	userRepository.On("UpdateUser", modified_user).Return(modified_user, nil)
+	userRepository.On("AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return e.Type == models.EVENT_USER_UPDATED && assert.ObjectsAreEqual([]string{"name"}, e.Fields)
+	})).Return(nil)
 
 	params := map[string]string{
 		"name":     "John Nobody",
@@ -196,14 +282,73 @@ func TestUpdateUser(t *testing.T) {
 	assert.Equal(t, modified_user.Password, update_user.Password)
 }
 
+func TestUpdateLegacyUserName(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	// Stored before canonical emails and schema versions existed.
+	legacy := models.User{
+		UUID:    user_uuid,
+		Name:    "John Doe",
+		Email:   "Joe25@MailProvider.com",
+		Address: "3197 Woodrow Way",
+	}
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(legacy, nil)
+	userRepository.On("UserExistsWithEmailAndNotUuid", "joe25@mailprovider.com", user_uuid).Return(false, nil)
+	userRepository.On("UpdateUser", mock.MatchedBy(func(user models.User) bool {
+		return user.EmailCanonical == "joe25@mailprovider.com" && user.SchemaVersion == 0
+	})).Return(func(user models.User) models.User {
+		return user
+	}, nil)
+	userRepository.On("AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return assert.ObjectsAreEqual([]string{"name"}, e.Fields)
+	})).Return(nil)
+
+	user, err := userService.UpdateUserIfMatch(user_uuid.String(), 0, map[string]string{"name": "John Nobody"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "John Nobody", user.Name)
+	assert.Equal(t, "joe25@mailprovider.com", user.EmailCanonical)
+}
+
+func TestUpdateLegacyUserSharingCanonicalEmail(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	legacy := models.User{
+		UUID:    user_uuid,
+		Name:    "John Doe",
+		Email:   "Joe25@MailProvider.com",
+		Address: "3197 Woodrow Way",
+	}
+
+	userRepository.On("GetUserByUUID", user_uuid).Return(legacy, nil)
+	userRepository.On("UserExistsWithEmailAndNotUuid", "joe25@mailprovider.com", user_uuid).Return(true, nil)
+	userRepository.On("UpdateUser", mock.MatchedBy(func(user models.User) bool {
+		return user.EmailCanonical == ""
+	})).Return(func(user models.User) models.User {
+		return user
+	}, nil)
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	user, err := userService.UpdateUserIfMatch(user_uuid.String(), 0, map[string]string{"name": "John Nobody"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "John Nobody", user.Name)
+	assert.Empty(t, user.EmailCanonical)
+}
+
 func TestUpdateUserNotFound(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 
 	u := uuid.MustParse(user_uuid)
 
-	userRepository.On("GetUserByUUID", u).Return(models.User{}, errors.New("document not found"))
+	userRepository.On("GetUserByUUID", u).Return(models.User{}, interfaces.ErrNotFound)
 
 	params := map[string]string{
 		"name":     "John Nobody",
@@ -211,14 +356,14 @@ func TestUpdateUserNotFound(t *testing.T) {
 	}
 
 	_, err := userService.UpdateUser(user_uuid, params)
-	assert.Equal(t, err, ErrUserNotFound)
+	assert.ErrorIs(t, err, ErrUserNotFound)
 
 }
 
 func TestUpdateUserWithInvalidUUID(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
 
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 
 	params := map[string]string{
 		"name":       "John Nobody",
@@ -233,7 +378,7 @@ func TestUpdateUserWithInvalidUUID(t *testing.T) {
 
 func TestUpdateUserWithInvalidDateFormat(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 	user := models.User{
 		UUID:      uuid.MustParse(user_uuid),
@@ -254,13 +399,14 @@ func TestUpdateUserWithInvalidDateFormat(t *testing.T) {
 
 	_, err := userService.UpdateUser(user_uuid, params)
 
-	assert.Equal(t, err, ErrInvalidDateFormat)
+	assert.ErrorIs(t, err, ErrInvalidDateFormat)
+	assert.ErrorIs(t, err, ErrValidationFailed)
 
 }
 
 func TestUpdateUserWithEmailCollision(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 
 	user := models.User{
@@ -289,7 +435,7 @@ func TestUpdateUserWithEmailCollision(t *testing.T) {
 
 func TestDeleteUser(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 
 	u := uuid.MustParse(user_uuid)
@@ -306,16 +452,21 @@ func TestDeleteUser(t *testing.T) {
 	userRepository.On("GetUserByUUID", u).Return(user, nil)
 
 	userRepository.On("DeleteUser", u).Return(nil)
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
 
 	err := userService.DeleteUser(user_uuid)
 	assert.Equal(t, err, nil)
 
+	userRepository.AssertCalled(t, "AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return e.Type == models.EVENT_USER_DELETED && e.UserUUID == u
+	}))
+
 }
 
 func TestDeleteUserWithInvalidUUID(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
 
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 
 	err := userService.DeleteUser("not an uuid")
 
@@ -324,14 +475,409 @@ func TestDeleteUserWithInvalidUUID(t *testing.T) {
 
 func TestDeleteUserNotFound(t *testing.T) {
 	userRepository := new(mocks.UserRepository)
-	userService := UserService{userRepository}
+	userService := UserService{UserRepository: userRepository}
 	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
 
 	u := uuid.MustParse(user_uuid)
 
-	userRepository.On("GetUserByUUID", u).Return(models.User{}, errors.New("document not found"))
+	userRepository.On("GetUserByUUID", u).Return(models.User{}, interfaces.ErrNotFound)
 
 	err := userService.DeleteUser(user_uuid)
-	assert.Equal(t, ErrUserNotFound, err)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+}
+
+func TestCreateUserWithConcurrentEmailRegistration(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(false, nil)
+	userRepository.On("CreateUser", mock.Anything).Return(models.User{}, interfaces.ErrEmailRegistered)
+
+	_, err := userService.CreateUser(
+		"John Doe", "1970-01-01", "joe25@mailprovider.com", "3197 Woodrow Way", "secret",
+	)
+
+	assert.ErrorIs(t, err, ErrEmailRegistered)
+}
+
+func TestCreateUserWithEmailCheckFailure(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	checkErr := errors.New("connection refused")
+
+	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(false, checkErr)
+
+	_, err := userService.CreateUser(
+		"John Doe", "1970-01-01", "joe25@mailprovider.com", "3197 Woodrow Way", "secret",
+	)
+
+	userRepository.AssertNotCalled(t, "CreateUser")
+
+	assert.Equal(t, checkErr, err)
+}
+
+func TestUpdateUserWithConcurrentEmailRegistration(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	user := models.User{
+		UUID:      uuid.MustParse(user_uuid),
+		BirthDate: time.Now(),
+		Name:      "John Doe",
+		Email:     "joe25@mailprovider.com",
+		Password:  "some_password",
+		Address:   "3197 Woodrow Way",
+	}
+
+	userRepository.On("GetUserByUUID", uuid.MustParse(user_uuid)).Return(user, nil)
+	userRepository.On("UserExistsWithEmailAndNotUuid", "different_email@mailprovider.com", uuid.MustParse(user_uuid)).Return(false, nil)
+	userRepository.On("UpdateUser", mock.Anything).Return(models.User{}, interfaces.ErrEmailRegistered)
+
+	params := map[string]string{
+		"email": "different_email@mailprovider.com",
+	}
+
+	_, err := userService.UpdateUser(user_uuid, params)
+
+	assert.ErrorIs(t, err, ErrEmailRegistered)
+}
+
+func TestUpdateUserIfMatch(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	user := models.User{
+		UUID:    uuid.MustParse(user_uuid),
+		Name:    "John Doe",
+		Version: 4,
+	}
+
+	modified_user := user
+	modified_user.Name = "John Nobody"
+	modified_user.SearchTrigrams = search.Trigrams("John Nobody")
+
+	stored_user := modified_user
+	stored_user.Version = 5
+
+	userRepository.On("GetUserByUUID", uuid.MustParse(user_uuid)).Return(user, nil)
+	userRepository.On("UpdateUser", modified_user).Return(stored_user, nil)
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	params := map[string]string{
+		"name": "John Nobody",
+	}
+
+	updated_user, err := userService.UpdateUserIfMatch(user_uuid, 4, params)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int64(5), updated_user.Version)
+}
+
+func TestUpdateUserIfMatchWithStaleVersion(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	user := models.User{
+		UUID:    uuid.MustParse(user_uuid),
+		Name:    "John Doe",
+		Version: 5,
+	}
+
+	userRepository.On("GetUserByUUID", uuid.MustParse(user_uuid)).Return(user, nil)
+
+	params := map[string]string{
+		"name": "John Nobody",
+	}
+
+	_, err := userService.UpdateUserIfMatch(user_uuid, 4, params)
+
+	userRepository.AssertNotCalled(t, "UpdateUser", mock.Anything)
+
+	assert.Equal(t, ErrVersionMismatch, err)
+}
+
+func TestUpdateUserIfMatchLosingRace(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	user := models.User{
+		UUID:    uuid.MustParse(user_uuid),
+		Name:    "John Doe",
+		Version: 4,
+	}
+
+	userRepository.On("GetUserByUUID", uuid.MustParse(user_uuid)).Return(user, nil)
+	userRepository.On("UpdateUser", mock.Anything).Return(models.User{}, interfaces.ErrVersionConflict)
+
+	params := map[string]string{
+		"name": "John Nobody",
+	}
+
+	_, err := userService.UpdateUserIfMatch(user_uuid, 4, params)
+
+	userRepository.AssertNumberOfCalls(t, "UpdateUser", 1)
+
+	assert.Equal(t, ErrVersionMismatch, err)
+}
+
+func TestUpdateUserRetriesAfterVersionConflict(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	stale_user := models.User{
+		UUID:    uuid.MustParse(user_uuid),
+		Name:    "John Doe",
+		Address: "3197 Woodrow Way",
+		Version: 4,
+	}
+
+	fresh_user := stale_user
+	fresh_user.Address = "1 Infinite Loop"
+	fresh_user.Version = 5
+
+	modified_user := fresh_user
+	modified_user.Name = "John Nobody"
+	modified_user.SearchTrigrams = search.Trigrams("John Nobody", "1 Infinite Loop")
+
+	userRepository.On("GetUserByUUID", uuid.MustParse(user_uuid)).Return(stale_user, nil).Once()
+	userRepository.On("GetUserByUUID", uuid.MustParse(user_uuid)).Return(fresh_user, nil).Once()
+	userRepository.On("UpdateUser", mock.Anything).Return(models.User{}, interfaces.ErrVersionConflict).Once()
+	userRepository.On("UpdateUser", modified_user).Return(modified_user, nil).Once()
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	params := map[string]string{
+		"name": "John Nobody",
+	}
+
+	updated_user, err := userService.UpdateUser(user_uuid, params)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "1 Infinite Loop", updated_user.Address)
+	assert.Equal(t, "John Nobody", updated_user.Name)
+}
+
+func TestUpdateUserGivesUpAfterRepeatedConflicts(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	user := models.User{
+		UUID: uuid.MustParse(user_uuid),
+		Name: "John Doe",
+	}
+
+	userRepository.On("GetUserByUUID", uuid.MustParse(user_uuid)).Return(user, nil)
+	userRepository.On("UpdateUser", mock.Anything).Return(models.User{}, interfaces.ErrVersionConflict)
+
+	params := map[string]string{
+		"name": "John Nobody",
+	}
+
+	_, err := userService.UpdateUser(user_uuid, params)
+
+	userRepository.AssertNumberOfCalls(t, "UpdateUser", UPDATE_ATTEMPTS)
+
+	assert.Equal(t, ErrConcurrentUpdate, err)
+}
+
+func TestRestoreUser(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	user := models.User{
+		UUID: uuid.MustParse(user_uuid),
+		Name: "John Doe",
+	}
+
+	userRepository.On("RestoreUser", uuid.MustParse(user_uuid)).Return(user, nil)
+	userRepository.On("AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return e.Type == models.EVENT_USER_RESTORED
+	})).Return(nil)
+
+	restored_user, err := userService.RestoreUser(user_uuid)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, user, restored_user)
+}
+
+func TestRestoreUserWithReusedEmail(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	userRepository.On("RestoreUser", uuid.MustParse(user_uuid)).Return(models.User{}, interfaces.ErrEmailRegistered)
+
+	_, err := userService.RestoreUser(user_uuid)
+
+	assert.ErrorIs(t, err, ErrEmailRegistered)
+}
+
+func TestRestoreUserNotDeleted(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	userRepository.On("RestoreUser", uuid.MustParse(user_uuid)).Return(models.User{}, interfaces.ErrNotFound)
+
+	_, err := userService.RestoreUser(user_uuid)
+
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	retention := 48 * time.Hour
+	expectedCutoff := time.Now().Add(-retention)
+
+	userRepository.On("PurgeDeletedUsers", mock.MatchedBy(func(before time.Time) bool {
+		return before.Sub(expectedCutoff) < time.Minute && expectedCutoff.Sub(before) < time.Minute
+	})).Return(int64(3), nil)
+
+	purged, err := userService.PurgeDeletedUsers(retention)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, int64(3), purged)
+}
+
+// failingCommit runs transactions against the mock but fails to commit them.
+type failingCommit struct {
+	*mocks.UserRepository
+	err error
+}
+
+func (f failingCommit) WithTransaction(fn func(interfaces.UserRepository) error) error {
+	if err := fn(f.UserRepository); err != nil {
+		return err
+	}
+	return f.err
+}
+
+func TestCreateUserCommitFailure(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: failingCommit{userRepository, interfaces.ErrUnavailable}}
+
+	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(false, nil)
+	userRepository.On("CreateUser", mock.Anything).Return(models.User{UUID: uuid.New()}, nil)
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	_, err := userService.CreateUser(
+		"John Doe", "1970-01-01", "joe25@mailprovider.com", "3197 Woodrow Way", "secret",
+	)
+
+	assert.ErrorIs(t, err, ErrStorageUnavailable)
+}
+
+func TestDeleteUserCommitFailure(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: failingCommit{userRepository, interfaces.ErrTimeout}}
+	u := uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40")
+
+	userRepository.On("GetUserByUUID", u).Return(models.User{UUID: u}, nil)
+	userRepository.On("DeleteUser", u).Return(nil)
+	userRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	err := userService.DeleteUser(u.String())
+
+	assert.ErrorIs(t, err, ErrStorageTimeout)
+}
+
+func TestUpdateUserWithoutChanges(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+	user_uuid := "d035e79d-ffe9-4ebf-b665-747353b3ea40"
+
+	user := models.User{
+		UUID:    uuid.MustParse(user_uuid),
+		Name:    "John Doe",
+		Address: "3197 Woodrow Way",
+	}
+
+	userRepository.On("GetUserByUUID", uuid.MustParse(user_uuid)).Return(user, nil)
+
+	params := map[string]string{
+		"name": "John Doe",
+	}
+
+	updated_user, err := userService.UpdateUser(user_uuid, params)
+
+	assert.Equal(t, err, nil)
+	assert.Equal(t, user, updated_user)
+	userRepository.AssertNotCalled(t, "UpdateUser", mock.Anything)
+	userRepository.AssertNotCalled(t, "AddEvent", mock.Anything)
+}
+
+func TestCreateUserEventFailure(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{UserRepository: userRepository}
+
+	userRepository.On("UserExistsWithEmail", "joe25@mailprovider.com").Return(false, nil)
+	userRepository.On("CreateUser", mock.Anything).Return(models.User{UUID: uuid.New()}, nil)
+	userRepository.On("AddEvent", mock.Anything).Return(interfaces.ErrTimeout)
+
+	_, err := userService.CreateUser(
+		"John Doe", "1970-01-01", "joe25@mailprovider.com", "3197 Woodrow Way", "secret",
+	)
+
+	assert.ErrorIs(t, err, ErrStorageTimeout)
+}
+
+func TestForTenantScopesRepositoryAndEvents(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	tenantRepository := new(mocks.UserRepository)
+	tenant := models.Tenant{ID: "acme"}
+
+	userRepository.On("ForTenant", "acme").Return(tenantRepository)
+
+	created := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), TenantID: "acme"}
+	tenantRepository.On("UserExistsWithEmail", "joe@acme.com").Return(false, nil)
+	tenantRepository.On("CreateUser", mock.Anything).Return(created, nil)
+	tenantRepository.On("AddEvent", mock.Anything).Return(nil)
+
+	userService := UserService{UserRepository: userRepository}.ForTenant(tenant)
+
+	_, err := userService.CreateUser("John Doe", "1970-01-31", "joe@acme.com", "3197 Woodrow Way", "secret")
+
+	assert.NoError(t, err)
+	userRepository.AssertNotCalled(t, "CreateUser", mock.Anything)
+	tenantRepository.AssertCalled(t, "AddEvent", mock.MatchedBy(func(e models.Event) bool {
+		return e.TenantID == "acme" && e.UserUUID == created.UUID
+	}))
+}
+
+func TestCreateUserEmailDomainNotAllowed(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{
+		UserRepository: userRepository,
+		Tenant:         models.Tenant{ID: "acme", Settings: models.TenantSettings{EmailDomains: []string{"acme.com"}}},
+	}
+
+	_, err := userService.CreateUser("John Doe", "1970-01-31", "joe@mailprovider.com", "3197 Woodrow Way", "secret")
+
+	assert.ErrorIs(t, err, ErrEmailDomainNotAllowed)
+	userRepository.AssertNotCalled(t, "CreateUser", mock.Anything)
+}
+
+func TestUpdateUserEmailDomainNotAllowed(t *testing.T) {
+	userRepository := new(mocks.UserRepository)
+	userService := UserService{
+		UserRepository: userRepository,
+		Tenant:         models.Tenant{ID: "acme", Settings: models.TenantSettings{EmailDomains: []string{"acme.com"}}},
+	}
+	user := models.User{UUID: uuid.MustParse("d035e79d-ffe9-4ebf-b665-747353b3ea40"), Email: "joe@acme.com"}
+
+	userRepository.On("GetUserByUUID", user.UUID).Return(user, nil)
+
+	_, err := userService.UpdateUser(user.UUID.String(), map[string]string{"email": "joe@ACME.org"})
 
+	assert.ErrorIs(t, err, ErrEmailDomainNotAllowed)
+	userRepository.AssertNotCalled(t, "UpdateUser", mock.Anything)
 }
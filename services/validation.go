@@ -0,0 +1,259 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ffardo/user-crud/models"
+)
+
+var ErrValidationFailed = errors.New("Invalid user")
+var ErrFieldRequired = errors.New("Field is required")
+var ErrFieldTooShort = errors.New("Field is too short")
+var ErrFieldTooLong = errors.New("Field is too long")
+var ErrInvalidCharacters = errors.New("Field has invalid characters")
+var ErrDateOutOfRange = errors.New("Date out of range")
+var ErrInvalidRules = errors.New("Invalid validation rules")
+
+// Character sets a field can be restricted to.
+const (
+	// CHARSET_PRINTABLE refuses control characters, such as newlines.
+	CHARSET_PRINTABLE = "printable"
+	// CHARSET_NAME allows letters, spaces, and the apostrophes, hyphens and
+	// periods names are written with.
+	CHARSET_NAME = "name"
+)
+
+// Rule restricts the values of a field. Zero limits do not apply. Values
+// are checked only when not empty, unless required.
+type Rule struct {
+	Required bool `json:"required"`
+	// MinLength and MaxLength count characters, not bytes.
+	MinLength int    `json:"min_length"`
+	MaxLength int    `json:"max_length"`
+	Charset   string `json:"charset"`
+	// MinDate and MaxDate bound dates, both included.
+	MinDate string `json:"min_date"`
+	MaxDate string `json:"max_date"`
+	// MinAge and MaxAge bound the years since a date, as of today.
+	MinAge *int `json:"min_age"`
+	MaxAge *int `json:"max_age"`
+}
+
+// Rules holds the rule of each field users are validated with, by the
+// fields' JSON names.
+type Rules map[string]Rule
+
+// DefaultRules returns the rules used unless a deployment configures its
+// own.
+func DefaultRules() Rules {
+	zero, oldest := 0, 150
+
+	return Rules{
+		"name":       {Required: true, MaxLength: 200, Charset: CHARSET_NAME},
+		"birth_date": {Required: true, MinAge: &zero, MaxAge: &oldest},
+		"email":      {Required: true, MaxLength: 254},
+		"address":    {MaxLength: 500, Charset: CHARSET_PRINTABLE},
+		"password":   {Required: true, MaxLength: 1024},
+	}
+}
+
+// ParseRules reads rules as JSON, an object of rules by field. The rules of
+// the fields it names replace the default ones.
+func ParseRules(data []byte) (Rules, error) {
+	var configured map[string]Rule
+	if err := json.Unmarshal(data, &configured); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRules, err)
+	}
+
+	rules := DefaultRules()
+
+	for field, rule := range configured {
+		if _, ok := rules[field]; !ok {
+			return nil, fmt.Errorf("%w: unknown field %s", ErrInvalidRules, field)
+		}
+
+		if err := rule.valid(); err != nil {
+			return nil, fmt.Errorf("%w: %s %v", ErrInvalidRules, field, err)
+		}
+
+		rules[field] = rule
+	}
+
+	return rules, nil
+}
+
+func (r Rule) valid() error {
+	switch r.Charset {
+	case "", CHARSET_PRINTABLE, CHARSET_NAME:
+	default:
+		return fmt.Errorf("has unknown charset %s", r.Charset)
+	}
+
+	for _, d := range []string{r.MinDate, r.MaxDate} {
+		if _, err := time.Parse(models.DATE_FORMAT, d); d != "" && err != nil {
+			return fmt.Errorf("has invalid date %s", d)
+		}
+	}
+
+	return nil
+}
+
+// check validates value, returning the first rule it breaks.
+func (r Rule) check(value string) error {
+	if strings.TrimSpace(value) == "" {
+		if r.Required {
+			return ErrFieldRequired
+		}
+		return nil
+	}
+
+	length := utf8.RuneCountInString(value)
+	if r.MinLength > 0 && length < r.MinLength {
+		return fmt.Errorf("%w (at least %d characters)", ErrFieldTooShort, r.MinLength)
+	}
+	if r.MaxLength > 0 && length > r.MaxLength {
+		return fmt.Errorf("%w (at most %d characters)", ErrFieldTooLong, r.MaxLength)
+	}
+
+	for _, c := range value {
+		if !inCharset(r.Charset, c) {
+			return ErrInvalidCharacters
+		}
+	}
+
+	return nil
+}
+
+// checkDate validates the date bounds of date as of now.
+func (r Rule) checkDate(date, now time.Time) error {
+	if r.MinDate != "" {
+		if min, _ := time.Parse(models.DATE_FORMAT, r.MinDate); date.Before(min) {
+			return fmt.Errorf("%w (from %s)", ErrDateOutOfRange, r.MinDate)
+		}
+	}
+	if r.MaxDate != "" {
+		if max, _ := time.Parse(models.DATE_FORMAT, r.MaxDate); date.After(max) {
+			return fmt.Errorf("%w (until %s)", ErrDateOutOfRange, r.MaxDate)
+		}
+	}
+
+	years := age(date, now)
+	if r.MinAge != nil && years < *r.MinAge {
+		return fmt.Errorf("%w (at least %d years ago)", ErrDateOutOfRange, *r.MinAge)
+	}
+	if r.MaxAge != nil && years > *r.MaxAge {
+		return fmt.Errorf("%w (at most %d years ago)", ErrDateOutOfRange, *r.MaxAge)
+	}
+
+	return nil
+}
+
+// age returns the whole years from date to now, negative for dates after
+// now.
+func age(date, now time.Time) int {
+	years := now.Year() - date.Year()
+	if now.Month() < date.Month() || (now.Month() == date.Month() && now.Day() < date.Day()) {
+		years--
+	}
+	return years
+}
+
+func inCharset(charset string, c rune) bool {
+	switch charset {
+	case CHARSET_PRINTABLE:
+		return !unicode.IsControl(c)
+	case CHARSET_NAME:
+		return unicode.IsLetter(c) || unicode.IsMark(c) || c == ' ' || strings.ContainsRune("'’-.", c)
+	}
+	return true
+}
+
+// ValidationError holds every field failing validation. errors.Is matches
+// it with ErrValidationFailed, as well as with the error of each field.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, f := range e {
+		messages = append(messages, f.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e ValidationError) Is(target error) bool {
+	return target == ErrValidationFailed
+}
+
+func (e ValidationError) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, f := range e {
+		errs = append(errs, f)
+	}
+	return errs
+}
+
+// rules returns the rules the service validates with.
+func (s UserService) rules() Rules {
+	if s.Rules == nil {
+		return DefaultRules()
+	}
+	return s.Rules
+}
+
+// validateFields checks the fields in params, by their JSON names, against
+// their rules and formats, and returns every one failing. Fields missing
+// from params are not checked.
+func (s UserService) validateFields(params map[string]string) error {
+	rules := s.rules()
+	now := time.Now()
+
+	fields := make([]string, 0, len(params))
+	for field := range params {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var failures ValidationError
+
+	for _, field := range fields {
+		value := params[field]
+		rule := rules[field]
+
+		err := rule.check(value)
+
+		switch {
+		case err != nil:
+		case field == "birth_date" && value != "":
+			date, perr := time.Parse(models.DATE_FORMAT, value)
+			if perr != nil {
+				err = ErrInvalidDateFormat
+			} else {
+				err = rule.checkDate(date, now)
+			}
+		case field == "email":
+			// Emails identify users, so they are checked even when
+			// rules leave them optional.
+			if _, cerr := s.Emails.Canonical(value); cerr != nil {
+				err = ErrInvalidEmailFormat
+			} else if !s.Tenant.Settings.AllowsEmail(value) {
+				err = ErrEmailDomainNotAllowed
+			}
+		}
+
+		if err != nil {
+			failures = append(failures, FieldError{field, err})
+		}
+	}
+
+	if len(failures) > 0 {
+		return failures
+	}
+	return nil
+}
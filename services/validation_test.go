@@ -0,0 +1,101 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ffardo/user-crud/interfaces/mocks"
+	"github.com/ffardo/user-crud/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFieldsCollectsEveryFailure(t *testing.T) {
+	userService := UserService{UserRepository: new(mocks.UserRepository)}
+
+	_, err := userService.CreateUser("", "tomorrow", "not an email", "3197 Woodrow Way\n", "secret")
+
+	assert.ErrorIs(t, err, ErrValidationFailed)
+
+	failures, ok := err.(ValidationError)
+	assert.True(t, ok)
+	assert.Equal(t, ValidationError{
+		{"address", ErrInvalidCharacters},
+		{"birth_date", ErrInvalidDateFormat},
+		{"email", ErrInvalidEmailFormat},
+		{"name", ErrFieldRequired},
+	}, failures)
+}
+
+func TestRuleCheck(t *testing.T) {
+	rule := Rule{Required: true, MinLength: 2, MaxLength: 5, Charset: CHARSET_NAME}
+
+	assert.NoError(t, rule.check("Joé"))
+	assert.ErrorIs(t, rule.check("  "), ErrFieldRequired)
+	assert.ErrorIs(t, rule.check("J"), ErrFieldTooShort)
+	assert.ErrorIs(t, rule.check("Johnny"), ErrFieldTooLong)
+	assert.ErrorIs(t, rule.check("J0e"), ErrInvalidCharacters)
+	assert.EqualError(t, rule.check("Johnny"), "Field is too long (at most 5 characters)")
+
+	assert.NoError(t, Rule{}.check(""))
+	assert.NoError(t, Rule{Charset: CHARSET_PRINTABLE}.check("3197 Woodrow Way, #2"))
+	assert.ErrorIs(t, Rule{Charset: CHARSET_PRINTABLE}.check("3197\tWoodrow Way"), ErrInvalidCharacters)
+}
+
+func TestRuleCheckDate(t *testing.T) {
+	now := time.Date(2020, 6, 15, 0, 0, 0, 0, time.UTC)
+	date := func(s string) time.Time {
+		d, _ := time.Parse(models.DATE_FORMAT, s)
+		return d
+	}
+
+	adult, oldest := 18, 150
+	rule := Rule{MinDate: "1900-01-01", MinAge: &adult, MaxAge: &oldest}
+
+	assert.NoError(t, rule.checkDate(date("2002-06-15"), now))
+	assert.ErrorIs(t, rule.checkDate(date("2002-06-16"), now), ErrDateOutOfRange)
+	assert.ErrorIs(t, rule.checkDate(date("1899-12-31"), now), ErrDateOutOfRange)
+	assert.ErrorIs(t, DefaultRules()["birth_date"].checkDate(date("2020-06-16"), now), ErrDateOutOfRange)
+	assert.ErrorIs(t, DefaultRules()["birth_date"].checkDate(date("1869-06-14"), now), ErrDateOutOfRange)
+}
+
+func TestValidateFieldsFutureBirthDate(t *testing.T) {
+	userService := UserService{UserRepository: new(mocks.UserRepository)}
+	tomorrow := time.Now().AddDate(0, 0, 1).Format(models.DATE_FORMAT)
+
+	_, err := userService.CreateUser("John Doe", tomorrow, "joe25@mailprovider.com", "", "secret")
+
+	assert.ErrorIs(t, err, ErrValidationFailed)
+	assert.ErrorIs(t, err, ErrDateOutOfRange)
+}
+
+func TestValidateFieldsWithConfiguredRules(t *testing.T) {
+	rules, err := ParseRules([]byte(`{"address": {"required": true, "max_length": 10}}`))
+	assert.NoError(t, err)
+
+	userService := UserService{UserRepository: new(mocks.UserRepository), Rules: rules}
+
+	assert.Equal(t, ValidationError{{"address", ErrFieldRequired}}, userService.validateFields(map[string]string{"address": ""}))
+
+	err = userService.validateFields(map[string]string{"address": strings.Repeat("a", 11), "name": "John Doe"})
+	assert.ErrorIs(t, err, ErrFieldTooLong)
+	assert.Len(t, err.(ValidationError), 1)
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte(`{"name": {"min_length": 2}}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, Rule{MinLength: 2}, rules["name"])
+	assert.Equal(t, DefaultRules()["email"], rules["email"])
+
+	for _, data := range []string{
+		`not json`,
+		`{"nickname": {}}`,
+		`{"name": {"charset": "ascii"}}`,
+		`{"birth_date": {"min_date": "01/01/1900"}}`,
+	} {
+		_, err := ParseRules([]byte(data))
+		assert.ErrorIs(t, err, ErrInvalidRules, data)
+	}
+}
@@ -1,42 +1,445 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/ffardo/user-crud/controllers"
+	"github.com/ffardo/user-crud/emails"
 	"github.com/ffardo/user-crud/infrastructures"
+	"github.com/ffardo/user-crud/interfaces"
+	"github.com/ffardo/user-crud/migrations"
+	"github.com/ffardo/user-crud/publishers"
 	"github.com/ffardo/user-crud/repositories"
 	"github.com/ffardo/user-crud/routes"
 	"github.com/ffardo/user-crud/services"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func setup() *gin.Engine {
+const DEFAULT_DELETED_USER_RETENTION = 30 * 24 * time.Hour
+const DEFAULT_PURGE_INTERVAL = time.Hour
+const MIGRATION_TIMEOUT = 10 * time.Minute
+const CHECK_TIMEOUT = 30 * time.Minute
+const DEFAULT_USER_CACHE_SIZE = 10000
+const DEFAULT_USER_CACHE_TTL = time.Minute
+const DEFAULT_USER_CACHE_NEGATIVE_TTL = 10 * time.Second
+const DEFAULT_RELAY_INTERVAL = time.Second
+const DEFAULT_RELAY_BATCH_SIZE = 100
+const DEFAULT_NATS_SUBJECT_PREFIX = "users"
+const DEFAULT_COMMAND_STARTUP_TIMEOUT = time.Minute
+const INDEX_RETRY_INTERVAL = time.Minute
 
-	mongoUri := os.Getenv("MONGO_URI")
-	mongoUsername := os.Getenv("MONGO_USERNAME")
-	mongoPassword := os.Getenv("MONGO_PASSWORD")
-	apiKey := os.Getenv("API_KEY")
+// Prefixes of the variables configuring each Mongo deployment. The
+// secondary is only used while moving users between deployments.
+const PRIMARY_MONGO = "MONGO"
+const SECONDARY_MONGO = "SECONDARY_MONGO"
+
+// connect returns a client once the Mongo configured by the variables
+// starting with prefix is reachable, giving up after MONGO_STARTUP_TIMEOUT.
+// It is meant for commands; the server waits without a limit by default,
+// see prepare.
+func connect(prefix string) *mongo.Client {
+
+	client := createClient(prefix)
+
+	ctx, cancel := context.WithTimeout(context.Background(), durationEnv("MONGO_STARTUP_TIMEOUT", DEFAULT_COMMAND_STARTUP_TIMEOUT))
+	defer cancel()
+
+	if err := waitForMongo(ctx, client); err != nil {
+		log.Fatalf("mongo unreachable: %v", err)
+	}
+
+	return client
+}
 
-	client, err := infrastructures.CreateMongoClient(mongoUri, mongoUsername, mongoPassword)
+func createClient(prefix string) *mongo.Client {
+
+	client, err := infrastructures.CreateMongoClient(mongoConfig(prefix))
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	return client
+}
+
+// waitForMongo pings until Mongo answers, backing off between attempts.
+func waitForMongo(ctx context.Context, client *mongo.Client) error {
+	backoff := infrastructures.Backoff{
+		Initial: durationEnv("MONGO_RETRY_INITIAL", infrastructures.DEFAULT_BACKOFF_INITIAL),
+		Max:     durationEnv("MONGO_RETRY_MAX", infrastructures.DEFAULT_BACKOFF_MAX),
+	}
+
+	return backoff.Retry(ctx, func(ctx context.Context) error {
+		return infrastructures.Ping(ctx, client)
+	}, func(attempt int, err error, wait time.Duration) {
+		log.Printf("mongo unreachable (attempt %d), retrying in %s: %v", attempt, wait.Round(time.Millisecond), err)
+	})
+}
+
+// mongoConfig reads the client settings from the variables starting with
+// prefix, PRIMARY_MONGO or SECONDARY_MONGO.
+func mongoConfig(prefix string) infrastructures.MongoConfig {
+	return infrastructures.MongoConfig{
+		URI:                    os.Getenv(prefix + "_URI"),
+		Username:               os.Getenv(prefix + "_USERNAME"),
+		Password:               os.Getenv(prefix + "_PASSWORD"),
+		MinPoolSize:            sizeEnv(prefix + "_MIN_POOL_SIZE"),
+		MaxPoolSize:            sizeEnv(prefix + "_MAX_POOL_SIZE"),
+		MaxConnIdleTime:        durationEnv(prefix+"_MAX_CONN_IDLE_TIME", 0),
+		ReadPreference:         os.Getenv(prefix + "_READ_PREFERENCE"),
+		ReadConcern:            os.Getenv(prefix + "_READ_CONCERN"),
+		WriteConcern:           os.Getenv(prefix + "_WRITE_CONCERN"),
+		WriteJournal:           os.Getenv(prefix+"_WRITE_JOURNAL") == "true",
+		WriteTimeout:           durationEnv(prefix+"_WRITE_TIMEOUT", 0),
+		ConnectTimeout:         durationEnv(prefix+"_CONNECT_TIMEOUT", 0),
+		ServerSelectionTimeout: durationEnv(prefix+"_SERVER_SELECTION_TIMEOUT", 0),
+		SocketTimeout:          durationEnv(prefix+"_SOCKET_TIMEOUT", 0),
+		TLS: infrastructures.TLSConfig{
+			Enabled:  os.Getenv(prefix+"_TLS") == "true",
+			CAFile:   os.Getenv(prefix + "_TLS_CA_FILE"),
+			CertFile: os.Getenv(prefix + "_TLS_CERT_FILE"),
+			KeyFile:  os.Getenv(prefix + "_TLS_KEY_FILE"),
+			Insecure: os.Getenv(prefix+"_TLS_INSECURE") == "true",
+		},
+	}
+}
+
+// storage names the database and collections used by the repositories and
+// the migrations, read from the variables starting with prefix.
+func storage(prefix string) repositories.Storage {
+	return repositories.Storage{
+		Database: os.Getenv(prefix + "_DATABASE"),
+		Users:    os.Getenv(prefix + "_USERS_COLLECTION"),
+		Outbox:   os.Getenv(prefix + "_OUTBOX_COLLECTION"),
+		Tokens:   os.Getenv(prefix + "_TOKENS_COLLECTION"),
+		Tenants:  os.Getenv(prefix + "_TENANTS_COLLECTION"),
+		Timeout:  durationEnv(prefix+"_OPERATION_TIMEOUT", 0),
+	}
+}
+
+func setup() *gin.Engine {
+
+	apiKey := os.Getenv("API_KEY")
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	retention := durationEnv("DELETED_USER_RETENTION", DEFAULT_DELETED_USER_RETENTION)
+	purgeInterval := positiveDurationEnv("PURGE_INTERVAL", DEFAULT_PURGE_INTERVAL)
+
+	startupTimeout := durationEnv("MONGO_STARTUP_TIMEOUT", 0)
+	healthInterval := durationEnv("MONGO_HEALTH_INTERVAL", infrastructures.DEFAULT_HEALTH_INTERVAL)
+
+	client := createClient(PRIMARY_MONGO)
+	readiness := &infrastructures.Readiness{}
+
 	ur := repositories.UserRepository{
-		Client: client,
+		Client:  client,
+		Storage: storage(PRIMARY_MONGO),
 	}
 
-	ur.Init()
+	var repository interfaces.UserRepository = ur
+
+	// With a secondary configured, writes are copied to it and reads
+	// compared against it, ahead of switching to it.
+	var secondaryClient *mongo.Client
+	if os.Getenv(SECONDARY_MONGO+"_URI") != "" {
+		secondaryClient = createClient(SECONDARY_MONGO)
+		secondary := repositories.UserRepository{
+			Client:  secondaryClient,
+			Storage: storage(SECONDARY_MONGO),
+		}
+		repository = repositories.NewDualUserRepository(ur, secondary, logMismatch)
+	}
+
+	if cacheSize := intEnv("USER_CACHE_SIZE", DEFAULT_USER_CACHE_SIZE); cacheSize > 0 {
+		repository = repositories.NewCachedUserRepository(
+			repository,
+			cacheSize,
+			durationEnv("USER_CACHE_TTL", DEFAULT_USER_CACHE_TTL),
+			durationEnv("USER_CACHE_NEGATIVE_TTL", DEFAULT_USER_CACHE_NEGATIVE_TTL),
+		)
+	}
+
+	us := services.UserService{
+		UserRepository: repository,
+		Emails:         emailNormalizer(),
+		Rules:          validationRules(),
+	}
 
 	uc := controllers.UserController{
-		UserService: services.UserService{
-			UserRepository: ur,
+		UserService: us,
+	}
+
+	purger := services.Purger{
+		UserService: us,
+		Retention:   retention,
+		Interval:    purgeInterval,
+	}
+
+	ts := services.TenantService{
+		TenantRepository: repositories.TenantRepository{
+			Client:  client,
+			Storage: storage(PRIMARY_MONGO),
 		},
 	}
 
-	return routes.InitRouter(&uc, apiKey)
+	tc := controllers.TenantController{
+		TenantService: ts,
+	}
+
+	requireReady := routes.RequireReady(readiness.Ready)
+
+	r := routes.InitRouter(&uc, routes.TenantAuth(apiKey, ts), requireReady)
+	routes.InitAdminRoutes(r, &uc, adminKey, requireReady)
+	routes.InitTenantAdminRoutes(r, &tc, adminKey, requireReady)
+	routes.InitHealthRoutes(r, controllers.HealthController{IsReady: readiness.Ready})
+	routes.InitDocsRoutes(r, controllers.DocsController{Document: controllers.OpenAPI()})
+
+	// The server starts answering right away. Until Mongo is reachable and
+	// prepared, and whenever the monitor later finds it unreachable, user
+	// requests fail with 503 and the readiness probe reports not ready.
+	go func() {
+		prepare(client, secondaryClient, startupTimeout)
+
+		go purger.Run(context.Background())
+		startRelay(client)
+
+		readiness.Set(true)
+		log.Print("ready")
+
+		monitor := infrastructures.MongoMonitor{
+			Client:    client,
+			Readiness: readiness,
+			Interval:  healthInterval,
+		}
+		monitor.Run(context.Background())
+	}()
+
+	return r
+
+}
+
+// prepare waits for Mongo, with no limit when timeout is zero, then applies
+// migrations if enabled and creates the indexes. The secondary, if any, only
+// gets its indexes: users are copied to it at the current schema version.
+// Any other failure stops the process. A unique index the stored users
+// prevent from being built is retried until it is, keeping the service not
+// ready meanwhile, as serving without it could store duplicate emails.
+func prepare(client, secondary *mongo.Client, timeout time.Duration) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := waitForMongo(ctx, client); err != nil {
+		log.Fatalf("mongo unreachable after %s: %v", timeout, err)
+	}
+
+	if os.Getenv("MIGRATE_ON_STARTUP") == "true" {
+		results, err := runMigrations(client, false)
+		for _, r := range results {
+			log.Printf("applied migration %d %s: %d documents", r.Version, r.Description, r.Documents)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	awaitIndexes(client, storage(PRIMARY_MONGO))
+
+	if secondary != nil {
+		if err := waitForMongo(ctx, secondary); err != nil {
+			log.Fatalf("secondary mongo unreachable after %s: %v", timeout, err)
+		}
+
+		awaitIndexes(secondary, storage(SECONDARY_MONGO))
+	}
+}
+
+// awaitIndexes ensures the indexes until none fails to build.
+func awaitIndexes(client *mongo.Client, names repositories.Storage) {
+	for !ensureIndexes(client, names) {
+		log.Printf("not ready until the indexes of %s are built, run the check command to list the users to fix; retrying in %s", names.DatabaseName(), INDEX_RETRY_INTERVAL)
+		time.Sleep(INDEX_RETRY_INTERVAL)
+	}
+}
+
+// ensureIndexes assigns users stored before tenants existed to the default
+// tenant, stopping the process when that makes emails collide, then creates
+// the missing indexes and logs the differences left. It returns false when
+// an index could not be built from the stored users.
+func ensureIndexes(client *mongo.Client, names repositories.Storage) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), repositories.INDEX_TIMEOUT)
+	defer cancel()
+
+	users := client.Database(names.DatabaseName()).Collection(names.UsersName())
+
+	assigned, err := migrations.AssignDefaultTenant(ctx, users)
+	switch {
+	case mongo.IsDuplicateKeyError(err):
+		log.Fatalf("users in %s share emails in the default tenant, run the check command to list them: %v", names.DatabaseName(), err)
+	case err != nil:
+		log.Fatalf("assigning users to the default tenant in %s: %v", names.DatabaseName(), err)
+	case assigned > 0:
+		log.Printf("assigned %d users to the default tenant in %s", assigned, names.DatabaseName())
+	}
+
+	manager := repositories.IndexManager{Client: client, Storage: names}
+
+	drift, err := manager.Ensure(ctx)
+	if err != nil {
+		log.Fatalf("creating indexes in %s: %v", names.DatabaseName(), err)
+	}
+
+	built := true
+	for _, d := range drift {
+		log.Printf("index drift in %s: %s", names.DatabaseName(), d)
+		if d.Kind == repositories.IndexFailed {
+			built = false
+		}
+	}
+
+	return built
+}
+
+func logMismatch(m repositories.Mismatch) {
+	log.Printf("secondary mismatch on %s of user %s: fields %v, error %v", m.Operation, m.UUID, m.Fields, m.Err)
+}
+
+// emailNormalizer is shared by the service and the migrations so canonical
+// emails are stored and looked up the same way.
+func emailNormalizer() emails.Normalizer {
+	return emails.Normalizer{
+		ProviderRules: os.Getenv("EMAIL_PROVIDER_RULES") == "true",
+	}
+}
+
+// validationRules reads the rules users are validated with from
+// VALIDATION_RULES_FILE, keeping the default ones when it is not set.
+func validationRules() services.Rules {
+	path := os.Getenv("VALIDATION_RULES_FILE")
+	if path == "" {
+		return services.DefaultRules()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rules, err := services.ParseRules(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return rules
+}
+
+// startRelay publishes outbox events to the publishers configured in the
+// environment. Without any, events are kept in the outbox until one is.
+func startRelay(client *mongo.Client) {
+	var publisher publishers.Multi
+
+	if path := os.Getenv("EVENTS_FILE"); path != "" {
+		f, err := publishers.OpenFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		publisher = append(publisher, f)
+	}
+
+	if url := os.Getenv("NATS_URL"); url != "" {
+		prefix := os.Getenv("NATS_SUBJECT_PREFIX")
+		if prefix == "" {
+			prefix = DEFAULT_NATS_SUBJECT_PREFIX
+		}
+		n, err := publishers.ConnectNATS(url, prefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		publisher = append(publisher, n)
+	}
+
+	outbox := repositories.OutboxRepository{
+		Client:  client,
+		Storage: storage(PRIMARY_MONGO),
+	}
+
+	if len(publisher) == 0 {
+		return
+	}
+
+	relay := services.Relay{
+		Outbox:    outbox,
+		Publisher: publisher,
+		BatchSize: positiveIntEnv("RELAY_BATCH_SIZE", DEFAULT_RELAY_BATCH_SIZE),
+		Interval:  positiveDurationEnv("RELAY_INTERVAL", DEFAULT_RELAY_INTERVAL),
+	}
+
+	go relay.Run(context.Background())
+}
+
+func durationEnv(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+
+	return d
+}
+
+// positiveDurationEnv reads a duration that must be above zero, such as the
+// interval of a ticker.
+func positiveDurationEnv(name string, fallback time.Duration) time.Duration {
+	d := durationEnv(name, fallback)
+	if d <= 0 {
+		log.Fatalf("invalid %s: %s is not above zero", name, d)
+	}
+
+	return d
+}
+
+func intEnv(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+
+	return i
+}
+
+// sizeEnv reads a size that cannot be negative, 0 when unset.
+func sizeEnv(name string) uint64 {
+	i := intEnv(name, 0)
+	if i < 0 {
+		log.Fatalf("invalid %s: %d is negative", name, i)
+	}
+
+	return uint64(i)
+}
+
+// positiveIntEnv reads a number that must be above zero, such as a batch
+// size.
+func positiveIntEnv(name string, fallback int) int {
+	i := intEnv(name, fallback)
+	if i <= 0 {
+		log.Fatalf("invalid %s: %d is not above zero", name, i)
+	}
 
+	return i
 }